@@ -0,0 +1,1557 @@
+//   Copyright 2020 DigitalOcean
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package cephclient runs the mon and mgr commands archimedes needs
+// against a Ceph cluster, through librados, the ceph CLI or the
+// ceph-mgr restful module.
+package cephclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/digitalocean/archimedes/internal/ratelimit"
+	log "github.com/sirupsen/logrus"
+)
+
+// Client provides an abstraction for client calls
+// made into Ceph.
+type Client interface {
+	// BackfillingPGs surfaces the list of PGs that are either
+	// in 'backfilling' or 'backfill_weight' state.
+	BackfillingPGs(ctx context.Context) (int, error)
+
+	// RecoveringPGs surfaces the list of PGs that are either
+	// in 'recovering' or 'recovery_weight' state.
+	RecoveringPGs(ctx context.Context) (int, error)
+
+	// ScrubbingPGs surfaces the list of PGs that are either
+	// in 'scrubbing' or 'scrubbing+deep' state.
+	ScrubbingPGs(ctx context.Context) (int, error)
+
+	// SnapTrimmingPGs surfaces the list of PGs that are either
+	// in 'snaptrim' or 'snaptrim_wait' state.
+	SnapTrimmingPGs(ctx context.Context) (int, error)
+
+	// InactivePGs surfaces the list of PGs that are either
+	// not active or are 'peering' or 'incomplete'.
+	InactivePGs(ctx context.Context) (int, error)
+
+	// MisplacedRatio surfaces the ratio of misplaced objects
+	// to the total number of object copies in the cluster.
+	MisplacedRatio(ctx context.Context) (float64, error)
+
+	// DegradedObjects surfaces the number of object copies
+	// that are currently degraded in the cluster.
+	DegradedObjects(ctx context.Context) (int, error)
+
+	// HealthStatus surfaces the overall health of the cluster
+	// along with the health checks that are currently raised.
+	HealthStatus(ctx context.Context) (*HealthOut, error)
+
+	// SlowOps surfaces the number of slow or blocked requests
+	// reported by the cluster health checks.
+	SlowOps(ctx context.Context) (int, error)
+
+	// Version returns the release of Ceph the mons run.
+	Version(ctx context.Context) (*CephRelease, error)
+
+	// ClusterStatus returns a parsed version of `ceph status`,
+	// which the methods above are derived from. Each of them issues
+	// `ceph status` on its own, so callers needing several counts
+	// at once should rather derive them from a single status.
+	ClusterStatus(ctx context.Context) (*ClusterStatusOut, error)
+
+	// OSDTree returns a parsed version of `ceph osd tree`.
+	OSDTree(ctx context.Context) (*OSDTreeOut, error)
+
+	// OSDTreeOf returns a parsed version of `ceph osd tree` holding
+	// every bucket but only the given OSDs.
+	OSDTreeOf(ctx context.Context, osdIDs []int) (*OSDTreeOut, error)
+
+	// OSDTreeFrom is like OSDTreeOf, but only covers the given bucket
+	// and everything underneath it, as `ceph osd tree-from` does.
+	OSDTreeFrom(ctx context.Context, bucket string, osdIDs []int) (*OSDTreeOut, error)
+
+	// OSDDF returns a parsed version of `ceph osd df tree`.
+	OSDDF(ctx context.Context) (*OSDDFOut, error)
+
+	// QuorumStatus returns a parsed version of `ceph quorum_status`.
+	QuorumStatus(ctx context.Context) (*QuorumStatusOut, error)
+
+	// OSDDump returns a parsed version of `ceph osd dump`.
+	OSDDump(ctx context.Context) (*OSDDumpOut, error)
+
+	// CrushRuleDump returns a parsed version of `ceph osd crush rule dump`.
+	CrushRuleDump(ctx context.Context) (*CrushRuleDumpOut, error)
+
+	// PGDump returns a parsed version of `ceph pg dump pgs`.
+	PGDump(ctx context.Context) (*PGDumpOut, error)
+
+	// PGStats returns a parsed version of `ceph pg stat`.
+	PGStats(ctx context.Context) (*PGStatsOut, error)
+
+	// OSDNetworkPings returns a parsed version of the mgr's
+	// `dump_osd_network` heartbeat ping times.
+	OSDNetworkPings(ctx context.Context) (*OSDNetworkOut, error)
+
+	// OSDPerf returns a parsed version of `ceph osd perf`.
+	OSDPerf(ctx context.Context) (*OSDPerfOut, error)
+
+	// SafeToDestroy returns a parsed version of `ceph osd
+	// safe-to-destroy` for the given OSDs, i.e. which of them no
+	// longer hold any data and can be removed without risk.
+	SafeToDestroy(ctx context.Context, osdIDs []int) (*SafeToDestroyOut, error)
+
+	// OKToStop returns a parsed version of `ceph osd ok-to-stop` for
+	// the given OSDs, i.e. whether stopping all of them at once keeps
+	// every PG available.
+	OKToStop(ctx context.Context, osdIDs []int) (*OKToStopOut, error)
+
+	// CrushReweight updates the given OSD to the crush reweight
+	// value provided.
+	CrushReweight(ctx context.Context, osdID int, crushWeight float64) error
+
+	// CrushReweightSubtree updates every OSD underneath the given
+	// bucket to the crush reweight value provided, in a single
+	// osdmap change.
+	CrushReweightSubtree(ctx context.Context, bucket string, crushWeight float64) error
+
+	// SetPrimaryAffinity sets how likely the given OSD is picked as
+	// the primary of its PGs, between 0 and 1.
+	SetPrimaryAffinity(ctx context.Context, osdID int, affinity float64) error
+
+	// MarkOut marks the given OSDs out of the cluster.
+	MarkOut(ctx context.Context, osdIDs []int) error
+
+	// CrushRemove removes the given OSD from the CRUSH map.
+	CrushRemove(ctx context.Context, osdID int) error
+
+	// PurgeOSD removes every trace of the given OSD from the cluster,
+	// i.e. the CRUSH map, its auth key and the OSD map. The OSD has
+	// to be down for the mons to accept it.
+	PurgeOSD(ctx context.Context, osdID int) error
+
+	// SetFlag sets the given OSD map flag, e.g. `noout` or
+	// `norebalance`, which is a no-op when it is set already.
+	SetFlag(ctx context.Context, flag string) error
+
+	// UnsetFlag unsets the given OSD map flag, which is a no-op
+	// when it is not set.
+	UnsetFlag(ctx context.Context, flag string) error
+
+	// ConfigGet returns the value of an option stored in the config
+	// database for the given daemon or type of daemon, e.g. `osd`,
+	// and whether it is stored there at all.
+	ConfigGet(ctx context.Context, who, key string) (string, bool, error)
+
+	// ConfigSet stores the value of an option in the config database
+	// for the given daemon or type of daemon.
+	ConfigSet(ctx context.Context, who, key, value string) error
+
+	// ConfigRemove removes an option from the config database for
+	// the given daemon or type of daemon, reverting it to whatever
+	// value applies otherwise.
+	ConfigRemove(ctx context.Context, who, key string) error
+
+	// EnableCephBalancer enables the Ceph balancer.
+	EnableCephBalancer(ctx context.Context) error
+
+	// DisableCephBalancer disables the Ceph balancer.
+	DisableCephBalancer(ctx context.Context) error
+
+	// BalancerStatus returns a parsed version of `ceph balancer status`.
+	BalancerStatus(ctx context.Context) (*BalancerStatusOut, error)
+
+	// Close is used to disconnect Ceph connection once used.
+	Close()
+}
+
+type client struct {
+	transport cephTransport
+
+	timeout        time.Duration
+	connectTimeout time.Duration
+	retries        int
+	backoff        time.Duration
+	limiter        *ratelimit.TokenBucket
+
+	// Connection settings applied on top of the ceph config, or
+	// instead of it when connecting without one.
+	monHost string
+	keyring string
+	keyFile string
+	key     string
+
+	logger log.FieldLogger
+}
+
+// cephTransport carries the mon and mgr commands of client
+// to the cluster, e.g. through librados or the ceph CLI.
+type cephTransport interface {
+	monCommand(ctx context.Context, cmd []byte) ([]byte, error)
+	mgrCommand(ctx context.Context, cmd []byte) ([]byte, error)
+	close()
+}
+
+// errNotConnected is returned by transports while they are
+// not connected to the cluster.
+var errNotConnected = errors.New("not connected to cluster")
+
+// errBusy is returned by mgr commands failing with EBUSY, which
+// `osd safe-to-destroy` and `osd ok-to-stop` answer with on some
+// releases rather than reporting a negative result.
+var errBusy = errors.New("device or resource busy")
+
+// Option provides a safe way to configure the
+// client returned by New.
+type Option func(*client)
+
+// WithCommandTimeout bounds the time a single mon or mgr
+// command may take, on top of the deadline of the context
+// it is issued with. A value of 0 disables the timeout.
+func WithCommandTimeout(val time.Duration) Option {
+	return func(c *client) {
+		c.timeout = val
+	}
+}
+
+// WithConnectTimeout bounds the time connecting to the cluster may
+// take, so that unreachable mons make the client fail rather than
+// hang. A value of 0 keeps the default of the backend, e.g. five
+// minutes for librados.
+func WithConnectTimeout(val time.Duration) Option {
+	return func(c *client) {
+		c.connectTimeout = val
+	}
+}
+
+// WithMonHost connects to the mons at the given addresses, e.g.
+// `10.0.0.1,10.0.0.2`, instead of the ones from the ceph config.
+// Along with a keyring or key it allows connecting without any
+// ceph config at all. Not supported by the REST client.
+func WithMonHost(val string) Option {
+	return func(c *client) {
+		c.monHost = val
+	}
+}
+
+// WithKeyring authenticates with the keyring at the given path
+// instead of the one from the ceph config. Not supported by the
+// REST client.
+func WithKeyring(val string) Option {
+	return func(c *client) {
+		c.keyring = val
+	}
+}
+
+// WithKeyFile authenticates with the secret key stored in the file
+// at the given path, e.g. a mounted secret, instead of a keyring.
+// Not supported by the REST client.
+func WithKeyFile(val string) Option {
+	return func(c *client) {
+		c.keyFile = val
+	}
+}
+
+// WithKey authenticates with the given secret key instead of a
+// keyring. Not supported by the REST client.
+func WithKey(val string) Option {
+	return func(c *client) {
+		c.key = val
+	}
+}
+
+// WithCommandRetries retries mon and mgr commands failing with
+// a transient error, e.g. while the mons elect a new leader, up
+// to the given number of times. A value of 0 disables retries.
+func WithCommandRetries(val int) Option {
+	return func(c *client) {
+		c.retries = val
+	}
+}
+
+// WithMaxCommandsPerSecond limits the rate of mon and mgr commands,
+// retries included, issued by the client, so that iterations
+// reweighting many OSDs don't burst commands at the mons. Commands
+// over the limit wait for their turn. A value of 0 disables the
+// limit.
+func WithMaxCommandsPerSecond(val int) Option {
+	return func(c *client) {
+		c.limiter = ratelimit.New(val, time.Second, nil)
+	}
+}
+
+// WithCommandBackoff sets the delay before the first retry of
+// a command, doubling with every retry after that up to
+// maxCommandBackoff.
+func WithCommandBackoff(val time.Duration) Option {
+	return func(c *client) {
+		c.backoff = val
+	}
+}
+
+// WithLogger routes the logs of the client, e.g. about retried
+// commands and reconnects, to the given logger instead of the
+// standard logrus logger.
+func WithLogger(val log.FieldLogger) Option {
+	return func(c *client) {
+		c.logger = val
+	}
+}
+
+func (c *client) log() log.FieldLogger {
+	if c.logger == nil {
+		return log.StandardLogger()
+	}
+	return c.logger
+}
+
+func (c *client) BackfillingPGs(ctx context.Context) (int, error) {
+	return c.getPGsByState(ctx, "backfilling", "backfill_wait")
+}
+
+func (c *client) RecoveringPGs(ctx context.Context) (int, error) {
+	return c.getPGsByState(ctx, "recovering", "recovery_wait")
+}
+
+func (c *client) ScrubbingPGs(ctx context.Context) (int, error) {
+	return c.getPGsByState(ctx, "scrubbing")
+}
+
+func (c *client) SnapTrimmingPGs(ctx context.Context) (int, error) {
+	// Matching on the prefix covers 'snaptrim_wait' as well.
+	return c.getPGsByState(ctx, "snaptrim")
+}
+
+func (c *client) InactivePGs(ctx context.Context) (int, error) {
+	status, err := c.ClusterStatus(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return status.InactivePGs(), nil
+}
+
+func (c *client) MisplacedRatio(ctx context.Context) (float64, error) {
+	status, err := c.ClusterStatus(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return status.MisplacedRatio(), nil
+}
+
+func (c *client) DegradedObjects(ctx context.Context) (int, error) {
+	status, err := c.ClusterStatus(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(status.PGMap.DegradedObjects), nil
+}
+
+func (c *client) HealthStatus(ctx context.Context) (*HealthOut, error) {
+	status, err := c.ClusterStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &status.Health, nil
+}
+
+func (c *client) SlowOps(ctx context.Context) (int, error) {
+	status, err := c.ClusterStatus(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return status.SlowOps(), nil
+}
+
+func (c *client) ClusterStatus(ctx context.Context) (*ClusterStatusOut, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "status",
+		"format": "json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := c.monCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeClusterStatus(buf)
+}
+
+func (c *client) getPGsByState(ctx context.Context, states ...string) (int, error) {
+	status, err := c.ClusterStatus(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return status.PGsInState(states...), nil
+}
+
+func (c *client) OSDTree(ctx context.Context) (*OSDTreeOut, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "osd tree",
+		"format": "json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := c.monCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeOSDTree(bytes.NewReader(buf), nil)
+}
+
+func (c *client) OSDTreeOf(ctx context.Context, osdIDs []int) (*OSDTreeOut, error) {
+	return c.filteredOSDTree(ctx, map[string]interface{}{
+		"prefix": "osd tree",
+		"format": "json",
+	}, osdIDs)
+}
+
+func (c *client) OSDTreeFrom(ctx context.Context, bucket string, osdIDs []int) (*OSDTreeOut, error) {
+	return c.filteredOSDTree(ctx, map[string]interface{}{
+		"prefix": "osd tree-from",
+		"bucket": bucket,
+		"format": "json",
+	}, osdIDs)
+}
+
+// filteredOSDTree issues the given tree command and keeps every
+// bucket but only the given OSDs of its output.
+func (c *client) filteredOSDTree(ctx context.Context, args map[string]interface{}, osdIDs []int) (*OSDTreeOut, error) {
+	cmd, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := c.monCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := make(map[int]bool, len(osdIDs))
+	for _, id := range osdIDs {
+		keep[id] = true
+	}
+	return decodeOSDTree(bytes.NewReader(buf), func(id int) bool { return keep[id] })
+}
+
+func (c *client) OSDDF(ctx context.Context) (*OSDDFOut, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix":        "osd df",
+		"output_method": "tree",
+		"format":        "json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := c.monCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	odf := &OSDDFOut{}
+	if err := json.Unmarshal(buf, odf); err != nil {
+		return nil, err
+	}
+
+	return odf, nil
+}
+
+func (c *client) QuorumStatus(ctx context.Context) (*QuorumStatusOut, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "quorum_status",
+		"format": "json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := c.monCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	qs := &QuorumStatusOut{}
+	if err := json.Unmarshal(buf, qs); err != nil {
+		return nil, err
+	}
+
+	return qs, nil
+}
+
+func (c *client) OSDDump(ctx context.Context) (*OSDDumpOut, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "osd dump",
+		"format": "json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := c.monCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	od := &OSDDumpOut{}
+	if err := json.Unmarshal(buf, od); err != nil {
+		return nil, err
+	}
+
+	return od, nil
+}
+
+func (c *client) CrushRuleDump(ctx context.Context) (*CrushRuleDumpOut, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "osd crush rule dump",
+		"format": "json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := c.monCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	crd := &CrushRuleDumpOut{}
+	if err := json.Unmarshal(buf, &crd.Rules); err != nil {
+		return nil, err
+	}
+
+	return crd, nil
+}
+
+func (c *client) PGDump(ctx context.Context) (*PGDumpOut, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix":       "pg dump",
+		"dumpcontents": []string{"pgs"},
+		"format":       "json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := c.monCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	pgd := &PGDumpOut{}
+	if err := json.Unmarshal(buf, pgd); err != nil {
+		return nil, err
+	}
+
+	return pgd, nil
+}
+
+func (c *client) PGStats(ctx context.Context) (*PGStatsOut, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "pg stat",
+		"format": "json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := c.monCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodePGStats(buf)
+}
+
+func (c *client) OSDNetworkPings(ctx context.Context) (*OSDNetworkOut, error) {
+	// A zero threshold makes the mgr report every heartbeat pair
+	// instead of only the ones it already considers slow.
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "dump_osd_network",
+		"value":  0,
+		"format": "json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := c.mgrCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	on := &OSDNetworkOut{}
+	if err := json.Unmarshal(buf, on); err != nil {
+		return nil, err
+	}
+
+	return on, nil
+}
+
+func (c *client) OSDPerf(ctx context.Context) (*OSDPerfOut, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "osd perf",
+		"format": "json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := c.monCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeOSDPerf(buf)
+}
+
+func (c *client) CrushReweight(ctx context.Context, osdID int, crushWeight float64) error {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "osd crush reweight",
+		"name":   fmt.Sprintf("osd.%d", osdID),
+		"weight": crushWeight,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.monCommand(ctx, cmd)
+	return err
+}
+
+func (c *client) CrushReweightSubtree(ctx context.Context, bucket string, crushWeight float64) error {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "osd crush reweight-subtree",
+		"name":   bucket,
+		"weight": crushWeight,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.monCommand(ctx, cmd)
+	return err
+}
+
+func (c *client) SetPrimaryAffinity(ctx context.Context, osdID int, affinity float64) error {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "osd primary-affinity",
+		"id":     fmt.Sprintf("osd.%d", osdID),
+		"weight": affinity,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.monCommand(ctx, cmd)
+	return err
+}
+
+func (c *client) MarkOut(ctx context.Context, osdIDs []int) error {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "osd out",
+		"ids":    osdIDStrings(osdIDs),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.monCommand(ctx, cmd)
+	return err
+}
+
+func (c *client) CrushRemove(ctx context.Context, osdID int) error {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "osd crush remove",
+		"name":   fmt.Sprintf("osd.%d", osdID),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.monCommand(ctx, cmd)
+	return err
+}
+
+func (c *client) PurgeOSD(ctx context.Context, osdID int) error {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix":               "osd purge",
+		"id":                   fmt.Sprintf("osd.%d", osdID),
+		"yes_i_really_mean_it": true,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.monCommand(ctx, cmd)
+	return err
+}
+
+func (c *client) SetFlag(ctx context.Context, flag string) error {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "osd set",
+		"key":    flag,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.monCommand(ctx, cmd)
+	return err
+}
+
+func (c *client) UnsetFlag(ctx context.Context, flag string) error {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "osd unset",
+		"key":    flag,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.monCommand(ctx, cmd)
+	return err
+}
+
+func (c *client) ConfigGet(ctx context.Context, who, key string) (string, bool, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "config dump",
+		"format": "json",
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	buf, err := c.monCommand(ctx, cmd)
+	if err != nil {
+		return "", false, err
+	}
+
+	// `config get` would report the effective value, even when it
+	// comes from the defaults rather than the config database.
+	var opts []configOption
+	if err := json.Unmarshal(buf, &opts); err != nil {
+		return "", false, err
+	}
+
+	for _, opt := range opts {
+		if opt.Section == who && opt.Name == key && opt.Mask == "" {
+			return opt.Value, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func (c *client) ConfigSet(ctx context.Context, who, key, value string) error {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "config set",
+		"who":    who,
+		"name":   key,
+		"value":  value,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.monCommand(ctx, cmd)
+	return err
+}
+
+func (c *client) ConfigRemove(ctx context.Context, who, key string) error {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "config rm",
+		"who":    who,
+		"name":   key,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.monCommand(ctx, cmd)
+	return err
+}
+
+func (c *client) SafeToDestroy(ctx context.Context, osdIDs []int) (*SafeToDestroyOut, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "osd safe-to-destroy",
+		"ids":    osdIDStrings(osdIDs),
+		"format": "json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Nautilus answers with EBUSY rather than JSON when any of the
+	// OSDs isn't safe.
+	buf, err := c.mgrCommandBusy(ctx, cmd)
+	if err == errBusy {
+		return &SafeToDestroyOut{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := &SafeToDestroyOut{}
+	if err := json.Unmarshal(buf, out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *client) OKToStop(ctx context.Context, osdIDs []int) (*OKToStopOut, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "osd ok-to-stop",
+		"ids":    osdIDStrings(osdIDs),
+		"format": "json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A negative answer comes as EBUSY, with the details only
+	// reported on stderr.
+	buf, err := c.mgrCommandBusy(ctx, cmd)
+	if err == errBusy {
+		return &OKToStopOut{OSDs: osdIDs}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Releases before Octopus report success without any output.
+	if len(bytes.TrimSpace(buf)) == 0 {
+		return &OKToStopOut{OKToStop: true, OSDs: osdIDs}, nil
+	}
+
+	out := &OKToStopOut{}
+	if err := json.Unmarshal(buf, out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// osdIDStrings formats OSD ids the way commands taking a list of
+// OSDs expect them.
+func osdIDStrings(osdIDs []int) []string {
+	ids := make([]string, 0, len(osdIDs))
+	for _, id := range osdIDs {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	return ids
+}
+
+func (c *client) EnableCephBalancer(ctx context.Context) error {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "balancer on",
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.mgrCommand(ctx, cmd)
+	return err
+}
+
+func (c *client) DisableCephBalancer(ctx context.Context) error {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "balancer off",
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.mgrCommand(ctx, cmd)
+	return err
+}
+
+func (c *client) BalancerStatus(ctx context.Context) (*BalancerStatusOut, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "balancer status",
+		"format": "json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := c.mgrCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := &BalancerStatusOut{}
+	if err := json.Unmarshal(buf, bs); err != nil {
+		return nil, err
+	}
+
+	return bs, nil
+}
+
+func (c *client) monCommand(ctx context.Context, cmd []byte) ([]byte, error) {
+	buf, err := c.command(ctx, func(ctx context.Context) ([]byte, error) {
+		return c.transport.monCommand(ctx, cmd)
+	})
+	if err != nil {
+		return nil, newCommandError(cmd, err)
+	}
+	return buf, nil
+}
+
+func (c *client) mgrCommand(ctx context.Context, cmd []byte) ([]byte, error) {
+	buf, err := c.command(ctx, func(ctx context.Context) ([]byte, error) {
+		return c.transport.mgrCommand(ctx, cmd)
+	})
+	if err != nil {
+		return nil, newCommandError(cmd, err)
+	}
+	return buf, nil
+}
+
+// mgrCommandBusy is like mgrCommand, but for commands that answer
+// with EBUSY on purpose. Those fail with errBusy right away rather
+// than being retried as if the mgr was busy.
+func (c *client) mgrCommandBusy(ctx context.Context, cmd []byte) ([]byte, error) {
+	buf, err := c.command(ctx, func(ctx context.Context) ([]byte, error) {
+		buf, err := c.transport.mgrCommand(ctx, cmd)
+		if coded, ok := err.(interface{ ErrorCode() int }); ok && syscall.Errno(-coded.ErrorCode()) == syscall.EBUSY {
+			return nil, errBusy
+		}
+		return buf, err
+	})
+	if err != nil && err != errBusy {
+		return nil, newCommandError(cmd, err)
+	}
+	return buf, err
+}
+
+// The delay between retries of a command doubles up to
+// maxCommandBackoff. Each delay is jittered so that commands failing
+// together, as when a mon goes away, are not retried in lockstep.
+const maxCommandBackoff = time.Minute
+
+// command runs the given call until it returns or the context is
+// done, whichever happens first. librados calls cannot be
+// interrupted, so a call that is given up on is left to finish
+// in the background.
+func (c *client) command(ctx context.Context, fn func(context.Context) ([]byte, error)) ([]byte, error) {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		buf, err := c.attempt(ctx, fn)
+		if err == nil || attempt >= c.retries || ctx.Err() != nil || !isTransient(err) {
+			return buf, err
+		}
+
+		delay := jitter(backoff)
+		c.log().WithField("attempt", attempt+1).WithField("backoff", delay).
+			Warnf("retrying ceph command after transient error: %s", err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff = nextBackoff(backoff)
+	}
+}
+
+// nextBackoff doubles the given backoff up to maxCommandBackoff. A
+// larger backoff, as set with WithCommandBackoff, is kept as is.
+func nextBackoff(backoff time.Duration) time.Duration {
+	if backoff >= maxCommandBackoff {
+		return backoff
+	}
+	backoff *= 2
+	if backoff > maxCommandBackoff {
+		backoff = maxCommandBackoff
+	}
+	return backoff
+}
+
+// jitter returns a random delay between half of the given backoff and
+// all of it.
+func jitter(backoff time.Duration) time.Duration {
+	if backoff <= 1 {
+		return backoff
+	}
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+}
+
+// attempt issues a command once, bounded by the command timeout.
+func (c *client) attempt(ctx context.Context, fn func(context.Context) ([]byte, error)) ([]byte, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		buf []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		buf, err := fn(ctx)
+		done <- result{buf: buf, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.buf, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// isTransient reports whether a failed command is worth retrying,
+// i.e. it timed out or failed because the mons were unreachable
+// or busy electing a leader rather than because it was rejected.
+func isTransient(err error) bool {
+	if err == context.DeadlineExceeded || err == errNotConnected {
+		return true
+	}
+	if temp, ok := err.(interface{ Temporary() bool }); ok {
+		return temp.Temporary()
+	}
+
+	coded, ok := err.(interface{ ErrorCode() int })
+	if !ok {
+		return false
+	}
+	switch syscall.Errno(-coded.ErrorCode()) {
+	case syscall.EAGAIN, syscall.EINTR, syscall.EBUSY, syscall.ETIMEDOUT,
+		syscall.ENOTCONN, syscall.ECONNREFUSED, syscall.ECONNRESET:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *client) Close() {
+	c.transport.close()
+}
+
+// Verify compile time that `client` implements `Client`.
+var _ Client = &client{}
+
+// connOptions returns the ceph config options the connection
+// settings of the client translate to, in a stable order.
+func (c *client) connOptions() [][2]string {
+	var opts [][2]string
+	for _, opt := range [][2]string{
+		{"mon_host", c.monHost},
+		{"keyring", c.keyring},
+		{"keyfile", c.keyFile},
+		{"key", c.key},
+	} {
+		if opt[1] != "" {
+			opts = append(opts, opt)
+		}
+	}
+	return opts
+}
+
+// timeoutOptions returns the ceph config options the timeouts of
+// the client translate to for librados, which otherwise keeps
+// waiting on unreachable mons long after the client gave up.
+func (c *client) timeoutOptions() [][2]string {
+	var opts [][2]string
+	if c.connectTimeout > 0 {
+		opts = append(opts, [2]string{"client_mount_timeout", timeoutSeconds(c.connectTimeout)})
+	}
+	if c.timeout > 0 {
+		opts = append(opts, [2]string{"rados_mon_op_timeout", timeoutSeconds(c.timeout)})
+	}
+	return opts
+}
+
+// timeoutSeconds formats a timeout as whole seconds, rounded up so
+// that short timeouts don't turn into none at all.
+func timeoutSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// clusterName derives the name of the cluster from the path
+// to its config, which is always /etc/ceph/<cluster>.conf.
+// Without a config the default cluster name is assumed.
+func clusterName(configPath string) (string, error) {
+	if configPath == "" {
+		return "ceph", nil
+	}
+
+	confParts := strings.SplitN(path.Base(configPath), ".", 2)
+	if len(confParts) < 2 {
+		return "", fmt.Errorf("invalid ceph conf: %q", configPath)
+	}
+
+	return confParts[0], nil
+}
+
+// OSDTreeOut provides a representation for output of
+// `ceph osd tree -f json`.
+type OSDTreeOut struct {
+	Nodes []OSDTreeNode `json:"nodes"`
+	Stray []OSDTreeNode `json:"stray"`
+}
+
+// OSDTreeNode is a bucket or OSD within the crush tree.
+type OSDTreeNode struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	Status      string  `json:"status"`
+	Reweight    float64 `json:"reweight"`
+	CrushWeight float64 `json:"crush_weight"`
+	Children    []int   `json:"children"`
+}
+
+// OSDDFOut provides a representation for output of
+// `ceph osd df tree -f json`.
+type OSDDFOut struct {
+	Nodes   []OSDDFNode `json:"nodes"`
+	Stray   []OSDDFNode `json:"stray"`
+	Summary struct {
+		TotalKB            int64   `json:"total_kb"`
+		TotalKBUsed        int64   `json:"total_kb_used"`
+		TotalKBAvail       int64   `json:"total_kb_avail"`
+		AverageUtilization float64 `json:"average_utilization"`
+		MinVar             float64 `json:"min_var"`
+		MaxVar             float64 `json:"max_var"`
+		Dev                float64 `json:"dev"`
+	} `json:"summary"`
+}
+
+// OSDDFNode is a bucket or OSD within the crush tree along with
+// its utilization, which buckets sum up across their children.
+type OSDDFNode struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	DeviceClass string  `json:"device_class"`
+	Status      string  `json:"status"`
+	Reweight    float64 `json:"reweight"`
+	CrushWeight float64 `json:"crush_weight"`
+	KB          int64   `json:"kb"`
+	KBUsed      int64   `json:"kb_used"`
+	KBAvail     int64   `json:"kb_avail"`
+	Utilization float64 `json:"utilization"`
+	Var         float64 `json:"var"`
+	PGs         int     `json:"pgs"`
+	Children    []int   `json:"children"`
+}
+
+// QuorumStatusOut provides a representation for output of
+// `ceph quorum_status -f json`.
+type QuorumStatusOut struct {
+	Quorum      []int    `json:"quorum"`
+	QuorumNames []string `json:"quorum_names"`
+	MonMap      struct {
+		Mons []MonInfo `json:"mons"`
+	} `json:"monmap"`
+}
+
+// MonInfo is a mon within the monmap.
+type MonInfo struct {
+	Rank int    `json:"rank"`
+	Name string `json:"name"`
+}
+
+// OSDDumpOut provides a representation for output of
+// `ceph osd dump -f json`.
+type OSDDumpOut struct {
+	Epoch int        `json:"epoch"`
+	Flags string     `json:"flags"`
+	OSDs  []OSDInfo  `json:"osds"`
+	Pools []PoolInfo `json:"pools"`
+}
+
+// PoolInfo is a pool within the osdmap.
+type PoolInfo struct {
+	Pool         int    `json:"pool"`
+	PoolName     string `json:"pool_name"`
+	CrushRule    int    `json:"crush_rule"`
+	PGNum        int    `json:"pg_num"`
+	PGNumTarget  int    `json:"pg_num_target"`
+	PGPNum       int    `json:"pg_placement_num"`
+	PGPNumTarget int    `json:"pg_placement_num_target"`
+}
+
+// OSDInfo is an OSD within the osdmap.
+type OSDInfo struct {
+	OSD             int      `json:"osd"`
+	Up              int      `json:"up"`
+	In              int      `json:"in"`
+	State           []string `json:"state"`
+	PrimaryAffinity float64  `json:"primary_affinity"`
+}
+
+// CrushRuleDumpOut provides a representation for output of
+// `ceph osd crush rule dump -f json`.
+type CrushRuleDumpOut struct {
+	Rules []CrushRule
+}
+
+// CrushRule is a rule within the crush map.
+type CrushRule struct {
+	RuleID   int             `json:"rule_id"`
+	RuleName string          `json:"rule_name"`
+	Steps    []CrushRuleStep `json:"steps"`
+}
+
+// CrushRuleStep is a single step of a crush rule.
+type CrushRuleStep struct {
+	Op       string `json:"op"`
+	Item     int    `json:"item"`
+	ItemName string `json:"item_name"`
+}
+
+// PGDumpOut provides a representation for output of
+// `ceph pg dump pgs -f json`.
+type PGDumpOut struct {
+	PGStats []PGStat `json:"pg_stats"`
+}
+
+// PGStat holds the state and statistics of a single PG.
+type PGStat struct {
+	PGID          string `json:"pgid"`
+	State         string `json:"state"`
+	Up            []int  `json:"up"`
+	Acting        []int  `json:"acting"`
+	UpPrimary     int    `json:"up_primary"`
+	ActingPrimary int    `json:"acting_primary"`
+	StatSum       struct {
+		NumBytes            int64 `json:"num_bytes"`
+		NumObjects          int64 `json:"num_objects"`
+		NumObjectsMisplaced int64 `json:"num_objects_misplaced"`
+		NumObjectsDegraded  int64 `json:"num_objects_degraded"`
+		NumObjectsRecovered int64 `json:"num_objects_recovered"`
+		NumBytesRecovered   int64 `json:"num_bytes_recovered"`
+	} `json:"stat_sum"`
+}
+
+// Pool returns the ID of the pool the PG belongs to, as PG IDs
+// are of the form `<pool>.<seed>`.
+func (pg PGStat) Pool() (int, error) {
+	return strconv.Atoi(strings.SplitN(pg.PGID, ".", 2)[0])
+}
+
+// PGStatsOut provides a representation for output of
+// `ceph pg stat -f json`.
+type PGStatsOut struct {
+	NumPGsByState []struct {
+		Name string `json:"name"`
+		Num  int    `json:"num"`
+	} `json:"num_pg_by_state"`
+	NumPGs                  int     `json:"num_pgs"`
+	NumBytes                int64   `json:"num_bytes"`
+	TotalBytes              int64   `json:"total_bytes"`
+	TotalAvailBytes         int64   `json:"total_avail_bytes"`
+	TotalUsedBytes          int64   `json:"total_used_bytes"`
+	DegradedObjects         int64   `json:"degraded_objects"`
+	DegradedTotal           int64   `json:"degraded_total"`
+	DegradedRatio           float64 `json:"degraded_ratio"`
+	MisplacedObjects        int64   `json:"misplaced_objects"`
+	MisplacedTotal          int64   `json:"misplaced_total"`
+	MisplacedRatio          float64 `json:"misplaced_ratio"`
+	RecoveringObjectsPerSec float64 `json:"recovering_objects_per_sec"`
+	RecoveringBytesPerSec   float64 `json:"recovering_bytes_per_sec"`
+}
+
+// HealthOut provides a representation for the health
+// section of `ceph -s -f json`.
+type HealthOut struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// HealthCheck is a health check raised by the cluster.
+type HealthCheck struct {
+	Severity string `json:"severity"`
+	Summary  struct {
+		Message string `json:"message"`
+		Count   int    `json:"count"`
+	} `json:"summary"`
+}
+
+// OSDNetworkOut provides a representation for output of
+// `ceph tell mgr dump_osd_network 0 -f json`.
+type OSDNetworkOut struct {
+	Entries []OSDPing `json:"entries"`
+}
+
+// OSDPing holds the heartbeat ping times between two OSDs.
+type OSDPing struct {
+	FromOSD   int    `json:"from osd"`
+	ToOSD     int    `json:"to osd"`
+	Interface string `json:"interface"`
+	Stale     bool   `json:"stale"`
+	Average   struct {
+		OneMin     float64 `json:"1min"`
+		FiveMin    float64 `json:"5min"`
+		FifteenMin float64 `json:"15min"`
+	} `json:"average"`
+}
+
+// OSDPerfOut provides a representation for output of
+// `ceph osd perf -f json`.
+type OSDPerfOut struct {
+	OSDPerfInfos []OSDPerfInfo `json:"osd_perf_infos"`
+}
+
+// OSDPerfInfo holds the latencies reported by a single OSD.
+type OSDPerfInfo struct {
+	ID        int `json:"id"`
+	PerfStats struct {
+		CommitLatencyMS float64 `json:"commit_latency_ms"`
+		ApplyLatencyMS  float64 `json:"apply_latency_ms"`
+	} `json:"perf_stats"`
+}
+
+// CommitLatency returns the commit latency of the OSD.
+func (info OSDPerfInfo) CommitLatency() time.Duration {
+	return time.Duration(info.PerfStats.CommitLatencyMS * float64(time.Millisecond))
+}
+
+// ApplyLatency returns the apply latency of the OSD.
+func (info OSDPerfInfo) ApplyLatency() time.Duration {
+	return time.Duration(info.PerfStats.ApplyLatencyMS * float64(time.Millisecond))
+}
+
+// Latency returns the higher of the commit and apply latency
+// of the OSD.
+func (info OSDPerfInfo) Latency() time.Duration {
+	if commit, apply := info.CommitLatency(), info.ApplyLatency(); commit > apply {
+		return commit
+	}
+	return info.ApplyLatency()
+}
+
+// Latencies returns the latency of every OSD by its ID.
+func (op *OSDPerfOut) Latencies() map[int]time.Duration {
+	latencies := make(map[int]time.Duration, len(op.OSDPerfInfos))
+	for _, info := range op.OSDPerfInfos {
+		latencies[info.ID] = info.Latency()
+	}
+	return latencies
+}
+
+// configOption is an option within the output of
+// `ceph config dump -f json`.
+type configOption struct {
+	Section string `json:"section"`
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	Mask    string `json:"mask"`
+}
+
+// SafeToDestroyOut provides a representation for output of
+// `ceph osd safe-to-destroy -f json`.
+type SafeToDestroyOut struct {
+	SafeToDestroy []int `json:"safe_to_destroy"`
+	Active        []int `json:"active"`
+	MissingStats  []int `json:"missing_stats"`
+	StoredPGs     []int `json:"stored_pgs"`
+}
+
+// Safe reports whether the given OSD can be destroyed.
+func (o *SafeToDestroyOut) Safe(osdID int) bool {
+	for _, id := range o.SafeToDestroy {
+		if id == osdID {
+			return true
+		}
+	}
+	return false
+}
+
+// OKToStopOut provides a representation for output of
+// `ceph osd ok-to-stop -f json`.
+type OKToStopOut struct {
+	OKToStop          bool     `json:"ok_to_stop"`
+	OSDs              []int    `json:"osds"`
+	NumOKPGs          int      `json:"num_ok_pgs"`
+	NumNotOKPGs       int      `json:"num_not_ok_pgs"`
+	BadBecomeInactive []string `json:"bad_become_inactive"`
+	OKBecomeDegraded  []string `json:"ok_become_degraded"`
+}
+
+// BalancerStatusOut provides a representation for output of
+// `ceph balancer status -f json`.
+type BalancerStatusOut struct {
+	Active         bool     `json:"active"`
+	Mode           string   `json:"mode"`
+	OptimizeResult string   `json:"optimize_result"`
+	Plans          []string `json:"plans"`
+
+	// LastOptimizeStarted and LastOptimizeDuration describe the
+	// latest optimization attempt, and are only reported by Nautilus
+	// and later releases.
+	LastOptimizeStarted  string `json:"last_optimize_started"`
+	LastOptimizeDuration string `json:"last_optimize_duration"`
+
+	// NoOptimizationNeeded is only reported by Pacific and later
+	// releases.
+	NoOptimizationNeeded bool `json:"no_optimization_needed"`
+}
+
+// Conflicts reports whether the balancer moves data on its own,
+// competing with manual reweights over the same placements.
+func (bs *BalancerStatusOut) Conflicts() bool {
+	return bs.Active && bs.Mode != "none"
+}
+
+// ClusterStatusOut provides a representation for output of
+// `ceph status -f json`.
+type ClusterStatusOut struct {
+	Health HealthOut `json:"health"`
+	PGMap  struct {
+		NumPGs                  float64        `json:"num_pgs"`
+		DataBytes               float64        `json:"data_bytes"`
+		BytesUsed               float64        `json:"bytes_used"`
+		BytesAvail              float64        `json:"bytes_avail"`
+		BytesTotal              float64        `json:"bytes_total"`
+		MisplacedObjects        float64        `json:"misplaced_objects"`
+		MisplacedTotal          float64        `json:"misplaced_total"`
+		MisplacedRatio          float64        `json:"misplaced_ratio"`
+		DegradedObjects         float64        `json:"degraded_objects"`
+		DegradedTotal           float64        `json:"degraded_total"`
+		DegradedRatio           float64        `json:"degraded_ratio"`
+		RecoveringObjectsPerSec float64        `json:"recovering_objects_per_sec"`
+		RecoveringBytesPerSec   float64        `json:"recovering_bytes_per_sec"`
+		PGsByState              []PGStateCount `json:"pgs_by_state"`
+	} `json:"pgmap"`
+	OSDMap OSDMapSummary `json:"-"`
+}
+
+// PGStateCount is the number of PGs in a given state, e.g.
+// `active+remapped+backfill_wait`.
+type PGStateCount struct {
+	Count  float64 `json:"count"`
+	States string  `json:"state_name"`
+}
+
+// OSDMapSummary summarizes the OSD map within the cluster status.
+type OSDMapSummary struct {
+	Epoch          int `json:"epoch"`
+	NumOSDs        int `json:"num_osds"`
+	NumUpOSDs      int `json:"num_up_osds"`
+	NumInOSDs      int `json:"num_in_osds"`
+	NumRemappedPGs int `json:"num_remapped_pgs"`
+}
+
+// PGsInState counts the PGs in any state containing one of
+// the given ones.
+func (cs *ClusterStatusOut) PGsInState(states ...string) int {
+	var count int
+	for _, p := range cs.PGMap.PGsByState {
+		for _, state := range states {
+			if strings.Contains(p.States, state) {
+				count += int(p.Count)
+			}
+		}
+	}
+
+	return count
+}
+
+// InactivePGs counts the PGs that are either not active or are
+// 'peering' or 'incomplete'.
+func (cs *ClusterStatusOut) InactivePGs() int {
+	var count int
+	for _, p := range cs.PGMap.PGsByState {
+		var active, stuck bool
+		for _, state := range strings.Split(p.States, "+") {
+			switch state {
+			case "active":
+				active = true
+			case "peering", "incomplete":
+				stuck = true
+			}
+		}
+
+		if !active || stuck {
+			count += int(p.Count)
+		}
+	}
+
+	return count
+}
+
+// MisplacedRatio returns the ratio of misplaced objects to the
+// total number of object copies in the cluster.
+func (cs *ClusterStatusOut) MisplacedRatio() float64 {
+	if cs.PGMap.MisplacedRatio > 0 {
+		return cs.PGMap.MisplacedRatio
+	}
+	if cs.PGMap.MisplacedTotal <= 0 {
+		return 0
+	}
+
+	return cs.PGMap.MisplacedObjects / cs.PGMap.MisplacedTotal
+}
+
+// SlowOps returns the number of slow or blocked requests
+// reported by the health checks.
+func (cs *ClusterStatusOut) SlowOps() int {
+	// Nautilus onwards reports SLOW_OPS while older releases used
+	// REQUEST_SLOW/REQUEST_STUCK for the same thing.
+	var count int
+	for _, code := range []string{"SLOW_OPS", "REQUEST_SLOW", "REQUEST_STUCK"} {
+		check, ok := cs.Health.Checks[code]
+		if !ok {
+			continue
+		}
+
+		if check.Summary.Count > 0 {
+			count += check.Summary.Count
+			continue
+		}
+
+		// Releases before Octopus only carry the count in the message,
+		// e.g. "42 slow ops, oldest one blocked for 31 sec, ...".
+		var n int
+		if _, err := fmt.Sscanf(check.Summary.Message, "%d", &n); err == nil {
+			count += n
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,508 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cephclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommand(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+
+	hung := func(context.Context) ([]byte, error) {
+		<-hang
+		return nil, nil
+	}
+
+	c := &client{timeout: 10 * time.Millisecond}
+	_, err := c.command(context.Background(), hung)
+	assert.Equal(t, context.DeadlineExceeded, err, "hung commands should time out")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c = &client{}
+	_, err = c.command(ctx, hung)
+	assert.Equal(t, context.Canceled, err, "hung commands should be cancellable")
+
+	buf, err := c.command(context.Background(), func(context.Context) ([]byte, error) {
+		return []byte("{}"), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("{}"), buf)
+
+	_, err = c.command(context.Background(), func(context.Context) ([]byte, error) {
+		return nil, errors.New("boom")
+	})
+	assert.EqualError(t, err, "boom")
+}
+
+type testErrno int
+
+func (e testErrno) Error() string  { return fmt.Sprintf("errno %d", int(e)) }
+func (e testErrno) ErrorCode() int { return int(e) }
+
+func TestCommandRetries(t *testing.T) {
+	// Calls are counted atomically, as a cancelled command leaves
+	// its attempt running in the background.
+	failing := func(errs ...error) (func(context.Context) ([]byte, error), *int32) {
+		var calls int32
+		return func(context.Context) ([]byte, error) {
+			n := atomic.AddInt32(&calls, 1)
+			if int(n) <= len(errs) {
+				return nil, errs[n-1]
+			}
+			return []byte("{}"), nil
+		}, &calls
+	}
+
+	tests := []struct {
+		name      string
+		retries   int
+		errs      []error
+		wantErr   error
+		wantCalls int
+	}{
+		{
+			name:      "retries disabled",
+			retries:   0,
+			errs:      []error{testErrno(-int(syscall.ETIMEDOUT))},
+			wantErr:   testErrno(-int(syscall.ETIMEDOUT)),
+			wantCalls: 1,
+		},
+		{
+			name:      "transient errors are retried",
+			retries:   3,
+			errs:      []error{testErrno(-int(syscall.ENOTCONN)), testErrno(-int(syscall.EAGAIN))},
+			wantCalls: 3,
+		},
+		{
+			name:      "retries are exhausted",
+			retries:   1,
+			errs:      []error{testErrno(-int(syscall.EAGAIN)), testErrno(-int(syscall.EAGAIN))},
+			wantErr:   testErrno(-int(syscall.EAGAIN)),
+			wantCalls: 2,
+		},
+		{
+			name:      "permanent errors are not retried",
+			retries:   3,
+			errs:      []error{testErrno(-int(syscall.EINVAL))},
+			wantErr:   testErrno(-int(syscall.EINVAL)),
+			wantCalls: 1,
+		},
+		{
+			name:      "unknown errors are not retried",
+			retries:   3,
+			errs:      []error{errors.New("boom")},
+			wantErr:   errors.New("boom"),
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn, calls := failing(tt.errs...)
+			c := &client{retries: tt.retries, backoff: time.Millisecond}
+
+			_, err := c.command(context.Background(), fn)
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, int32(tt.wantCalls), atomic.LoadInt32(calls))
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	fn, calls := failing(testErrno(-int(syscall.EAGAIN)))
+	c := &client{retries: 3, backoff: time.Hour}
+	_, err := c.command(ctx, fn)
+	assert.Error(t, err, "cancelled commands should not be retried")
+	assert.LessOrEqual(t, atomic.LoadInt32(calls), int32(1))
+}
+
+func TestCommandBackoff(t *testing.T) {
+	tests := []struct {
+		backoff time.Duration
+		want    time.Duration
+	}{
+		{backoff: time.Second, want: 2 * time.Second},
+		{backoff: 40 * time.Second, want: maxCommandBackoff},
+		{backoff: maxCommandBackoff, want: maxCommandBackoff},
+		{backoff: time.Hour, want: time.Hour},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, nextBackoff(tt.backoff), "backoff after %s", tt.backoff)
+	}
+
+	for i := 0; i < 100; i++ {
+		d := jitter(time.Second)
+		assert.True(t, d >= time.Second/2 && d <= time.Second, "jittered backoff %s should stay between half and all of the backoff", d)
+	}
+	assert.Equal(t, time.Duration(0), jitter(0))
+}
+
+// testTransport answers commands with canned output, keyed by
+// their prefix.
+type testTransport struct {
+	out  map[string]string
+	cmds []map[string]interface{}
+}
+
+func (t *testTransport) monCommand(ctx context.Context, cmd []byte) ([]byte, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(cmd, &fields); err != nil {
+		return nil, err
+	}
+	t.cmds = append(t.cmds, fields)
+
+	out, ok := t.out[fields["prefix"].(string)]
+	if !ok {
+		return nil, testErrno(-int(syscall.EINVAL))
+	}
+	return []byte(out), nil
+}
+
+func (t *testTransport) mgrCommand(ctx context.Context, cmd []byte) ([]byte, error) {
+	return t.monCommand(ctx, cmd)
+}
+
+func (t *testTransport) close() {}
+
+func TestOSDDF(t *testing.T) {
+	tr := &testTransport{out: map[string]string{
+		"osd df": `{
+			"nodes": [
+				{"id": -1, "name": "default", "type": "root", "reweight": -1, "kb": 300, "kb_used": 90,
+				 "kb_avail": 210, "utilization": 30, "var": 1, "pgs": 0, "children": [-2]},
+				{"id": -2, "name": "host-a", "type": "host", "reweight": -1, "kb": 300, "kb_used": 90,
+				 "kb_avail": 210, "utilization": 30, "var": 1, "pgs": 0, "children": [0]},
+				{"id": 0, "device_class": "hdd", "name": "osd.0", "type": "osd", "crush_weight": 0.5,
+				 "reweight": 1, "kb": 300, "kb_used": 90, "kb_avail": 210, "utilization": 30, "var": 1,
+				 "pgs": 64, "status": "up"}
+			],
+			"stray": [],
+			"summary": {"total_kb": 300, "total_kb_used": 90, "total_kb_avail": 210,
+				"average_utilization": 30, "min_var": 1, "max_var": 1, "dev": 0}
+		}`,
+	}}
+	c := &client{transport: tr}
+
+	out, err := c.OSDDF(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "tree", tr.cmds[0]["output_method"])
+	assert.Len(t, out.Nodes, 3)
+	assert.Equal(t, OSDDFNode{
+		ID: 0, Name: "osd.0", Type: "osd", DeviceClass: "hdd", Status: "up", Reweight: 1, CrushWeight: 0.5,
+		KB: 300, KBUsed: 90, KBAvail: 210, Utilization: 30, Var: 1, PGs: 64,
+	}, out.Nodes[2])
+	assert.Equal(t, []int{-2}, out.Nodes[0].Children)
+	assert.Equal(t, int64(300), out.Summary.TotalKB)
+	assert.Equal(t, 30.0, out.Summary.AverageUtilization)
+}
+
+func TestPGStats(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+	}{
+		{
+			name: "luminous",
+			out: `{"num_pg_by_state": [{"name": "active+clean", "num": 120},
+				{"name": "active+remapped+backfill_wait", "num": 8}],
+				"num_pgs": 128, "num_bytes": 4096, "misplaced_objects": 10, "misplaced_total": 100,
+				"misplaced_ratio": 0.1, "recovering_bytes_per_sec": 2048}`,
+		},
+		{
+			name: "nautilus",
+			out: `{"pg_ready": true, "pg_summary": {"num_pg_by_state": [{"name": "active+clean", "num": 120},
+				{"name": "active+remapped+backfill_wait", "num": 8}],
+				"num_pgs": 128, "num_bytes": 4096, "misplaced_objects": 10, "misplaced_total": 100,
+				"misplaced_ratio": 0.1, "recovering_bytes_per_sec": 2048}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &client{transport: &testTransport{out: map[string]string{"pg stat": tt.out}}}
+
+			out, err := c.PGStats(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 128, out.NumPGs)
+			assert.Equal(t, int64(4096), out.NumBytes)
+			assert.Equal(t, int64(10), out.MisplacedObjects)
+			assert.Equal(t, 0.1, out.MisplacedRatio)
+			assert.Equal(t, 2048.0, out.RecoveringBytesPerSec)
+			assert.Len(t, out.NumPGsByState, 2)
+			assert.Equal(t, "active+remapped+backfill_wait", out.NumPGsByState[1].Name)
+			assert.Equal(t, 8, out.NumPGsByState[1].Num)
+		})
+	}
+}
+
+func TestPGStatPool(t *testing.T) {
+	pool, err := PGStat{PGID: "12.3f"}.Pool()
+	assert.NoError(t, err)
+	assert.Equal(t, 12, pool)
+
+	_, err = PGStat{PGID: "bogus"}.Pool()
+	assert.Error(t, err)
+}
+
+func TestOSDPerf(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+	}{
+		{
+			name: "luminous",
+			out: `{"osd_perf_infos": [{"id": 1, "perf_stats": {"commit_latency_ms": 12, "apply_latency_ms": 3}},
+				{"id": 2, "perf_stats": {"commit_latency_ms": 1, "apply_latency_ms": 0.5}}]}`,
+		},
+		{
+			name: "nautilus",
+			out: `{"osdstats": {"osd_perf_infos": [{"id": 1, "perf_stats": {"commit_latency_ms": 12, "apply_latency_ms": 3}},
+				{"id": 2, "perf_stats": {"commit_latency_ms": 1, "apply_latency_ms": 0.5}}]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &client{transport: &testTransport{out: map[string]string{"osd perf": tt.out}}}
+
+			out, err := c.OSDPerf(context.Background())
+			assert.NoError(t, err)
+			assert.Len(t, out.OSDPerfInfos, 2)
+			assert.Equal(t, 12*time.Millisecond, out.OSDPerfInfos[0].CommitLatency())
+			assert.Equal(t, 3*time.Millisecond, out.OSDPerfInfos[0].ApplyLatency())
+			assert.Equal(t, map[int]time.Duration{
+				1: 12 * time.Millisecond,
+				2: time.Millisecond,
+			}, out.Latencies())
+		})
+	}
+}
+
+func TestClusterStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+	}{
+		{
+			name: "nautilus",
+			out: `{"health": {"status": "HEALTH_WARN", "checks": {"SLOW_OPS": {"severity": "HEALTH_WARN",
+				"summary": {"message": "12 slow ops, oldest one blocked for 31 sec"}}}},
+				"osdmap": {"osdmap": {"epoch": 42, "num_osds": 3, "num_up_osds": 3, "num_in_osds": 2, "num_remapped_pgs": 5}},
+				"pgmap": {"num_pgs": 128, "misplaced_objects": 10, "misplaced_total": 100,
+				"pgs_by_state": [{"state_name": "active+clean", "count": 100},
+				{"state_name": "active+remapped+backfill_wait", "count": 20},
+				{"state_name": "active+remapped+backfilling", "count": 5},
+				{"state_name": "peering", "count": 3}]}}`,
+		},
+		{
+			name: "octopus",
+			out: `{"health": {"status": "HEALTH_WARN", "checks": {"SLOW_OPS": {"severity": "HEALTH_WARN",
+				"summary": {"message": "12 slow ops, oldest one blocked for 31 sec", "count": 12}}}},
+				"osdmap": {"epoch": 42, "num_osds": 3, "num_up_osds": 3, "num_in_osds": 2, "num_remapped_pgs": 5},
+				"pgmap": {"num_pgs": 128, "misplaced_objects": 10, "misplaced_total": 100, "misplaced_ratio": 0.1,
+				"pgs_by_state": [{"state_name": "active+clean", "count": 100},
+				{"state_name": "active+remapped+backfill_wait", "count": 20},
+				{"state_name": "active+remapped+backfilling", "count": 5},
+				{"state_name": "peering", "count": 3}]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &testTransport{out: map[string]string{"status": tt.out}}
+			c := &client{transport: tr}
+
+			cs, err := c.ClusterStatus(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, "HEALTH_WARN", cs.Health.Status)
+			assert.Equal(t, OSDMapSummary{Epoch: 42, NumOSDs: 3, NumUpOSDs: 3, NumInOSDs: 2, NumRemappedPGs: 5}, cs.OSDMap)
+			assert.Equal(t, 25, cs.PGsInState("backfilling", "backfill_wait"))
+			assert.Equal(t, 3, cs.InactivePGs())
+			assert.Equal(t, 0.1, cs.MisplacedRatio())
+			assert.Equal(t, 12, cs.SlowOps())
+
+			bpgs, err := c.BackfillingPGs(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, 25, bpgs)
+		})
+	}
+}
+
+func TestSetFlag(t *testing.T) {
+	tr := &testTransport{out: map[string]string{"osd set": "", "osd unset": ""}}
+	c := &client{transport: tr}
+
+	assert.NoError(t, c.SetFlag(context.Background(), "noout"))
+	assert.NoError(t, c.UnsetFlag(context.Background(), "norebalance"))
+	assert.Equal(t, []map[string]interface{}{
+		{"prefix": "osd set", "key": "noout"},
+		{"prefix": "osd unset", "key": "norebalance"},
+	}, tr.cmds)
+}
+
+func TestConfig(t *testing.T) {
+	tr := &testTransport{out: map[string]string{
+		"config dump": `[
+			{"section": "global", "name": "osd_max_backfills", "value": "4", "mask": ""},
+			{"section": "osd", "name": "osd_max_backfills", "value": "2", "mask": "host:node1"},
+			{"section": "osd", "name": "osd_recovery_max_active", "value": "3", "mask": ""}
+		]`,
+		"config set": "",
+		"config rm":  "",
+	}}
+	c := &client{transport: tr}
+
+	val, ok, err := c.ConfigGet(context.Background(), "osd", "osd_recovery_max_active")
+	assert.NoError(t, err)
+	assert.True(t, ok, "option should be found")
+	assert.Equal(t, "3", val)
+
+	// Neither options of other sections nor masked ones apply.
+	_, ok, err = c.ConfigGet(context.Background(), "osd", "osd_max_backfills")
+	assert.NoError(t, err)
+	assert.False(t, ok, "option should not be found")
+
+	tr.cmds = nil
+	assert.NoError(t, c.ConfigSet(context.Background(), "osd", "osd_max_backfills", "8"))
+	assert.NoError(t, c.ConfigRemove(context.Background(), "osd", "osd_max_backfills"))
+	assert.Equal(t, []map[string]interface{}{
+		{"prefix": "config set", "who": "osd", "name": "osd_max_backfills", "value": "8"},
+		{"prefix": "config rm", "who": "osd", "name": "osd_max_backfills"},
+	}, tr.cmds)
+}
+
+func TestToggleCephBalancer(t *testing.T) {
+	tr := &testTransport{out: map[string]string{"balancer on": "", "balancer off": ""}}
+	c := &client{transport: tr}
+
+	assert.NoError(t, c.DisableCephBalancer(context.Background()))
+	assert.NoError(t, c.EnableCephBalancer(context.Background()))
+	assert.Equal(t, []map[string]interface{}{
+		{"prefix": "balancer off"},
+		{"prefix": "balancer on"},
+	}, tr.cmds)
+}
+
+func TestBalancerStatus(t *testing.T) {
+	tr := &testTransport{out: map[string]string{
+		"balancer status": `{
+			"active": true,
+			"last_optimize_duration": "0:00:00.001174",
+			"last_optimize_started": "Wed Oct 13 11:30:39 2021",
+			"mode": "upmap",
+			"no_optimization_needed": true,
+			"optimize_result": "Unable to find further optimization",
+			"plans": ["auto_2021-10-13_11:30:39"]
+		}`,
+	}}
+	c := &client{transport: tr}
+
+	bs, err := c.BalancerStatus(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, &BalancerStatusOut{
+		Active:               true,
+		Mode:                 "upmap",
+		OptimizeResult:       "Unable to find further optimization",
+		Plans:                []string{"auto_2021-10-13_11:30:39"},
+		LastOptimizeStarted:  "Wed Oct 13 11:30:39 2021",
+		LastOptimizeDuration: "0:00:00.001174",
+		NoOptimizationNeeded: true,
+	}, bs)
+	assert.True(t, bs.Conflicts(), "active balancer should conflict")
+
+	bs.Mode = "none"
+	assert.False(t, bs.Conflicts(), "balancer without a mode should not conflict")
+}
+
+func TestSafeToDestroy(t *testing.T) {
+	tr := &testTransport{out: map[string]string{
+		"osd safe-to-destroy": `{"safe_to_destroy": [1], "active": [], "missing_stats": [], "stored_pgs": [2]}`,
+	}}
+	c := &client{transport: tr}
+
+	out, err := c.SafeToDestroy(context.Background(), []int{1, 2})
+	assert.NoError(t, err)
+	assert.True(t, out.Safe(1), "osd.1 should be safe to destroy")
+	assert.False(t, out.Safe(2), "osd.2 should not be safe to destroy")
+	assert.Equal(t, []interface{}{"1", "2"}, tr.cmds[0]["ids"])
+
+	// Nautilus fails the command instead when any osd isn't safe.
+	tr.out = map[string]string{}
+	c.transport = &errnoTransport{testTransport: tr, errno: syscall.EBUSY}
+	c.retries = 3
+	out, err = c.SafeToDestroy(context.Background(), []int{1, 2})
+	assert.NoError(t, err)
+	assert.False(t, out.Safe(1), "no osd should be safe to destroy")
+	assert.Len(t, tr.cmds, 2, "command should not be retried")
+}
+
+// errnoTransport fails every command with the given errno.
+type errnoTransport struct {
+	*testTransport
+	errno syscall.Errno
+}
+
+func (t *errnoTransport) mgrCommand(ctx context.Context, cmd []byte) ([]byte, error) {
+	t.testTransport.mgrCommand(ctx, cmd)
+	return nil, testErrno(-int(t.errno))
+}
+
+func TestOKToStop(t *testing.T) {
+	tr := &testTransport{out: map[string]string{
+		"osd ok-to-stop": `{"ok_to_stop": true, "osds": [1], "num_ok_pgs": 12, "num_not_ok_pgs": 0}`,
+	}}
+	c := &client{transport: tr}
+
+	out, err := c.OKToStop(context.Background(), []int{1})
+	assert.NoError(t, err)
+	assert.Equal(t, &OKToStopOut{OKToStop: true, OSDs: []int{1}, NumOKPGs: 12}, out)
+
+	// Releases before Octopus don't report anything on success.
+	tr.out["osd ok-to-stop"] = ""
+	out, err = c.OKToStop(context.Background(), []int{1})
+	assert.NoError(t, err)
+	assert.True(t, out.OKToStop, "osd should be ok to stop")
+
+	tr.cmds = nil
+	c.transport = &errnoTransport{testTransport: tr, errno: syscall.EBUSY}
+	c.retries = 3
+	out, err = c.OKToStop(context.Background(), []int{1})
+	assert.NoError(t, err)
+	assert.False(t, out.OKToStop, "osd should not be ok to stop")
+	assert.Len(t, tr.cmds, 1, "command should not be retried")
+}
+
+func TestTimeoutOptions(t *testing.T) {
+	c := &client{}
+	assert.Empty(t, c.timeoutOptions(), "no timeouts should keep the librados defaults")
+
+	c = &client{connectTimeout: 30 * time.Second, timeout: 1500 * time.Millisecond}
+	assert.Equal(t, [][2]string{
+		{"client_mount_timeout", "30"},
+		{"rados_mon_op_timeout", "2"},
+	}, c.timeoutOptions())
+}
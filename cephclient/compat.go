@@ -0,0 +1,79 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cephclient
+
+import (
+	"encoding/json"
+)
+
+// The decoders below accept the outputs of every supported release
+// whose layout changed over time. The layout is told apart by the
+// output itself rather than by the release detected on connect, since
+// mons and mgrs run different releases in the middle of an upgrade.
+
+// decodeClusterStatus decodes the output of `ceph status`. Releases
+// before Octopus nest the OSD map summary once more.
+func decodeClusterStatus(buf []byte) (*ClusterStatusOut, error) {
+	cs := &struct {
+		ClusterStatusOut
+		OSDMap struct {
+			OSDMapSummary
+			OSDMap *OSDMapSummary `json:"osdmap"`
+		} `json:"osdmap"`
+	}{}
+	if err := json.Unmarshal(buf, cs); err != nil {
+		return nil, err
+	}
+
+	cs.ClusterStatusOut.OSDMap = cs.OSDMap.OSDMapSummary
+	if cs.OSDMap.OSDMap != nil {
+		cs.ClusterStatusOut.OSDMap = *cs.OSDMap.OSDMap
+	}
+	return &cs.ClusterStatusOut, nil
+}
+
+// decodePGStats decodes the output of `ceph pg stat`. Nautilus moved
+// the stats underneath `pg_summary`.
+func decodePGStats(buf []byte) (*PGStatsOut, error) {
+	ps := &struct {
+		PGStatsOut
+		PGSummary *PGStatsOut `json:"pg_summary"`
+	}{}
+	if err := json.Unmarshal(buf, ps); err != nil {
+		return nil, err
+	}
+
+	if ps.PGSummary != nil {
+		return ps.PGSummary, nil
+	}
+	return &ps.PGStatsOut, nil
+}
+
+// decodeOSDPerf decodes the output of `ceph osd perf`. Nautilus moved
+// the perf infos underneath `osdstats`.
+func decodeOSDPerf(buf []byte) (*OSDPerfOut, error) {
+	op := &struct {
+		OSDPerfOut
+		OSDStats OSDPerfOut `json:"osdstats"`
+	}{}
+	if err := json.Unmarshal(buf, op); err != nil {
+		return nil, err
+	}
+
+	if len(op.OSDPerfInfos) == 0 {
+		return &op.OSDStats, nil
+	}
+	return &op.OSDPerfOut, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cephclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+var (
+	// ErrOSDNotFound classifies errors about OSDs that do not exist,
+	// e.g. a command rejected by the mons for an unknown OSD.
+	ErrOSDNotFound = errors.New("osd not found")
+
+	// ErrMonCommand classifies errors of mon and mgr commands. Use
+	// errors.As with a *CommandError for the details.
+	ErrMonCommand = errors.New("ceph command failed")
+)
+
+// CommandError is returned by the ceph client when a mon or mgr
+// command fails. It matches ErrMonCommand, and ErrOSDNotFound when
+// an OSD command failed with ENOENT.
+type CommandError struct {
+	// Prefix is the prefix of the failed command, e.g. "osd out".
+	Prefix string
+	Err    error
+}
+
+func newCommandError(cmd []byte, err error) *CommandError {
+	var c struct {
+		Prefix string `json:"prefix"`
+	}
+	json.Unmarshal(cmd, &c)
+	return &CommandError{Prefix: c.Prefix, Err: err}
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Prefix, e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CommandError) Is(target error) bool {
+	switch target {
+	case ErrMonCommand:
+		return true
+	case ErrOSDNotFound:
+		coded, ok := e.Err.(interface{ ErrorCode() int })
+		return ok && syscall.Errno(-coded.ErrorCode()) == syscall.ENOENT && strings.HasPrefix(e.Prefix, "osd ")
+	}
+	return false
+}
+
+// Temporary reports whether the command is worth retrying.
+func (e *CommandError) Temporary() bool {
+	return isTransient(e.Err)
+}
+
+// IsTransient reports whether err is likely to go away on its own,
+// so that retrying, e.g. running again later, may succeed. Commands
+// that timed out or could not reach the cluster are transient, while
+// rejected commands are not.
+func IsTransient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var cerr *CommandError
+	return errors.As(err, &cerr) && cerr.Temporary()
+}
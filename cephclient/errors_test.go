@@ -0,0 +1,49 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cephclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandError(t *testing.T) {
+	tr := &testTransport{out: map[string]string{}}
+	c := &client{transport: tr}
+
+	// Unknown prefixes fail with EINVAL.
+	err := c.CrushReweight(context.Background(), 1, 1)
+	var cerr *CommandError
+	assert.True(t, errors.As(err, &cerr))
+	assert.Equal(t, "osd crush reweight", cerr.Prefix)
+	assert.True(t, errors.Is(err, ErrMonCommand))
+	assert.False(t, errors.Is(err, ErrOSDNotFound))
+	assert.False(t, IsTransient(err))
+
+	c.transport = &errnoTransport{testTransport: tr, errno: syscall.ENOENT}
+	_, err = c.OKToStop(context.Background(), []int{1})
+	assert.True(t, errors.Is(err, ErrOSDNotFound), "enoent for an osd command should mean a missing osd")
+
+	c.transport = &errnoTransport{testTransport: tr, errno: syscall.ETIMEDOUT}
+	_, err = c.OKToStop(context.Background(), []int{1})
+	assert.True(t, errors.Is(err, ErrMonCommand))
+	assert.True(t, IsTransient(err))
+	assert.True(t, IsTransient(fmt.Errorf("cannot check the osds: %w", err)), "wrapped errors should be classified too")
+}
@@ -0,0 +1,233 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cephclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// execTransport issues commands by running the ceph CLI, for
+// hosts lacking librados.
+type execTransport struct {
+	binary string
+	// args are passed to every invocation ahead of the command.
+	args []string
+	// env is added to the environment of every invocation.
+	env []string
+}
+
+// execPositionalArgs lists, per command prefix, the arguments
+// the ceph CLI takes positionally, in order. Any argument not
+// listed here other than the format is rejected.
+var execPositionalArgs = map[string][]string{
+	"osd df":                     {"output_method"},
+	"pg dump":                    {"dumpcontents"},
+	"dump_osd_network":           {"value"},
+	"osd crush reweight":         {"name", "weight"},
+	"osd crush reweight-subtree": {"name", "weight"},
+	"config set":                 {"who", "name", "value"},
+	"config rm":                  {"who", "name"},
+	"osd ok-to-stop":             {"ids"},
+	"osd out":                    {"ids"},
+	"osd primary-affinity":       {"id", "weight"},
+	"osd crush remove":           {"name"},
+	"osd tree-from":              {"bucket"},
+	"osd purge":                  {"id"},
+	"osd safe-to-destroy":        {"ids"},
+	"osd set":                    {"key"},
+	"osd unset":                  {"key"},
+}
+
+// execError is returned when the ceph CLI exits unsuccessfully,
+// which it does with the errno of the failed command.
+type execError struct {
+	status int
+	stderr string
+}
+
+func (e *execError) Error() string {
+	return fmt.Sprintf("ceph exited with status %d: %s", e.status, e.stderr)
+}
+
+// ErrorCode mirrors librados by returning the negated errno.
+func (e *execError) ErrorCode() int {
+	return -e.status
+}
+
+func (t *execTransport) monCommand(ctx context.Context, cmd []byte) ([]byte, error) {
+	args, err := execArgs(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.run(ctx, args)
+}
+
+func (t *execTransport) mgrCommand(ctx context.Context, cmd []byte) ([]byte, error) {
+	args, err := execArgs(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.run(ctx, append([]string{"tell", "mgr"}, args...))
+}
+
+func (t *execTransport) run(ctx context.Context, args []string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, t.binary, append(append([]string{}, t.args...), args...)...)
+	if len(t.env) > 0 {
+		cmd.Env = append(os.Environ(), t.env...)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return nil, &execError{
+			status: exitErr.ExitCode(),
+			stderr: strings.TrimSpace(stderr.String()),
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (t *execTransport) close() {}
+
+// execArgs translates a JSON mon or mgr command into the
+// arguments of the equivalent ceph CLI invocation.
+func execArgs(cmd []byte) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(cmd))
+	dec.UseNumber()
+
+	var fields map[string]interface{}
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+
+	prefix, ok := fields["prefix"].(string)
+	if !ok {
+		return nil, fmt.Errorf("command without prefix: %s", cmd)
+	}
+	delete(fields, "prefix")
+	args := strings.Fields(prefix)
+
+	for _, name := range execPositionalArgs[prefix] {
+		val, ok := fields[name]
+		if !ok {
+			continue
+		}
+		delete(fields, name)
+
+		switch v := val.(type) {
+		case []interface{}:
+			for _, elem := range v {
+				args = append(args, fmt.Sprint(elem))
+			}
+		default:
+			args = append(args, fmt.Sprint(v))
+		}
+	}
+
+	if format, ok := fields["format"]; ok {
+		delete(fields, "format")
+		args = append(args, "--format", fmt.Sprint(format))
+	}
+
+	// Boolean arguments, e.g. `yes_i_really_mean_it`, are flags on
+	// the command line.
+	var flags []string
+	for name, val := range fields {
+		if set, ok := val.(bool); ok {
+			delete(fields, name)
+			if set {
+				flags = append(flags, "--"+strings.Replace(name, "_", "-", -1))
+			}
+		}
+	}
+	sort.Strings(flags)
+	args = append(args, flags...)
+
+	for name := range fields {
+		return nil, fmt.Errorf("unsupported argument %q for %q", name, prefix)
+	}
+
+	return args, nil
+}
+
+// NewExec returns a client running the given ceph
+// binary as the given Ceph user for every call, rather than
+// linking against librados.
+func NewExec(binary, user, configPath string, opt ...Option) (Client, error) {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find ceph binary %q: %s", binary, err)
+	}
+
+	cluster, err := clusterName(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &client{
+		backoff: time.Second,
+	}
+	for _, fn := range opt {
+		fn(c)
+	}
+
+	args := []string{"--cluster", cluster, "--id", user}
+	if configPath != "" {
+		args = append(args, "--conf", configPath)
+	}
+	if c.connectTimeout > 0 {
+		args = append(args, "--connect-timeout", timeoutSeconds(c.connectTimeout))
+	}
+	// The key is handed over through the environment, as arguments
+	// are visible to every user on the host.
+	var env []string
+	for _, opt := range c.connOptions() {
+		if opt[0] == "key" {
+			env = append(env, "CEPH_ARGS=--key="+opt[1])
+			continue
+		}
+		args = append(args, "--"+opt[0], opt[1])
+	}
+	c.transport = &execTransport{
+		binary: path,
+		args:   args,
+		env:    env,
+	}
+
+	if err := c.checkRelease(context.Background()); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cephclient
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "plain command",
+			cmd:  `{"prefix":"osd tree","format":"json"}`,
+			want: []string{"osd", "tree", "--format", "json"},
+		},
+		{
+			name: "list argument",
+			cmd:  `{"prefix":"pg dump","dumpcontents":["pgs"],"format":"json"}`,
+			want: []string{"pg", "dump", "pgs", "--format", "json"},
+		},
+		{
+			name: "ordered arguments",
+			cmd:  `{"prefix":"osd crush reweight","weight":1.02,"name":"osd.3"}`,
+			want: []string{"osd", "crush", "reweight", "osd.3", "1.02"},
+		},
+		{
+			name: "output method",
+			cmd:  `{"prefix":"osd df","output_method":"tree","format":"json"}`,
+			want: []string{"osd", "df", "tree", "--format", "json"},
+		},
+		{
+			name: "flag argument",
+			cmd:  `{"prefix":"osd purge","id":"osd.3","yes_i_really_mean_it":true}`,
+			want: []string{"osd", "purge", "osd.3", "--yes-i-really-mean-it"},
+		},
+		{
+			name:    "unsupported argument",
+			cmd:     `{"prefix":"osd tree","states":["up"]}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing prefix",
+			cmd:     `{"format":"json"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := execArgs([]byte(tt.cmd))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestExecTransport(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "ceph")
+	script := `#!/bin/sh
+case "$*" in
+*fail*) echo "mon unavailable" >&2; exit 11 ;;
+*) echo "$*" ;;
+esac
+`
+	assert.NoError(t, ioutil.WriteFile(binary, []byte(script), 0755))
+
+	tr := &execTransport{binary: binary, args: []string{"--id", "admin"}}
+
+	out, err := tr.monCommand(context.Background(), []byte(`{"prefix":"osd tree","format":"json"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "--id admin osd tree --format json\n", string(out))
+
+	out, err = tr.mgrCommand(context.Background(), []byte(`{"prefix":"balancer status","format":"json"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "--id admin tell mgr balancer status --format json\n", string(out))
+
+	_, err = tr.monCommand(context.Background(), []byte(`{"prefix":"fail"}`))
+	assert.EqualError(t, err, "ceph exited with status 11: mon unavailable")
+	assert.True(t, isTransient(err), "errno exit statuses should be classified like librados errors")
+}
+
+func TestNewExecCephClient(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "ceph")
+	args := filepath.Join(dir, "args")
+	script := `#!/bin/sh
+echo "$CEPH_ARGS $*" > ` + args + `
+echo '{"version": "ceph version 16.2.7 (dd0603118f56ab514f133c8d2e3adfc983942503) pacific (stable)"}'
+`
+	assert.NoError(t, ioutil.WriteFile(binary, []byte(script), 0755))
+
+	c, err := NewExec(binary, "admin", "",
+		WithMonHost("10.0.0.1,10.0.0.2"),
+		WithKey("AQBsecret=="),
+	)
+	assert.NoError(t, err)
+	defer c.Close()
+
+	got, err := ioutil.ReadFile(args)
+	assert.NoError(t, err)
+	assert.Equal(t, "--key=AQBsecret== --cluster ceph --id admin --mon_host 10.0.0.1,10.0.0.2 version --format json\n", string(got),
+		"key should be passed through the environment")
+}
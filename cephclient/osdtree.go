@@ -0,0 +1,110 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cephclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// decodeOSDTree decodes the output of `ceph osd tree` one node at a
+// time. Buckets are always kept, whereas OSDs are only kept when
+// `keep` reports true for their ID, or when `keep` is nil. On clusters
+// with thousands of OSDs this saves holding on to every OSD node when
+// only a handful of them are being reweighted.
+func decodeOSDTree(r io.Reader, keep func(id int) bool) (*OSDTreeOut, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	out := &OSDTreeOut{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok {
+		case "nodes":
+			out.Nodes, err = decodeOSDTreeNodes(dec, keep)
+		case "stray":
+			out.Stray, err = decodeOSDTreeNodes(dec, keep)
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// decodeOSDTreeNodes decodes a list of tree nodes, reusing a single
+// node for the OSDs that are dropped.
+func decodeOSDTreeNodes(dec *json.Decoder, keep func(id int) bool) ([]OSDTreeNode, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok == nil {
+		return nil, nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return nil, fmt.Errorf("unexpected token %v, want [", tok)
+	}
+
+	var nodes []OSDTreeNode
+	var node OSDTreeNode
+	for dec.More() {
+		node = OSDTreeNode{Children: node.Children[:0]}
+		if err := dec.Decode(&node); err != nil {
+			return nil, err
+		}
+		if node.Type == "osd" && keep != nil && !keep(node.ID) {
+			continue
+		}
+
+		kept := node
+		kept.Children = nil
+		if len(node.Children) > 0 {
+			kept.Children = append([]int(nil), node.Children...)
+		}
+		nodes = append(nodes, kept)
+	}
+
+	if err := expectDelim(dec, ']'); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// expectDelim reads the next token and fails unless it is `want`.
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("unexpected token %v, want %s", tok, want)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cephclient
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const osdTreeJSON = `{
+  "nodes": [
+    {"id": -1, "name": "default", "type": "root", "type_id": 11, "children": [-3, -5]},
+    {"id": -3, "name": "host-a", "type": "host", "type_id": 1, "pool_weights": {}, "children": [1, 0]},
+    {"id": 0, "device_class": "hdd", "name": "osd.0", "type": "osd", "type_id": 0, "crush_weight": 1.5, "depth": 2, "pool_weights": {}, "exists": 1, "status": "up", "reweight": 1, "primary_affinity": 1},
+    {"id": 1, "device_class": "hdd", "name": "osd.1", "type": "osd", "type_id": 0, "crush_weight": 0.5, "depth": 2, "pool_weights": {}, "exists": 1, "status": "up", "reweight": 1, "primary_affinity": 1},
+    {"id": -5, "name": "host-b", "type": "host", "type_id": 1, "pool_weights": {}, "children": [2]},
+    {"id": 2, "device_class": "hdd", "name": "osd.2", "type": "osd", "type_id": 0, "crush_weight": 2, "depth": 2, "pool_weights": {}, "exists": 1, "status": "down", "reweight": 0, "primary_affinity": 1}
+  ],
+  "stray": [
+    {"id": 3, "name": "osd.3", "type": "osd", "type_id": 0, "crush_weight": 0, "depth": 0, "exists": 1, "status": "down", "reweight": 0, "primary_affinity": 1}
+  ]
+}`
+
+func TestDecodeOSDTree(t *testing.T) {
+	root := OSDTreeNode{ID: -1, Name: "default", Type: "root", Children: []int{-3, -5}}
+	hostA := OSDTreeNode{ID: -3, Name: "host-a", Type: "host", Children: []int{1, 0}}
+	hostB := OSDTreeNode{ID: -5, Name: "host-b", Type: "host", Children: []int{2}}
+	osd0 := OSDTreeNode{ID: 0, Name: "osd.0", Type: "osd", Status: "up", Reweight: 1, CrushWeight: 1.5}
+	osd1 := OSDTreeNode{ID: 1, Name: "osd.1", Type: "osd", Status: "up", Reweight: 1, CrushWeight: 0.5}
+	osd2 := OSDTreeNode{ID: 2, Name: "osd.2", Type: "osd", Status: "down", CrushWeight: 2}
+	osd3 := OSDTreeNode{ID: 3, Name: "osd.3", Type: "osd", Status: "down"}
+
+	tests := []struct {
+		name    string
+		json    string
+		keep    func(id int) bool
+		want    *OSDTreeOut
+		wantErr bool
+	}{
+		{
+			name: "everything",
+			json: osdTreeJSON,
+			want: &OSDTreeOut{
+				Nodes: []OSDTreeNode{root, hostA, osd0, osd1, hostB, osd2},
+				Stray: []OSDTreeNode{osd3},
+			},
+		},
+		{
+			name: "only some osds",
+			json: osdTreeJSON,
+			keep: func(id int) bool { return id == 1 || id == 3 },
+			want: &OSDTreeOut{
+				Nodes: []OSDTreeNode{root, hostA, osd1, hostB},
+				Stray: []OSDTreeNode{osd3},
+			},
+		},
+		{
+			name: "no stray",
+			json: `{"nodes": [{"id": -1, "name": "default", "type": "root"}], "stray": null}`,
+			keep: func(id int) bool { return false },
+			want: &OSDTreeOut{
+				Nodes: []OSDTreeNode{{ID: -1, Name: "default", Type: "root"}},
+			},
+		},
+		{
+			name:    "truncated",
+			json:    osdTreeJSON[:200],
+			wantErr: true,
+		},
+		{
+			name:    "not an object",
+			json:    `[]`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeOSDTree(strings.NewReader(tt.json), tt.keep)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
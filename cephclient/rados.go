@@ -0,0 +1,227 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build cgo
+// +build cgo
+
+package cephclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+	log "github.com/sirupsen/logrus"
+)
+
+// radosTransport issues commands through librados.
+type radosTransport struct {
+	mu   sync.Mutex
+	conn *rados.Conn
+
+	// dial establishes a new connection to replace one that was
+	// lost, no sooner than redialAt.
+	dial          func() (*rados.Conn, error)
+	redialAt      time.Time
+	redialBackoff time.Duration
+
+	logger log.FieldLogger
+}
+
+func (t *radosTransport) log() log.FieldLogger {
+	if t.logger == nil {
+		return log.StandardLogger()
+	}
+	return t.logger
+}
+
+// The delay between attempts to re-establish a lost connection
+// doubles from minRedialBackoff up to maxRedialBackoff.
+const (
+	minRedialBackoff = time.Second
+	maxRedialBackoff = 5 * time.Minute
+)
+
+func (t *radosTransport) monCommand(ctx context.Context, cmd []byte) ([]byte, error) {
+	return t.withConn(func(conn *rados.Conn) ([]byte, string, error) {
+		return conn.MonCommand(cmd)
+	})
+}
+
+func (t *radosTransport) mgrCommand(ctx context.Context, cmd []byte) ([]byte, error) {
+	return t.withConn(func(conn *rados.Conn) ([]byte, string, error) {
+		return conn.MgrCommand([][]byte{cmd})
+	})
+}
+
+// withConn calls fn with the current connection, reconnecting
+// first if it was lost. A connection that fn finds to be lost
+// is dropped, to be re-established by the next command.
+func (t *radosTransport) withConn(fn func(*rados.Conn) ([]byte, string, error)) ([]byte, error) {
+	conn, err := t.getConn()
+	if err != nil {
+		return nil, err
+	}
+
+	buf, _, err := fn(conn)
+	if isConnectionLost(err) {
+		t.dropConn(conn, err)
+		return nil, errNotConnected
+	}
+	return buf, err
+}
+
+func (t *radosTransport) getConn() (*rados.Conn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn != nil {
+		return t.conn, nil
+	}
+	if t.dial == nil || time.Now().Before(t.redialAt) {
+		return nil, errNotConnected
+	}
+
+	conn, err := t.dial()
+	if err != nil {
+		t.redialBackoff *= 2
+		if t.redialBackoff < minRedialBackoff {
+			t.redialBackoff = minRedialBackoff
+		}
+		if t.redialBackoff > maxRedialBackoff {
+			t.redialBackoff = maxRedialBackoff
+		}
+		t.redialAt = time.Now().Add(t.redialBackoff)
+
+		t.log().WithField("backoff", t.redialBackoff).Warnf("failed reconnecting to cluster: %s", err)
+		return nil, errNotConnected
+	}
+
+	t.log().Info("reconnected to cluster")
+	t.conn = conn
+	t.redialBackoff = 0
+	return conn, nil
+}
+
+func (t *radosTransport) dropConn(conn *rados.Conn, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Another command may have dropped or replaced it already.
+	if t.conn != conn {
+		return
+	}
+
+	t.log().Warnf("lost connection to cluster: %s", err)
+	t.conn = nil
+	// Shutting down a broken connection may block for as long
+	// as the mons are unreachable.
+	go conn.Shutdown()
+}
+
+func (t *radosTransport) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn != nil {
+		t.conn.Shutdown()
+		t.conn = nil
+	}
+	t.dial = nil
+}
+
+// isConnectionLost reports whether a command failed because
+// the connection to the cluster is no longer usable.
+func isConnectionLost(err error) bool {
+	if err == rados.ErrNotConnected {
+		return true
+	}
+
+	coded, ok := err.(interface{ ErrorCode() int })
+	if !ok {
+		return false
+	}
+	switch syscall.Errno(-coded.ErrorCode()) {
+	case syscall.ENOTCONN, syscall.ESHUTDOWN:
+		return true
+	default:
+		return false
+	}
+}
+
+// New takes in Ceph user and path to ceph.conf for
+// establishing a connection to ceph cluster and returning a
+// usable handle. The path may be empty when the mons and the
+// credentials are given through WithMonHost and WithKeyring or
+// WithKey instead.
+func New(user, configPath string, opt ...Option) (Client, error) {
+	cluster, err := clusterName(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &client{
+		backoff: time.Second,
+	}
+	for _, fn := range opt {
+		fn(c)
+	}
+
+	dial := func() (*rados.Conn, error) {
+		conn, err := rados.NewConnWithClusterAndUser(cluster, user)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create conn stub (user=%q,cluster=%q): %s", user, cluster, err)
+		}
+
+		if configPath != "" {
+			err = conn.ReadConfigFile(configPath)
+			if err != nil {
+				return nil, fmt.Errorf("error reading config file %q: %s", configPath, err)
+			}
+		}
+
+		for _, opt := range append(c.connOptions(), c.timeoutOptions()...) {
+			if err := conn.SetConfigOption(opt[0], opt[1]); err != nil {
+				return nil, fmt.Errorf("error setting config option %q: %s", opt[0], err)
+			}
+		}
+
+		if err := conn.Connect(); err != nil {
+			return nil, fmt.Errorf("error connecting to cluster: %s", err)
+		}
+
+		return conn, nil
+	}
+
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	c.transport = &radosTransport{
+		conn:   conn,
+		dial:   dial,
+		logger: c.logger,
+	}
+
+	if err := c.checkRelease(context.Background()); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
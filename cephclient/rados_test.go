@@ -0,0 +1,87 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build cgo
+// +build cgo
+
+package cephclient
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ceph/go-ceph/rados"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconnect(t *testing.T) {
+	dials := 0
+	dialErr := errors.New("mons unreachable")
+	tr := &radosTransport{
+		dial: func() (*rados.Conn, error) {
+			dials++
+			if dialErr != nil {
+				return nil, dialErr
+			}
+			return &rados.Conn{}, nil
+		},
+	}
+
+	_, err := tr.getConn()
+	assert.Equal(t, errNotConnected, err)
+	assert.Equal(t, 1, dials)
+	assert.Equal(t, minRedialBackoff, tr.redialBackoff)
+
+	_, err = tr.getConn()
+	assert.Equal(t, errNotConnected, err, "should not redial before the backoff passed")
+	assert.Equal(t, 1, dials)
+
+	tr.redialAt = time.Time{}
+	_, err = tr.getConn()
+	assert.Equal(t, errNotConnected, err)
+	assert.Equal(t, 2, dials)
+	assert.Equal(t, 2*minRedialBackoff, tr.redialBackoff, "backoff should double")
+
+	dialErr = nil
+	tr.redialAt = time.Time{}
+	conn, err := tr.getConn()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, dials)
+	assert.Equal(t, time.Duration(0), tr.redialBackoff, "backoff should reset once connected")
+
+	_, err = tr.withConn(func(*rados.Conn) ([]byte, string, error) {
+		return nil, "", testErrno(-int(syscall.EINVAL))
+	})
+	assert.Error(t, err)
+	assert.Equal(t, conn, tr.conn, "should keep the connection on other errors")
+
+	_, err = tr.withConn(func(*rados.Conn) ([]byte, string, error) {
+		return nil, "", testErrno(-int(syscall.ENOTCONN))
+	})
+	assert.Equal(t, errNotConnected, err)
+	assert.Nil(t, tr.conn, "should drop a lost connection")
+
+	c := &client{transport: tr, retries: 1}
+	buf, err := c.command(context.Background(), func(context.Context) ([]byte, error) {
+		return tr.withConn(func(*rados.Conn) ([]byte, string, error) {
+			return []byte("{}"), "", nil
+		})
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("{}"), buf)
+	assert.Equal(t, 4, dials, "should reconnect on the next command")
+}
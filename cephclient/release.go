@@ -0,0 +1,114 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cephclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Major versions of the oldest Ceph release whose output can be
+// parsed, Nautilus, and of the newest one known, Reef.
+const (
+	minSupportedRelease = 14
+	maxKnownRelease     = 18
+)
+
+// CephRelease identifies the release of Ceph a cluster runs.
+type CephRelease struct {
+	Major int
+	Minor int
+	Patch int
+	Name  string
+}
+
+func (r CephRelease) String() string {
+	return fmt.Sprintf("%d.%d.%d %s", r.Major, r.Minor, r.Patch, r.Name)
+}
+
+// parseCephVersion parses the version reported by `ceph version`,
+// e.g. "ceph version 16.2.7 (dd0603118f56ab514f133c8d2e3adfc983942503)
+// pacific (stable)".
+func parseCephVersion(version string) (CephRelease, error) {
+	var release CephRelease
+
+	fields := strings.Fields(version)
+	if len(fields) < 3 || fields[0] != "ceph" || fields[1] != "version" {
+		return release, fmt.Errorf("unexpected ceph version %q", version)
+	}
+
+	// Development builds carry a suffix, e.g. 17.0.0-1234-gabcdef.
+	num := strings.SplitN(fields[2], "-", 2)[0]
+	if _, err := fmt.Sscanf(num, "%d.%d.%d", &release.Major, &release.Minor, &release.Patch); err != nil {
+		return release, fmt.Errorf("unexpected ceph version %q: %s", version, err)
+	}
+	if len(fields) > 4 {
+		release.Name = fields[4]
+	}
+
+	return release, nil
+}
+
+func (c *client) Version(ctx context.Context) (*CephRelease, error) {
+	cmd, err := json.Marshal(map[string]interface{}{
+		"prefix": "version",
+		"format": "json",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := c.monCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &struct {
+		Version string `json:"version"`
+	}{}
+	if err := json.Unmarshal(buf, v); err != nil {
+		return nil, err
+	}
+
+	release, err := parseCephVersion(v.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
+// checkRelease detects the release the cluster runs, and fails on
+// releases whose output cannot be parsed. Releases newer than the
+// ones known are let through, their output is likely to still be
+// understood.
+func (c *client) checkRelease(ctx context.Context) error {
+	release, err := c.Version(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot detect ceph release: %s", err)
+	}
+	ll := c.log().WithField("ceph.release", release.String())
+	if release.Major < minSupportedRelease {
+		return fmt.Errorf("unsupported ceph release %s, Nautilus or later is required", release)
+	}
+	if release.Major > maxKnownRelease {
+		ll.Warn("ceph release is newer than the ones known, output may not be parsed correctly")
+		return nil
+	}
+
+	ll.Debug("detected ceph release")
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cephclient
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCephVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    CephRelease
+		wantErr bool
+	}{
+		{
+			name:    "stable",
+			version: "ceph version 16.2.7 (dd0603118f56ab514f133c8d2e3adfc983942503) pacific (stable)",
+			want:    CephRelease{Major: 16, Minor: 2, Patch: 7, Name: "pacific"},
+		},
+		{
+			name:    "development",
+			version: "ceph version 17.0.0-8051-g15b54dc9 (15b54dc9eaa835e95809e32e8ddf109d416320c9) quincy (dev)",
+			want:    CephRelease{Major: 17, Minor: 0, Patch: 0, Name: "quincy"},
+		},
+		{
+			name:    "garbage",
+			version: "not a version",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCephVersion(tt.version)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCheckRelease(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		wantErr string
+	}{
+		{
+			name:    "supported",
+			version: "ceph version 15.2.17 (8a82819d84cf884bd39c17e3236e0632ac146dc4) octopus (stable)",
+		},
+		{
+			name:    "newer",
+			version: "ceph version 19.2.0 (16063ff2022298c9300e49a547a16ffda59baf13) squid (stable)",
+		},
+		{
+			name:    "unsupported",
+			version: "ceph version 13.2.10 (564bdc4ae87418a232fc901524470e1a0f76d641) mimic (stable)",
+			wantErr: "unsupported ceph release 13.2.10 mimic, Nautilus or later is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &testTransport{out: map[string]string{
+				"version": `{"version": "` + tt.version + `"}`,
+			}}
+			c := &client{transport: tr}
+
+			err := c.checkRelease(context.Background())
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
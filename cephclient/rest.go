@@ -0,0 +1,183 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cephclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// restTransport issues commands through the `/request` endpoint
+// of the ceph-mgr restful module, which accepts the same JSON
+// commands as librados and hands them to the mons.
+type restTransport struct {
+	endpoint string
+	user     string
+	key      string
+	client   *http.Client
+}
+
+// restError is returned when the restful module rejects a
+// request or the command it carried failed.
+type restError struct {
+	status int
+	msg    string
+}
+
+func (e *restError) Error() string {
+	return fmt.Sprintf("ceph-mgr restful request failed with status %d: %s", e.status, e.msg)
+}
+
+// Temporary reports whether the mgr was merely unavailable,
+// e.g. while failing over to a standby.
+func (e *restError) Temporary() bool {
+	switch e.status {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// restRequestOut provides a representation for the response
+// of the restful module to a request it waited on.
+type restRequestOut struct {
+	HasFailed bool             `json:"has_failed"`
+	Finished  []restCommandOut `json:"finished"`
+	Failed    []restCommandOut `json:"failed"`
+}
+
+type restCommandOut struct {
+	Outb string `json:"outb"`
+	Outs string `json:"outs"`
+}
+
+func (t *restTransport) monCommand(ctx context.Context, cmd []byte) ([]byte, error) {
+	return t.request(ctx, cmd)
+}
+
+// mgrCommand relies on the mons forwarding commands they do not
+// know about to the active mgr, as the restful module only ever
+// addresses the mons.
+func (t *restTransport) mgrCommand(ctx context.Context, cmd []byte) ([]byte, error) {
+	return t.request(ctx, cmd)
+}
+
+func (t *restTransport) request(ctx context.Context, cmd []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint+"/request?wait=1", bytes.NewReader(cmd))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(t.user, t.key)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(body))
+		if msg == "" {
+			msg = http.StatusText(resp.StatusCode)
+		}
+		return nil, &restError{status: resp.StatusCode, msg: msg}
+	}
+
+	out := &restRequestOut{}
+	if err := json.Unmarshal(body, out); err != nil {
+		return nil, err
+	}
+	if out.HasFailed || len(out.Failed) > 0 {
+		var msgs []string
+		for _, f := range out.Failed {
+			msgs = append(msgs, f.Outs)
+		}
+		return nil, &restError{status: resp.StatusCode, msg: strings.Join(msgs, "; ")}
+	}
+	if len(out.Finished) == 0 {
+		return nil, fmt.Errorf("ceph-mgr restful request did not finish")
+	}
+
+	return []byte(out.Finished[0].Outb), nil
+}
+
+func (t *restTransport) close() {
+	t.client.CloseIdleConnections()
+}
+
+// NewREST returns a client issuing every call through
+// the ceph-mgr restful module listening on the given endpoint,
+// e.g. https://mgr.example.com:8003, authenticating as the given
+// user with the API key created by `ceph restful create-key`.
+// The TLS config may be nil to verify the mgr against the system
+// roots. No ceph.conf or keyring is needed.
+func NewREST(endpoint, user, key string, tlsConfig *tls.Config, opt ...Option) (Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid restful endpoint %q: %s", endpoint, err)
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid restful endpoint %q: must use https", endpoint)
+	}
+
+	c := &client{
+		backoff: time.Second,
+	}
+	for _, fn := range opt {
+		fn(c)
+	}
+	if len(c.connOptions()) > 0 {
+		return nil, errors.New("mon host, keyring and key are not supported by the restful client")
+	}
+
+	dialer := &net.Dialer{Timeout: c.connectTimeout}
+	c.transport = &restTransport{
+		endpoint: strings.TrimSuffix(u.String(), "/"),
+		user:     user,
+		key:      key,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				DialContext:         dialer.DialContext,
+				TLSClientConfig:     tlsConfig,
+				TLSHandshakeTimeout: c.connectTimeout,
+			},
+		},
+	}
+
+	if err := c.checkRelease(context.Background()); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cephclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRESTTransport(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, key, ok := r.BasicAuth(); !ok || user != "admin" || key != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/request" || r.URL.Query().Get("wait") != "1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var cmd map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch cmd["prefix"] {
+		case "osd tree":
+			w.Write([]byte(`{"has_failed":false,"finished":[{"outb":"{\"nodes\":[]}","outs":""}],"failed":[]}`))
+		case "mgr failover":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(`{"has_failed":true,"finished":[],"failed":[{"outb":"","outs":"unknown command"}]}`))
+		}
+	}))
+	defer srv.Close()
+
+	tr := &restTransport{endpoint: srv.URL, user: "admin", key: "secret", client: srv.Client()}
+
+	out, err := tr.monCommand(context.Background(), []byte(`{"prefix":"osd tree","format":"json"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"nodes":[]}`, string(out))
+
+	_, err = tr.mgrCommand(context.Background(), []byte(`{"prefix":"bogus"}`))
+	assert.EqualError(t, err, "ceph-mgr restful request failed with status 200: unknown command")
+	assert.False(t, isTransient(err), "failed commands should not be retried")
+
+	_, err = tr.monCommand(context.Background(), []byte(`{"prefix":"mgr failover"}`))
+	assert.Error(t, err)
+	assert.True(t, isTransient(err), "unavailable mgrs should be retried")
+
+	tr.key = "wrong"
+	_, err = tr.monCommand(context.Background(), []byte(`{"prefix":"osd tree"}`))
+	assert.EqualError(t, err, "ceph-mgr restful request failed with status 401: Unauthorized")
+	assert.False(t, isTransient(err))
+
+	_, err = NewREST("http://mgr:8003", "admin", "secret", nil)
+	assert.Error(t, err, "plain http endpoints should be refused")
+}
@@ -0,0 +1,1022 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cephtest provides a fake cephclient.Client, so that
+// code orchestrating a Rebalancer can be tested without a cluster.
+package cephtest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+)
+
+// OSD describes an OSD placed into the tree built by NewOSDTree.
+type OSD struct {
+	ID          int
+	Host        string
+	CrushWeight float64
+
+	// Status defaults to `up`.
+	Status string
+}
+
+// NewOSDTree builds an OSD tree with a `default` root bucket
+// holding a host bucket for every distinct host, which in turn
+// hold their OSDs.
+func NewOSDTree(osds ...OSD) *cephclient.OSDTreeOut {
+	root := cephclient.OSDTreeNode{ID: -1, Name: "default", Type: "root"}
+	tree := &cephclient.OSDTreeOut{}
+
+	hosts := map[string]int{}
+	var hostNodes []cephclient.OSDTreeNode
+	var osdNodes []cephclient.OSDTreeNode
+	for _, osd := range osds {
+		i, ok := hosts[osd.Host]
+		if !ok {
+			i = len(hostNodes)
+			hosts[osd.Host] = i
+			id := -2 - i
+			hostNodes = append(hostNodes, cephclient.OSDTreeNode{ID: id, Name: osd.Host, Type: "host"})
+			root.Children = append(root.Children, id)
+		}
+		hostNodes[i].Children = append(hostNodes[i].Children, osd.ID)
+
+		status := osd.Status
+		if status == "" {
+			status = "up"
+		}
+		osdNodes = append(osdNodes, cephclient.OSDTreeNode{
+			ID:          osd.ID,
+			Name:        fmt.Sprintf("osd.%d", osd.ID),
+			Type:        "osd",
+			Status:      status,
+			Reweight:    1,
+			CrushWeight: osd.CrushWeight,
+		})
+	}
+
+	tree.Nodes = append(tree.Nodes, root)
+	tree.Nodes = append(tree.Nodes, hostNodes...)
+	tree.Nodes = append(tree.Nodes, osdNodes...)
+	return tree
+}
+
+// State is the cluster state served by a Client. Outputs left nil
+// are served as empty ones, except for the health which defaults
+// to `HEALTH_OK`.
+type State struct {
+	OSDTree *cephclient.OSDTreeOut
+	OSDDF   *cephclient.OSDDFOut
+
+	// PGsByState maps PG state names as reported by `ceph status`,
+	// e.g. `active+remapped+backfill_wait`, to the number of PGs in
+	// that state. The PG counts of the client are derived from it.
+	PGsByState map[string]int
+
+	MisplacedRatio  float64
+	DegradedObjects int
+	SlowOps         int
+	Health          *cephclient.HealthOut
+
+	QuorumStatus    *cephclient.QuorumStatusOut
+	OSDDump         *cephclient.OSDDumpOut
+	CrushRuleDump   *cephclient.CrushRuleDumpOut
+	PGDump          *cephclient.PGDumpOut
+	PGStats         *cephclient.PGStatsOut
+	OSDNetworkPings *cephclient.OSDNetworkOut
+	OSDPerf         *cephclient.OSDPerfOut
+
+	// SafeToDestroy lists the OSDs reported safe to destroy, all
+	// others are reported to still store PGs.
+	SafeToDestroy []int
+
+	// NotOKToStop lists the OSDs that make `ok-to-stop` fail when
+	// asked about along with any other OSDs.
+	NotOKToStop []int
+
+	BalancerActive bool
+
+	// Release is the release of Ceph reported by the client,
+	// defaulting to Pacific.
+	Release *cephclient.CephRelease
+
+	// Config maps daemons or types of daemons, e.g. `osd`, to the
+	// options stored for them in the config database.
+	Config map[string]map[string]string
+}
+
+// Call records a single call made into a Client, along with the
+// arguments it was made with other than the context.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// Fault describes how calls to a method of a Client misbehave.
+type Fault struct {
+	// Err is returned by the affected calls, which then have no
+	// effect. Calls are only delayed when nil.
+	Err error
+
+	// Latency delays the affected calls, or until their context
+	// is done.
+	Latency time.Duration
+
+	// Every only affects every n-th call, e.g. to fail every
+	// third CrushReweight. Every call is affected when 0 or 1.
+	Every int
+
+	// Times limits how many calls are affected, after which the
+	// method recovers. Unlimited when 0.
+	Times int
+
+	calls, hits int
+}
+
+// Client is a fake cephclient.Client serving the cluster state
+// it was created with. Crush reweights and balancer toggles update
+// that state like they would on a cluster. It is safe for
+// concurrent use.
+type Client struct {
+	mu     sync.Mutex
+	state  State
+	calls  []Call
+	faults map[string]*Fault
+	closed bool
+}
+
+// Verify compile time that `Client` implements `cephclient.Client`.
+var _ cephclient.Client = &Client{}
+
+// New returns a client serving the given state. The client takes
+// ownership of the state, which should only be changed through
+// Update from then on.
+func New(state State) *Client {
+	return &Client{state: state}
+}
+
+// Update changes the state served by the client, e.g. to let PGs
+// finish backfilling in between iterations.
+func (c *Client) Update(fn func(*State)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fn(&c.state)
+}
+
+// InjectFault makes calls to the given method, e.g. `CrushReweight`,
+// misbehave as described by the fault from now on, replacing any
+// fault injected into it before.
+func (c *Client) InjectFault(method string, f Fault) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.faults == nil {
+		c.faults = map[string]*Fault{}
+	}
+	f.calls, f.hits = 0, 0
+	c.faults[method] = &f
+}
+
+// ClearFaults removes every injected fault.
+func (c *Client) ClearFaults() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.faults = nil
+}
+
+// Calls returns every call made into the client, in order.
+func (c *Client) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]Call(nil), c.calls...)
+}
+
+// CallsTo returns the calls made to the given method, in order.
+func (c *Client) CallsTo(method string) []Call {
+	var calls []Call
+	for _, call := range c.Calls() {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// CrushWeights returns the crush weight of every OSD in the tree.
+func (c *Client) CrushWeights() map[int]float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	weights := map[int]float64{}
+	if c.state.OSDTree == nil {
+		return weights
+	}
+	for _, node := range c.state.OSDTree.Nodes {
+		if node.Type == "osd" {
+			weights[node.ID] = node.CrushWeight
+		}
+	}
+	return weights
+}
+
+// Config returns the options stored in the config database, keyed by
+// daemon or type of daemon and then by option.
+func (c *Client) Config() map[string]map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	config := make(map[string]map[string]string, len(c.state.Config))
+	for who, opts := range c.state.Config {
+		config[who] = make(map[string]string, len(opts))
+		for key, val := range opts {
+			config[who][key] = val
+		}
+	}
+	return config
+}
+
+// Closed reports whether the client was closed.
+func (c *Client) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+// call records a call to the given method and applies the fault
+// injected into it, if any.
+func (c *Client) call(ctx context.Context, method string, args ...interface{}) error {
+	c.mu.Lock()
+	c.calls = append(c.calls, Call{Method: method, Args: args})
+
+	f, ok := c.faults[method]
+	if ok {
+		f.calls++
+		if f.Every > 1 && f.calls%f.Every != 0 || f.Times > 0 && f.hits >= f.Times {
+			ok = false
+		} else {
+			f.hits++
+		}
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	if f.Latency > 0 {
+		select {
+		case <-time.After(f.Latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return f.Err
+}
+
+// countPGs counts the PGs in any state containing one of the
+// given ones, the way archimedes does against `ceph status`.
+func (c *Client) countPGs(states ...string) int {
+	var count int
+	for name, n := range c.state.PGsByState {
+		for _, state := range states {
+			if strings.Contains(name, state) {
+				count += n
+			}
+		}
+	}
+	return count
+}
+
+func (c *Client) BackfillingPGs(ctx context.Context) (int, error) {
+	if err := c.call(ctx, "BackfillingPGs"); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.countPGs("backfilling", "backfill_wait"), nil
+}
+
+func (c *Client) RecoveringPGs(ctx context.Context) (int, error) {
+	if err := c.call(ctx, "RecoveringPGs"); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.countPGs("recovering", "recovery_wait"), nil
+}
+
+func (c *Client) ScrubbingPGs(ctx context.Context) (int, error) {
+	if err := c.call(ctx, "ScrubbingPGs"); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.countPGs("scrubbing"), nil
+}
+
+func (c *Client) SnapTrimmingPGs(ctx context.Context) (int, error) {
+	if err := c.call(ctx, "SnapTrimmingPGs"); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.countPGs("snaptrim"), nil
+}
+
+func (c *Client) InactivePGs(ctx context.Context) (int, error) {
+	if err := c.call(ctx, "InactivePGs"); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var count int
+	for name, n := range c.state.PGsByState {
+		var active, stuck bool
+		for _, state := range strings.Split(name, "+") {
+			switch state {
+			case "active":
+				active = true
+			case "peering", "incomplete":
+				stuck = true
+			}
+		}
+
+		if !active || stuck {
+			count += n
+		}
+	}
+	return count, nil
+}
+
+func (c *Client) MisplacedRatio(ctx context.Context) (float64, error) {
+	if err := c.call(ctx, "MisplacedRatio"); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state.MisplacedRatio, nil
+}
+
+func (c *Client) DegradedObjects(ctx context.Context) (int, error) {
+	if err := c.call(ctx, "DegradedObjects"); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state.DegradedObjects, nil
+}
+
+func (c *Client) HealthStatus(ctx context.Context) (*cephclient.HealthOut, error) {
+	if err := c.call(ctx, "HealthStatus"); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.Health == nil {
+		return &cephclient.HealthOut{Status: "HEALTH_OK"}, nil
+	}
+	return c.state.Health, nil
+}
+
+func (c *Client) SlowOps(ctx context.Context) (int, error) {
+	if err := c.call(ctx, "SlowOps"); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state.SlowOps, nil
+}
+
+func (c *Client) Version(ctx context.Context) (*cephclient.CephRelease, error) {
+	if err := c.call(ctx, "Version"); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.Release == nil {
+		return &cephclient.CephRelease{Major: 16, Minor: 2, Patch: 7, Name: "pacific"}, nil
+	}
+	release := *c.state.Release
+	return &release, nil
+}
+
+func (c *Client) ClusterStatus(ctx context.Context) (*cephclient.ClusterStatusOut, error) {
+	if err := c.call(ctx, "ClusterStatus"); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cs := &cephclient.ClusterStatusOut{}
+	for name, n := range c.state.PGsByState {
+		cs.PGMap.PGsByState = append(cs.PGMap.PGsByState,
+			cephclient.PGStateCount{Count: float64(n), States: name})
+		cs.PGMap.NumPGs += float64(n)
+	}
+	cs.PGMap.MisplacedRatio = c.state.MisplacedRatio
+	cs.PGMap.DegradedObjects = float64(c.state.DegradedObjects)
+
+	cs.Health = cephclient.HealthOut{Status: "HEALTH_OK", Checks: map[string]cephclient.HealthCheck{}}
+	if c.state.Health != nil {
+		cs.Health.Status = c.state.Health.Status
+		for code, check := range c.state.Health.Checks {
+			cs.Health.Checks[code] = check
+		}
+	}
+	if c.state.SlowOps > 0 {
+		check := cephclient.HealthCheck{Severity: "HEALTH_WARN"}
+		check.Summary.Count = c.state.SlowOps
+		cs.Health.Checks["SLOW_OPS"] = check
+	}
+
+	return cs, nil
+}
+
+func (c *Client) OSDTree(ctx context.Context) (*cephclient.OSDTreeOut, error) {
+	if err := c.call(ctx, "OSDTree"); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.OSDTree == nil {
+		return &cephclient.OSDTreeOut{}, nil
+	}
+
+	// Hand out a copy, so later reweights don't show up in trees
+	// fetched before them.
+	tree := &cephclient.OSDTreeOut{
+		Nodes: append([]cephclient.OSDTreeNode(nil), c.state.OSDTree.Nodes...),
+		Stray: append([]cephclient.OSDTreeNode(nil), c.state.OSDTree.Stray...),
+	}
+	return tree, nil
+}
+
+func (c *Client) OSDTreeOf(ctx context.Context, osdIDs []int) (*cephclient.OSDTreeOut, error) {
+	if err := c.call(ctx, "OSDTreeOf", osdIDs); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.OSDTree == nil {
+		return &cephclient.OSDTreeOut{}, nil
+	}
+
+	keep := make(map[int]bool, len(osdIDs))
+	for _, id := range osdIDs {
+		keep[id] = true
+	}
+
+	tree := &cephclient.OSDTreeOut{}
+	for _, node := range c.state.OSDTree.Nodes {
+		if node.Type != "osd" || keep[node.ID] {
+			tree.Nodes = append(tree.Nodes, node)
+		}
+	}
+	for _, node := range c.state.OSDTree.Stray {
+		if keep[node.ID] {
+			tree.Stray = append(tree.Stray, node)
+		}
+	}
+	return tree, nil
+}
+
+func (c *Client) OSDTreeFrom(ctx context.Context, bucket string, osdIDs []int) (*cephclient.OSDTreeOut, error) {
+	if err := c.call(ctx, "OSDTreeFrom", bucket, osdIDs); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.OSDTree == nil {
+		return nil, fmt.Errorf("bucket %s not found", bucket)
+	}
+
+	keep := make(map[int]bool, len(osdIDs))
+	for _, id := range osdIDs {
+		keep[id] = true
+	}
+
+	nodes := make(map[int]cephclient.OSDTreeNode, len(c.state.OSDTree.Nodes))
+	var queue []int
+	for _, node := range c.state.OSDTree.Nodes {
+		nodes[node.ID] = node
+		if node.Name == bucket && node.Type != "osd" {
+			queue = append(queue, node.ID)
+		}
+	}
+	if len(queue) == 0 {
+		return nil, fmt.Errorf("bucket %s not found", bucket)
+	}
+
+	tree := &cephclient.OSDTreeOut{}
+	for len(queue) > 0 {
+		node, ok := nodes[queue[0]]
+		queue = queue[1:]
+		if !ok || (node.Type == "osd" && !keep[node.ID]) {
+			continue
+		}
+		tree.Nodes = append(tree.Nodes, node)
+		queue = append(queue, node.Children...)
+	}
+	return tree, nil
+}
+
+func (c *Client) OSDDF(ctx context.Context) (*cephclient.OSDDFOut, error) {
+	if err := c.call(ctx, "OSDDF"); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.OSDDF == nil {
+		return &cephclient.OSDDFOut{}, nil
+	}
+	return c.state.OSDDF, nil
+}
+
+func (c *Client) QuorumStatus(ctx context.Context) (*cephclient.QuorumStatusOut, error) {
+	if err := c.call(ctx, "QuorumStatus"); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.QuorumStatus == nil {
+		return &cephclient.QuorumStatusOut{}, nil
+	}
+	return c.state.QuorumStatus, nil
+}
+
+func (c *Client) OSDDump(ctx context.Context) (*cephclient.OSDDumpOut, error) {
+	if err := c.call(ctx, "OSDDump"); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.OSDDump == nil {
+		return &cephclient.OSDDumpOut{}, nil
+	}
+	return c.state.OSDDump, nil
+}
+
+func (c *Client) CrushRuleDump(ctx context.Context) (*cephclient.CrushRuleDumpOut, error) {
+	if err := c.call(ctx, "CrushRuleDump"); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.CrushRuleDump == nil {
+		return &cephclient.CrushRuleDumpOut{}, nil
+	}
+	return c.state.CrushRuleDump, nil
+}
+
+func (c *Client) PGDump(ctx context.Context) (*cephclient.PGDumpOut, error) {
+	if err := c.call(ctx, "PGDump"); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.PGDump == nil {
+		return &cephclient.PGDumpOut{}, nil
+	}
+	return c.state.PGDump, nil
+}
+
+func (c *Client) PGStats(ctx context.Context) (*cephclient.PGStatsOut, error) {
+	if err := c.call(ctx, "PGStats"); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.PGStats == nil {
+		return &cephclient.PGStatsOut{}, nil
+	}
+	return c.state.PGStats, nil
+}
+
+func (c *Client) OSDNetworkPings(ctx context.Context) (*cephclient.OSDNetworkOut, error) {
+	if err := c.call(ctx, "OSDNetworkPings"); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.OSDNetworkPings == nil {
+		return &cephclient.OSDNetworkOut{}, nil
+	}
+	return c.state.OSDNetworkPings, nil
+}
+
+func (c *Client) OSDPerf(ctx context.Context) (*cephclient.OSDPerfOut, error) {
+	if err := c.call(ctx, "OSDPerf"); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.OSDPerf == nil {
+		return &cephclient.OSDPerfOut{}, nil
+	}
+	return c.state.OSDPerf, nil
+}
+
+func (c *Client) CrushReweight(ctx context.Context, osdID int, crushWeight float64) error {
+	if err := c.call(ctx, "CrushReweight", osdID, crushWeight); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.OSDTree == nil {
+		return fmt.Errorf("osd.%d does not exist", osdID)
+	}
+	for i := range c.state.OSDTree.Nodes {
+		if c.state.OSDTree.Nodes[i].ID == osdID {
+			c.state.OSDTree.Nodes[i].CrushWeight = crushWeight
+			return nil
+		}
+	}
+	return fmt.Errorf("osd.%d does not exist", osdID)
+}
+
+func (c *Client) CrushReweightSubtree(ctx context.Context, bucket string, crushWeight float64) error {
+	if err := c.call(ctx, "CrushReweightSubtree", bucket, crushWeight); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.OSDTree == nil {
+		return fmt.Errorf("bucket %s does not exist", bucket)
+	}
+
+	index := make(map[int]int, len(c.state.OSDTree.Nodes))
+	var queue []int
+	for i, node := range c.state.OSDTree.Nodes {
+		index[node.ID] = i
+		if node.Name == bucket && node.Type != "osd" {
+			queue = append(queue, node.ID)
+		}
+	}
+	if len(queue) == 0 {
+		return fmt.Errorf("bucket %s does not exist", bucket)
+	}
+
+	for len(queue) > 0 {
+		i, ok := index[queue[0]]
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+
+		node := &c.state.OSDTree.Nodes[i]
+		if node.Type == "osd" {
+			node.CrushWeight = crushWeight
+			continue
+		}
+		queue = append(queue, node.Children...)
+	}
+	return nil
+}
+
+func (c *Client) SetPrimaryAffinity(ctx context.Context, osdID int, affinity float64) error {
+	if err := c.call(ctx, "SetPrimaryAffinity", osdID, affinity); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.OSDDump == nil {
+		c.state.OSDDump = &cephclient.OSDDumpOut{}
+	}
+	for i := range c.state.OSDDump.OSDs {
+		if c.state.OSDDump.OSDs[i].OSD == osdID {
+			c.state.OSDDump.OSDs[i].PrimaryAffinity = affinity
+			return nil
+		}
+	}
+	c.state.OSDDump.OSDs = append(c.state.OSDDump.OSDs, cephclient.OSDInfo{OSD: osdID, PrimaryAffinity: affinity})
+	return nil
+}
+
+func (c *Client) MarkOut(ctx context.Context, osdIDs []int) error {
+	if err := c.call(ctx, "MarkOut", osdIDs); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, id := range osdIDs {
+		node := c.osd(id)
+		if node == nil {
+			return fmt.Errorf("osd.%d does not exist", id)
+		}
+		node.Reweight = 0
+	}
+	return nil
+}
+
+func (c *Client) CrushRemove(ctx context.Context, osdID int) error {
+	if err := c.call(ctx, "CrushRemove", osdID); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node := c.osd(osdID)
+	if node == nil {
+		return fmt.Errorf("osd.%d does not exist", osdID)
+	}
+	// OSDs outside of the CRUSH map are reported as stray.
+	stray := *node
+	stray.CrushWeight = 0
+	c.removeOSD(osdID)
+	c.state.OSDTree.Stray = append(c.state.OSDTree.Stray, stray)
+	return nil
+}
+
+func (c *Client) PurgeOSD(ctx context.Context, osdID int) error {
+	if err := c.call(ctx, "PurgeOSD", osdID); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	node := c.osd(osdID)
+	if node == nil {
+		return fmt.Errorf("osd.%d does not exist", osdID)
+	}
+	if node.Status != "down" {
+		return fmt.Errorf("osd.%d is not `down`", osdID)
+	}
+	c.removeOSD(osdID)
+	return nil
+}
+
+// osd returns the node of the given OSD within the tree, if any.
+func (c *Client) osd(id int) *cephclient.OSDTreeNode {
+	if c.state.OSDTree == nil {
+		return nil
+	}
+	for i := range c.state.OSDTree.Nodes {
+		if c.state.OSDTree.Nodes[i].ID == id && c.state.OSDTree.Nodes[i].Type == "osd" {
+			return &c.state.OSDTree.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// removeOSD drops the given OSD from the tree and its parent bucket.
+func (c *Client) removeOSD(id int) {
+	nodes := c.state.OSDTree.Nodes[:0]
+	for _, node := range c.state.OSDTree.Nodes {
+		if node.ID == id && node.Type == "osd" {
+			continue
+		}
+		var children []int
+		for _, child := range node.Children {
+			if child != id {
+				children = append(children, child)
+			}
+		}
+		node.Children = children
+		nodes = append(nodes, node)
+	}
+	c.state.OSDTree.Nodes = nodes
+}
+
+func (c *Client) SetFlag(ctx context.Context, flag string) error {
+	if err := c.call(ctx, "SetFlag", flag); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setFlag(flag, true)
+	return nil
+}
+
+func (c *Client) UnsetFlag(ctx context.Context, flag string) error {
+	if err := c.call(ctx, "UnsetFlag", flag); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setFlag(flag, false)
+	return nil
+}
+
+func (c *Client) setFlag(flag string, set bool) {
+	if c.state.OSDDump == nil {
+		c.state.OSDDump = &cephclient.OSDDumpOut{}
+	}
+
+	var flags []string
+	for _, f := range strings.Split(c.state.OSDDump.Flags, ",") {
+		if f != "" && f != flag {
+			flags = append(flags, f)
+		}
+	}
+	if set {
+		flags = append(flags, flag)
+	}
+	c.state.OSDDump.Flags = strings.Join(flags, ",")
+}
+
+func (c *Client) EnableCephBalancer(ctx context.Context) error {
+	if err := c.call(ctx, "EnableCephBalancer"); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.state.BalancerActive = true
+	return nil
+}
+
+func (c *Client) SafeToDestroy(ctx context.Context, osdIDs []int) (*cephclient.SafeToDestroyOut, error) {
+	if err := c.call(ctx, "SafeToDestroy", osdIDs); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := &cephclient.SafeToDestroyOut{}
+	for _, id := range osdIDs {
+		safe := false
+		for _, s := range c.state.SafeToDestroy {
+			if s == id {
+				safe = true
+				break
+			}
+		}
+		if safe {
+			out.SafeToDestroy = append(out.SafeToDestroy, id)
+		} else {
+			out.StoredPGs = append(out.StoredPGs, id)
+		}
+	}
+	return out, nil
+}
+
+func (c *Client) OKToStop(ctx context.Context, osdIDs []int) (*cephclient.OKToStopOut, error) {
+	if err := c.call(ctx, "OKToStop", osdIDs); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := &cephclient.OKToStopOut{OKToStop: true, OSDs: osdIDs}
+	for _, id := range osdIDs {
+		for _, bad := range c.state.NotOKToStop {
+			if bad == id {
+				out.OKToStop = false
+			}
+		}
+	}
+	return out, nil
+}
+
+func (c *Client) ConfigGet(ctx context.Context, who, key string) (string, bool, error) {
+	if err := c.call(ctx, "ConfigGet", who, key); err != nil {
+		return "", false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	val, ok := c.state.Config[who][key]
+	return val, ok, nil
+}
+
+func (c *Client) ConfigSet(ctx context.Context, who, key, value string) error {
+	if err := c.call(ctx, "ConfigSet", who, key, value); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.Config == nil {
+		c.state.Config = map[string]map[string]string{}
+	}
+	if c.state.Config[who] == nil {
+		c.state.Config[who] = map[string]string{}
+	}
+	c.state.Config[who][key] = value
+	return nil
+}
+
+func (c *Client) ConfigRemove(ctx context.Context, who, key string) error {
+	if err := c.call(ctx, "ConfigRemove", who, key); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.state.Config[who], key)
+	return nil
+}
+
+func (c *Client) DisableCephBalancer(ctx context.Context) error {
+	if err := c.call(ctx, "DisableCephBalancer"); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.state.BalancerActive = false
+	return nil
+}
+
+func (c *Client) BalancerStatus(ctx context.Context) (*cephclient.BalancerStatusOut, error) {
+	if err := c.call(ctx, "BalancerStatus"); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return &cephclient.BalancerStatusOut{Active: c.state.BalancerActive, Mode: "upmap"}, nil
+}
+
+func (c *Client) Close() {
+	c.call(context.Background(), "Close")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+}
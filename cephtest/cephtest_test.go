@@ -0,0 +1,197 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cephtest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/digitalocean/archimedes/rebalancer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOSDTree(t *testing.T) {
+	tree := cephtest.NewOSDTree(
+		cephtest.OSD{ID: 1, Host: "a", CrushWeight: 1.5},
+		cephtest.OSD{ID: 2, Host: "b"},
+		cephtest.OSD{ID: 3, Host: "a", Status: "down"},
+	)
+
+	assert.Equal(t, []cephclient.OSDTreeNode{
+		{ID: -1, Name: "default", Type: "root", Children: []int{-2, -3}},
+		{ID: -2, Name: "a", Type: "host", Children: []int{1, 3}},
+		{ID: -3, Name: "b", Type: "host", Children: []int{2}},
+		{ID: 1, Name: "osd.1", Type: "osd", Status: "up", Reweight: 1, CrushWeight: 1.5},
+		{ID: 2, Name: "osd.2", Type: "osd", Status: "up", Reweight: 1},
+		{ID: 3, Name: "osd.3", Type: "osd", Status: "down", Reweight: 1},
+	}, tree.Nodes)
+}
+
+func TestPGCounts(t *testing.T) {
+	c := cephtest.New(cephtest.State{
+		PGsByState: map[string]int{
+			"active+clean":                  90,
+			"active+remapped+backfilling":   2,
+			"active+remapped+backfill_wait": 3,
+			"active+recovery_wait":          4,
+			"active+clean+scrubbing+deep":   5,
+			"active+clean+snaptrim_wait":    6,
+			"peering":                       7,
+		},
+	})
+	ctx := context.Background()
+
+	n, _ := c.BackfillingPGs(ctx)
+	assert.Equal(t, 5, n)
+	n, _ = c.RecoveringPGs(ctx)
+	assert.Equal(t, 4, n)
+	n, _ = c.ScrubbingPGs(ctx)
+	assert.Equal(t, 5, n)
+	n, _ = c.SnapTrimmingPGs(ctx)
+	assert.Equal(t, 6, n)
+	n, _ = c.InactivePGs(ctx)
+	assert.Equal(t, 7, n)
+}
+
+func TestRebalancer(t *testing.T) {
+	c := cephtest.New(cephtest.State{
+		OSDTree: cephtest.NewOSDTree(
+			cephtest.OSD{ID: 1, Host: "a"},
+			cephtest.OSD{ID: 2, Host: "b"},
+		),
+	})
+
+	r, err := rebalancer.New(
+		rebalancer.WithCephClient(c),
+		rebalancer.WithTargetCrushWeightMap(map[int]float64{1: 1.0, 2: 0.5}),
+		rebalancer.WithWeightIncrement(0.5),
+		rebalancer.WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer: %s", err)
+	}
+
+	r.DoReweight()
+	assert.ElementsMatch(t, []cephtest.Call{
+		{Method: "CrushReweight", Args: []interface{}{1, 0.5}},
+		{Method: "CrushReweight", Args: []interface{}{2, 0.5}},
+	}, c.CallsTo("CrushReweight"))
+
+	c.Update(func(s *cephtest.State) {
+		s.PGsByState = map[string]int{"active+remapped+backfilling": 20}
+	})
+	r.DoReweight()
+	assert.Len(t, c.CallsTo("CrushReweight"), 2, "should hold off while backfilling")
+
+	c.Update(func(s *cephtest.State) {
+		s.PGsByState = nil
+	})
+	r.DoReweight()
+	assert.Equal(t, map[int]float64{1: 1.0, 2: 0.5}, c.CrushWeights())
+
+	r.DoReweight()
+	assert.Empty(t, r.RemainingTargets(), "every target should be achieved")
+}
+
+func TestFaults(t *testing.T) {
+	c := cephtest.New(cephtest.State{
+		OSDTree: cephtest.NewOSDTree(cephtest.OSD{ID: 1, Host: "a"}),
+	})
+	ctx := context.Background()
+	errBoom := errors.New("boom")
+
+	c.InjectFault("CrushReweight", cephtest.Fault{Err: errBoom, Every: 2})
+	var errs []error
+	for i := 1; i <= 4; i++ {
+		errs = append(errs, c.CrushReweight(ctx, 1, float64(i)))
+	}
+	assert.Equal(t, []error{nil, errBoom, nil, errBoom}, errs, "every second call should fail")
+	assert.Equal(t, map[int]float64{1: 3}, c.CrushWeights(), "failed calls should have no effect")
+	assert.Len(t, c.CallsTo("CrushReweight"), 4, "failed calls should be recorded")
+
+	c.InjectFault("BackfillingPGs", cephtest.Fault{Err: errBoom, Times: 2})
+	for i := 0; i < 2; i++ {
+		_, err := c.BackfillingPGs(ctx)
+		assert.Equal(t, errBoom, err)
+	}
+	_, err := c.BackfillingPGs(ctx)
+	assert.NoError(t, err, "the method should recover after the given times")
+
+	c.InjectFault("OSDTree", cephtest.Fault{Latency: time.Hour})
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = c.OSDTree(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err, "slow calls should respect the context")
+
+	c.ClearFaults()
+	_, err = c.OSDTree(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestRebalancerFaults(t *testing.T) {
+	c := cephtest.New(cephtest.State{
+		OSDTree: cephtest.NewOSDTree(
+			cephtest.OSD{ID: 1, Host: "a"},
+			cephtest.OSD{ID: 2, Host: "b"},
+		),
+	})
+
+	r, err := rebalancer.New(
+		rebalancer.WithCephClient(c),
+		rebalancer.WithTargetCrushWeightMap(map[int]float64{1: 1.0, 2: 1.0}),
+		rebalancer.WithWeightIncrement(0.5),
+		rebalancer.WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer: %s", err)
+	}
+
+	c.InjectFault("ClusterStatus", cephtest.Fault{Err: errors.New("mon election"), Times: 1})
+	r.DoReweight()
+	assert.Empty(t, c.CallsTo("CrushReweight"), "should skip the iteration when a gate fails")
+
+	c.InjectFault("CrushReweight", cephtest.Fault{Err: errors.New("EAGAIN"), Every: 2})
+	r.DoReweight()
+	var total float64
+	for _, w := range c.CrushWeights() {
+		total += w
+	}
+	assert.Equal(t, 0.5, total, "should carry on after a failed reweight")
+
+	c.ClearFaults()
+	for i := 0; i < 3; i++ {
+		r.DoReweight()
+	}
+	assert.Equal(t, map[int]float64{1: 1.0, 2: 1.0}, c.CrushWeights(), "should recover from failed reweights")
+}
+
+func TestFlags(t *testing.T) {
+	c := cephtest.New(cephtest.State{
+		OSDDump: &cephclient.OSDDumpOut{Flags: "sortbitwise,noout"},
+	})
+	ctx := context.Background()
+
+	assert.NoError(t, c.SetFlag(ctx, "norebalance"))
+	assert.NoError(t, c.SetFlag(ctx, "noout"))
+	assert.NoError(t, c.UnsetFlag(ctx, "sortbitwise"))
+
+	out, err := c.OSDDump(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "norebalance,noout", out.Flags)
+}
@@ -0,0 +1,183 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/archimedes/rebalancer"
+	"github.com/urfave/cli/v2"
+)
+
+// Actions recorded in the audit log.
+const (
+	auditActionReweight = "reweight"
+	auditActionExternal = "external"
+)
+
+// auditRecord is a line of the audit log. Weight is the weight the
+// OSD was changed to, and Previous, for changes made outside of
+// archimedes, the weight it had been reweighted to last.
+type auditRecord struct {
+	Time     time.Time `json:"time"`
+	OSD      int       `json:"osd"`
+	Action   string    `json:"action"`
+	Weight   float64   `json:"weight"`
+	Previous *float64  `json:"previous,omitempty"`
+}
+
+// auditLog appends the reweights of a run, and the weight changes it
+// ran into, to a file as JSON lines. Dry run reweights are left out.
+type auditLog struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// handle records the events that change weights, as an event handler
+// of the rebalancer.
+func (a *auditLog) handle(e rebalancer.Event) {
+	rec := auditRecord{Time: time.Now().UTC()}
+	switch e := e.(type) {
+	case rebalancer.ReweightApplied:
+		if e.DryRun {
+			return
+		}
+		rec.OSD, rec.Action, rec.Weight = e.OSD, auditActionReweight, e.Weight
+	case rebalancer.WeightChanged:
+		applied := e.Applied
+		rec.OSD, rec.Action, rec.Weight, rec.Previous = e.OSD, auditActionExternal, e.Observed, &applied
+	default:
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(rec); err != nil {
+		log.Printf("cannot write to audit log: %s", err)
+	}
+}
+
+func (a *auditLog) Close() error {
+	return a.f.Close()
+}
+
+// readAuditLog returns the records of the audit log at path that
+// concern one of osds, all of them if there are none, and fall within
+// [since, until), either of which may be zero.
+func readAuditLog(path string, osds []int, since, until time.Time) ([]auditRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	wanted := make(map[int]bool, len(osds))
+	for _, osd := range osds {
+		wanted[osd] = true
+	}
+
+	var recs []auditRecord
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var rec auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if len(wanted) > 0 && !wanted[rec.OSD] {
+			continue
+		}
+		if !since.IsZero() && rec.Time.Before(since) {
+			continue
+		}
+		if !until.IsZero() && !rec.Time.Before(until) {
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// parseHistoryTime parses the bounds of the history command, given
+// either as RFC 3339 timestamps or as dates in local time.
+func parseHistoryTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 timestamp nor a YYYY-MM-DD date", s)
+	}
+	return t, nil
+}
+
+// history prints the weight changes recorded in the audit log.
+func history(ctx *cli.Context) error {
+	path := ctx.String(auditLogFlag.Name)
+	if path == "" {
+		return fmt.Errorf("no audit log given, see --%s", auditLogFlag.Name)
+	}
+
+	since, err := parseHistoryTime(ctx.String(sinceFlag.Name))
+	if err != nil {
+		return fmt.Errorf("invalid --%s: %s", sinceFlag.Name, err)
+	}
+	until, err := parseHistoryTime(ctx.String(untilFlag.Name))
+	if err != nil {
+		return fmt.Errorf("invalid --%s: %s", untilFlag.Name, err)
+	}
+
+	recs, err := readAuditLog(path, ctx.IntSlice(osdFlag.Name), since, until)
+	if err != nil {
+		return fmt.Errorf("cannot read audit log: %s", err)
+	}
+	if jsonOutput(ctx) {
+		return printJSON(append([]auditRecord{}, recs...))
+	}
+
+	for _, rec := range recs {
+		ts := rec.Time.Local().Format(time.RFC3339)
+		switch {
+		case rec.Action == auditActionExternal && rec.Previous != nil:
+			fmt.Printf("%s osd.%d: changed outside of archimedes from %v to %v\n", ts, rec.OSD, *rec.Previous, rec.Weight)
+		default:
+			fmt.Printf("%s osd.%d: reweighted to %v\n", ts, rec.OSD, rec.Weight)
+		}
+	}
+	if len(recs) == 0 {
+		fmt.Println("no matching weight changes found")
+	}
+	return nil
+}
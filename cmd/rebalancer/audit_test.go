@@ -0,0 +1,163 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/rebalancer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.handle(rebalancer.ReweightApplied{OSD: 1, Weight: 1.5})
+	a.handle(rebalancer.ReweightApplied{OSD: 2, Weight: 1.5, DryRun: true})
+	a.handle(rebalancer.TargetReached{OSD: 1})
+	a.handle(rebalancer.WeightChanged{OSD: 1, Applied: 1.5, Observed: 1})
+	assert.NoError(t, a.Close())
+
+	recs, err := readAuditLog(path, nil, time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	if assert.Len(t, recs, 2, "only weight changes should be recorded") {
+		assert.Equal(t, auditActionReweight, recs[0].Action)
+		assert.Equal(t, 1.5, recs[0].Weight)
+		assert.Nil(t, recs[0].Previous)
+		assert.Equal(t, auditActionExternal, recs[1].Action)
+		assert.Equal(t, 1.0, recs[1].Weight)
+		if assert.NotNil(t, recs[1].Previous) {
+			assert.Equal(t, 1.5, *recs[1].Previous)
+		}
+	}
+}
+
+func TestReadAuditLogFilters(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2021, 6, 1, hour, 0, 0, 0, time.UTC)
+	}
+	var data []byte
+	for _, rec := range []auditRecord{
+		{Time: at(10), OSD: 1, Action: auditActionReweight, Weight: 1.1},
+		{Time: at(11), OSD: 2, Action: auditActionReweight, Weight: 2.1},
+		{Time: at(12), OSD: 1, Action: auditActionReweight, Weight: 1.2},
+		{Time: at(13), OSD: 3, Action: auditActionReweight, Weight: 3.1},
+	} {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := ioutil.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		osds    []int
+		since   time.Time
+		until   time.Time
+		weights []float64
+	}{
+		{
+			name:    "All",
+			weights: []float64{1.1, 2.1, 1.2, 3.1},
+		},
+		{
+			name:    "OSDs",
+			osds:    []int{1, 3},
+			weights: []float64{1.1, 1.2, 3.1},
+		},
+		{
+			name:    "Since Is Inclusive",
+			since:   at(11),
+			weights: []float64{2.1, 1.2, 3.1},
+		},
+		{
+			name:    "Until Is Exclusive",
+			until:   at(12),
+			weights: []float64{1.1, 2.1},
+		},
+		{
+			name:    "All Filters",
+			osds:    []int{1},
+			since:   at(11),
+			until:   at(13),
+			weights: []float64{1.2},
+		},
+		{
+			name:  "None Matching",
+			osds:  []int{4},
+			since: at(11),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recs, err := readAuditLog(path, tt.osds, tt.since, tt.until)
+			assert.NoError(t, err)
+
+			var weights []float64
+			for _, rec := range recs {
+				weights = append(weights, rec.Weight)
+			}
+			assert.Equal(t, tt.weights, weights)
+		})
+	}
+
+	t.Run("Malformed", func(t *testing.T) {
+		bad := filepath.Join(t.TempDir(), "audit.log")
+		if err := ioutil.WriteFile(bad, append(data, "not json\n"...), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := readAuditLog(bad, nil, time.Time{}, time.Time{})
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "line 5:")
+		}
+	})
+}
+
+func TestParseHistoryTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+		err  bool
+	}{
+		{in: ""},
+		{in: "2021-06-01T12:30:00Z", want: time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC)},
+		{in: "2021-06-01", want: time.Date(2021, 6, 1, 0, 0, 0, 0, time.Local)},
+		{in: "2021-06-01 12:30", err: true},
+		{in: "yesterday", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseHistoryTime(tt.in)
+		if tt.err {
+			assert.Error(t, err, tt.in)
+			continue
+		}
+		assert.NoError(t, err, tt.in)
+		assert.True(t, tt.want.Equal(got), "%q parsed as %s", tt.in, got)
+	}
+}
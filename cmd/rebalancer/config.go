@@ -0,0 +1,216 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/digitalocean/archimedes/rebalancer"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// applyConfigFile sets the flags named by the keys of the --config
+// file to the values found, so that every flag can be given through
+// the file:
+//
+//	ceph-backend: rest
+//	ceph-rest-url: https://mgr.example.com:8003
+//	target-osd-crush-weights: '1:2.5999,2:2.5999'
+//	weight-increment: 0.05
+//	active-hours: ['Mon-Fri 22:00-06:00']
+//
+// Flags given on the command line or through their environment
+// variable take precedence, and the file leaves them be. Lists set
+// their flag once per element.
+func applyConfigFile(ctx *cli.Context, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("cannot parse %s: %s", path, err)
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == configFlag.Name {
+			return fmt.Errorf("%s: config files cannot be nested", path)
+		}
+
+		if ctx.IsSet(name) {
+			overriddenSettings(ctx)[name] = true
+			continue
+		}
+
+		vals, ok := settings[name].([]interface{})
+		if !ok {
+			vals = []interface{}{settings[name]}
+		}
+		for _, val := range vals {
+			switch val.(type) {
+			case nil, []interface{}, map[string]interface{}:
+				return fmt.Errorf("%s: %s must be a value or a list of values", path, name)
+			}
+			if err := setFlag(ctx, name, fmt.Sprint(val)); err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// overriddenSettings returns the settings of the --config file left
+// out in favor of the command line or the environment, so that
+// reloads leave them out as well.
+func overriddenSettings(ctx *cli.Context) map[string]bool {
+	if ctx.App.Metadata == nil {
+		ctx.App.Metadata = make(map[string]interface{})
+	}
+	overridden, ok := ctx.App.Metadata["overridden-settings"].(map[string]bool)
+	if !ok {
+		overridden = make(map[string]bool)
+		ctx.App.Metadata["overridden-settings"] = overridden
+	}
+	return overridden
+}
+
+// loadConfigFile applies the --config file, if any, before a
+// command runs.
+func loadConfigFile(ctx *cli.Context) error {
+	if path := ctx.String(configFlag.Name); path != "" {
+		if err := applyConfigFile(ctx, path); err != nil {
+			return fmt.Errorf("failed loading config: %s", err)
+		}
+	}
+	// The output format may as well be given in the config file.
+	return checkOutput(ctx)
+}
+
+// setFlag sets the flag of the given name on the context defining
+// it, either the command's or the app's for global flags. Flags of
+// other commands are ignored, so that commands can share a file.
+func setFlag(ctx *cli.Context, name, val string) error {
+	set := func(c *cli.Context) error {
+		if err := c.Set(name, val); err != nil {
+			return fmt.Errorf("invalid %s: %s", name, err)
+		}
+		return nil
+	}
+
+	if hasFlag(ctx.Command.Flags, name) {
+		return set(ctx)
+	}
+	for _, c := range ctx.Lineage()[1:] {
+		if c.App != nil && hasFlag(c.App.Flags, name) {
+			return set(c)
+		}
+	}
+	for _, cmd := range ctx.App.Commands {
+		if hasFlag(cmd.Flags, name) {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown setting %s", name)
+}
+
+func hasFlag(flags []cli.Flag, name string) bool {
+	for _, f := range flags {
+		for _, n := range f.Names() {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fileConfig holds the settings of the --config file which are
+// re-read on SIGHUP, as they can be changed while running. Other
+// settings of the file only take effect on restart.
+//
+//	target-osd-crush-weights: '1:2.5999,2:2.5999'
+//	weight-increment: 0.05
+//	sleep-duration: 10m
+//	max-backfill-pgs: 20
+type fileConfig struct {
+	TargetOSDCrushWeights *string        `yaml:"target-osd-crush-weights"`
+	WeightIncrement       *float64       `yaml:"weight-increment"`
+	SleepDuration         *time.Duration `yaml:"sleep-duration"`
+	MaxBackfillPGs        *int           `yaml:"max-backfill-pgs"`
+	MaxRecoveryPGs        *int           `yaml:"max-recovery-pgs"`
+	MaxInactivePGs        *int           `yaml:"max-inactive-pgs"`
+	MaxScrubbingPGs       *int           `yaml:"max-scrubbing-pgs"`
+	MaxSnapTrimPGs        *int           `yaml:"max-snaptrim-pgs"`
+}
+
+// loadConfig reads the config file and turns the settings found
+// into rebalancer options, leaving out the overridden ones.
+func loadConfig(path string, overridden map[string]bool) ([]rebalancer.Option, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg fileConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %s", path, err)
+	}
+
+	var opts []rebalancer.Option
+	if cfg.TargetOSDCrushWeights != nil && !overridden[targetOSDsCrushFlag.Name] {
+		twMap, err := parseTargetWeightMap(*cfg.TargetOSDCrushWeights)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing target-weights: %s", err)
+		}
+		opts = append(opts, rebalancer.WithTargetCrushWeightMap(twMap))
+	}
+	if cfg.WeightIncrement != nil && !overridden[weightIncrementFlag.Name] {
+		opts = append(opts, rebalancer.WithWeightIncrement(*cfg.WeightIncrement))
+	}
+	if cfg.SleepDuration != nil && !overridden[sleepDurationFlag.Name] {
+		opts = append(opts, rebalancer.WithSleepInterval(*cfg.SleepDuration))
+	}
+	if cfg.MaxBackfillPGs != nil && !overridden[maxBackfillPGsFlag.Name] {
+		opts = append(opts, rebalancer.WithMaxBackfillPGsAllowed(*cfg.MaxBackfillPGs))
+	}
+	if cfg.MaxRecoveryPGs != nil && !overridden[maxRecoveryPGsFlag.Name] {
+		opts = append(opts, rebalancer.WithMaxRecoveryPGsAllowed(*cfg.MaxRecoveryPGs))
+	}
+	if cfg.MaxInactivePGs != nil && !overridden[maxInactivePGsFlag.Name] {
+		opts = append(opts, rebalancer.WithMaxInactivePGsAllowed(*cfg.MaxInactivePGs))
+	}
+	if cfg.MaxScrubbingPGs != nil && !overridden[maxScrubbingPGsFlag.Name] {
+		opts = append(opts, rebalancer.WithMaxScrubbingPGs(*cfg.MaxScrubbingPGs))
+	}
+	if cfg.MaxSnapTrimPGs != nil && !overridden[maxSnapTrimPGsFlag.Name] {
+		opts = append(opts, rebalancer.WithMaxSnapTrimPGs(*cfg.MaxSnapTrimPGs))
+	}
+
+	return opts, nil
+}
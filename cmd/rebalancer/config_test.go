@@ -0,0 +1,167 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/digitalocean/archimedes/rebalancer"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+)
+
+// runWithConfig runs a command taking the given flags and the
+// --config file holding cfg, returning its context once done.
+func runWithConfig(t *testing.T, cfg string, flags []cli.Flag, args ...string) *cli.Context {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var got *cli.Context
+	app := &cli.App{
+		Flags: []cli.Flag{outputFlag},
+		Commands: []*cli.Command{{
+			Name:   "test",
+			Flags:  append([]cli.Flag{configFlag}, flags...),
+			Before: loadConfigFile,
+			Action: func(ctx *cli.Context) error {
+				got = ctx
+				return nil
+			},
+		}},
+	}
+	assert.NoError(t, app.Run(append([]string{"archimedes", "test", "--config", path}, args...)))
+	return got
+}
+
+func TestConfigFilePrecedence(t *testing.T) {
+	os.Setenv("CEPH_REBALANCER_SLEEP_DURATION", "7m")
+	defer os.Unsetenv("CEPH_REBALANCER_SLEEP_DURATION")
+
+	cfg := "weight-increment: 0.5\nsleep-duration: 3m\nmax-backfill-pgs: 20\n"
+	ctx := runWithConfig(t, cfg,
+		[]cli.Flag{
+			&cli.Float64Flag{Name: weightIncrementFlag.Name},
+			&cli.DurationFlag{Name: sleepDurationFlag.Name, EnvVars: sleepDurationFlag.EnvVars},
+			&cli.IntFlag{Name: maxBackfillPGsFlag.Name},
+		},
+		"--weight-increment", "0.1",
+	)
+
+	assert.Equal(t, 0.1, ctx.Float64(weightIncrementFlag.Name), "the command line should override the file")
+	assert.Equal(t, 7*time.Minute, ctx.Duration(sleepDurationFlag.Name), "the environment should override the file")
+	assert.Equal(t, 20, ctx.Int(maxBackfillPGsFlag.Name), "the file should set flags given nowhere else")
+	assert.Equal(t, map[string]bool{
+		weightIncrementFlag.Name: true,
+		sleepDurationFlag.Name:   true,
+	}, overriddenSettings(ctx), "reloads should leave out the overridden settings")
+}
+
+func TestLoadConfigOverridden(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	cfg := "weight-increment: 0.5\nmax-backfill-pgs: 20\n"
+	if err := ioutil.WriteFile(path, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := loadConfig(path, nil)
+	assert.NoError(t, err)
+	assert.Len(t, opts, 2)
+
+	opts, err = loadConfig(path, map[string]bool{weightIncrementFlag.Name: true})
+	assert.NoError(t, err)
+	assert.Len(t, opts, 1, "the overridden weight increment should be left out")
+}
+
+func TestReloadOnHangup(t *testing.T) {
+	cc := cephtest.New(cephtest.State{
+		OSDTree: cephtest.NewOSDTree(
+			cephtest.OSD{ID: 1, Host: "a", CrushWeight: 1},
+			cephtest.OSD{ID: 2, Host: "b", CrushWeight: 1},
+		),
+	})
+	r, err := rebalancer.New(
+		rebalancer.WithCephClient(cc),
+		rebalancer.WithTargetCrushWeightMap(map[int]float64{1: 2, 2: 2}),
+		rebalancer.WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logs := &syncBuffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	reloadOnHangup(ctx, r, path, nil)
+
+	hangup := func(targets string) {
+		if err := ioutil.WriteFile(path, []byte("target-osd-crush-weights: '"+targets+"'\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handled := func(msg string) {
+		assert.Eventually(t, func() bool {
+			return strings.Contains(logs.String(), msg)
+		}, 5*time.Second, 10*time.Millisecond, "reload should be handled")
+	}
+
+	hangup("1:2,2:3")
+	handled("reloaded config")
+	assert.Equal(t, map[int]float64{1: 2, 2: 3}, r.RemainingTargets())
+
+	// Lowering a target takes --allow-downweight.
+	hangup("1:0.5,2:3")
+	handled("invalid config, keeping previous settings")
+	assert.Equal(t, map[int]float64{1: 2, 2: 3}, r.RemainingTargets())
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use, e.g. to
+// collect the logs of a goroutine.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
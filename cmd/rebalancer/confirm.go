@@ -0,0 +1,72 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/digitalocean/archimedes/rebalancer"
+)
+
+// confirm prints the reweights the run is about to make and asks for
+// confirmation, guarding against mistyped target maps. Deltas are
+// shown to the weight precision of the run. No answer, as when there
+// is no terminal, counts as no.
+func confirm(ctx context.Context, r *rebalancer.Rebalancer, precision int, in io.Reader, out io.Writer) error {
+	sim, err := r.Simulate(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot compute reweights to confirm: %s", err)
+	}
+
+	targets := r.RemainingTargets()
+	osds := make([]int, 0, len(targets))
+	for osd := range targets {
+		osds = append(osds, osd)
+	}
+	sort.Ints(osds)
+
+	fmt.Fprintln(out, "about to reweight:")
+	for _, osd := range osds {
+		start, ok := sim.Start[osd]
+		if !ok {
+			fmt.Fprintf(out, "  osd.%d: not found in the osd tree\n", osd)
+			continue
+		}
+		fmt.Fprintf(out, "  osd.%d: %v -> %v (%+.*f)\n", osd, start, targets[osd], precision, targets[osd]-start)
+	}
+	fmt.Fprintf(out, "estimated iterations: %d, estimated duration: %s\n", len(sim.Iterations), sim.Duration)
+	fmt.Fprint(out, "proceed? [y/N] ")
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("cannot read confirmation: %s", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	case "":
+		if errors.Is(err, io.EOF) {
+			fmt.Fprintln(out)
+			return fmt.Errorf("no confirmation given, pass --%s to reweight without one", yesFlag.Name)
+		}
+	}
+	return errors.New("reweighting not confirmed")
+}
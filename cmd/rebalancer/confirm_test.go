@@ -0,0 +1,105 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/digitalocean/archimedes/rebalancer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		name      string
+		precision int
+		answer    string
+		err       string
+		shown     []string
+	}{
+		{
+			name:      "Yes",
+			precision: 4,
+			answer:    "y\n",
+			shown: []string{
+				"osd.1: 1 -> 1.5 (+0.5000)",
+				"osd.2: 2 -> 1.25 (-0.7500)",
+				"osd.3: not found in the osd tree",
+				"proceed? [y/N] ",
+			},
+		},
+		{
+			name:      "Precision",
+			precision: 2,
+			answer:    "YES\n",
+			shown:     []string{"osd.1: 1 -> 1.5 (+0.50)", "osd.2: 2 -> 1.25 (-0.75)\n"},
+		},
+		{
+			name:      "No",
+			precision: 4,
+			answer:    "n\n",
+			err:       "reweighting not confirmed",
+		},
+		{
+			name:      "Empty Line",
+			precision: 4,
+			answer:    "\n",
+			err:       "reweighting not confirmed",
+		},
+		{
+			name:      "No Answer",
+			precision: 4,
+			answer:    "",
+			err:       "no confirmation given, pass --yes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := cephtest.New(cephtest.State{
+				OSDTree: cephtest.NewOSDTree(
+					cephtest.OSD{ID: 1, Host: "a", CrushWeight: 1},
+					cephtest.OSD{ID: 2, Host: "a", CrushWeight: 2},
+				),
+			})
+			r, err := rebalancer.New(
+				rebalancer.WithCephClient(cc),
+				rebalancer.WithTargetCrushWeightMap(map[int]float64{1: 1.5, 2: 1.25, 3: 1}),
+				rebalancer.WithAllowDownweight(true),
+				rebalancer.WithWeightPrecision(tt.precision),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var out strings.Builder
+			err = confirm(context.Background(), r, tt.precision, strings.NewReader(tt.answer), &out)
+			if tt.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tt.err)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+			for _, s := range tt.shown {
+				assert.Contains(t, out.String(), s)
+			}
+			assert.Empty(t, cc.CallsTo("CrushReweight"), "confirming should not reweight")
+		})
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// sizeUnits maps the units sizes may be given in to their number of
+// bytes. Drives are sold by SI units, while Ceph reports sizes in IEC
+// units and abbreviates them to a single letter.
+var sizeUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"K":   1 << 10,
+	"KIB": 1 << 10,
+	"KB":  1e3,
+	"M":   1 << 20,
+	"MIB": 1 << 20,
+	"MB":  1e6,
+	"G":   1 << 30,
+	"GIB": 1 << 30,
+	"GB":  1e9,
+	"T":   1 << 40,
+	"TIB": 1 << 40,
+	"TB":  1e12,
+	"P":   1 << 50,
+	"PIB": 1 << 50,
+	"PB":  1e15,
+}
+
+// parseSize parses a size such as 8TB, 7.3T or 7.3TiB into bytes.
+func parseSize(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(s[i:]))]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in size %q", s)
+	}
+	if math.IsInf(n*unit, 0) {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return n * unit, nil
+}
+
+// bytesToWeight returns the CRUSH weight of a device of the given
+// size, which by convention is its size in TiB.
+func bytesToWeight(b float64) float64 {
+	return b / (1 << 40)
+}
+
+// kbToWeight returns the CRUSH weight of a device of the given size
+// in KiB, as reported by `ceph osd df`.
+func kbToWeight(kb int64) float64 {
+	return bytesToWeight(float64(kb) * (1 << 10))
+}
+
+func roundTo(w float64, precision int) float64 {
+	p := math.Pow10(precision)
+	return math.Round(w*p) / p
+}
+
+// conversion is the outcome of the convert command. Sizes are given
+// in TiB, like Ceph reports them, and in TB, like drives are sold.
+type conversion struct {
+	Sizes   []sizeConversion   `json:"sizes,omitempty"`
+	Weights []weightConversion `json:"weights,omitempty"`
+	OSDs    []osdConversion    `json:"osds,omitempty"`
+
+	// Targets is the target-weight map reweighting the OSDs to
+	// the size of their devices.
+	Targets string `json:"targets,omitempty"`
+}
+
+type sizeConversion struct {
+	Size   string  `json:"size"`
+	Weight float64 `json:"weight"`
+}
+
+type weightConversion struct {
+	Weight float64 `json:"weight"`
+	TiB    float64 `json:"tib"`
+	TB     float64 `json:"tb"`
+}
+
+type osdConversion struct {
+	OSD     int     `json:"osd"`
+	Found   bool    `json:"found"`
+	TiB     float64 `json:"tib"`
+	Weight  float64 `json:"weight"`
+	Current float64 `json:"current"`
+}
+
+// convert converts sizes to CRUSH weights and back, and lists the
+// CRUSH weights matching the size of the devices of OSDs.
+func convert(ctx *cli.Context) error {
+	sizes := ctx.StringSlice(sizeFlag.Name)
+	weights := ctx.Float64Slice(weightFlag.Name)
+	osds := ctx.IntSlice(osdFlag.Name)
+	if len(sizes)+len(weights)+len(osds) == 0 {
+		return fmt.Errorf("nothing to convert, see --%s, --%s and --%s", sizeFlag.Name, weightFlag.Name, osdFlag.Name)
+	}
+	precision := ctx.Int(weightPrecisionFlag.Name)
+
+	var c conversion
+	for _, s := range sizes {
+		b, err := parseSize(s)
+		if err != nil {
+			return err
+		}
+		c.Sizes = append(c.Sizes, sizeConversion{Size: s, Weight: roundTo(bytesToWeight(b), precision)})
+	}
+	for _, w := range weights {
+		b := w * (1 << 40)
+		c.Weights = append(c.Weights, weightConversion{Weight: w, TiB: roundTo(b/(1<<40), 2), TB: roundTo(b/1e12, 2)})
+	}
+	if len(osds) > 0 {
+		if err := convertOSDs(ctx, &c, osds, precision); err != nil {
+			return err
+		}
+	}
+
+	if jsonOutput(ctx) {
+		return printJSON(&c)
+	}
+	for _, sc := range c.Sizes {
+		fmt.Printf("%s: weight %v\n", sc.Size, sc.Weight)
+	}
+	for _, wc := range c.Weights {
+		fmt.Printf("weight %v: %.2f TiB, %.2f TB\n", wc.Weight, wc.TiB, wc.TB)
+	}
+	for _, oc := range c.OSDs {
+		switch {
+		case !oc.Found:
+			fmt.Printf("osd.%d: not found\n", oc.OSD)
+		case oc.TiB == 0:
+			fmt.Printf("osd.%d: size unknown, the osd may be down\n", oc.OSD)
+		default:
+			fmt.Printf("osd.%d: %.2f TiB, weight %v, currently %v\n", oc.OSD, oc.TiB, oc.Weight, oc.Current)
+		}
+	}
+	if c.Targets != "" {
+		fmt.Printf("full size targets: %s\n", c.Targets)
+	}
+	return nil
+}
+
+// convertOSDs adds the CRUSH weights matching the size of the devices
+// of the given OSDs, as reported by `ceph osd df`, to the conversion.
+func convertOSDs(ctx *cli.Context, c *conversion, osds []int, precision int) error {
+	cc, err := newCephClient(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot create new ceph-client: %s", err)
+	}
+	defer cc.Close()
+
+	df, err := cc.OSDDF(context.Background())
+	if err != nil {
+		return fmt.Errorf("cannot get osd df: %s", err)
+	}
+	nodes := make(map[int]int, len(df.Nodes))
+	for i, node := range df.Nodes {
+		if node.Type == "osd" {
+			nodes[node.ID] = i
+		}
+	}
+
+	sort.Ints(osds)
+	targets := make(map[int]float64, len(osds))
+	for _, osd := range osds {
+		oc := osdConversion{OSD: osd}
+		// OSDs that are down report no size.
+		if i, ok := nodes[osd]; ok {
+			node := df.Nodes[i]
+			oc.Found = true
+			oc.Current = node.CrushWeight
+			if node.KB > 0 {
+				oc.TiB = roundTo(kbToWeight(node.KB), 2)
+				oc.Weight = roundTo(kbToWeight(node.KB), precision)
+				targets[osd] = oc.Weight
+			}
+		}
+		c.OSDs = append(c.OSDs, oc)
+	}
+	if len(targets) > 0 {
+		c.Targets = formatTargetWeightMap(targets)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size  string
+		bytes float64
+		err   string
+	}{
+		{size: "1024", bytes: 1024},
+		{size: "512B", bytes: 512},
+		{size: "1.5K", bytes: 1536},
+		{size: "2KiB", bytes: 2048},
+		{size: "2KB", bytes: 2000},
+		{size: "8TB", bytes: 8e12},
+		{size: "7.3T", bytes: 7.3 * (1 << 40)},
+		{size: "7.3TiB", bytes: 7.3 * (1 << 40)},
+		{size: " 512 gib ", bytes: 512 * (1 << 30)},
+		{size: "1PB", bytes: 1e15},
+		{size: "", err: `invalid size ""`},
+		{size: "TB", err: `invalid size "TB"`},
+		{size: "-1T", err: `invalid size "-1T"`},
+		{size: "1.2.3T", err: `invalid size "1.2.3T"`},
+		{size: "8XB", err: `unknown unit in size "8XB"`},
+		{size: "8T B", err: `unknown unit in size "8T B"`},
+		{size: "8TBs", err: `unknown unit in size "8TBs"`},
+		{size: strings.Repeat("9", 400), err: "invalid size"},
+		{size: strings.Repeat("9", 300) + "PB", err: "is too large"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSize(tt.size)
+		if tt.err != "" {
+			if assert.Error(t, err, tt.size) {
+				assert.Contains(t, err.Error(), tt.err)
+			}
+			continue
+		}
+		assert.NoError(t, err, tt.size)
+		assert.Equal(t, tt.bytes, got, tt.size)
+	}
+}
+
+func TestSizeToWeight(t *testing.T) {
+	b, err := parseSize("8TB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 7.276, roundTo(bytesToWeight(b), 3), "drives are sold in TB but weighted in TiB")
+	assert.Equal(t, 2.0, kbToWeight(2<<30))
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/digitalocean/archimedes/rebalancer"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// Ways a run shows its progress, see --progress.
+const (
+	progressAuto  = "auto"
+	progressTable = "table"
+	progressLogs  = "logs"
+)
+
+// displayInterval is how often the progress table is redrawn.
+const displayInterval = time.Second
+
+// showTable reports whether a run renders the live progress table
+// rather than only logging. In auto mode that is when stdout is a
+// terminal and no JSON is printed to it.
+func showTable(ctx *cli.Context) (bool, error) {
+	switch p := ctx.String(progressFlag.Name); p {
+	case progressAuto:
+		return !jsonOutput(ctx) && isTerminal(os.Stdout), nil
+	case progressTable:
+		return true, nil
+	case progressLogs:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown progress display %q, either %s, %s or %s", p, progressAuto, progressTable, progressLogs)
+	}
+}
+
+// isTerminal reports whether f is a terminal rather than a file or a
+// pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// quietLogger returns the logger the rebalancer logs to while the
+// progress table is shown, which only lets through warnings and
+// errors so that the table is not scrolled away.
+func quietLogger() log.FieldLogger {
+	l := log.New()
+	l.SetOutput(os.Stderr)
+	l.SetFormatter(log.StandardLogger().Formatter)
+	l.SetLevel(log.WarnLevel)
+	return l
+}
+
+// progressDisplay redraws the progress of a run in place.
+type progressDisplay struct {
+	r   *rebalancer.Rebalancer
+	out io.Writer
+
+	// The first progress seen and when, which the remaining time is
+	// extrapolated from.
+	since        time.Time
+	startPercent float64
+}
+
+func newProgressDisplay(r *rebalancer.Rebalancer, out io.Writer) *progressDisplay {
+	return &progressDisplay{
+		r:            r,
+		out:          out,
+		since:        time.Now(),
+		startPercent: r.Progress().Percent,
+	}
+}
+
+// run redraws the table every displayInterval until ctx is done, when
+// it draws it a last time.
+func (d *progressDisplay) run(ctx context.Context) {
+	ticker := time.NewTicker(displayInterval)
+	defer ticker.Stop()
+
+	for {
+		d.render(d.r.Status(), time.Now())
+		select {
+		case <-ctx.Done():
+			d.render(d.r.Status(), time.Now())
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// eta estimates how long the run has left, going by the pace of the
+// progress made since the display started.
+func (d *progressDisplay) eta(percent float64, now time.Time) string {
+	done := percent - d.startPercent
+	if done <= 0 {
+		return "unknown"
+	}
+	left := time.Duration(float64(now.Sub(d.since)) * (100 - percent) / done)
+	return left.Round(time.Second).String()
+}
+
+// render clears the terminal and draws the table for the given status.
+func (d *progressDisplay) render(s rebalancer.Status, now time.Time) {
+	fmt.Fprint(d.out, "\033[H\033[2J")
+
+	state := "reweighting"
+	switch it := s.LastIteration; {
+	case s.Paused:
+		state = "paused, send SIGUSR2 to resume"
+	case it == nil:
+		state = "starting"
+	case it.Done:
+		state = "all osds processed"
+	case it.SkipReason != "":
+		state = "waiting, " + it.SkipReason
+	}
+	fmt.Fprintf(d.out, "progress: %.1f%%, %d osds remaining, eta %s\n", s.Progress.Percent, len(s.Remaining), d.eta(s.Progress.Percent, now))
+	fmt.Fprintf(d.out, "state: %s\n\n", state)
+
+	osds := make([]int, 0, len(s.Progress.OSDs))
+	for osd := range s.Progress.OSDs {
+		osds = append(osds, osd)
+	}
+	sort.Ints(osds)
+
+	w := tabwriter.NewWriter(d.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OSD\tCURRENT\tTARGET\tDONE")
+	for _, osd := range osds {
+		op := s.Progress.OSDs[osd]
+		fmt.Fprintf(w, "osd.%d\t%v\t%v\t%.1f%%\n", osd, op.Current, op.Target, osdPercent(op))
+	}
+	w.Flush()
+
+	if it := s.LastIteration; it != nil && len(it.Gates) > 0 {
+		fmt.Fprintln(d.out)
+		w = tabwriter.NewWriter(d.out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "GATE\tRESULT\tDETAIL")
+		for _, c := range it.Gates {
+			result, detail := checkResult(c)
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, result, detail)
+		}
+		w.Flush()
+	}
+}
+
+// osdPercent is the share of the weight change of an OSD applied so
+// far, from 0 to 100.
+func osdPercent(op rebalancer.OSDProgress) float64 {
+	if op.Completed || op.Target == op.Start {
+		return 100
+	}
+	return 100 * (op.Current - op.Start) / (op.Target - op.Start)
+}
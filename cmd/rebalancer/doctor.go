@@ -0,0 +1,96 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/digitalocean/archimedes/rebalancer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/urfave/cli/v2"
+)
+
+// doctor runs the checks the reweight command would run before its
+// first iteration once and prints their outcome. It exits with 1 if
+// any check failed, or with exitTempFail if some could not be run.
+func doctor(ctx *cli.Context) error {
+	cc, err := newCephClient(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot create new ceph-client: %s", err)
+	}
+	defer cc.Close()
+
+	opts, err := rebalancerOptions(ctx, cc, prometheus.NewRegistry())
+	if err != nil {
+		return err
+	}
+	r, err := rebalancer.New(opts...)
+	if err != nil {
+		return fmt.Errorf("initializing archimedes failed: %s", err)
+	}
+
+	var failed, errored int
+	var checks []gateStatus
+	for _, c := range r.Preflight(context.Background()) {
+		result, detail := checkResult(c)
+		switch result {
+		case "error":
+			errored++
+		case "fail":
+			failed++
+		}
+		checks = append(checks, gateStatus{Name: c.Name, Result: result, Detail: detail})
+	}
+
+	if jsonOutput(ctx) {
+		err := printJSON(struct {
+			OK     bool         `json:"ok"`
+			Checks []gateStatus `json:"checks"`
+		}{failed+errored == 0, checks})
+		if err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CHECK\tRESULT\tDETAIL")
+		for _, c := range checks {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, c.Result, c.Detail)
+		}
+		w.Flush()
+	}
+
+	switch {
+	case failed > 0:
+		return cli.Exit(fmt.Sprintf("%d checks failed", failed), 1)
+	case errored > 0:
+		return cli.Exit(fmt.Sprintf("%d checks could not be run, retrying may succeed", errored), exitTempFail)
+	}
+	return nil
+}
+
+// checkResult returns whether a check passed, failed or could not be
+// run, along with its detail.
+func checkResult(c rebalancer.Check) (result, detail string) {
+	switch {
+	case c.Err != nil:
+		return "error", c.Err.Error()
+	case !c.OK:
+		return "fail", c.Reason
+	}
+	return "pass", c.Reason
+}
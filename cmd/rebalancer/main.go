@@ -16,16 +16,24 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	rebalancer "github.com/digitalocean/archimedes"
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/gates"
+	"github.com/digitalocean/archimedes/rebalancer"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/urfave/cli/v2"
@@ -33,6 +41,10 @@ import (
 
 const (
 	appName = "archimedes"
+
+	// exitTempFail is the exit status of runs that failed for
+	// reasons likely to go away on their own, as in sysexits.h.
+	exitTempFail = 75
 )
 
 func main() {
@@ -48,8 +60,24 @@ func main() {
 	app.Flags = []cli.Flag{
 		cephUserFlag,
 		cephConfigPathFlag,
+		cephMonHostFlag,
+		cephKeyringFlag,
+		cephKeyFileFlag,
+		cephKeyFlag,
+		cephBackendFlag,
+		cephBinaryFlag,
+		cephRESTURLFlag,
+		cephRESTKeyFileFlag,
+		cephRESTCAFileFlag,
+		cephTimeoutFlag,
+		cephConnectTimeoutFlag,
+		cephMaxCommandsPerSecondFlag,
+		cephRetriesFlag,
+		cephBackoffFlag,
 		metricsAddrFlag,
+		outputFlag,
 	}
+	app.Before = checkOutput
 	app.Commands = commands
 
 	if err := app.Run(os.Args); err != nil {
@@ -62,74 +90,725 @@ var commands = []*cli.Command{
 		Name:        "reweight",
 		Usage:       "Reweight a set of OSDs",
 		Description: "Reweight a set of OSDs",
+		Flags:       reweightFlags,
+		Before:      loadConfigFile,
+		Action:      reweight,
+	},
+	{
+		Name:        "plan",
+		Usage:       "Show the reweights planned for a set of OSDs",
+		Description: "Compute the weights each iteration would apply to a set of OSDs until they reach their target, without changing anything on the cluster",
 		Flags: []cli.Flag{
-			maxBackfillPGsFlag,
-			maxRecoveryPGsFlag,
+			configFlag,
 			targetOSDsCrushFlag,
 			weightIncrementFlag,
+			weightPrecisionFlag,
 			sleepDurationFlag,
-			enableCephBalancerFlag,
-			dryRunFlag,
+			minSleepDurationFlag,
+			maxSleepDurationFlag,
+			maxIterationsFlag,
+			maxOSDsPerIterationFlag,
+			planOutFlag,
+			planKeyFileFlag,
 		},
+		Before: loadConfigFile,
 		Action: func(ctx *cli.Context) error {
-			cc, err := rebalancer.NewCephClient(
-				ctx.String(cephUserFlag.Name),
-				ctx.String(cephConfigPathFlag.Name),
-			)
+			twMap, err := parseTargetWeightMap(ctx.String(targetOSDsCrushFlag.Name))
 			if err != nil {
-				return fmt.Errorf("cannot create new ceph-client: %s", err)
+				return fmt.Errorf("failed parsing target-weights: %s", err)
 			}
-			defer cc.Close()
 
-			twMap, err := parseTargetWeightMap(ctx.String(targetOSDsCrushFlag.Name))
+			cc, err := newCephClient(ctx)
 			if err != nil {
-				return fmt.Errorf("failed parsing target-weights: %s", err)
+				return fmt.Errorf("cannot create new ceph-client: %s", err)
 			}
+			defer cc.Close()
 
 			r, err := rebalancer.New(
 				rebalancer.WithCephClient(cc),
-				rebalancer.WithMaxBackfillPGsAllowed(ctx.Int(maxBackfillPGsFlag.Name)),
-				rebalancer.WithMaxRecoveryPGsAllowed(ctx.Int(maxRecoveryPGsFlag.Name)),
 				rebalancer.WithTargetCrushWeightMap(twMap),
 				rebalancer.WithWeightIncrement(ctx.Float64(weightIncrementFlag.Name)),
+				rebalancer.WithWeightPrecision(ctx.Int(weightPrecisionFlag.Name)),
 				rebalancer.WithSleepInterval(ctx.Duration(sleepDurationFlag.Name)),
-				rebalancer.WithEnableCephBalancer(ctx.Bool(enableCephBalancerFlag.Name)),
-				rebalancer.WithDryRun(ctx.Bool(dryRunFlag.Name)),
+				rebalancer.WithMinSleepInterval(ctx.Duration(minSleepDurationFlag.Name)),
+				rebalancer.WithMaxSleepInterval(ctx.Duration(maxSleepDurationFlag.Name)),
+				rebalancer.WithMaxIterations(ctx.Int(maxIterationsFlag.Name)),
+				rebalancer.WithMaxOSDsPerIteration(ctx.Int(maxOSDsPerIterationFlag.Name)),
+				rebalancer.WithDryRun(true),
+				rebalancer.WithMetricsRegistry(prometheus.NewRegistry()),
 			)
 			if err != nil {
 				return fmt.Errorf("initializing archimedes failed: %s", err)
 			}
 
-			go func() {
-				prometheus.MustRegister(r)
-				http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-					w.Write(
-						[]byte(`
-							<html>
-								<head><title>Ceph-Rebalancer</title></head>
-								<body>
-									<h1>Prometheus metrics for Ceph Rebalancer</h1>
-									<p><a href='/metrics'>Metrics</a></p>
-								</body>
-							</html>
-						`),
-					)
-				})
-				http.Handle("/metrics", promhttp.Handler())
-
-				metricsAddr := ctx.String(metricsAddrFlag.Name)
-				if err := http.ListenAndServe(metricsAddr, nil); err != nil {
-					log.Fatalf("cannot start metrics server on %q: %s", metricsAddr, err)
+			sim, err := r.Simulate(context.Background())
+			if err != nil {
+				return fmt.Errorf("cannot plan reweights: %s", err)
+			}
+
+			p := newPlan(twMap, sim, ctx.Float64(weightIncrementFlag.Name), ctx.Int(weightPrecisionFlag.Name), ctx.Int(maxOSDsPerIterationFlag.Name))
+			out := ctx.String(planOutFlag.Name)
+			if out != "" {
+				key, err := readPlanKey(ctx.String(planKeyFileFlag.Name))
+				if err != nil {
+					return err
+				}
+				if err := writePlan(out, p, key); err != nil {
+					return fmt.Errorf("cannot write plan: %s", err)
+				}
+			}
+			if jsonOutput(ctx) {
+				return printJSON(p)
+			}
+
+			osds := make([]int, 0, len(twMap))
+			for osd := range twMap {
+				osds = append(osds, osd)
+			}
+			sort.Ints(osds)
+			for _, osd := range osds {
+				start, ok := sim.Start[osd]
+				if !ok {
+					fmt.Printf("osd.%d: not found in the osd tree\n", osd)
+					continue
 				}
-			}()
 
-			cctx, cancel := context.WithCancel(context.Background())
-			defer cancel()
+				steps := []string{strconv.FormatFloat(start, 'f', -1, 64)}
+				for _, weights := range sim.Iterations {
+					if w, ok := weights[osd]; ok {
+						steps = append(steps, strconv.FormatFloat(w, 'f', -1, 64))
+					}
+				}
+				fmt.Printf("osd.%d: %s\n", osd, strings.Join(steps, " -> "))
+			}
+			fmt.Printf("iterations: %d\n", len(sim.Iterations))
+			fmt.Printf("estimated duration: %s\n", sim.Duration)
 
-			r.Run(cctx)
+			if out != "" {
+				fmt.Printf("plan written to %s, carry it out with apply --plan %s\n", out, out)
+			}
 			return nil
 		},
 	},
+	{
+		Name:        "apply",
+		Usage:       "Reweight a set of OSDs as planned",
+		Description: "Carry out a plan written by the plan command, refusing to start if the weights of its OSDs changed since",
+		Flags:       append([]cli.Flag{planFlag, planKeyFileFlag}, reweightFlags...),
+		Before:      loadConfigFile,
+		Action:      apply,
+	},
+	{
+		Name:        "resume",
+		Usage:       "Carry on with a reweight from its saved state",
+		Description: "Carry on with a reweight that crashed or was stopped from the state it saved to --state-file, with the targets, applied weights and budgets it left off with",
+		Flags:       reweightFlags,
+		Before:      loadConfigFile,
+		Action:      resume,
+	},
+	{
+		Name:        "validate",
+		Usage:       "Check a set of target weights against the cluster",
+		Description: "Check target weights for unknown OSDs, targets below the current weight unless --allow-downweight is passed or beyond the device capacity and duplicate entries, exiting non-zero if any is found",
+		Flags: []cli.Flag{
+			configFlag,
+			targetOSDsCrushFlag,
+			allowDownweightFlag,
+		},
+		Before: loadConfigFile,
+		Action: validate,
+	},
+	{
+		Name:        "doctor",
+		Usage:       "Run the checks gating reweights once",
+		Description: "Run the connection, balancer and gate checks of reweight once and print whether each passes, exiting non-zero if any does not",
+		Flags:       reweightFlags,
+		Before:      loadConfigFile,
+		Action:      doctor,
+	},
+	{
+		Name:        "status",
+		Usage:       "Show how a running reweight is going",
+		Description: "Query a running reweight for the progress of every OSD, the outcome of its last iteration and the state of the gates it evaluated",
+		Flags: []cli.Flag{
+			instanceFlag,
+		},
+		Action: status,
+	},
+	{
+		Name:        "history",
+		Usage:       "List the weight changes recorded in the audit log",
+		Description: "List the reweights recorded in the audit log by reweight, and the changes made outside of archimedes it ran into, optionally only those of some OSDs or within a time range",
+		Flags: []cli.Flag{
+			configFlag,
+			auditLogFlag,
+			osdFlag,
+			sinceFlag,
+			untilFlag,
+		},
+		Before: loadConfigFile,
+		Action: history,
+	},
+	{
+		Name:        "snapshot",
+		Usage:       "Save the weights of a set of OSDs",
+		Description: "Save the current CRUSH weights of the OSDs given, and of the OSDs under the buckets given, for the restore command to return them to",
+		Flags: []cli.Flag{
+			configFlag,
+			snapshotFlag,
+			osdFlag,
+			subtreeFlag,
+		},
+		Before: loadConfigFile,
+		Action: takeSnapshot,
+	},
+	{
+		Name:        "restore",
+		Usage:       "Return a set of OSDs to the weights saved by snapshot",
+		Description: "Gradually reweight the OSDs of a snapshot back to the weights saved, the way reweight does, respecting its gates",
+		Flags:       append([]cli.Flag{snapshotFlag}, reweightFlags...),
+		Before:      loadConfigFile,
+		Action:      restore,
+	},
+	{
+		Name:        "convert",
+		Usage:       "Convert between device sizes and CRUSH weights",
+		Description: "Convert device sizes to CRUSH weights and back, and show the CRUSH weights matching the size of the devices of OSDs as reported by osd df",
+		Flags: []cli.Flag{
+			configFlag,
+			sizeFlag,
+			weightFlag,
+			osdFlag,
+			weightPrecisionFlag,
+		},
+		Before: loadConfigFile,
+		Action: convert,
+	},
+	{
+		Name:        "balancer-status",
+		Usage:       "Show the state of the Ceph balancer",
+		Description: "Show the state of the Ceph balancer and whether it would compete with reweights",
+		Action: func(ctx *cli.Context) error {
+			cc, err := newCephClient(ctx)
+			if err != nil {
+				return fmt.Errorf("cannot create new ceph-client: %s", err)
+			}
+			defer cc.Close()
+
+			status, err := cc.BalancerStatus(context.Background())
+			if err != nil {
+				return fmt.Errorf("cannot get balancer status: %s", err)
+			}
+			if jsonOutput(ctx) {
+				return printJSON(struct {
+					*cephclient.BalancerStatusOut
+					Conflicts bool `json:"conflicts"`
+				}{status, status.Conflicts()})
+			}
+
+			fmt.Printf("active: %t\n", status.Active)
+			fmt.Printf("mode: %s\n", status.Mode)
+			if status.LastOptimizeStarted != "" {
+				fmt.Printf("last optimization: %s, took %s\n", status.LastOptimizeStarted, status.LastOptimizeDuration)
+			}
+			if status.OptimizeResult != "" {
+				fmt.Printf("optimize result: %s\n", status.OptimizeResult)
+			}
+			fmt.Printf("plans: %d\n", len(status.Plans))
+			for _, plan := range status.Plans {
+				fmt.Printf("  %s\n", plan)
+			}
+
+			if status.Conflicts() {
+				fmt.Println("the balancer will compete with reweights, see --ceph-balancer-policy")
+			} else {
+				fmt.Println("the balancer will not compete with reweights")
+			}
+			return nil
+		},
+	},
+}
+
+// reweightFlags are the flags of the reweight command, which the
+// apply command shares.
+var reweightFlags = []cli.Flag{
+	configFlag,
+	maxBackfillPGsFlag,
+	maxRecoveryPGsFlag,
+	poolAwarePGCountsFlag,
+	maxInactivePGsFlag,
+	pauseOnPGAutoscalingFlag,
+	maxScrubbingPGsFlag,
+	maxSnapTrimPGsFlag,
+	maxBackfillBytesFlag,
+	maxMisplacedRatioFlag,
+	maxDegradedObjectsFlag,
+	maxDegradedPGsFlag,
+	maxSlowOpsFlag,
+	maxDownOSDsFlag,
+	requireMonQuorumFlag,
+	maxHeartbeatLatencyFlag,
+	maxOSDLatencyFlag,
+	osdLatencyPercentileFlag,
+	pauseOnHealthWarnFlag,
+	abortOnHealthErrFlag,
+	rollbackOnAbortFlag,
+	fullOSDScopeFlag,
+	flagPolicyFlag,
+	allowedFlagsFlag,
+	promQLGateFlag,
+	alertmanagerURLFlag,
+	alertSelectorFlag,
+	gateHookFlag,
+	gateHookTimeoutFlag,
+	gateExprFlag,
+	activeHoursFlag,
+	maintenanceCalendarFlag,
+	targetOSDsCrushFlag,
+	weightIncrementFlag,
+	weightPrecisionFlag,
+	maxMissingIterationsFlag,
+	verifyReweightsFlag,
+	osdmapWaitFlag,
+	sleepDurationFlag,
+	runImmediatelyFlag,
+	minSleepDurationFlag,
+	maxSleepDurationFlag,
+	canaryOSDFlag,
+	canarySoakFlag,
+	maxImpactScoreFlag,
+	impactScoreSamplesFlag,
+	maxDurationFlag,
+	maxIterationsFlag,
+	enableCephBalancerFlag,
+	balancerPolicyFlag,
+	allowDownweightFlag,
+	okToStopPolicyFlag,
+	downOSDPolicyFlag,
+	drainCompletionFlag,
+	primaryAffinityFlag,
+	osdMaxBackfillsFlag,
+	osdRecoveryMaxActiveFlag,
+	osdTreeCacheTTLFlag,
+	maxReweightsPerHourFlag,
+	maxOSDsPerIterationFlag,
+	reweightWorkersFlag,
+	failureDomainFlag,
+	maxOSDsPerFailureDomainFlag,
+	maxWeightDeltaPerHostFlag,
+	dryRunFlag,
+	yesFlag,
+	progressFlag,
+	auditLogFlag,
+	stateFileFlag,
+}
+
+// reweight runs a reweighting campaign as configured by the flags.
+func reweight(ctx *cli.Context) error {
+	return reweightWith(ctx)
+}
+
+// reweightWith runs a reweighting campaign as configured by the flags
+// and the given options, which take precedence.
+func reweightWith(ctx *cli.Context, extra ...rebalancer.Option) error {
+	cc, err := newCephClient(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot create new ceph-client: %s", err)
+	}
+	defer cc.Close()
+
+	// A private registry keeps the exported metrics to those
+	// of the rebalancer and the process running it.
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+
+	opts, err := rebalancerOptions(ctx, cc, registry)
+	if err != nil {
+		return err
+	}
+
+	table, err := showTable(ctx)
+	if err != nil {
+		return err
+	}
+	if table {
+		opts = append(opts, rebalancer.WithLogger(quietLogger()))
+	}
+
+	if path := ctx.String(auditLogFlag.Name); path != "" {
+		audit, err := openAuditLog(path)
+		if err != nil {
+			return fmt.Errorf("cannot open audit log: %s", err)
+		}
+		defer audit.Close()
+		opts = append(opts, rebalancer.WithEventHandler(audit.handle))
+	}
+	if path := ctx.String(stateFileFlag.Name); path != "" {
+		opts = append(opts, rebalancer.WithStateHandler(func(s rebalancer.State) {
+			if err := writeState(path, s); err != nil {
+				log.Printf("cannot save state: %s", err)
+			}
+		}))
+	}
+	opts = append(opts, extra...)
+
+	r, err := rebalancer.New(opts...)
+	if err != nil {
+		return fmt.Errorf("initializing archimedes failed: %s", err)
+	}
+
+	if !ctx.Bool(dryRunFlag.Name) && !ctx.Bool(yesFlag.Name) {
+		if err := confirm(context.Background(), r, ctx.Int(weightPrecisionFlag.Name), os.Stdin, os.Stderr); err != nil {
+			return err
+		}
+	}
+
+	go func() {
+		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write(
+				[]byte(`
+					<html>
+						<head><title>Ceph-Rebalancer</title></head>
+						<body>
+							<h1>Prometheus metrics for Ceph Rebalancer</h1>
+							<p><a href='/metrics'>Metrics</a></p>
+							<p><a href='/status'>Status</a></p>
+						</body>
+					</html>
+				`),
+			)
+		})
+		http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		http.Handle("/status", statusHandler(r))
+
+		metricsAddr := ctx.String(metricsAddrFlag.Name)
+		if err := http.ListenAndServe(metricsAddr, nil); err != nil {
+			log.Fatalf("cannot start metrics server on %q: %s", metricsAddr, err)
+		}
+	}()
+
+	// Ctrl-C or `docker stop` cancels the run, which then restores
+	// the recovery options and the Ceph balancer it changed.
+	cctx, cancel := interruptible(ctx.Context)
+	defer cancel()
+
+	// Reloads must not change the targets of an applied plan, a
+	// restored snapshot or a resumed run.
+	if cfgPath := ctx.String(configFlag.Name); cfgPath != "" && ctx.Command.Name == "reweight" {
+		reloadOnHangup(cctx, r, cfgPath, overriddenSettings(ctx))
+	}
+
+	// SIGUSR1 freezes the campaign, e.g. during an incident, and
+	// SIGUSR2 carries on with it.
+	usr := make(chan os.Signal, 1)
+	signal.Notify(usr, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(usr)
+
+	go func() {
+		for {
+			select {
+			case <-cctx.Done():
+				return
+			case sig := <-usr:
+				if sig == syscall.SIGUSR1 {
+					r.Pause()
+				} else {
+					r.Resume()
+				}
+			}
+		}
+	}()
+
+	if ctx.Bool(dryRunFlag.Name) {
+		sim, err := r.Simulate(cctx)
+		if err != nil {
+			log.Printf("cannot simulate reweighting: %s", err)
+		} else {
+			for i, weights := range sim.Iterations {
+				log.Printf("iteration %d would reweight: %s", i+1, formatTargetWeightMap(weights))
+			}
+			log.Printf("expected iterations: %d, estimated duration: %s", len(sim.Iterations), sim.Duration)
+		}
+	}
+
+	stopDisplay := func() {}
+	if table {
+		dctx, cancelDisplay := context.WithCancel(cctx)
+		displayed := make(chan struct{})
+		go func() {
+			defer close(displayed)
+			newProgressDisplay(r, os.Stdout).run(dctx)
+		}()
+		stopDisplay = func() {
+			cancelDisplay()
+			<-displayed
+		}
+	}
+
+	summary, runErr := r.Run(cctx)
+	stopDisplay()
+
+	if summary.RolledBack {
+		log.Printf("reweighting was aborted and rolled back")
+	}
+	log.Printf("iterations run: %d", summary.Iterations)
+	if len(summary.Completed) > 0 {
+		log.Printf("osds completed: %v", summary.Completed)
+	}
+	skipped := make([]int, 0, len(summary.Skipped))
+	for osd := range summary.Skipped {
+		skipped = append(skipped, osd)
+	}
+	sort.Ints(skipped)
+	for _, osd := range skipped {
+		log.Printf("osd.%d skipped: %s", osd, summary.Skipped[osd])
+	}
+	if len(summary.Remaining) > 0 {
+		log.Printf("osds left to be reweighted: %s", formatTargetWeightMap(summary.Remaining))
+	}
+	if summary.LastError != nil && summary.LastError != runErr {
+		log.Printf("last error: %s", summary.LastError)
+	}
+
+	drained := r.DrainedOSDs()
+	if jsonOutput(ctx) {
+		if err := printJSON(newRunSummary(summary, drained, runErr)); err != nil {
+			return err
+		}
+	}
+	osds := make([]int, 0, len(drained))
+	for osd := range drained {
+		osds = append(osds, osd)
+	}
+	sort.Ints(osds)
+	for _, osd := range osds {
+		if drained[osd] {
+			log.Printf("drained osd.%d is safe to destroy", osd)
+		} else {
+			log.Printf("drained osd.%d is not yet safe to destroy", osd)
+		}
+	}
+
+	if runErr != nil {
+		if cephclient.IsTransient(runErr) {
+			return cli.Exit(fmt.Sprintf("reweighting did not finish, retrying may succeed: %s", runErr), exitTempFail)
+		}
+		return fmt.Errorf("reweighting did not finish: %s", runErr)
+	}
+	return nil
+}
+
+// rebalancerOptions returns the options of a rebalancer as configured
+// by the flags of the reweight command.
+func rebalancerOptions(ctx *cli.Context, cc cephclient.Client, registry prometheus.Registerer) ([]rebalancer.Option, error) {
+	var err error
+	// The target weights may as well be given in the config file.
+	var twMap map[int]float64
+	if tw := ctx.String(targetOSDsCrushFlag.Name); tw != "" {
+		if twMap, err = parseTargetWeightMap(tw); err != nil {
+			return nil, fmt.Errorf("failed parsing target-weights: %s", err)
+		}
+	}
+
+	var amQueries []gates.AlertmanagerQuery
+	if amURL := ctx.String(alertmanagerURLFlag.Name); amURL != "" {
+		for _, selector := range ctx.Generic(alertSelectorFlag.Name).(*rawStrings).values {
+			amQueries = append(amQueries, gates.AlertmanagerQuery{
+				Endpoint: amURL,
+				Matchers: gates.ParseAlertMatchers(selector),
+			})
+		}
+	}
+
+	var activeHours []gates.TimeWindow
+	for _, val := range ctx.StringSlice(activeHoursFlag.Name) {
+		w, err := gates.ParseTimeWindow(val)
+		if err != nil {
+			return nil, err
+		}
+		activeHours = append(activeHours, w)
+	}
+	if path := ctx.String(maintenanceCalendarFlag.Name); path != "" {
+		windows, err := gates.LoadTimeWindows(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading maintenance calendar: %s", err)
+		}
+		activeHours = append(activeHours, windows...)
+	}
+
+	return []rebalancer.Option{
+		rebalancer.WithCephClient(cc),
+		rebalancer.WithMaxBackfillPGsAllowed(ctx.Int(maxBackfillPGsFlag.Name)),
+		rebalancer.WithMaxRecoveryPGsAllowed(ctx.Int(maxRecoveryPGsFlag.Name)),
+		rebalancer.WithPoolAwarePGCounts(ctx.Bool(poolAwarePGCountsFlag.Name)),
+		rebalancer.WithMaxInactivePGsAllowed(ctx.Int(maxInactivePGsFlag.Name)),
+		rebalancer.WithPauseOnPGAutoscaling(ctx.Bool(pauseOnPGAutoscalingFlag.Name)),
+		rebalancer.WithMaxScrubbingPGs(ctx.Int(maxScrubbingPGsFlag.Name)),
+		rebalancer.WithMaxSnapTrimPGs(ctx.Int(maxSnapTrimPGsFlag.Name)),
+		rebalancer.WithMaxBackfillBytes(ctx.Int64(maxBackfillBytesFlag.Name)),
+		rebalancer.WithMaxMisplacedRatio(ctx.Float64(maxMisplacedRatioFlag.Name)),
+		rebalancer.WithMaxDegradedObjects(ctx.Int(maxDegradedObjectsFlag.Name)),
+		rebalancer.WithMaxDegradedPGs(ctx.Int(maxDegradedPGsFlag.Name)),
+		rebalancer.WithMaxSlowOps(ctx.Int(maxSlowOpsFlag.Name)),
+		rebalancer.WithMaxDownOSDs(ctx.Int(maxDownOSDsFlag.Name)),
+		rebalancer.WithRequireMonQuorum(ctx.Bool(requireMonQuorumFlag.Name)),
+		rebalancer.WithMaxHeartbeatLatency(ctx.Duration(maxHeartbeatLatencyFlag.Name)),
+		rebalancer.WithMaxOSDLatency(ctx.Duration(maxOSDLatencyFlag.Name)),
+		rebalancer.WithOSDLatencyPercentile(ctx.Float64(osdLatencyPercentileFlag.Name)),
+		rebalancer.WithPauseOnHealthWarnChecks(ctx.StringSlice(pauseOnHealthWarnFlag.Name)),
+		rebalancer.WithAbortOnHealthErr(ctx.Bool(abortOnHealthErrFlag.Name)),
+		rebalancer.WithRollbackOnAbort(ctx.Bool(rollbackOnAbortFlag.Name)),
+		rebalancer.WithFullOSDScope(ctx.String(fullOSDScopeFlag.Name)),
+		rebalancer.WithFlagPolicy(ctx.String(flagPolicyFlag.Name)),
+		rebalancer.WithAllowedFlags(ctx.StringSlice(allowedFlagsFlag.Name)),
+		rebalancer.WithPromQLQueries(ctx.Generic(promQLGateFlag.Name).(*promQLQueries).queries...),
+		rebalancer.WithAlertmanagerQueries(amQueries...),
+		rebalancer.WithGateHooks(ctx.StringSlice(gateHookFlag.Name)...),
+		rebalancer.WithGateHookTimeout(ctx.Duration(gateHookTimeoutFlag.Name)),
+		rebalancer.WithGateExpressions(ctx.Generic(gateExprFlag.Name).(*rawStrings).values...),
+		rebalancer.WithActiveHours(activeHours...),
+		rebalancer.WithTargetCrushWeightMap(twMap),
+		rebalancer.WithWeightIncrement(ctx.Float64(weightIncrementFlag.Name)),
+		rebalancer.WithWeightPrecision(ctx.Int(weightPrecisionFlag.Name)),
+		rebalancer.WithMaxMissingIterations(ctx.Int(maxMissingIterationsFlag.Name)),
+		rebalancer.WithVerifyReweights(ctx.Bool(verifyReweightsFlag.Name)),
+		rebalancer.WithOSDMapWait(ctx.Duration(osdmapWaitFlag.Name)),
+		rebalancer.WithSleepInterval(ctx.Duration(sleepDurationFlag.Name)),
+		rebalancer.WithRunImmediately(ctx.Bool(runImmediatelyFlag.Name)),
+		rebalancer.WithMinSleepInterval(ctx.Duration(minSleepDurationFlag.Name)),
+		rebalancer.WithMaxSleepInterval(ctx.Duration(maxSleepDurationFlag.Name)),
+		rebalancer.WithCanaryOSD(ctx.Int(canaryOSDFlag.Name)),
+		rebalancer.WithCanarySoak(ctx.Duration(canarySoakFlag.Name)),
+		rebalancer.WithMaxImpactScore(ctx.Float64(maxImpactScoreFlag.Name)),
+		rebalancer.WithImpactScoreSamples(ctx.Int(impactScoreSamplesFlag.Name)),
+		rebalancer.WithMaxDuration(ctx.Duration(maxDurationFlag.Name)),
+		rebalancer.WithMaxIterations(ctx.Int(maxIterationsFlag.Name)),
+		rebalancer.WithEnableCephBalancer(ctx.Bool(enableCephBalancerFlag.Name)),
+		rebalancer.WithBalancerPolicy(ctx.String(balancerPolicyFlag.Name)),
+		rebalancer.WithAllowDownweight(ctx.Bool(allowDownweightFlag.Name)),
+		rebalancer.WithOKToStopPolicy(ctx.String(okToStopPolicyFlag.Name)),
+		rebalancer.WithDownOSDPolicy(ctx.String(downOSDPolicyFlag.Name)),
+		rebalancer.WithDrainCompletion(ctx.String(drainCompletionFlag.Name)),
+		rebalancer.WithPrimaryAffinity(ctx.Bool(primaryAffinityFlag.Name)),
+		rebalancer.WithOSDMaxBackfills(ctx.Int(osdMaxBackfillsFlag.Name)),
+		rebalancer.WithOSDRecoveryMaxActive(ctx.Int(osdRecoveryMaxActiveFlag.Name)),
+		rebalancer.WithOSDTreeCacheTTL(ctx.Duration(osdTreeCacheTTLFlag.Name)),
+		rebalancer.WithMaxReweightsPerHour(ctx.Int(maxReweightsPerHourFlag.Name)),
+		rebalancer.WithMaxOSDsPerIteration(ctx.Int(maxOSDsPerIterationFlag.Name)),
+		rebalancer.WithReweightWorkers(ctx.Int(reweightWorkersFlag.Name)),
+		rebalancer.WithFailureDomain(ctx.String(failureDomainFlag.Name)),
+		rebalancer.WithMaxOSDsPerFailureDomain(ctx.Int(maxOSDsPerFailureDomainFlag.Name)),
+		rebalancer.WithMaxWeightDeltaPerHost(ctx.Float64(maxWeightDeltaPerHostFlag.Name)),
+		rebalancer.WithDryRun(ctx.Bool(dryRunFlag.Name)),
+		rebalancer.WithMetricsRegistry(registry),
+	}, nil
+}
+
+// interruptible returns a context cancelled on SIGINT or SIGTERM,
+// until cancel is called.
+func interruptible(ctx context.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+}
+
+// reloadOnHangup reloads the config file into the running rebalancer
+// on every SIGHUP until ctx is done, leaving out the overridden
+// settings.
+func reloadOnHangup(ctx context.Context, r *rebalancer.Rebalancer, path string, overridden map[string]bool) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reloadConfig(ctx, r, path, overridden)
+			}
+		}
+	}()
+}
+
+// reloadConfig re-reads the config file and applies it to the
+// running rebalancer, keeping the previous settings on failure.
+func reloadConfig(ctx context.Context, r *rebalancer.Rebalancer, path string, overridden map[string]bool) {
+	opts, err := loadConfig(path, overridden)
+	if err != nil {
+		log.Printf("failed reloading config, keeping previous settings: %s", err)
+		return
+	}
+	if err := r.Reconfigure(ctx, opts...); err != nil {
+		log.Printf("invalid config, keeping previous settings: %s", err)
+		return
+	}
+	log.Printf("reloaded config from %s", path)
+}
+
+// newCephClient connects to the cluster through the backend
+// selected on the command line.
+func newCephClient(ctx *cli.Context) (cephclient.Client, error) {
+	opts := []cephclient.Option{
+		cephclient.WithCommandTimeout(ctx.Duration(cephTimeoutFlag.Name)),
+		cephclient.WithConnectTimeout(ctx.Duration(cephConnectTimeoutFlag.Name)),
+		cephclient.WithMaxCommandsPerSecond(ctx.Int(cephMaxCommandsPerSecondFlag.Name)),
+		cephclient.WithCommandRetries(ctx.Int(cephRetriesFlag.Name)),
+		cephclient.WithCommandBackoff(ctx.Duration(cephBackoffFlag.Name)),
+	}
+
+	user := ctx.String(cephUserFlag.Name)
+	configPath := ctx.String(cephConfigPathFlag.Name)
+
+	// Mons given on the command line make the default ceph.conf,
+	// which likely doesn't exist then, optional.
+	if monHost := ctx.String(cephMonHostFlag.Name); monHost != "" {
+		opts = append(opts, cephclient.WithMonHost(monHost))
+		if !ctx.IsSet(cephConfigPathFlag.Name) {
+			configPath = ""
+		}
+	}
+	if keyring := ctx.String(cephKeyringFlag.Name); keyring != "" {
+		opts = append(opts, cephclient.WithKeyring(keyring))
+	}
+	if keyFile := ctx.String(cephKeyFileFlag.Name); keyFile != "" {
+		opts = append(opts, cephclient.WithKeyFile(keyFile))
+	}
+	if key := ctx.String(cephKeyFlag.Name); key != "" {
+		opts = append(opts, cephclient.WithKey(key))
+	}
+
+	switch backend := ctx.String(cephBackendFlag.Name); backend {
+	case "rados":
+		return cephclient.New(user, configPath, opts...)
+	case "exec":
+		return cephclient.NewExec(ctx.String(cephBinaryFlag.Name), user, configPath, opts...)
+	case "rest":
+		key, err := ioutil.ReadFile(ctx.String(cephRESTKeyFileFlag.Name))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read restful api key: %s", err)
+		}
+
+		var tlsConfig *tls.Config
+		if caFile := ctx.String(cephRESTCAFileFlag.Name); caFile != "" {
+			ca, err := ioutil.ReadFile(caFile)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read restful ca: %s", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("no certificates found in %s", caFile)
+			}
+			tlsConfig = &tls.Config{RootCAs: pool}
+		}
+
+		return cephclient.NewREST(ctx.String(cephRESTURLFlag.Name), user,
+			strings.TrimSpace(string(key)), tlsConfig, opts...)
+	default:
+		return nil, fmt.Errorf("invalid ceph backend %q", backend)
+	}
 }
 
 // The target-weight map is expected in the following csv format:
@@ -143,12 +822,34 @@ var commands = []*cli.Command{
 //  }
 // when no errors are found in the input.
 func parseTargetWeightMap(twStr string) (map[int]float64, error) {
+	pairs, err := parseTargetWeightPairs(twStr)
+	if err != nil {
+		return nil, err
+	}
+
+	twMap := make(map[int]float64, len(pairs))
+	for _, p := range pairs {
+		twMap[p.osd] = p.weight
+	}
+
+	return twMap, nil
+}
+
+// targetWeight is a single osd-weight pair of a target-weight map.
+type targetWeight struct {
+	osd    int
+	weight float64
+}
+
+// parseTargetWeightPairs parses a target-weight map into its pairs,
+// in the order given and keeping duplicates.
+func parseTargetWeightPairs(twStr string) ([]targetWeight, error) {
 	parts := strings.Split(twStr, ",")
 	if len(parts) == 0 {
 		return nil, errors.New("empty target-weight map found")
 	}
 
-	twMap := make(map[int]float64, len(parts))
+	pairs := make([]targetWeight, 0, len(parts))
 	for _, part := range parts {
 		osdAndWeight := strings.SplitN(part, ":", 2)
 		if len(osdAndWeight) < 2 {
@@ -167,71 +868,755 @@ func parseTargetWeightMap(twStr string) (map[int]float64, error) {
 			return nil, fmt.Errorf("weight should be a float, %q provided: %s", weight, err)
 		}
 
-		twMap[o] = w
+		pairs = append(pairs, targetWeight{osd: o, weight: w})
 	}
 
-	return twMap, nil
+	return pairs, nil
+}
+
+// formatTargetWeightMap is the inverse of parseTargetWeightMap,
+// with OSDs sorted by id.
+func formatTargetWeightMap(twMap map[int]float64) string {
+	osds := make([]int, 0, len(twMap))
+	for osd := range twMap {
+		osds = append(osds, osd)
+	}
+	sort.Ints(osds)
+
+	parts := make([]string, 0, len(osds))
+	for _, osd := range osds {
+		parts = append(parts, fmt.Sprintf("%d:%s", osd, strconv.FormatFloat(twMap[osd], 'f', -1, 64)))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// promQLQueries collects each occurrence of the repeatable
+// PromQL gate flag, provided in the following format:
+//  'http://prometheus:9090|<expr>|<threshold>'
+//
+// Unlike string slice flags, values are not split on commas
+// since those are common in PromQL expressions, but on semicolons,
+// see splitEntries.
+type promQLQueries struct {
+	queries []gates.PromQLQuery
+}
+
+func (p *promQLQueries) Set(val string) error {
+	for _, entry := range splitEntries(val) {
+		if err := p.add(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *promQLQueries) add(val string) error {
+	first, last := strings.Index(val, "|"), strings.LastIndex(val, "|")
+	if first < 0 || first == last {
+		return fmt.Errorf("promql gate should be 'endpoint|expr|threshold', %q provided", val)
+	}
+
+	threshold := val[last+1:]
+	t, err := strconv.ParseFloat(threshold, 64)
+	if err != nil {
+		return fmt.Errorf("threshold should be a float, %q provided: %s", threshold, err)
+	}
+
+	p.queries = append(p.queries, gates.PromQLQuery{
+		Endpoint:  val[:first],
+		Expr:      val[first+1 : last],
+		Threshold: t,
+	})
+	return nil
+}
+
+func (p *promQLQueries) String() string {
+	qs := make([]string, 0, len(p.queries))
+	for _, q := range p.queries {
+		qs = append(qs, fmt.Sprintf("%s|%s|%v", q.Endpoint, q.Expr, q.Threshold))
+	}
+	return strings.Join(qs, " ")
+}
+
+// rawStrings collects each occurrence of a repeatable flag
+// verbatim, without splitting the values on commas but on
+// semicolons, see splitEntries.
+type rawStrings struct {
+	values []string
+}
+
+func (r *rawStrings) Set(val string) error {
+	r.values = append(r.values, splitEntries(val)...)
+	return nil
+}
+
+// splitEntries splits the value of a repeatable flag whose entries
+// may contain commas into its entries, separated by semicolons
+// instead. This lets such flags be given several entries at once
+// through their environment variable, which is set only once.
+func splitEntries(val string) []string {
+	var entries []string
+	for _, entry := range strings.Split(val, ";") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func (r *rawStrings) String() string {
+	return strings.Join(r.values, " ")
 }
 
 var (
 	cephUserFlag = &cli.StringFlag{
-		Name:  "ceph-user",
-		Usage: "Ceph username provided without the 'client.' prefix.",
+		Name:    "ceph-user",
+		EnvVars: []string{"CEPH_REBALANCER_CEPH_USER"},
+		Usage:   "Ceph username provided without the 'client.' prefix.",
 	}
 
 	cephConfigPathFlag = &cli.StringFlag{
-		Name:  "ceph-conf",
-		Value: "/etc/ceph/ceph.conf",
-		Usage: "Ceph config used for establishing connection to the cluster.",
+		Name:    "ceph-conf",
+		EnvVars: []string{"CEPH_REBALANCER_CEPH_CONF"},
+		Value:   "/etc/ceph/ceph.conf",
+		Usage:   "Ceph config used for establishing connection to the cluster.",
+	}
+
+	cephMonHostFlag = &cli.StringFlag{
+		Name:    "mon-host",
+		EnvVars: []string{"CEPH_REBALANCER_MON_HOST"},
+		Usage:   "Comma separated mon addresses to connect to, e.g. '10.0.0.1,10.0.0.2'. Without an explicit --ceph-conf no ceph config is read then.",
+	}
+
+	cephKeyringFlag = &cli.StringFlag{
+		Name:    "keyring",
+		EnvVars: []string{"CEPH_REBALANCER_KEYRING"},
+		Usage:   "Path to the keyring of the Ceph user, instead of the one from the ceph config.",
+	}
+
+	cephKeyFileFlag = &cli.StringFlag{
+		Name:    "key-file",
+		EnvVars: []string{"CEPH_REBALANCER_KEY_FILE"},
+		Usage:   "Path to a file holding only the secret key of the Ceph user, e.g. a mounted secret, instead of a keyring.",
+	}
+
+	cephKeyFlag = &cli.StringFlag{
+		Name:    "key",
+		EnvVars: []string{"CEPH_REBALANCER_KEY"},
+		Usage:   "Secret key of the Ceph user, instead of a keyring. Prefer setting it through the environment over the command line.",
+	}
+
+	cephBackendFlag = &cli.StringFlag{
+		Name:    "ceph-backend",
+		EnvVars: []string{"CEPH_REBALANCER_CEPH_BACKEND"},
+		Value:   "rados",
+		Usage:   "How to talk to the cluster: 'rados' links against librados, 'exec' runs the ceph CLI for every call and 'rest' goes through the ceph-mgr restful module.",
+	}
+
+	cephBinaryFlag = &cli.StringFlag{
+		Name:    "ceph-binary",
+		EnvVars: []string{"CEPH_REBALANCER_CEPH_BINARY"},
+		Value:   "ceph",
+		Usage:   "The ceph CLI run by the exec backend.",
+	}
+
+	cephRESTURLFlag = &cli.StringFlag{
+		Name:    "ceph-rest-url",
+		EnvVars: []string{"CEPH_REBALANCER_CEPH_REST_URL"},
+		Usage:   "The https endpoint of the ceph-mgr restful module used by the rest backend, e.g. https://mgr.example.com:8003.",
+	}
+
+	cephRESTKeyFileFlag = &cli.StringFlag{
+		Name:    "ceph-rest-key-file",
+		EnvVars: []string{"CEPH_REBALANCER_CEPH_REST_KEY_FILE"},
+		Usage:   "File holding the restful api key of the ceph user, as created by `ceph restful create-key`.",
+	}
+
+	cephRESTCAFileFlag = &cli.StringFlag{
+		Name:    "ceph-rest-ca-file",
+		EnvVars: []string{"CEPH_REBALANCER_CEPH_REST_CA_FILE"},
+		Usage:   "PEM encoded CA to verify the ceph-mgr restful module against instead of the system roots.",
 	}
 
+	cephConnectTimeoutFlag = &cli.DurationFlag{
+		Name:    "ceph-connect-timeout",
+		EnvVars: []string{"CEPH_REBALANCER_CEPH_CONNECT_TIMEOUT"},
+		Value:   30 * time.Second,
+		Usage:   "The amount of time connecting to the cluster may take before giving up. 0 keeps the default of the backend.",
+	}
+
+	cephMaxCommandsPerSecondFlag = &cli.IntFlag{
+		Name:    "ceph-max-commands-per-second",
+		EnvVars: []string{"CEPH_REBALANCER_CEPH_MAX_COMMANDS_PER_SECOND"},
+		Value:   20,
+		Usage:   "Maximum number of mon and mgr commands issued per second, retries included. 0 means no limit.",
+	}
+
+	cephTimeoutFlag = &cli.DurationFlag{
+		Name:    "ceph-timeout",
+		EnvVars: []string{"CEPH_REBALANCER_CEPH_TIMEOUT"},
+		Value:   time.Minute,
+		Usage:   "The amount of time a single mon or mgr command may take before it is given up on. 0 disables the timeout.",
+	}
+
+	cephRetriesFlag = &cli.IntFlag{
+		Name:    "ceph-retries",
+		EnvVars: []string{"CEPH_REBALANCER_CEPH_RETRIES"},
+		Value:   3,
+		Usage:   "The number of times a mon or mgr command failing with a transient error, e.g. during a mon election, is retried. 0 disables retries.",
+	}
+
+	cephBackoffFlag = &cli.DurationFlag{
+		Name:    "ceph-backoff",
+		EnvVars: []string{"CEPH_REBALANCER_CEPH_BACKOFF"},
+		Value:   time.Second,
+		Usage:   "The amount of time to wait before retrying a failed mon or mgr command, doubling with every retry up to a minute. Retries are jittered.",
+	}
+
+	stateFileFlag = &cli.StringFlag{
+		Name:    "state-file",
+		EnvVars: []string{"CEPH_REBALANCER_STATE_FILE"},
+		Usage:   "File the state of the run is saved to after every iteration, for the resume command to carry on from.",
+	}
+	auditLogFlag = &cli.StringFlag{
+		Name:    "audit-log",
+		EnvVars: []string{"CEPH_REBALANCER_AUDIT_LOG"},
+		Usage:   "Path of the file the reweights applied, and the weight changes made outside of archimedes, are appended to as JSON lines.",
+	}
+	osdFlag = &cli.IntSliceFlag{
+		Name:    "osd",
+		EnvVars: []string{"CEPH_REBALANCER_OSD"},
+		Usage:   "OSD to list the weight changes of, to snapshot the weight of or to convert the size of. Repeat to give several OSDs.",
+	}
+	sizeFlag = &cli.StringSliceFlag{
+		Name:    "size",
+		EnvVars: []string{"CEPH_REBALANCER_SIZE"},
+		Usage:   "Device size to convert to a CRUSH weight, e.g. 8TB. TB and the like are SI units, while TiB and the single letters Ceph abbreviates them to, e.g. 7.3T, are IEC units. Repeat to convert several sizes.",
+	}
+	weightFlag = &cli.Float64SliceFlag{
+		Name:    "weight",
+		EnvVars: []string{"CEPH_REBALANCER_WEIGHT"},
+		Usage:   "CRUSH weight to convert to a device size. Repeat to convert several weights.",
+	}
+	subtreeFlag = &cli.StringSliceFlag{
+		Name:    "subtree",
+		EnvVars: []string{"CEPH_REBALANCER_SUBTREE"},
+		Usage:   "CRUSH bucket, e.g. a host, whose OSDs to snapshot the weights of. Repeat to give several buckets.",
+	}
+	snapshotFlag = &cli.StringFlag{
+		Name:    "snapshot",
+		EnvVars: []string{"CEPH_REBALANCER_SNAPSHOT"},
+		Usage:   "File the snapshot command saves weights to, and the restore command returns OSDs to the weights of.",
+	}
+	sinceFlag = &cli.StringFlag{
+		Name:    "since",
+		EnvVars: []string{"CEPH_REBALANCER_SINCE"},
+		Usage:   "Only list the weight changes made at or after the given RFC 3339 timestamp or YYYY-MM-DD date.",
+	}
+	untilFlag = &cli.StringFlag{
+		Name:    "until",
+		EnvVars: []string{"CEPH_REBALANCER_UNTIL"},
+		Usage:   "Only list the weight changes made before the given RFC 3339 timestamp or YYYY-MM-DD date.",
+	}
+	outputFlag = &cli.StringFlag{
+		Name:    "output",
+		EnvVars: []string{"CEPH_REBALANCER_OUTPUT"},
+		Value:   outputText,
+		Usage:   "Format results are printed in, either text or json.",
+	}
+	instanceFlag = &cli.StringFlag{
+		Name:    "instance",
+		EnvVars: []string{"CEPH_REBALANCER_INSTANCE"},
+		Value:   "http://localhost:8928",
+		Usage:   "URL of the metrics server of the running reweight to query.",
+	}
 	metricsAddrFlag = &cli.StringFlag{
-		Name:  "metrics-addr",
-		Value: ":8928",
-		Usage: "Address on which metrics will be exported. Needs exposed in Docker.release too.",
+		Name:    "metrics-addr",
+		EnvVars: []string{"CEPH_REBALANCER_METRICS_ADDR"},
+		Value:   ":8928",
+		Usage:   "Address on which metrics will be exported. Needs exposed in Docker.release too.",
 	}
 )
 
 var (
 	maxBackfillPGsFlag = &cli.IntFlag{
-		Name:  "max-backfill-pgs",
-		Value: 10,
-		Usage: "Number of maximum PGs allowed to be in backfill/backfill_wait state.",
+		Name:    "max-backfill-pgs",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_BACKFILL_PGS"},
+		Value:   10,
+		Usage:   "Number of maximum PGs allowed to be in backfill/backfill_wait state.",
 	}
 
 	maxRecoveryPGsFlag = &cli.IntFlag{
-		Name:  "max-recovery-pgs",
-		Value: 10,
-		Usage: "Number of maximum PGs allowed to be in recovering/recovery_wait state.",
+		Name:    "max-recovery-pgs",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_RECOVERY_PGS"},
+		Value:   10,
+		Usage:   "Number of maximum PGs allowed to be in recovering/recovery_wait state.",
+	}
+
+	poolAwarePGCountsFlag = &cli.BoolFlag{
+		Name:    "pool-aware-pg-counts",
+		EnvVars: []string{"CEPH_REBALANCER_POOL_AWARE_PG_COUNTS"},
+		Value:   false,
+		Usage:   "Only count backfilling/recovering PGs of pools whose CRUSH rules map to the target OSDs.",
+	}
+
+	maxInactivePGsFlag = &cli.IntFlag{
+		Name:    "max-inactive-pgs",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_INACTIVE_PGS"},
+		Value:   0,
+		Usage:   "Number of maximum PGs allowed to be inactive, peering or incomplete.",
+	}
+
+	pauseOnPGAutoscalingFlag = &cli.BoolFlag{
+		Name:    "pause-on-pg-autoscaling",
+		EnvVars: []string{"CEPH_REBALANCER_PAUSE_ON_PG_AUTOSCALING"},
+		Value:   true,
+		Usage:   "Pause reweighting while any pool is changing its pg_num/pgp_num.",
+	}
+
+	maxScrubbingPGsFlag = &cli.IntFlag{
+		Name:    "max-scrubbing-pgs",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_SCRUBBING_PGS"},
+		Value:   -1,
+		Usage:   "Number of maximum PGs allowed to be scrubbing/deep-scrubbing. A negative value disables the check.",
+	}
+
+	maxSnapTrimPGsFlag = &cli.IntFlag{
+		Name:    "max-snaptrim-pgs",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_SNAPTRIM_PGS"},
+		Value:   -1,
+		Usage:   "Number of maximum PGs allowed to be in snaptrim/snaptrim_wait state. A negative value disables the check.",
+	}
+
+	maxBackfillBytesFlag = &cli.Int64Flag{
+		Name:    "max-backfill-bytes",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_BACKFILL_BYTES"},
+		Value:   0,
+		Usage:   "Maximum bytes held by PGs in backfill/backfill_wait state. 0 disables the check.",
+	}
+
+	maxMisplacedRatioFlag = &cli.Float64Flag{
+		Name:    "max-misplaced-ratio",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_MISPLACED_RATIO"},
+		Value:   0,
+		Usage:   "Maximum ratio (0-1) of misplaced objects in the cluster. 0 disables the check.",
+	}
+
+	maxDegradedObjectsFlag = &cli.IntFlag{
+		Name:    "max-degraded-objects",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_DEGRADED_OBJECTS"},
+		Value:   -1,
+		Usage:   "Maximum number of degraded objects in the cluster. A negative value disables the check.",
+	}
+
+	maxDegradedPGsFlag = &cli.IntFlag{
+		Name:    "max-degraded-pgs",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_DEGRADED_PGS"},
+		Value:   -1,
+		Usage:   "Maximum number of degraded PGs, in the pools of the target OSDs with --pool-aware-pg-counts. A negative value disables the check.",
+	}
+
+	maxSlowOpsFlag = &cli.IntFlag{
+		Name:    "max-slow-ops",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_SLOW_OPS"},
+		Value:   -1,
+		Usage:   "Maximum number of slow or blocked requests in the cluster. A negative value disables the check.",
+	}
+
+	maxDownOSDsFlag = &cli.IntFlag{
+		Name:    "max-down-osds",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_DOWN_OSDS"},
+		Value:   0,
+		Usage:   "Maximum number of OSDs that may be down while still in. A negative value disables the check.",
+	}
+
+	requireMonQuorumFlag = &cli.BoolFlag{
+		Name:    "require-mon-quorum",
+		EnvVars: []string{"CEPH_REBALANCER_REQUIRE_MON_QUORUM"},
+		Value:   true,
+		Usage:   "Require every monitor to be in quorum before reweighting.",
+	}
+
+	maxHeartbeatLatencyFlag = &cli.DurationFlag{
+		Name:    "max-heartbeat-latency",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_HEARTBEAT_LATENCY"},
+		Value:   0,
+		Usage:   "Maximum average heartbeat ping time between OSDs, e.g. '100ms'. 0 disables the check.",
+	}
+
+	maxOSDLatencyFlag = &cli.DurationFlag{
+		Name:    "max-osd-latency",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_OSD_LATENCY"},
+		Value:   0,
+		Usage:   "Maximum OSD commit/apply latency at the configured percentile, e.g. '50ms'. 0 disables the check.",
+	}
+
+	osdLatencyPercentileFlag = &cli.Float64Flag{
+		Name:    "osd-latency-percentile",
+		EnvVars: []string{"CEPH_REBALANCER_OSD_LATENCY_PERCENTILE"},
+		Value:   99,
+		Usage:   "Percentile (0-100) of OSD latencies compared against --max-osd-latency.",
+	}
+
+	pauseOnHealthWarnFlag = &cli.StringSliceFlag{
+		Name:    "pause-on-health-warn",
+		EnvVars: []string{"CEPH_REBALANCER_PAUSE_ON_HEALTH_WARN"},
+		Usage:   "Health check codes, e.g. 'OSD_NEARFULL', that pause reweighting while in HEALTH_WARN. '*' matches any check.",
+	}
+
+	abortOnHealthErrFlag = &cli.BoolFlag{
+		Name:    "abort-on-health-err",
+		EnvVars: []string{"CEPH_REBALANCER_ABORT_ON_HEALTH_ERR"},
+		Value:   true,
+		Usage:   "Abort reweighting altogether when the cluster reports HEALTH_ERR.",
+	}
+
+	rollbackOnAbortFlag = &cli.BoolFlag{
+		Name:    "rollback-on-abort",
+		EnvVars: []string{"CEPH_REBALANCER_ROLLBACK_ON_ABORT"},
+		Usage:   "When reweighting is aborted because of the state of the cluster, e.g. HEALTH_ERR, gradually return the OSDs reweighted to the weights they had before, regardless of the gates.",
+	}
+
+	fullOSDScopeFlag = &cli.StringFlag{
+		Name:    "full-osd-scope",
+		EnvVars: []string{"CEPH_REBALANCER_FULL_OSD_SCOPE"},
+		Value:   rebalancer.FullScopeAny,
+		Usage:   "OSDs checked for nearfull/backfillfull/full state: 'any', 'targets', 'subtree' or 'none'.",
+	}
+
+	flagPolicyFlag = &cli.StringFlag{
+		Name:    "cluster-flag-policy",
+		EnvVars: []string{"CEPH_REBALANCER_CLUSTER_FLAG_POLICY"},
+		Value:   rebalancer.FlagPolicyPause,
+		Usage:   "Reaction to norebalance/norecover/nobackfill being set: 'pause', 'abort' or 'ignore'.",
+	}
+
+	allowedFlagsFlag = &cli.StringSliceFlag{
+		Name:    "allowed-cluster-flags",
+		EnvVars: []string{"CEPH_REBALANCER_ALLOWED_CLUSTER_FLAGS"},
+		Usage:   "Conflicting OSD map flags that are expected to be set, e.g. 'norebalance'.",
+	}
+
+	promQLGateFlag = &cli.GenericFlag{
+		Name:    "promql-gate",
+		EnvVars: []string{"CEPH_REBALANCER_PROMQL_GATE"},
+		Value:   &promQLQueries{},
+		Usage:   "Prometheus query gating each iteration, as 'endpoint|expr|threshold'. Can be repeated, or given several queries separated by ';'.",
+	}
+
+	alertmanagerURLFlag = &cli.StringFlag{
+		Name:    "alertmanager-url",
+		EnvVars: []string{"CEPH_REBALANCER_ALERTMANAGER_URL"},
+		Value:   "",
+		Usage:   "Alertmanager queried for firing alerts matching --alert-selector, e.g. 'http://alertmanager:9093'.",
+	}
+
+	alertSelectorFlag = &cli.GenericFlag{
+		Name:    "alert-selector",
+		EnvVars: []string{"CEPH_REBALANCER_ALERT_SELECTOR"},
+		Value:   &rawStrings{},
+		Usage:   "Label selector of alerts pausing reweighting, e.g. 'severity=\"critical\",team=\"storage\"'. Can be repeated, or given several selectors separated by ';'.",
+	}
+
+	gateHookFlag = &cli.StringSliceFlag{
+		Name:    "gate-hook",
+		EnvVars: []string{"CEPH_REBALANCER_GATE_HOOK"},
+		Usage:   "Executable run before each iteration, a non-zero exit skips the iteration. Can be repeated.",
+	}
+
+	gateHookTimeoutFlag = &cli.DurationFlag{
+		Name:    "gate-hook-timeout",
+		EnvVars: []string{"CEPH_REBALANCER_GATE_HOOK_TIMEOUT"},
+		Value:   time.Minute,
+		Usage:   "The amount of time a gate hook may run before the iteration is skipped.",
+	}
+
+	gateExprFlag = &cli.GenericFlag{
+		Name:    "gate-expr",
+		EnvVars: []string{"CEPH_REBALANCER_GATE_EXPR"},
+		Value:   &rawStrings{},
+		Usage:   "Expression over cluster stats that must hold for an iteration to proceed, e.g. 'hour >= 22 || hour < 6 || backfill_pgs <= 10' to allow fewer backfilling PGs during the day than --max-backfill-pgs. Expressions only add restrictions to the built-in gates. Can be repeated, or given several expressions separated by ';'.",
+	}
+
+	activeHoursFlag = &cli.StringSliceFlag{
+		Name:    "active-hours",
+		EnvVars: []string{"CEPH_REBALANCER_ACTIVE_HOURS"},
+		Usage:   "Window reweights are limited to, as 'HH:MM-HH:MM' optionally prefixed by weekdays and followed by a time zone, e.g. 'mon-fri 22:00-06:00 Europe/Berlin'. Can be repeated.",
+	}
+
+	maintenanceCalendarFlag = &cli.StringFlag{
+		Name:    "maintenance-calendar",
+		EnvVars: []string{"CEPH_REBALANCER_MAINTENANCE_CALENDAR"},
+		Value:   "",
+		Usage:   "File listing one allowed maintenance window per line, in the format of --active-hours, e.g. 'sat,sun 00:00-00:00'.",
+	}
+
+	configFlag = &cli.StringFlag{
+		Name:    "config",
+		EnvVars: []string{"CEPH_REBALANCER_CONFIG"},
+		Value:   "",
+		Usage:   "YAML file with settings for any flag of the same name, which the command line and environment override. On SIGHUP, target-osd-crush-weights, weight-increment, sleep-duration and the max-*-pgs thresholds are re-read from it.",
+	}
+
+	planOutFlag = &cli.StringFlag{
+		Name:    "out",
+		EnvVars: []string{"CEPH_REBALANCER_OUT"},
+		Usage:   "File to write the plan to as signed JSON, for the apply command to carry out.",
+	}
+
+	planFlag = &cli.StringFlag{
+		Name:    "plan",
+		EnvVars: []string{"CEPH_REBALANCER_PLAN"},
+		Usage:   "Plan written by the plan command to carry out.",
+	}
+
+	planKeyFileFlag = &cli.StringFlag{
+		Name:    "plan-key-file",
+		EnvVars: []string{"CEPH_REBALANCER_PLAN_KEY_FILE"},
+		Usage:   "File holding the key plans are signed and verified with.",
 	}
 
 	targetOSDsCrushFlag = &cli.StringFlag{
-		Name:  "target-osd-crush-weights",
-		Value: "",
-		Usage: "OSDs and CRUSH weights provided in format of: 'osd-id:weight,osd-id:weight'.",
+		Name:    "target-osd-crush-weights",
+		EnvVars: []string{"CEPH_REBALANCER_TARGET_OSD_CRUSH_WEIGHTS"},
+		Value:   "",
+		Usage:   "OSDs and CRUSH weights provided in format of: 'osd-id:weight,osd-id:weight'.",
 	}
 
 	weightIncrementFlag = &cli.Float64Flag{
-		Name:  "weight-increment",
-		Value: 0.02,
-		Usage: "Value by which the CRUSH weights will be incremented per iteration.",
+		Name:    "weight-increment",
+		EnvVars: []string{"CEPH_REBALANCER_WEIGHT_INCREMENT"},
+		Value:   0.02,
+		Usage:   "Value by which the CRUSH weights will be incremented per iteration.",
+	}
+
+	weightPrecisionFlag = &cli.IntFlag{
+		Name:    "weight-precision",
+		EnvVars: []string{"CEPH_REBALANCER_WEIGHT_PRECISION"},
+		Value:   4,
+		Usage:   "Number of decimal places CRUSH weights are rounded to, at most 4.",
+	}
+
+	maxMissingIterationsFlag = &cli.IntFlag{
+		Name:    "max-missing-iterations",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_MISSING_ITERATIONS"},
+		Value:   3,
+		Usage:   "Number of iterations in a row a target OSD may be missing before it is skipped.",
+	}
+
+	verifyReweightsFlag = &cli.BoolFlag{
+		Name:    "verify-reweights",
+		EnvVars: []string{"CEPH_REBALANCER_VERIFY_REWEIGHTS"},
+		Value:   true,
+		Usage:   "Read the weights of reweighted OSDs back to confirm the reweights took effect.",
+	}
+
+	osdmapWaitFlag = &cli.DurationFlag{
+		Name:    "osdmap-wait",
+		EnvVars: []string{"CEPH_REBALANCER_OSDMAP_WAIT"},
+		Value:   0,
+		Usage:   "The amount of time to wait for a new osdmap epoch before reading reweighted OSDs back. 0 disables waiting.",
 	}
 
 	sleepDurationFlag = &cli.DurationFlag{
-		Name:  "sleep-duration",
-		Value: 5 * time.Minute,
-		Usage: "The amount of time to sleep between each iteration of reweight run.",
+		Name:    "sleep-duration",
+		EnvVars: []string{"CEPH_REBALANCER_SLEEP_DURATION"},
+		Value:   5 * time.Minute,
+		Usage:   "The amount of time to sleep between each iteration of reweight run.",
+	}
+
+	canaryOSDFlag = &cli.IntFlag{
+		Name:    "canary-osd",
+		EnvVars: []string{"CEPH_REBALANCER_CANARY_OSD"},
+		Value:   -1,
+		Usage:   "Target OSD fully ramped and soaked on its own first, aborting if slow ops, latency or degraded objects exceed their thresholds meanwhile, or if it gets skipped. Takes at least one of --max-slow-ops, --max-osd-latency, --max-heartbeat-latency or --max-degraded-objects. -1 disables the canary.",
+	}
+
+	canarySoakFlag = &cli.DurationFlag{
+		Name:    "canary-soak",
+		EnvVars: []string{"CEPH_REBALANCER_CANARY_SOAK"},
+		Value:   time.Hour,
+		Usage:   "The amount of time the cluster is observed after the canary OSD reached its target, before the remaining OSDs start.",
+	}
+
+	maxImpactScoreFlag = &cli.Float64Flag{
+		Name:    "max-impact-score",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_IMPACT_SCORE"},
+		Value:   0,
+		Usage:   "Impact score, the highest ratio of backfilling PGs, misplaced ratio or OSD latency to its threshold, above which reweighting slows down. 0 only reports the score.",
+	}
+
+	impactScoreSamplesFlag = &cli.IntFlag{
+		Name:    "impact-score-samples",
+		EnvVars: []string{"CEPH_REBALANCER_IMPACT_SCORE_SAMPLES"},
+		Value:   3,
+		Usage:   "The number of consecutive iterations above --max-impact-score after which the weight increment is halved.",
+	}
+
+	maxDurationFlag = &cli.DurationFlag{
+		Name:    "max-duration",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_DURATION"},
+		Value:   0,
+		Usage:   "The amount of time after which the run stops, reporting the OSDs left to be reweighted. 0 disables the limit.",
+	}
+
+	maxIterationsFlag = &cli.IntFlag{
+		Name:    "max-iterations",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_ITERATIONS"},
+		Value:   0,
+		Usage:   "The number of reweight increments after which the run stops. 0 disables the limit.",
+	}
+
+	runImmediatelyFlag = &cli.BoolFlag{
+		Name:    "run-immediately",
+		EnvVars: []string{"CEPH_REBALANCER_RUN_IMMEDIATELY"},
+		Value:   true,
+		Usage:   "Run the first iteration on start instead of sleeping for --sleep-duration first.",
+	}
+
+	minSleepDurationFlag = &cli.DurationFlag{
+		Name:    "min-sleep-duration",
+		EnvVars: []string{"CEPH_REBALANCER_MIN_SLEEP_DURATION"},
+		Value:   0,
+		Usage:   "Lower bound of the sleep duration when adapting it to the backfill drain rate. Requires --max-sleep-duration.",
+	}
+
+	maxSleepDurationFlag = &cli.DurationFlag{
+		Name:    "max-sleep-duration",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_SLEEP_DURATION"},
+		Value:   0,
+		Usage:   "Upper bound of the sleep duration when adapting it to the backfill drain rate. Requires --min-sleep-duration.",
 	}
 
 	enableCephBalancerFlag = &cli.BoolFlag{
-		Name:  "enable-ceph-balancer",
-		Value: false,
-		Usage: "Enable the Ceph balancer after reweights successfully complete.",
+		Name:    "enable-ceph-balancer",
+		EnvVars: []string{"CEPH_REBALANCER_ENABLE_CEPH_BALANCER"},
+		Value:   false,
+		Usage:   "Enable the Ceph balancer after reweights successfully complete.",
+	}
+
+	balancerPolicyFlag = &cli.StringFlag{
+		Name:    "ceph-balancer-policy",
+		EnvVars: []string{"CEPH_REBALANCER_CEPH_BALANCER_POLICY"},
+		Value:   rebalancer.BalancerPolicyRefuse,
+		Usage:   "Reaction to an active Ceph balancer: 'refuse' to run, 'disable' it for the run, or 'ignore' it.",
+	}
+
+	allowDownweightFlag = &cli.BoolFlag{
+		Name:    "allow-downweight",
+		EnvVars: []string{"CEPH_REBALANCER_ALLOW_DOWNWEIGHT"},
+		Usage:   "Allow targets below the current weight of an OSD, which evacuate data off it. Such targets are refused otherwise.",
+	}
+
+	okToStopPolicyFlag = &cli.StringFlag{
+		Name:    "ok-to-stop-policy",
+		EnvVars: []string{"CEPH_REBALANCER_OK_TO_STOP_POLICY"},
+		Value:   rebalancer.OKToStopPolicyRefuse,
+		Usage:   "Reaction to OSDs to downweight failing 'ceph osd ok-to-stop': 'refuse' to run, 'warn' about it, or 'ignore' the check.",
+	}
+
+	downOSDPolicyFlag = &cli.StringFlag{
+		Name:    "down-osd-policy",
+		EnvVars: []string{"CEPH_REBALANCER_DOWN_OSD_POLICY"},
+		Value:   rebalancer.DownOSDPolicySkip,
+		Usage:   "Reaction to OSDs to weight up being down or out: 'skip' them until they are back, 'wait' for them before reweighting anything, or 'fail' the run.",
+	}
+
+	drainCompletionFlag = &cli.StringFlag{
+		Name:    "drain-completion",
+		EnvVars: []string{"CEPH_REBALANCER_DRAIN_COMPLETION"},
+		Value:   rebalancer.DrainCompletionNone,
+		Usage:   "Step taken once an OSD drained to 0 is safe to destroy: 'none', mark it 'out', also 'remove' it from the CRUSH map, or 'purge' it, which requires the OSD to be stopped.",
+	}
+
+	primaryAffinityFlag = &cli.BoolFlag{
+		Name:    "adjust-primary-affinity",
+		EnvVars: []string{"CEPH_REBALANCER_ADJUST_PRIMARY_AFFINITY"},
+		Value:   false,
+		Usage:   "Set the primary affinity of OSDs to drain to 0 before they start draining, and back to 1 on OSDs finishing at a non-zero weight.",
+	}
+
+	osdMaxBackfillsFlag = &cli.IntFlag{
+		Name:    "osd-max-backfills",
+		EnvVars: []string{"CEPH_REBALANCER_OSD_MAX_BACKFILLS"},
+		Value:   0,
+		Usage:   "Raise osd_max_backfills of all OSDs to this value for the duration of the run, restoring the original afterwards. 0 leaves it untouched.",
+	}
+
+	osdRecoveryMaxActiveFlag = &cli.IntFlag{
+		Name:    "osd-recovery-max-active",
+		EnvVars: []string{"CEPH_REBALANCER_OSD_RECOVERY_MAX_ACTIVE"},
+		Value:   0,
+		Usage:   "Raise osd_recovery_max_active of all OSDs to this value for the duration of the run, restoring the original afterwards. 0 leaves it untouched.",
+	}
+
+	osdTreeCacheTTLFlag = &cli.DurationFlag{
+		Name:    "osd-tree-cache-ttl",
+		EnvVars: []string{"CEPH_REBALANCER_OSD_TREE_CACHE_TTL"},
+		Value:   0,
+		Usage:   "The amount of time the OSD tree is reused between gates and iterations before it is queried again. It is always queried again after a reweight. 0 disables the cache.",
+	}
+
+	maxReweightsPerHourFlag = &cli.IntFlag{
+		Name:    "max-reweights-per-hour",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_REWEIGHTS_PER_HOUR"},
+		Value:   0,
+		Usage:   "Maximum number of CRUSH reweight commands issued per hour. 0 means no limit.",
+	}
+
+	maxOSDsPerIterationFlag = &cli.IntFlag{
+		Name:    "max-osds-per-iteration",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_OSDS_PER_ITERATION"},
+		Value:   0,
+		Usage:   "Maximum number of OSDs reweighted per iteration, picked round-robin. 0 means no limit.",
+	}
+
+	reweightWorkersFlag = &cli.IntFlag{
+		Name:    "reweight-workers",
+		EnvVars: []string{"CEPH_REBALANCER_REWEIGHT_WORKERS"},
+		Value:   1,
+		Usage:   "Number of reweight commands issued concurrently within an iteration. Only whole buckets raised to one weight are reweighted with a single command, other OSDs each take their own.",
+	}
+
+	failureDomainFlag = &cli.StringFlag{
+		Name:    "failure-domain",
+		EnvVars: []string{"CEPH_REBALANCER_FAILURE_DOMAIN"},
+		Value:   "host",
+		Usage:   "CRUSH bucket type treated as a failure domain, e.g. 'host' or 'rack'.",
+	}
+
+	maxOSDsPerFailureDomainFlag = &cli.IntFlag{
+		Name:    "max-osds-per-failure-domain",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_OSDS_PER_FAILURE_DOMAIN"},
+		Value:   0,
+		Usage:   "Maximum number of OSDs ramped concurrently within a failure domain. 0 means no limit.",
+	}
+
+	maxWeightDeltaPerHostFlag = &cli.Float64Flag{
+		Name:    "max-weight-delta-per-host",
+		EnvVars: []string{"CEPH_REBALANCER_MAX_WEIGHT_DELTA_PER_HOST"},
+		Value:   0,
+		Usage:   "Maximum total CRUSH weight change applied under a single host per iteration. 0 means no limit.",
 	}
 
 	dryRunFlag = &cli.BoolFlag{
-		Name:  "dry-run",
-		Value: true,
-		Usage: "No action taken on the cluster when true. Explicitly pass as false for rebalance to take place.",
+		Name:    "dry-run",
+		EnvVars: []string{"CEPH_REBALANCER_DRY_RUN"},
+		Value:   true,
+		Usage:   "No action taken on the cluster when true. Explicitly pass as false for rebalance to take place.",
+	}
+
+	progressFlag = &cli.StringFlag{
+		Name:    "progress",
+		EnvVars: []string{"CEPH_REBALANCER_PROGRESS"},
+		Value:   progressAuto,
+		Usage:   "How progress is shown: 'table' redraws a live table of the OSDs and gates, only logging warnings, 'logs' only logs, and 'auto' shows the table when stdout is a terminal.",
+	}
+
+	yesFlag = &cli.BoolFlag{
+		Name:    "yes",
+		EnvVars: []string{"CEPH_REBALANCER_YES"},
+		Usage:   "Reweight without asking for confirmation when not a dry run, e.g. when run without a terminal.",
 	}
 )
@@ -0,0 +1,65 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/gates"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+)
+
+func TestRepeatableFlagsFromEnv(t *testing.T) {
+	os.Setenv("CEPH_REBALANCER_GATE_EXPR", "backfill_pgs <= 20; misplaced_ratio < 0.1;")
+	defer os.Unsetenv("CEPH_REBALANCER_GATE_EXPR")
+	os.Setenv("CEPH_REBALANCER_PROMQL_GATE", "http://prom:9090|sum by (a, b) (x)|5;http://prom:9090|y|1")
+	defer os.Unsetenv("CEPH_REBALANCER_PROMQL_GATE")
+
+	exprs, queries := &rawStrings{}, &promQLQueries{}
+	app := &cli.App{
+		Flags: []cli.Flag{
+			&cli.GenericFlag{Name: gateExprFlag.Name, EnvVars: gateExprFlag.EnvVars, Value: exprs},
+			&cli.GenericFlag{Name: promQLGateFlag.Name, EnvVars: promQLGateFlag.EnvVars, Value: queries},
+		},
+		Action: func(ctx *cli.Context) error { return nil },
+	}
+	assert.NoError(t, app.Run([]string{"archimedes"}))
+
+	assert.Equal(t, []string{"backfill_pgs <= 20", "misplaced_ratio < 0.1"}, exprs.values,
+		"entries should be split on semicolons only")
+	assert.Equal(t, []gates.PromQLQuery{
+		{Endpoint: "http://prom:9090", Expr: "sum by (a, b) (x)", Threshold: 5},
+		{Endpoint: "http://prom:9090", Expr: "y", Threshold: 1},
+	}, queries.queries)
+}
+
+func TestInterruptible(t *testing.T) {
+	ctx, cancel := interruptible(context.Background())
+	defer cancel()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGTERM should cancel the run")
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/digitalocean/archimedes/rebalancer"
+	"github.com/urfave/cli/v2"
+)
+
+// Formats commands print their results in, see --output. Logs go to
+// stderr either way.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// checkOutput refuses unknown output formats.
+func checkOutput(ctx *cli.Context) error {
+	switch f := ctx.String(outputFlag.Name); f {
+	case outputText, outputJSON:
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q, either %s or %s", f, outputText, outputJSON)
+	}
+}
+
+// jsonOutput reports whether results are printed as JSON.
+func jsonOutput(ctx *cli.Context) bool {
+	return ctx.String(outputFlag.Name) == outputJSON
+}
+
+// printJSON prints a result to stdout as JSON.
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// runSummary is the outcome of a run of reweight, apply, restore or
+// resume as printed as JSON.
+type runSummary struct {
+	Iterations int             `json:"iterations"`
+	Completed  []int           `json:"completed"`
+	Skipped    map[int]string  `json:"skipped"`
+	Remaining  map[int]float64 `json:"remaining"`
+	RolledBack bool            `json:"rolled_back"`
+
+	// Drained maps the OSDs drained to zero to whether they are safe
+	// to destroy.
+	Drained map[int]bool `json:"drained"`
+
+	// Error tells why the run ended early, and LastError is the last
+	// error met meanwhile.
+	Error     string `json:"error,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+func newRunSummary(s rebalancer.Summary, drained map[int]bool, runErr error) *runSummary {
+	rs := &runSummary{
+		Iterations: s.Iterations,
+		Completed:  append([]int{}, s.Completed...),
+		Skipped:    s.Skipped,
+		Remaining:  s.Remaining,
+		RolledBack: s.RolledBack,
+		Drained:    drained,
+	}
+	if runErr != nil {
+		rs.Error = runErr.Error()
+	}
+	if s.LastError != nil {
+		rs.LastError = s.LastError.Error()
+	}
+	return rs
+}
@@ -0,0 +1,217 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/rebalancer"
+	"github.com/urfave/cli/v2"
+)
+
+// plan is the reviewable outcome of the plan command, which the
+// apply command carries out once the cluster is found to still be in
+// the state the plan starts from.
+type plan struct {
+	CreatedAt           time.Time         `json:"created_at"`
+	Targets             map[int]float64   `json:"targets"`
+	Start               map[int]float64   `json:"start"`
+	WeightIncrement     float64           `json:"weight_increment"`
+	WeightPrecision     int               `json:"weight_precision"`
+	MaxOSDsPerIteration int               `json:"max_osds_per_iteration"`
+	Iterations          []map[int]float64 `json:"iterations"`
+	Duration            string            `json:"estimated_duration"`
+
+	// Signature is the hex encoded HMAC-SHA256 of the plan without
+	// its signature, keyed with the contents of --plan-key-file.
+	Signature string `json:"signature"`
+}
+
+func newPlan(targets map[int]float64, sim *rebalancer.Simulation, increment float64, precision, maxOSDs int) *plan {
+	return &plan{
+		CreatedAt:           time.Now().UTC().Truncate(time.Second),
+		Targets:             targets,
+		Start:               sim.Start,
+		WeightIncrement:     increment,
+		WeightPrecision:     precision,
+		MaxOSDsPerIteration: maxOSDs,
+		Iterations:          sim.Iterations,
+		Duration:            sim.Duration.String(),
+	}
+}
+
+// sign returns the signature of the plan for the given key.
+func (p *plan) sign(key []byte) (string, error) {
+	unsigned := *p
+	unsigned.Signature = ""
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// writePlan signs the plan and writes it to the given path.
+func writePlan(path string, p *plan, key []byte) error {
+	sig, err := p.sign(key)
+	if err != nil {
+		return err
+	}
+	p.Signature = sig
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// readPlan reads the plan at the given path, refusing plans that were
+// not signed with the given key or were changed since.
+func readPlan(path string, key []byte) (*plan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %s", path, err)
+	}
+
+	sig, err := p.sign(key)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(sig), []byte(p.Signature)) {
+		return nil, fmt.Errorf("%s is not signed with the given key or was changed since", path)
+	}
+	if len(p.Targets) == 0 {
+		return nil, fmt.Errorf("%s holds no target osds", path)
+	}
+	return &p, nil
+}
+
+// checkDrift compares the weights of the plan's target OSDs against
+// the weights it starts from, and lists every OSD that drifted.
+func (p *plan) checkDrift(ctx context.Context, cc cephclient.Client) error {
+	osds := make([]int, 0, len(p.Targets))
+	for osd := range p.Targets {
+		osds = append(osds, osd)
+	}
+	sort.Ints(osds)
+
+	tree, err := cc.OSDTreeOf(ctx, osds)
+	if err != nil {
+		return fmt.Errorf("cannot read the osd tree: %s", err)
+	}
+	cws := make(map[int]float64, len(tree.Nodes))
+	for _, node := range tree.Nodes {
+		if node.Type == "osd" {
+			cws[node.ID] = node.CrushWeight
+		}
+	}
+
+	// Weights are compared at the precision the plan was made with.
+	tolerance := math.Pow10(-p.WeightPrecision) / 2
+
+	var drifted []string
+	for _, osd := range osds {
+		start, planned := p.Start[osd]
+		cw, found := cws[osd]
+		switch {
+		case planned && !found:
+			drifted = append(drifted, fmt.Sprintf("osd.%d is gone", osd))
+		case !planned && found:
+			drifted = append(drifted, fmt.Sprintf("osd.%d appeared", osd))
+		case planned && math.Abs(cw-start) >= tolerance:
+			drifted = append(drifted, fmt.Sprintf("osd.%d is at %v instead of %v", osd, cw, start))
+		}
+	}
+	if len(drifted) > 0 {
+		return errors.New("the cluster drifted from the plan: " + strings.Join(drifted, ", "))
+	}
+	return nil
+}
+
+// readPlanKey reads the key plans are signed with.
+func readPlanKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("a key to sign and verify plans with is required, see --plan-key-file")
+	}
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read plan key: %s", err)
+	}
+	key = []byte(strings.TrimSpace(string(key)))
+	if len(key) == 0 {
+		return nil, fmt.Errorf("plan key %s is empty", path)
+	}
+	return key, nil
+}
+
+// apply carries out the plan given through --plan with the settings
+// it was made with, once the cluster is found not to have drifted
+// from the weights the plan starts from.
+func apply(ctx *cli.Context) error {
+	key, err := readPlanKey(ctx.String(planKeyFileFlag.Name))
+	if err != nil {
+		return err
+	}
+	p, err := readPlan(ctx.String(planFlag.Name), key)
+	if err != nil {
+		return fmt.Errorf("cannot read plan: %s", err)
+	}
+
+	cc, err := newCephClient(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot create new ceph-client: %s", err)
+	}
+	err = p.checkDrift(context.Background(), cc)
+	cc.Close()
+	if err != nil {
+		return err
+	}
+
+	// The plan overrides both the command line and the config file.
+	for name, val := range map[string]string{
+		targetOSDsCrushFlag.Name:     formatTargetWeightMap(p.Targets),
+		weightIncrementFlag.Name:     strconv.FormatFloat(p.WeightIncrement, 'f', -1, 64),
+		weightPrecisionFlag.Name:     strconv.Itoa(p.WeightPrecision),
+		maxOSDsPerIterationFlag.Name: strconv.Itoa(p.MaxOSDsPerIteration),
+	} {
+		if err := ctx.Set(name, val); err != nil {
+			return fmt.Errorf("cannot apply plan: %s", err)
+		}
+	}
+
+	return reweight(ctx)
+}
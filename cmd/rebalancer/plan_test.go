@@ -0,0 +1,183 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func testPlan() *plan {
+	return &plan{
+		CreatedAt:           time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		Targets:             map[int]float64{1: 1.5, 2: 2},
+		Start:               map[int]float64{1: 1, 2: 1.5},
+		WeightIncrement:     0.25,
+		WeightPrecision:     2,
+		MaxOSDsPerIteration: 1,
+		Iterations:          []map[int]float64{{1: 1.25}, {2: 1.75}, {1: 1.5}, {2: 2}},
+		Duration:            "1h0m0s",
+	}
+}
+
+func TestReadPlan(t *testing.T) {
+	key := []byte("secret")
+
+	tests := []struct {
+		name string
+		// write writes the plan to path, signed with key.
+		write func(t *testing.T, path string)
+		key   []byte
+		err   string
+	}{
+		{
+			name: "Valid",
+			write: func(t *testing.T, path string) {
+				assert.NoError(t, writePlan(path, testPlan(), key))
+			},
+		},
+		{
+			name: "Tampered Body",
+			write: func(t *testing.T, path string) {
+				assert.NoError(t, writePlan(path, testPlan(), key))
+				data, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data = []byte(strings.Replace(string(data), `"weight_increment": 0.25`, `"weight_increment": 0.5`, 1))
+				assert.NoError(t, ioutil.WriteFile(path, data, 0o644))
+			},
+			err: "is not signed with the given key or was changed since",
+		},
+		{
+			name: "Wrong Key",
+			write: func(t *testing.T, path string) {
+				assert.NoError(t, writePlan(path, testPlan(), []byte("other")))
+			},
+			err: "is not signed with the given key or was changed since",
+		},
+		{
+			name: "Missing Signature",
+			write: func(t *testing.T, path string) {
+				data, err := json.Marshal(testPlan())
+				if err != nil {
+					t.Fatal(err)
+				}
+				assert.NoError(t, ioutil.WriteFile(path, data, 0o644))
+			},
+			err: "is not signed with the given key or was changed since",
+		},
+		{
+			name: "No Targets",
+			write: func(t *testing.T, path string) {
+				p := testPlan()
+				p.Targets = nil
+				assert.NoError(t, writePlan(path, p, key))
+			},
+			err: "holds no target osds",
+		},
+		{
+			name: "Not JSON",
+			write: func(t *testing.T, path string) {
+				assert.NoError(t, ioutil.WriteFile(path, []byte("targets: 1:1.5\n"), 0o644))
+			},
+			err: "cannot parse",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "plan.json")
+			tt.write(t, path)
+
+			p, err := readPlan(path, key)
+			if tt.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tt.err)
+				}
+				return
+			}
+			if assert.NoError(t, err) {
+				want := testPlan()
+				want.Signature = p.Signature
+				assert.Equal(t, want, p)
+			}
+		})
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	tests := []struct {
+		name string
+		osds []cephtest.OSD
+		err  string
+	}{
+		{
+			name: "No Drift",
+			osds: []cephtest.OSD{{ID: 1, Host: "a", CrushWeight: 1}, {ID: 2, Host: "a", CrushWeight: 1.5}},
+		},
+		{
+			name: "Within Precision",
+			osds: []cephtest.OSD{{ID: 1, Host: "a", CrushWeight: 1.004}, {ID: 2, Host: "a", CrushWeight: 1.5}},
+		},
+		{
+			name: "Weight Drifted",
+			osds: []cephtest.OSD{{ID: 1, Host: "a", CrushWeight: 1.2}, {ID: 2, Host: "a", CrushWeight: 1.5}},
+			err:  "the cluster drifted from the plan: osd.1 is at 1.2 instead of 1",
+		},
+		{
+			name: "OSD Gone",
+			osds: []cephtest.OSD{{ID: 1, Host: "a", CrushWeight: 1}},
+			err:  "the cluster drifted from the plan: osd.2 is gone",
+		},
+		{
+			name: "Several Drifted",
+			osds: []cephtest.OSD{{ID: 1, Host: "a", CrushWeight: 0.5}},
+			err:  "the cluster drifted from the plan: osd.1 is at 0.5 instead of 1, osd.2 is gone",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := cephtest.New(cephtest.State{OSDTree: cephtest.NewOSDTree(tt.osds...)})
+
+			err := testPlan().checkDrift(context.Background(), cc)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	t.Run("OSD Appeared", func(t *testing.T) {
+		cc := cephtest.New(cephtest.State{OSDTree: cephtest.NewOSDTree(
+			cephtest.OSD{ID: 1, Host: "a", CrushWeight: 1},
+			cephtest.OSD{ID: 2, Host: "a", CrushWeight: 1.5},
+		)})
+		p := testPlan()
+		delete(p.Start, 2)
+
+		assert.EqualError(t, p.checkDrift(context.Background(), cc), "the cluster drifted from the plan: osd.2 appeared")
+	})
+}
@@ -0,0 +1,181 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/rebalancer"
+	"github.com/urfave/cli/v2"
+)
+
+// snapshot holds the CRUSH weights of a set of OSDs as saved by the
+// snapshot command, for the restore command to return them to.
+type snapshot struct {
+	CreatedAt time.Time       `json:"created_at"`
+	Weights   map[int]float64 `json:"weights"`
+}
+
+// selectOSDs returns the OSDs of the tree that were given, along with
+// those under the given buckets, failing on any that isn't found.
+func selectOSDs(tree *cephclient.OSDTreeOut, osds []int, buckets []string) ([]int, error) {
+	nodes := make(map[int]cephclient.OSDTreeNode, len(tree.Nodes))
+	byName := make(map[string]int, len(tree.Nodes))
+	for _, node := range tree.Nodes {
+		nodes[node.ID] = node
+		byName[node.Name] = node.ID
+	}
+
+	selected := make(map[int]bool)
+	for _, osd := range osds {
+		if node, ok := nodes[osd]; !ok || node.Type != "osd" {
+			return nil, fmt.Errorf("osd.%d not found in the osd tree", osd)
+		}
+		selected[osd] = true
+	}
+	for _, bucket := range buckets {
+		id, ok := byName[bucket]
+		if !ok {
+			return nil, fmt.Errorf("bucket %s not found in the osd tree", bucket)
+		}
+		stack := []int{id}
+		for len(stack) > 0 {
+			node := nodes[stack[len(stack)-1]]
+			stack = stack[:len(stack)-1]
+			if node.Type == "osd" {
+				selected[node.ID] = true
+			}
+			stack = append(stack, node.Children...)
+		}
+	}
+
+	ids := make([]int, 0, len(selected))
+	for osd := range selected {
+		ids = append(ids, osd)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// takeSnapshot saves the current CRUSH weights of the OSDs given
+// through --osd and --subtree to the --snapshot file.
+func takeSnapshot(ctx *cli.Context) error {
+	path := ctx.String(snapshotFlag.Name)
+	if path == "" {
+		return fmt.Errorf("no snapshot file given, see --%s", snapshotFlag.Name)
+	}
+
+	cc, err := newCephClient(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot create new ceph-client: %s", err)
+	}
+	defer cc.Close()
+
+	s, err := newSnapshot(context.Background(), cc, ctx.IntSlice(osdFlag.Name), ctx.StringSlice(subtreeFlag.Name))
+	if err != nil {
+		return err
+	}
+	if err := writeSnapshot(path, s); err != nil {
+		return fmt.Errorf("cannot write snapshot: %s", err)
+	}
+	if jsonOutput(ctx) {
+		return printJSON(s)
+	}
+	fmt.Printf("weights of %d osds saved to %s, return to them with restore --snapshot %s\n", len(s.Weights), path, path)
+	return nil
+}
+
+// newSnapshot returns the current CRUSH weights of the given OSDs and
+// of the OSDs under the given buckets.
+func newSnapshot(ctx context.Context, cc cephclient.Client, osds []int, buckets []string) (*snapshot, error) {
+	tree, err := cc.OSDTree(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the osd tree: %s", err)
+	}
+	osds, err = selectOSDs(tree, osds, buckets)
+	if err != nil {
+		return nil, err
+	}
+	if len(osds) == 0 {
+		return nil, fmt.Errorf("no osds selected, see --%s and --%s", osdFlag.Name, subtreeFlag.Name)
+	}
+
+	cws := make(map[int]float64, len(tree.Nodes))
+	for _, node := range tree.Nodes {
+		if node.Type == "osd" {
+			cws[node.ID] = node.CrushWeight
+		}
+	}
+	s := &snapshot{
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Weights:   make(map[int]float64, len(osds)),
+	}
+	for _, osd := range osds {
+		s.Weights[osd] = cws[osd]
+	}
+	return s, nil
+}
+
+// writeSnapshot writes the snapshot to the given path.
+func writeSnapshot(path string, s *snapshot) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// readSnapshot reads the snapshot at the given path.
+func readSnapshot(path string) (*snapshot, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no snapshot file given, see --%s", snapshotFlag.Name)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %s", path, err)
+	}
+	if len(s.Weights) == 0 {
+		return nil, errors.New("snapshot holds no osds")
+	}
+	return &s, nil
+}
+
+// restore gradually returns the OSDs of the --snapshot file to the
+// weights saved, reweighting them like the reweight command would.
+// Undoing a campaign takes downweighting, so it is always allowed.
+func restore(ctx *cli.Context) error {
+	s, err := readSnapshot(ctx.String(snapshotFlag.Name))
+	if err != nil {
+		return fmt.Errorf("cannot read snapshot: %s", err)
+	}
+
+	// The snapshot overrides both the command line and the config file.
+	if err := ctx.Set(targetOSDsCrushFlag.Name, formatTargetWeightMap(s.Weights)); err != nil {
+		return fmt.Errorf("cannot restore snapshot: %s", err)
+	}
+	return reweightWith(ctx, rebalancer.WithAllowDownweight(true))
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/digitalocean/archimedes/rebalancer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	cc := cephtest.New(cephtest.State{
+		OSDTree: cephtest.NewOSDTree(
+			cephtest.OSD{ID: 1, Host: "a", CrushWeight: 1},
+			cephtest.OSD{ID: 2, Host: "a", CrushWeight: 2},
+			cephtest.OSD{ID: 3, Host: "b", CrushWeight: 3},
+			cephtest.OSD{ID: 4, Host: "b", CrushWeight: 4},
+		),
+	})
+	ctx := context.Background()
+
+	s, err := newSnapshot(ctx, cc, []int{3}, []string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[int]float64{1: 1, 2: 2, 3: 3}, s.Weights, "the osds given and those under the buckets should be saved")
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	assert.NoError(t, writeSnapshot(path, s))
+	read, err := readSnapshot(path)
+	assert.NoError(t, err)
+	assert.Equal(t, s, read)
+
+	// A campaign raises some weights and lowers others, which restore
+	// returns them from, leaving the OSDs not saved alone.
+	for osd, w := range map[int]float64{1: 1.5, 2: 1, 3: 4, 4: 5} {
+		assert.NoError(t, cc.CrushReweight(ctx, osd, w))
+	}
+	targets, err := parseTargetWeightMap(formatTargetWeightMap(read.Weights))
+	assert.NoError(t, err)
+	r, err := rebalancer.New(
+		rebalancer.WithCephClient(cc),
+		rebalancer.WithTargetCrushWeightMap(targets),
+		rebalancer.WithWeightIncrement(0.5),
+		rebalancer.WithAllowDownweight(true),
+		rebalancer.WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5 && len(r.RemainingTargets()) > 0; i++ {
+		r.DoReweight()
+	}
+	assert.Empty(t, r.RemainingTargets())
+	assert.Equal(t, map[int]float64{1: 1, 2: 2, 3: 3, 4: 5}, cc.CrushWeights())
+}
+
+func TestNewSnapshot(t *testing.T) {
+	cc := cephtest.New(cephtest.State{
+		OSDTree: cephtest.NewOSDTree(cephtest.OSD{ID: 1, Host: "a", CrushWeight: 1}),
+	})
+
+	tests := []struct {
+		name    string
+		osds    []int
+		buckets []string
+		err     string
+	}{
+		{name: "Unknown OSD", osds: []int{2}, err: "osd.2 not found in the osd tree"},
+		{name: "Bucket Given As OSD", osds: []int{-2}, err: "osd.-2 not found in the osd tree"},
+		{name: "Unknown Bucket", buckets: []string{"b"}, err: "bucket b not found in the osd tree"},
+		{name: "Nothing Selected", err: "no osds selected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newSnapshot(context.Background(), cc, tt.osds, tt.buckets)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
+
+func TestReadSnapshot(t *testing.T) {
+	_, err := readSnapshot("")
+	assert.Error(t, err, "a snapshot file is required")
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	assert.NoError(t, writeSnapshot(path, &snapshot{}))
+	_, err = readSnapshot(path)
+	assert.EqualError(t, err, "snapshot holds no osds")
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/digitalocean/archimedes/rebalancer"
+	"github.com/urfave/cli/v2"
+)
+
+// writeState saves the state of a run to the given path. It is
+// written to a temporary file first and renamed over the previous
+// state, so that a crash never leaves a partial state behind.
+func writeState(path string, s rebalancer.State) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readState reads the state saved to the given path.
+func readState(path string) (*rebalancer.State, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s rebalancer.State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %s", path, err)
+	}
+	return &s, nil
+}
+
+// resume carries on with the run whose state was saved to the
+// --state-file, which it keeps saving to.
+func resume(ctx *cli.Context) error {
+	path := ctx.String(stateFileFlag.Name)
+	if path == "" {
+		return fmt.Errorf("no state file given, see --%s", stateFileFlag.Name)
+	}
+	s, err := readState(path)
+	if err != nil {
+		return fmt.Errorf("cannot read state: %s", err)
+	}
+	if len(s.Remaining) == 0 {
+		if jsonOutput(ctx) {
+			return printJSON(&runSummary{
+				Iterations: s.Iterations,
+				Completed:  append([]int{}, s.Completed...),
+				Skipped:    s.Skipped,
+				Remaining:  s.Remaining,
+			})
+		}
+		fmt.Println("the saved run has no osds left to reweight")
+		return nil
+	}
+
+	log.Printf("resuming run saved at %s with %d osds remaining", s.SavedAt.Format(time.RFC3339), len(s.Remaining))
+	return reweightWith(ctx, rebalancer.WithState(*s))
+}
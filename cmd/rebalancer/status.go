@@ -0,0 +1,188 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/digitalocean/archimedes/rebalancer"
+	"github.com/urfave/cli/v2"
+)
+
+// statusTimeout bounds how long the status command waits for the
+// running instance to answer.
+const statusTimeout = 10 * time.Second
+
+// statusResponse is the state of a running rebalancer as served on
+// /status.
+type statusResponse struct {
+	Percent       float64           `json:"percent"`
+	OSDs          map[int]osdStatus `json:"osds"`
+	Remaining     map[int]float64   `json:"remaining"`
+	Paused        bool              `json:"paused"`
+	LastIteration *iterationStatus  `json:"last_iteration,omitempty"`
+}
+
+type osdStatus struct {
+	Start     float64 `json:"start"`
+	Current   float64 `json:"current"`
+	Target    float64 `json:"target"`
+	Completed bool    `json:"completed"`
+}
+
+type iterationStatus struct {
+	Reweights  map[int]float64 `json:"reweights"`
+	Completed  []int           `json:"completed,omitempty"`
+	Skipped    map[int]string  `json:"skipped,omitempty"`
+	SkipReason string          `json:"skip_reason,omitempty"`
+	Gates      []gateStatus    `json:"gates"`
+	Done       bool            `json:"done"`
+}
+
+type gateStatus struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func newStatusResponse(s rebalancer.Status) *statusResponse {
+	resp := &statusResponse{
+		Percent:   s.Progress.Percent,
+		OSDs:      make(map[int]osdStatus, len(s.Progress.OSDs)),
+		Remaining: s.Remaining,
+		Paused:    s.Paused,
+	}
+	for osd, op := range s.Progress.OSDs {
+		resp.OSDs[osd] = osdStatus(op)
+	}
+
+	if res := s.LastIteration; res != nil {
+		it := &iterationStatus{
+			Reweights:  res.Reweights,
+			Completed:  res.Completed,
+			Skipped:    res.Skipped,
+			SkipReason: res.SkipReason,
+			Done:       res.Done,
+		}
+		for _, c := range res.Gates {
+			result, detail := checkResult(c)
+			it.Gates = append(it.Gates, gateStatus{Name: c.Name, Result: result, Detail: detail})
+		}
+		resp.LastIteration = it
+	}
+	return resp
+}
+
+// statusHandler serves the state of the rebalancer as JSON.
+func statusHandler(r *rebalancer.Rebalancer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(newStatusResponse(r.Status())); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// status queries a running instance for its state and prints it.
+func status(ctx *cli.Context) error {
+	url := strings.TrimRight(ctx.String(instanceFlag.Name), "/") + "/status"
+
+	client := &http.Client{Timeout: statusTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("cannot query running instance: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot query running instance: %s returned %s", url, resp.Status)
+	}
+
+	var s statusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return fmt.Errorf("cannot decode status: %s", err)
+	}
+	if jsonOutput(ctx) {
+		return printJSON(&s)
+	}
+
+	fmt.Printf("progress: %.1f%%, %d osds remaining\n", s.Percent, len(s.Remaining))
+	if s.Paused {
+		fmt.Println("reweighting is paused, send SIGUSR2 to resume")
+	}
+
+	osds := make([]int, 0, len(s.OSDs))
+	for osd := range s.OSDs {
+		osds = append(osds, osd)
+	}
+	sort.Ints(osds)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OSD\tSTART\tCURRENT\tTARGET\tSTATE")
+	for _, osd := range osds {
+		op := s.OSDs[osd]
+		state := "reweighting"
+		if op.Completed {
+			state = "completed"
+		}
+		fmt.Fprintf(w, "osd.%d\t%v\t%v\t%v\t%s\n", osd, op.Start, op.Current, op.Target, state)
+	}
+	w.Flush()
+
+	it := s.LastIteration
+	if it == nil {
+		fmt.Println("no iteration has run yet")
+		return nil
+	}
+
+	switch {
+	case it.Done:
+		fmt.Println("last iteration: all osds processed")
+	case it.SkipReason != "":
+		fmt.Printf("last iteration: skipped, %s\n", it.SkipReason)
+	case len(it.Reweights) == 0:
+		fmt.Println("last iteration: no osd reweighted")
+	default:
+		fmt.Printf("last iteration: reweighted %s\n", formatTargetWeightMap(it.Reweights))
+	}
+	for _, osd := range it.Completed {
+		fmt.Printf("  osd.%d completed\n", osd)
+	}
+	skipped := make([]int, 0, len(it.Skipped))
+	for osd := range it.Skipped {
+		skipped = append(skipped, osd)
+	}
+	sort.Ints(skipped)
+	for _, osd := range skipped {
+		fmt.Printf("  osd.%d skipped: %s\n", osd, it.Skipped[osd])
+	}
+
+	if len(it.Gates) > 0 {
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "GATE\tRESULT\tDETAIL")
+		for _, g := range it.Gates {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", g.Name, g.Result, g.Detail)
+		}
+		w.Flush()
+	}
+	return nil
+}
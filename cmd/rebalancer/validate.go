@@ -0,0 +1,114 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/urfave/cli/v2"
+)
+
+// capacityTolerance is how far a target weight may exceed the size of
+// its device in TiB, as crush weights are usually rounded up from it.
+const capacityTolerance = 0.0001
+
+// validateTargets checks the target-weight pairs against the OSDs of
+// the cluster and returns the problems found, if any. Targets below
+// the current weight are only a problem unless allowDownweight is set.
+func validateTargets(pairs []targetWeight, df *cephclient.OSDDFOut, allowDownweight bool) []string {
+	osds := make(map[int]cephclient.OSDDFNode, len(df.Nodes))
+	for _, node := range df.Nodes {
+		if node.Type == "osd" {
+			osds[node.ID] = node
+		}
+	}
+
+	var problems []string
+	seen := make(map[int]bool, len(pairs))
+	for _, p := range pairs {
+		if seen[p.osd] {
+			problems = append(problems, fmt.Sprintf("osd.%d: given more than once", p.osd))
+			continue
+		}
+		seen[p.osd] = true
+
+		node, ok := osds[p.osd]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("osd.%d: unknown to the cluster", p.osd))
+			continue
+		case p.weight < 0:
+			problems = append(problems, fmt.Sprintf("osd.%d: target %v is negative", p.osd, p.weight))
+			continue
+		case p.weight < node.CrushWeight && !allowDownweight:
+			problems = append(problems, fmt.Sprintf("osd.%d: target %v is below the current weight %v", p.osd, p.weight, node.CrushWeight))
+		}
+
+		// OSDs that are down report no size.
+		if node.KB > 0 {
+			capacity := kbToWeight(node.KB)
+			if p.weight > capacity+capacityTolerance {
+				problems = append(problems, fmt.Sprintf("osd.%d: target %v exceeds the device capacity of %.4f TiB", p.osd, p.weight, capacity))
+			}
+		}
+	}
+
+	return problems
+}
+
+// validate checks the targets given through --target-osd-crush-weights
+// against the cluster, exiting non-zero if any problem is found.
+func validate(ctx *cli.Context) error {
+	pairs, err := parseTargetWeightPairs(ctx.String(targetOSDsCrushFlag.Name))
+	if err != nil {
+		return fmt.Errorf("failed parsing target-weights: %s", err)
+	}
+
+	cc, err := newCephClient(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot create new ceph-client: %s", err)
+	}
+	defer cc.Close()
+
+	df, err := cc.OSDDF(context.Background())
+	if err != nil {
+		return fmt.Errorf("cannot get osd df: %s", err)
+	}
+
+	problems := validateTargets(pairs, df, ctx.Bool(allowDownweightFlag.Name))
+	if jsonOutput(ctx) {
+		err = printJSON(struct {
+			Valid    bool     `json:"valid"`
+			Targets  int      `json:"targets"`
+			Problems []string `json:"problems"`
+		}{len(problems) == 0, len(pairs), append([]string{}, problems...)})
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+	}
+	if len(problems) > 0 {
+		return cli.Exit(fmt.Sprintf("%d problems found with the targets", len(problems)), 1)
+	}
+	if !jsonOutput(ctx) {
+		fmt.Printf("all %d targets are valid\n", len(pairs))
+	}
+	return nil
+}
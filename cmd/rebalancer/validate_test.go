@@ -0,0 +1,110 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTargets(t *testing.T) {
+	const tib = 1 << 30 // in KiB
+	df := &cephclient.OSDDFOut{
+		Nodes: []cephclient.OSDDFNode{
+			{ID: -1, Name: "host-a", Type: "host", KB: 6 * tib},
+			{ID: 1, Type: "osd", CrushWeight: 1, KB: 2 * tib},
+			{ID: 2, Type: "osd", CrushWeight: 3, KB: 4 * tib},
+			{ID: 3, Type: "osd", CrushWeight: 1},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		targets         string
+		allowDownweight bool
+		problems        []string
+	}{
+		{
+			name:    "Valid",
+			targets: "1:2,2:3.5,3:10",
+		},
+		{
+			name:    "Unknown OSD",
+			targets: "1:2,4:1",
+			problems: []string{
+				"osd.4: unknown to the cluster",
+			},
+		},
+		{
+			name:    "Bucket",
+			targets: "-1:1",
+			problems: []string{
+				"osd.-1: unknown to the cluster",
+			},
+		},
+		{
+			name:    "Duplicate",
+			targets: "1:1.5,1:2",
+			problems: []string{
+				"osd.1: given more than once",
+			},
+		},
+		{
+			name:    "Downweight",
+			targets: "2:2",
+			problems: []string{
+				"osd.2: target 2 is below the current weight 3",
+			},
+		},
+		{
+			name:            "Downweight Allowed",
+			targets:         "2:2",
+			allowDownweight: true,
+		},
+		{
+			name:    "Over Capacity",
+			targets: "1:2.5,2:4.00005",
+			problems: []string{
+				"osd.1: target 2.5 exceeds the device capacity of 2.0000 TiB",
+			},
+		},
+		{
+			name:    "Several",
+			targets: "1:0.5,4:1,1:3",
+			problems: []string{
+				"osd.1: target 0.5 is below the current weight 1",
+				"osd.4: unknown to the cluster",
+				"osd.1: given more than once",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pairs, err := parseTargetWeightPairs(tt.targets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, tt.problems, validateTargets(pairs, df, tt.allowDownweight))
+		})
+	}
+
+	t.Run("Negative", func(t *testing.T) {
+		problems := validateTargets([]targetWeight{{osd: 1, weight: -1}}, df, true)
+		assert.Equal(t, []string{"osd.1: target -1 is negative"}, problems)
+	})
+}
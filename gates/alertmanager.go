@@ -0,0 +1,132 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AlertmanagerQuery selects alerts from Alertmanager that pause
+// reweighting for as long as any of them is firing.
+type AlertmanagerQuery struct {
+	// Endpoint is the base URL of the Alertmanager server,
+	// e.g. `http://alertmanager:9093`.
+	Endpoint string
+
+	// Matchers are label matchers in Alertmanager syntax, e.g.
+	// `severity="critical"` or `alertname=~"Ceph.*"`. An alert
+	// has to satisfy all of them to be selected.
+	Matchers []string
+}
+
+var alertmanagerClient = &http.Client{Timeout: 30 * time.Second}
+
+// Evaluate closes the gate while any of the selected alerts fires.
+func (q AlertmanagerQuery) Evaluate(ctx context.Context) (bool, string, error) {
+	alerts, err := q.firingAlerts()
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for firing alerts: %w", err)
+	}
+	if len(alerts) > 0 {
+		return false, fmt.Sprintf("firing alerts found: %s", strings.Join(alerts, ",")), nil
+	}
+	return true, "", nil
+}
+
+// firingAlerts returns the names of the alerts that match the query
+// and are neither silenced nor inhibited.
+func (q AlertmanagerQuery) firingAlerts() ([]string, error) {
+	u, err := url.Parse(strings.TrimRight(q.Endpoint, "/") + "/api/v2/alerts")
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = url.Values{
+		"active":    {"true"},
+		"silenced":  {"false"},
+		"inhibited": {"false"},
+		"filter":    q.Matchers,
+	}.Encode()
+
+	resp, err := alertmanagerClient.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	var alerts []struct {
+		Labels map[string]string `json:"labels"`
+		Status struct {
+			State string `json:"state"`
+		} `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&alerts); err != nil {
+		return nil, err
+	}
+
+	var firing []string
+	for _, alert := range alerts {
+		if alert.Status.State == "active" {
+			firing = append(firing, alert.Labels["alertname"])
+		}
+	}
+
+	return firing, nil
+}
+
+// ParseAlertMatchers breaks a selector such as
+// `severity="critical",team=~"storage|infra"` into its individual
+// matchers. Commas within quoted values are preserved.
+func ParseAlertMatchers(selector string) []string {
+	selector = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(selector), "{"), "}")
+
+	var (
+		matchers []string
+		current  strings.Builder
+		quoted   bool
+		escaped  bool
+	)
+	for _, c := range selector {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			if m := strings.TrimSpace(current.String()); m != "" {
+				matchers = append(matchers, m)
+			}
+			current.Reset()
+			continue
+		}
+		current.WriteRune(c)
+	}
+	if m := strings.TrimSpace(current.String()); m != "" {
+		matchers = append(matchers, m)
+	}
+
+	return matchers
+}
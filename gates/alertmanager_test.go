@@ -0,0 +1,58 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gates
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlertmanagerQuery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/alerts", r.URL.Path)
+		assert.Equal(t, []string{`severity="critical"`, `team=~"storage|infra"`}, r.URL.Query()["filter"])
+		assert.Equal(t, "false", r.URL.Query().Get("silenced"))
+
+		w.Write([]byte(`[
+			{"labels":{"alertname":"CephOSDDown"},"status":{"state":"active"}},
+			{"labels":{"alertname":"CephSlowOps"},"status":{"state":"suppressed"}}
+		]`))
+	}))
+	defer srv.Close()
+
+	alerts, err := AlertmanagerQuery{
+		Endpoint: srv.URL,
+		Matchers: ParseAlertMatchers(`{severity="critical", team=~"storage|infra"}`),
+	}.firingAlerts()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"CephOSDDown"}, alerts)
+
+	ok, reason, err := AlertmanagerQuery{Endpoint: srv.URL, Matchers: []string{`severity="critical"`, `team=~"storage|infra"`}}.Evaluate(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, ok, "a firing alert should close the gate")
+	assert.Equal(t, "firing alerts found: CephOSDDown", reason)
+}
+
+func TestParseAlertMatchers(t *testing.T) {
+	assert.Equal(t,
+		[]string{`alertname=~"Ceph.*"`, `cluster="a,b"`},
+		ParseAlertMatchers(`alertname=~"Ceph.*",cluster="a,b"`),
+	)
+	assert.Nil(t, ParseAlertMatchers(""))
+}
@@ -0,0 +1,37 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gates provides the preconditions evaluated before every
+// reweighting iteration, along with the gates backed by external
+// systems such as Prometheus and Alertmanager.
+package gates
+
+import "context"
+
+// Gate is a precondition that has to hold before an iteration is
+// allowed to reweight any OSDs. Evaluate reports whether the gate
+// is open and, if it isn't, a human readable reason why. An error
+// means the gate could not be evaluated, which also skips the
+// iteration.
+type Gate interface {
+	Evaluate(ctx context.Context) (ok bool, reason string, err error)
+}
+
+// GateFunc adapts an ordinary function to the Gate interface.
+type GateFunc func(ctx context.Context) (bool, string, error)
+
+// Evaluate calls f(ctx).
+func (f GateFunc) Evaluate(ctx context.Context) (bool, string, error) {
+	return f(ctx)
+}
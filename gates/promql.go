@@ -0,0 +1,139 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gates
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PromQLQuery is a Prometheus query evaluated before every
+// iteration. The iteration is skipped when any sample returned
+// by the query exceeds the threshold.
+type PromQLQuery struct {
+	// Endpoint is the base URL of the Prometheus server,
+	// e.g. `http://prometheus:9090`.
+	Endpoint string
+
+	// Expr is the PromQL expression to evaluate.
+	Expr string
+
+	// Threshold is the highest acceptable sample value.
+	Threshold float64
+}
+
+var promQLClient = &http.Client{Timeout: 30 * time.Second}
+
+// Evaluate closes the gate when the query returns a sample above
+// the threshold.
+func (q PromQLQuery) Evaluate(ctx context.Context) (bool, string, error) {
+	val, ok, err := q.evaluate()
+	if err != nil {
+		return false, "", fmt.Errorf("failed evaluating promql query %q: %s", q.Expr, err)
+	}
+	if ok && val > q.Threshold {
+		return false, fmt.Sprintf("promql query %q is at %g, above %g", q.Expr, val, q.Threshold), nil
+	}
+	return true, "", nil
+}
+
+// evaluate runs the instant query and returns the highest sample
+// value found, or false when the query returned no samples.
+func (q PromQLQuery) evaluate() (float64, bool, error) {
+	u, err := url.Parse(strings.TrimRight(q.Endpoint, "/") + "/api/v1/query")
+	if err != nil {
+		return 0, false, err
+	}
+	u.RawQuery = url.Values{"query": {q.Expr}}.Encode()
+
+	resp, err := promQLClient.Get(u.String())
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	out := &promQLResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return 0, false, fmt.Errorf("cannot decode response (status=%d): %s", resp.StatusCode, err)
+	}
+	if out.Status != "success" {
+		return 0, false, fmt.Errorf("query failed: %s", out.Error)
+	}
+
+	var samples [][]interface{}
+	switch out.Data.ResultType {
+	case "vector":
+		var vector []struct {
+			Value []interface{} `json:"value"`
+		}
+		if err := json.Unmarshal(out.Data.Result, &vector); err != nil {
+			return 0, false, err
+		}
+		for _, v := range vector {
+			samples = append(samples, v.Value)
+		}
+	case "scalar":
+		var scalar []interface{}
+		if err := json.Unmarshal(out.Data.Result, &scalar); err != nil {
+			return 0, false, err
+		}
+		samples = append(samples, scalar)
+	default:
+		return 0, false, fmt.Errorf("unsupported result type %q", out.Data.ResultType)
+	}
+
+	var (
+		highest float64
+		found   bool
+	)
+	for _, sample := range samples {
+		// Samples are encoded as [<timestamp>, "<value>"].
+		if len(sample) != 2 {
+			return 0, false, fmt.Errorf("malformed sample: %v", sample)
+		}
+		str, ok := sample[1].(string)
+		if !ok {
+			return 0, false, fmt.Errorf("malformed sample value: %v", sample[1])
+		}
+		val, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if !found || val > highest {
+			highest = val
+		}
+		found = true
+	}
+
+	return highest, found, nil
+}
+
+// promQLResponse provides a representation for responses of
+// the Prometheus `/api/v1/query` endpoint.
+type promQLResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
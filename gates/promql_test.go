@@ -0,0 +1,68 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gates
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromQLQuery(t *testing.T) {
+	responses := map[string]string{
+		"vector": `{"status":"success","data":{"resultType":"vector","result":[
+			{"metric":{"instance":"a"},"value":[1620000000.1,"0.5"]},
+			{"metric":{"instance":"b"},"value":[1620000000.1,"2.5"]}
+		]}}`,
+		"empty":  `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+		"scalar": `{"status":"success","data":{"resultType":"scalar","result":[1620000000.1,"7"]}}`,
+		"error":  `{"status":"error","errorType":"bad_data","error":"parse error"}`,
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/query", r.URL.Path)
+		w.Write([]byte(responses[r.URL.Query().Get("query")]))
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		expr  string
+		value float64
+		found bool
+		err   bool
+	}{
+		{expr: "vector", value: 2.5, found: true},
+		{expr: "empty", found: false},
+		{expr: "scalar", value: 7, found: true},
+		{expr: "error", err: true},
+	} {
+		val, found, err := PromQLQuery{Endpoint: srv.URL + "/", Expr: tt.expr}.evaluate()
+		assert.Equal(t, tt.err, err != nil, "%s: error should match", tt.expr)
+		assert.Equal(t, tt.found, found, "%s: found should match", tt.expr)
+		assert.Equal(t, tt.value, val, "%s: value should match", tt.expr)
+	}
+
+	ok, reason, err := PromQLQuery{Endpoint: srv.URL, Expr: "vector", Threshold: 2}.Evaluate(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, ok, "a sample above the threshold should close the gate")
+	assert.Equal(t, `promql query "vector" is at 2.5, above 2`, reason)
+
+	ok, _, err = PromQLQuery{Endpoint: srv.URL, Expr: "empty", Threshold: 2}.Evaluate(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok, "no samples should leave the gate open")
+}
@@ -0,0 +1,213 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gates
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a window of time recurring every day, or only on
+// certain weekdays, e.g. from 22:00 to 06:00. A window ending before
+// it starts wraps around midnight, and one ending when it starts
+// covers the whole day.
+type TimeWindow struct {
+	// Start and End are offsets from midnight.
+	Start time.Duration
+	End   time.Duration
+
+	// Weekdays the window starts on, every day when empty. A window
+	// wrapping around midnight belongs to the day it starts on.
+	Weekdays []time.Weekday
+
+	// Location the window is evaluated in, defaults to local time.
+	Location *time.Location
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseTimeWindow parses a window in the format of `HH:MM-HH:MM`,
+// optionally prefixed by a comma separated list of weekdays or
+// weekday ranges and followed by an IANA time zone name, e.g.
+// `22:00-06:00 Europe/Berlin` or `mon-fri,sun 22:00-06:00`.
+func ParseTimeWindow(s string) (TimeWindow, error) {
+	var w TimeWindow
+
+	fields := strings.Fields(s)
+	if len(fields) > 0 && (fields[0][0] < '0' || fields[0][0] > '9') {
+		days, err := parseWeekdays(fields[0])
+		if err != nil {
+			return w, fmt.Errorf("invalid time window %q: %s", s, err)
+		}
+		w.Weekdays = days
+		fields = fields[1:]
+	}
+	if len(fields) == 0 || len(fields) > 2 {
+		return w, fmt.Errorf("invalid time window %q", s)
+	}
+
+	bounds := strings.Split(fields[0], "-")
+	if len(bounds) != 2 {
+		return w, fmt.Errorf("invalid time window %q", s)
+	}
+
+	var err error
+	if w.Start, err = parseTimeOfDay(bounds[0]); err != nil {
+		return w, fmt.Errorf("invalid time window %q: %s", s, err)
+	}
+	if w.End, err = parseTimeOfDay(bounds[1]); err != nil {
+		return w, fmt.Errorf("invalid time window %q: %s", s, err)
+	}
+
+	if len(fields) == 2 {
+		if w.Location, err = time.LoadLocation(fields[1]); err != nil {
+			return w, fmt.Errorf("invalid time window %q: %s", s, err)
+		}
+	}
+
+	return w, nil
+}
+
+// LoadTimeWindows reads a calendar file listing one time window per
+// line in the format accepted by ParseTimeWindow. Empty lines and
+// lines starting with `#` are ignored.
+func LoadTimeWindows(path string) ([]TimeWindow, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var windows []TimeWindow
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		w, err := ParseTimeWindow(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, i+1, err)
+		}
+		windows = append(windows, w)
+	}
+
+	return windows, nil
+}
+
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	day := func(name string) (int, error) {
+		for i, d := range weekdays {
+			if strings.EqualFold(name, d) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("invalid weekday %q", name)
+	}
+
+	var days []time.Weekday
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.Split(part, "-")
+		if len(bounds) > 2 {
+			return nil, fmt.Errorf("invalid weekday range %q", part)
+		}
+
+		from, err := day(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, err = day(bounds[1]); err != nil {
+				return nil, err
+			}
+		}
+
+		// Ranges may wrap around the end of the week, e.g. fri-mon.
+		for d := from; ; d = (d + 1) % 7 {
+			days = append(days, time.Weekday(d))
+			if d == to {
+				break
+			}
+		}
+	}
+
+	return days, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t falls within the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	if w.Location != nil {
+		t = t.In(w.Location)
+	}
+	offset := time.Duration(t.Hour())*time.Hour +
+		time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+
+	switch {
+	case w.Start == w.End:
+		return w.on(t.Weekday())
+	case w.Start < w.End:
+		return offset >= w.Start && offset < w.End && w.on(t.Weekday())
+	case offset >= w.Start:
+		return w.on(t.Weekday())
+	case offset < w.End:
+		// Past midnight, the window started the day before.
+		return w.on((t.Weekday() + 6) % 7)
+	default:
+		return false
+	}
+}
+
+func (w TimeWindow) on(day time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, d := range w.Weekdays {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (w TimeWindow) String() string {
+	var s string
+	if len(w.Weekdays) > 0 {
+		days := make([]string, 0, len(w.Weekdays))
+		for _, d := range w.Weekdays {
+			days = append(days, weekdays[d])
+		}
+		s = strings.Join(days, ",") + " "
+	}
+
+	s += fmt.Sprintf("%02d:%02d-%02d:%02d",
+		int(w.Start.Hours()), int(w.Start.Minutes())%60,
+		int(w.End.Hours()), int(w.End.Minutes())%60)
+	if w.Location != nil {
+		s += " " + w.Location.String()
+	}
+	return s
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gates
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeWindow(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2021, 1, 1, hour, min, 0, 0, time.UTC)
+	}
+
+	for _, tt := range []struct {
+		window string
+		str    string
+
+		inside  []time.Time
+		outside []time.Time
+		err     bool
+	}{
+		{
+			window:  "09:00-17:30 UTC",
+			inside:  []time.Time{at(9, 0), at(12, 0), at(17, 29)},
+			outside: []time.Time{at(8, 59), at(17, 30), at(23, 0)},
+		},
+		{
+			window:  "22:00-06:00 UTC",
+			inside:  []time.Time{at(22, 0), at(23, 59), at(0, 0), at(5, 59)},
+			outside: []time.Time{at(6, 0), at(12, 0), at(21, 59)},
+		},
+		{
+			window: "00:00-00:00 UTC",
+			inside: []time.Time{at(0, 0), at(12, 0), at(23, 59)},
+		},
+		{
+			// 22:00 UTC is 23:00 in Berlin during winter.
+			window:  "23:00-01:00 Europe/Berlin",
+			inside:  []time.Time{at(22, 0), at(23, 30)},
+			outside: []time.Time{at(0, 0), at(21, 59)},
+		},
+		{
+			// 2021-01-01 is a Friday.
+			window:  "fri 22:00-06:00 UTC",
+			inside:  []time.Time{at(22, 0), at(23, 59), at(24, 0), at(24+5, 59)},
+			outside: []time.Time{at(0, 0), at(5, 59), at(24+22, 0)},
+		},
+		{
+			window:  "sat,sun 00:00-00:00 UTC",
+			inside:  []time.Time{at(24, 0), at(48+23, 59)},
+			outside: []time.Time{at(12, 0), at(72, 0)},
+		},
+		{
+			window:  "thu-sat 09:00-17:00 UTC",
+			str:     "thu,fri,sat 09:00-17:00 UTC",
+			inside:  []time.Time{at(9, 0), at(24+16, 59), at(-24+12, 0)},
+			outside: []time.Time{at(48+12, 0), at(-48+12, 0)},
+		},
+		{window: "someday 22:00-06:00", err: true},
+		{window: "mon-tue-wed 22:00-06:00", err: true},
+		{window: "mon", err: true},
+		{window: "22:00", err: true},
+		{window: "25:00-06:00", err: true},
+		{window: "22:00-06:00 Nowhere/Special", err: true},
+		{window: "22:00-06:00 UTC extra", err: true},
+	} {
+		t.Run(tt.window, func(t *testing.T) {
+			w, err := ParseTimeWindow(tt.window)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			str := tt.str
+			if str == "" {
+				str = tt.window
+			}
+			assert.Equal(t, str, w.String())
+
+			for _, ts := range tt.inside {
+				assert.True(t, w.Contains(ts), "%s should be inside", ts)
+			}
+			for _, ts := range tt.outside {
+				assert.False(t, w.Contains(ts), "%s should be outside", ts)
+			}
+		})
+	}
+}
+
+func TestLoadTimeWindows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archimedes")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "calendar")
+	err = ioutil.WriteFile(path, []byte(`# weekends, all day
+sat,sun 00:00-00:00 UTC
+
+mon-fri 22:00-06:00 UTC
+`), 0644)
+	if err != nil {
+		t.Fatalf("failed writing calendar: %s", err)
+	}
+
+	windows, err := LoadTimeWindows(path)
+	assert.NoError(t, err)
+	if assert.Len(t, windows, 2) {
+		assert.Equal(t, "sat,sun 00:00-00:00 UTC", windows[0].String())
+		assert.Equal(t, "mon,tue,wed,thu,fri 22:00-06:00 UTC", windows[1].String())
+	}
+
+	err = ioutil.WriteFile(path, []byte("sat,sun 00:00-00:00\nnever\n"), 0644)
+	if err != nil {
+		t.Fatalf("failed writing calendar: %s", err)
+	}
+	_, err = LoadTimeWindows(path)
+	assert.EqualError(t, err, path+`:2: invalid time window "never": invalid weekday "never"`)
+}
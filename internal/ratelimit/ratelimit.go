@@ -0,0 +1,151 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit provides the token-bucket limiter used for ceph
+// commands and reweights.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucket is a minimal token-bucket limiter. It starts full
+// with `capacity` tokens and refills continuously so that at
+// most `capacity` tokens are handed out in any `period` window.
+//
+// A nil *TokenBucket never limits.
+type TokenBucket struct {
+	mu sync.Mutex
+
+	capacity float64
+	tokens   float64
+	rate     float64 // tokens per second
+	last     time.Time
+
+	clock Clock
+}
+
+// Clock tells the time a TokenBucket refills by and waits for the
+// refills, so that buckets can be driven by a fake clock in tests.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// New returns a TokenBucket handing out `capacity` tokens per
+// `period` of the given clock, or of the system clock if it is nil.
+// It returns nil, which never limits, if either is not positive.
+func New(capacity int, period time.Duration, clock Clock) *TokenBucket {
+	if capacity <= 0 || period <= 0 {
+		return nil
+	}
+	if clock == nil {
+		clock = systemClock{}
+	}
+
+	return &TokenBucket{
+		capacity: float64(capacity),
+		tokens:   float64(capacity),
+		rate:     float64(capacity) / period.Seconds(),
+		clock:    clock,
+	}
+}
+
+// Allow reports whether a token is available and consumes it
+// if so.
+func (b *TokenBucket) Allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// Wait blocks until a token is available and consumes it, unless
+// the context is done first.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+
+	for {
+		b.mu.Lock()
+		b.refill()
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		select {
+		case <-b.clock.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Tokens returns the number of tokens available as of now, and the
+// time it was taken at.
+func (b *TokenBucket) Tokens() (float64, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	return b.tokens, b.last
+}
+
+// SetTokens sets the number of tokens that were available at the
+// given time, e.g. to carry a budget over from an earlier process.
+// Tokens keep refilling from then on, up to the capacity.
+func (b *TokenBucket) SetTokens(tokens float64, at time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = math.Max(0, math.Min(b.capacity, tokens))
+	b.last = at
+}
+
+func (b *TokenBucket) refill() {
+	now := b.clock.Now()
+	if !b.last.IsZero() {
+		elapsed := now.Sub(b.last).Seconds()
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
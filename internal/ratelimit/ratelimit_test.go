@@ -0,0 +1,148 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a Clock whose time only moves when advanced, firing
+// the waits that are due.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []fakeWait
+}
+
+type fakeWait struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waits = append(c.waits, fakeWait{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward, firing the waits due by then.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waits := c.waits[:0]
+	for _, w := range c.waits {
+		if w.at.After(c.now) {
+			waits = append(waits, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waits = waits
+}
+
+// Waiting returns the number of waits not fired yet.
+func (c *fakeClock) Waiting() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waits)
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	b := New(2, time.Hour, clock)
+
+	assert.True(t, b.Allow(), "first token should be available")
+	assert.True(t, b.Allow(), "second token should be available")
+	assert.False(t, b.Allow(), "bucket should be drained")
+
+	clock.Advance(30 * time.Minute)
+	assert.True(t, b.Allow(), "one token should refill after half the period")
+	assert.False(t, b.Allow(), "only one token should have refilled")
+
+	clock.Advance(10 * time.Hour)
+	assert.True(t, b.Allow())
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow(), "refill should not exceed capacity")
+
+	var unlimited *TokenBucket
+	assert.True(t, unlimited.Allow(), "nil bucket should never limit")
+	assert.Nil(t, New(0, time.Hour, clock), "zero capacity should disable limiting")
+}
+
+func TestTokenBucketSetTokens(t *testing.T) {
+	now := time.Unix(0, 0).Add(time.Hour)
+	b := New(4, time.Hour, &fakeClock{now: now})
+
+	b.SetTokens(1, now.Add(-30*time.Minute))
+	tokens, at := b.Tokens()
+	assert.Equal(t, 3.0, tokens, "tokens should refill since they were set")
+	assert.Equal(t, now, at)
+
+	b.SetTokens(10, now)
+	tokens, _ = b.Tokens()
+	assert.Equal(t, 4.0, tokens, "tokens should not exceed capacity")
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	b := New(1, time.Minute, clock)
+	assert.NoError(t, b.Wait(context.Background()), "the first token should not be waited for")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Wait(context.Background())
+	}()
+	for clock.Waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(30 * time.Second)
+	select {
+	case <-done:
+		t.Fatal("wait should last until the token refilled")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(30 * time.Second)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("wait should return once the token refilled")
+	}
+	assert.False(t, b.Allow(), "the refilled token should have been consumed")
+
+	b = New(1, time.Hour, clock)
+	assert.True(t, b.Allow())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, b.Wait(ctx), "done context should stop waiting")
+
+	var unlimited *TokenBucket
+	assert.NoError(t, unlimited.Wait(ctx), "nil bucket should never wait")
+}
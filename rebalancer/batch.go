@@ -0,0 +1,184 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	log "github.com/sirupsen/logrus"
+)
+
+// plannedReweight is a reweight decided on while visiting the target
+// OSDs, applied once all of them have been visited.
+type plannedReweight struct {
+	osd    int
+	weight float64
+	ll     log.FieldLogger
+}
+
+// applyReweights applies the planned reweights and returns how many
+// of them succeeded. Every change to a CRUSH weight makes for a new
+// osdmap epoch and another round of peering, so buckets whose OSDs
+// are all planned to the same weight, such as a new host ramping up,
+// are reweighted at once through `ceph osd crush reweight-subtree`.
+// The remaining OSDs, and those of buckets failing to be reweighted,
+// are reweighted one by one, possibly concurrently, as Ceph has no
+// command setting the CRUSH weights of arbitrary OSDs at once. Unless disabled,
+// the weights are read back afterwards and reweights which did not
+// take effect, e.g. because the mons clamped them, are not counted.
+func (r *Rebalancer) applyReweights(ctx context.Context, tree *cephclient.OSDTreeOut, planned []plannedReweight) int {
+	epoch := r.osdmapEpoch(ctx)
+
+	byOSD := make(map[int]plannedReweight, len(planned))
+	for _, p := range planned {
+		byOSD[p.osd] = p
+	}
+
+	done := make(map[int]bool)
+	for _, node := range tree.Nodes {
+		weight, ok := bucketWeight(node, byOSD)
+		if !ok {
+			continue
+		}
+
+		ll := r.log().WithField("bucket", node.Name).WithField("weight", weight)
+		if err := r.ceph.CrushReweightSubtree(ctx, node.Name, weight); err != nil {
+			ll.WithError(err).Warn("cannot reweight bucket, reweighting its osds one by one")
+			continue
+		}
+		ll.WithField("osds", node.Children).Info("reweighted all osds of bucket at once")
+
+		for _, osd := range node.Children {
+			done[osd] = true
+		}
+	}
+
+	var single []plannedReweight
+	for _, p := range planned {
+		if !done[p.osd] {
+			single = append(single, p)
+		}
+	}
+	errs := make(map[int]error, len(single))
+	for i, err := range r.crushReweightAll(ctx, single) {
+		errs[single[i].osd] = err
+	}
+
+	var reweighted []int
+	for _, p := range planned {
+		if errs[p.osd] == nil {
+			reweighted = append(reweighted, p.osd)
+		}
+	}
+	observed := r.observeWeights(ctx, reweighted, epoch)
+
+	var applied int
+	for _, p := range planned {
+		if err := errs[p.osd]; err != nil {
+			r.lastErr = err
+			if errors.Is(err, cephclient.ErrOSDNotFound) {
+				r.missOSD(p.osd, "unknown to the mons", p.ll.WithError(err))
+				continue
+			}
+			p.ll.WithError(err).Error("cannot reweight osd")
+			continue
+		}
+		r.cachedTree = nil
+
+		if w, ok := observed[p.osd]; observed != nil && (!ok || !r.weightsEqual(w, p.weight)) {
+			r.lastErr = fmt.Errorf("reweight of osd %d to %v did not take effect", p.osd, p.weight)
+			if !ok {
+				p.ll.Error("reweight did not take effect, osd not found when reading it back")
+				continue
+			}
+			// The weight found is what later iterations carry on from,
+			// rather than mistaking it for an external change.
+			p.ll.WithField("observed.weight", w).Error("reweight did not take effect")
+			r.crushWeightMap[p.osd] = w
+			r.trackReweight(p.osd, w)
+			continue
+		}
+
+		// Only remember weights that were applied, a failed reweight would
+		// otherwise be mistaken for the optimal weight on the next run.
+		r.crushWeightMap[p.osd] = p.weight
+
+		applied++
+		r.lastReweightedOSD = p.osd
+		r.recordReweight(p.osd, p.weight)
+		r.trackReweight(p.osd, p.weight)
+		p.ll.Info("reweight applied!")
+	}
+
+	return applied
+}
+
+// crushReweightAll reweights the given OSDs one by one, with up to
+// `reweightWorkers` commands in flight at once, and returns the error
+// of each command in the same order.
+func (r *Rebalancer) crushReweightAll(ctx context.Context, planned []plannedReweight) []error {
+	errs := make([]error, len(planned))
+
+	workers := r.reweightWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(planned) {
+		workers = len(planned)
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = r.ceph.CrushReweight(ctx, planned[i].osd, planned[i].weight)
+			}
+		}()
+	}
+
+	for i := range planned {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return errs
+}
+
+// bucketWeight returns the weight shared by all the OSDs of the given
+// bucket, and whether the bucket holds two or more OSDs and nothing
+// else, all of them planned to that weight.
+func bucketWeight(node cephclient.OSDTreeNode, planned map[int]plannedReweight) (float64, bool) {
+	if node.Type == "osd" || len(node.Children) < 2 {
+		return 0, false
+	}
+
+	var weight float64
+	for i, child := range node.Children {
+		p, ok := planned[child]
+		if !ok || (i > 0 && p.weight != weight) {
+			return 0, false
+		}
+		weight = p.weight
+	}
+	return weight, true
+}
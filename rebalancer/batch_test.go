@@ -0,0 +1,166 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyReweights(t *testing.T) {
+	newTree := func() *cephclient.OSDTreeOut {
+		return &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: -1, Name: "default", Type: "root", Children: []int{-2, -3, -4}},
+				{ID: -2, Name: "host-a", Type: "host", Children: []int{1, 2}},
+				{ID: -3, Name: "host-b", Type: "host", Children: []int{3, 4}},
+				{ID: -4, Name: "host-c", Type: "host", Children: []int{5, 6}},
+				{ID: 1, Name: "osd.1", Type: "osd", CrushWeight: 1},
+				{ID: 2, Name: "osd.2", Type: "osd", CrushWeight: 1},
+				{ID: 3, Name: "osd.3", Type: "osd", CrushWeight: 1},
+				{ID: 4, Name: "osd.4", Type: "osd", CrushWeight: 0.5},
+				{ID: 5, Name: "osd.5", Type: "osd", CrushWeight: 1},
+				{ID: 6, Name: "osd.6", Type: "osd", CrushWeight: 1},
+			},
+		}
+	}
+	plan := func(weights ...float64) []plannedReweight {
+		var planned []plannedReweight
+		for i := 0; i < len(weights); i += 2 {
+			osd := int(weights[i])
+			planned = append(planned, plannedReweight{osd: osd, weight: weights[i+1], ll: log.WithField("osd", osd)})
+		}
+		return planned
+	}
+
+	tests := []struct {
+		name         string
+		planned      []plannedReweight
+		subtreeErr   error
+		wantSubtrees []string
+		wantWeights  map[int]float64
+	}{
+		{
+			name:         "whole hosts at the same weight",
+			planned:      plan(1, 1.5, 2, 1.5, 3, 1.5, 4, 1, 5, 1.5),
+			wantSubtrees: []string{"host-a"},
+			wantWeights:  map[int]float64{1: 1.5, 2: 1.5, 3: 1.5, 4: 1, 5: 1.5},
+		},
+		{
+			name:         "several hosts",
+			planned:      plan(1, 1.5, 2, 1.5, 5, 2, 6, 2),
+			wantSubtrees: []string{"host-a", "host-c"},
+			wantWeights:  map[int]float64{1: 1.5, 2: 1.5, 5: 2, 6: 2},
+		},
+		{
+			name:         "bucket reweight failing",
+			planned:      plan(1, 1.5, 2, 1.5),
+			subtreeErr:   errors.New("EINVAL"),
+			wantSubtrees: []string{"host-a"},
+			wantWeights:  map[int]float64{1: 1.5, 2: 1.5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cephtest.New(cephtest.State{OSDTree: newTree()})
+			if tt.subtreeErr != nil {
+				c.InjectFault("CrushReweightSubtree", cephtest.Fault{Err: tt.subtreeErr})
+			}
+			r := &Rebalancer{ceph: c, crushWeightMap: make(map[int]float64)}
+
+			applied := r.applyReweights(context.Background(), newTree(), tt.planned)
+			assert.Equal(t, len(tt.planned), applied)
+
+			var subtrees []string
+			for _, call := range c.CallsTo("CrushReweightSubtree") {
+				subtrees = append(subtrees, call.Args[0].(string))
+			}
+			assert.Equal(t, tt.wantSubtrees, subtrees)
+			weights := c.CrushWeights()
+			for osd, w := range tt.wantWeights {
+				assert.Equal(t, w, weights[osd], "osd.%d", osd)
+			}
+			assert.Equal(t, tt.wantWeights, r.crushWeightMap)
+			assert.Equal(t, tt.planned[len(tt.planned)-1].osd, r.lastReweightedOSD)
+		})
+	}
+}
+
+// slowReweightClient records how many reweights are in flight at once.
+type slowReweightClient struct {
+	*cephtest.Client
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	failOSD     int
+}
+
+func (c *slowReweightClient) CrushReweight(ctx context.Context, osdID int, crushWeight float64) error {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+
+	if osdID == c.failOSD {
+		return errors.New("EAGAIN")
+	}
+	return c.Client.CrushReweight(ctx, osdID, crushWeight)
+}
+
+func TestReweightWorkers(t *testing.T) {
+	for _, workers := range []int{0, 1, 3, 10} {
+		tree := &cephclient.OSDTreeOut{}
+		var planned []plannedReweight
+		for osd := 0; osd < 6; osd++ {
+			tree.Nodes = append(tree.Nodes, cephclient.OSDTreeNode{ID: osd, Name: fmt.Sprintf("osd.%d", osd), Type: "osd"})
+			planned = append(planned, plannedReweight{osd: osd, weight: 1, ll: log.WithField("osd", osd)})
+		}
+
+		c := &slowReweightClient{Client: cephtest.New(cephtest.State{OSDTree: tree}), failOSD: 2}
+		r := &Rebalancer{ceph: c, crushWeightMap: make(map[int]float64), reweightWorkers: workers}
+
+		applied := r.applyReweights(context.Background(), tree, planned)
+		assert.Equal(t, 5, applied, "only the failed reweight should be left out")
+		assert.Equal(t, map[int]float64{0: 1, 1: 1, 3: 1, 4: 1, 5: 1}, r.crushWeightMap)
+
+		want := workers
+		if want < 1 {
+			want = 1
+		}
+		if want > len(planned) {
+			want = len(planned)
+		}
+		assert.Equal(t, want, c.maxInFlight, "workers: %d", workers)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/digitalocean/archimedes/gates"
+)
+
+// inCanaryPhase reports whether the canary OSD is still being ramped
+// or soaked, i.e. the remaining target OSDs have to wait.
+func (r *Rebalancer) inCanaryPhase() bool {
+	return r.canaryOSD >= 0 && !r.canaryPassed
+}
+
+// checkCanary reports whether the iteration may go on reweighting
+// with regards to the canary OSD. While the canary is ramping and
+// soaking, any client impact beyond the slow ops, latency and
+// degraded objects thresholds aborts the run, and the remaining
+// OSDs only start once the soak window has passed. A canary skipped
+// rather than ramped aborts the run as well.
+func (r *Rebalancer) checkCanary(ctx context.Context) bool {
+	if !r.inCanaryPhase() {
+		return true
+	}
+
+	ll := r.log().WithField("canary.osd", r.canaryOSD)
+	for _, g := range []gates.GateFunc{r.slowOpsGate, r.osdLatencyGate, r.heartbeatLatencyGate, r.degradedGate} {
+		ok, reason, err := g(ctx)
+		if err != nil {
+			ll.WithError(err).Error("failed checking for canary impact")
+			r.skipIteration(fmt.Sprintf("failed checking for canary impact: %s", err))
+			return false
+		}
+		if !ok {
+			r.abortErr = classify(ErrGateBlocked, fmt.Errorf("canary osd %d breached impact thresholds: %s", r.canaryOSD, reason))
+			r.skipIteration(r.abortErr.Error())
+			return false
+		}
+	}
+
+	if _, ramping := r.targetCrushWeightMap[r.canaryOSD]; ramping {
+		return true
+	}
+
+	// A canary skipped, e.g. because it went missing from the osd
+	// tree, never showed the impact of being fully ramped.
+	if reason, skipped := r.skippedOSDs[r.canaryOSD]; skipped {
+		r.abortErr = classify(ErrGateBlocked, fmt.Errorf("canary osd %d was skipped: %s", r.canaryOSD, reason))
+		r.skipIteration(r.abortErr.Error())
+		return false
+	}
+
+	now := r.now()
+	if r.canaryDoneAt.IsZero() {
+		ll.WithField("soak", r.canarySoak).Info("canary osd reached its target, soaking")
+		r.canaryDoneAt = now
+	}
+	if now.Sub(r.canaryDoneAt) < r.canarySoak {
+		ll.WithField("since", r.canaryDoneAt).Info("skipping reweighting, soaking canary osd")
+		r.skipIteration("soaking canary osd")
+		return false
+	}
+
+	ll.Info("canary osd soaked without impact, proceeding with remaining osds")
+	r.canaryPassed = true
+	return true
+}
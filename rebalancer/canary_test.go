@@ -0,0 +1,134 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanary(t *testing.T) {
+	newRebalancer := func(tc *cephtest.Client) *Rebalancer {
+		r, err := New(
+			WithCephClient(tc),
+			WithTargetCrushWeightMap(map[int]float64{1: 1.0, 2: 1.0}),
+			WithWeightIncrement(0.5),
+			WithCanaryOSD(1),
+			WithCanarySoak(time.Hour),
+			WithMaxSlowOps(0),
+			WithDryRun(false),
+		)
+		if err != nil {
+			t.Fatalf("failed initializing rebalancer: %s", err)
+		}
+		return r
+	}
+	newTree := func() *cephclient.OSDTreeOut {
+		return &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd"},
+				{ID: 2, Type: "osd"},
+			},
+		}
+	}
+	newClient := func() *cephtest.Client {
+		return cephtest.New(cephtest.State{OSDTree: newTree()})
+	}
+
+	t.Run("Passed", func(t *testing.T) {
+		tc := newClient()
+		defer tc.Close()
+
+		r := newRebalancer(tc)
+		clock := newFakeClock(time.Unix(0, 0))
+		r.clock = clock
+
+		// Ramp the canary up to its target, then let it be removed.
+		for i := 0; i < 3; i++ {
+			r.DoReweight()
+		}
+		assert.Equal(t, 1.0, tc.CrushWeights()[1], "canary should be fully ramped")
+		assert.Zero(t, tc.CrushWeights()[2], "other osds should wait for the canary")
+
+		r.DoReweight()
+		clock.Advance(30 * time.Minute)
+		r.DoReweight()
+		assert.Zero(t, tc.CrushWeights()[2], "other osds should wait for the soak")
+
+		clock.Advance(30 * time.Minute)
+		r.DoReweight()
+		assert.Equal(t, 0.5, tc.CrushWeights()[2], "other osds should start after the soak")
+		assert.NoError(t, r.abortErr)
+	})
+
+	t.Run("Impact", func(t *testing.T) {
+		tc := newClient()
+		defer tc.Close()
+
+		r := newRebalancer(tc)
+		r.DoReweight()
+		assert.NoError(t, r.abortErr)
+
+		tc.Update(func(s *cephtest.State) { s.SlowOps = 5 })
+		r.DoReweight()
+		assert.Error(t, r.abortErr, "impact during the canary should abort")
+		assert.Len(t, tc.CallsTo("CrushReweight"), 1, "no reweights should happen after the impact")
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		// The canary never shows up in the osd tree.
+		tree := newTree()
+		tree.Nodes = tree.Nodes[1:]
+		tc := cephtest.New(cephtest.State{OSDTree: tree})
+		defer tc.Close()
+
+		r := newRebalancer(tc)
+		for i := 0; i <= r.maxMissingIterations; i++ {
+			r.DoReweight()
+		}
+		if assert.Error(t, r.abortErr, "a skipped canary should abort") {
+			assert.Contains(t, r.abortErr.Error(), "canary osd 1 was skipped")
+		}
+		assert.Empty(t, tc.CallsTo("CrushReweight"), "other osds should not start")
+	})
+
+	t.Run("No Thresholds", func(t *testing.T) {
+		tc := newClient()
+		defer tc.Close()
+
+		_, err := New(
+			WithCephClient(tc),
+			WithTargetCrushWeightMap(map[int]float64{1: 1.0, 2: 1.0}),
+			WithCanaryOSD(1),
+		)
+		assert.Error(t, err, "a canary which cannot fail should be refused")
+	})
+
+	t.Run("Not A Target", func(t *testing.T) {
+		tc := newClient()
+		defer tc.Close()
+
+		_, err := New(
+			WithCephClient(tc),
+			WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+			WithCanaryOSD(3),
+		)
+		assert.Error(t, err)
+	})
+}
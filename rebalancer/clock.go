@@ -0,0 +1,74 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import "time"
+
+// Clock tells the time and creates the timers the Rebalancer waits
+// on between iterations, so that runs can be driven by a fake clock
+// in tests rather than by real sleeps.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the part of time.Timer used by the Rebalancer.
+type Timer interface {
+	C() <-chan time.Time
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// realClock is the Clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// limiterClock is the Clock of the reweight limiter, so that it
+// refills by the same clock as the Rebalancer.
+type limiterClock struct {
+	Clock
+}
+
+func (c limiterClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// now returns the current time according to the clock given through
+// WithClock, or the system clock when there is none.
+func (r *Rebalancer) now() time.Time {
+	return r.getClock().Now()
+}
+
+func (r *Rebalancer) getClock() Clock {
+	if r.clock == nil {
+		return realClock{}
+	}
+	return r.clock
+}
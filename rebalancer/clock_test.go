@@ -0,0 +1,209 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a Clock whose time only moves when advanced, firing
+// the timers that are due.
+type fakeClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	c      *fakeClock
+	ch     chan time.Time
+	at     time.Time
+	active bool
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	c := &fakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{c: c, ch: make(chan time.Time, 1)}
+	c.mu.Lock()
+	c.timers = append(c.timers, t)
+	c.mu.Unlock()
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward, firing the timers due by then.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.fire()
+}
+
+// Set moves the clock to the given time, firing the timers due by then.
+func (c *fakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+	c.fire()
+}
+
+// BlockUntil waits until at least n timers are waiting to fire.
+func (c *fakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.pending() < n {
+		c.cond.Wait()
+	}
+}
+
+func (c *fakeClock) pending() int {
+	n := 0
+	for _, t := range c.timers {
+		if t.active {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *fakeClock) fire() {
+	for _, t := range c.timers {
+		if t.active && !t.at.After(c.now) {
+			t.active = false
+			select {
+			case t.ch <- c.now:
+			default:
+			}
+		}
+	}
+	c.cond.Broadcast()
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	active := t.active
+	t.at, t.active = t.c.now.Add(d), true
+	t.c.fire()
+	return active
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	active := t.active
+	t.active = false
+	t.c.cond.Broadcast()
+	return active
+}
+
+func TestRunWithClock(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd"},
+			},
+		},
+	})
+	defer tc.Close()
+
+	clock := newFakeClock(time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC))
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 10}),
+		WithWeightIncrement(1),
+		WithSleepInterval(10*time.Minute),
+		WithMaxDuration(25*time.Minute),
+		WithDryRun(false),
+		WithClock(clock),
+	)
+	assert.NoError(t, err)
+
+	type result struct {
+		summary Summary
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		summary, err := r.Run(context.Background())
+		done <- result{summary, err}
+	}()
+
+	// Iterations run right away and then every 10 minutes, waiting
+	// on the next iteration and the maximum duration in between.
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(2)
+		clock.Advance(10 * time.Minute)
+	}
+	clock.BlockUntil(2)
+	clock.Advance(5 * time.Minute)
+
+	res := <-done
+	assert.Equal(t, ErrMaxDuration, res.err)
+	assert.Equal(t, 3, res.summary.Iterations)
+	assert.Len(t, tc.CallsTo("CrushReweight"), 3)
+	assert.Equal(t, map[int]float64{1: 10}, res.summary.Remaining)
+}
+
+func TestReweightLimitWithClock(t *testing.T) {
+	cc := cephtest.New(cephtest.State{
+		OSDTree: cephtest.NewOSDTree(cephtest.OSD{ID: 1, Host: "a"}),
+	})
+
+	clock := newFakeClock(time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC))
+	r, err := New(
+		WithCephClient(cc),
+		WithTargetCrushWeightMap(map[int]float64{1: 10}),
+		WithWeightIncrement(1),
+		WithMaxReweightsPerHour(2),
+		WithDryRun(false),
+		WithClock(clock),
+	)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		r.DoReweight()
+	}
+	assert.Equal(t, 2.0, cc.CrushWeights()[1], "reweights past the hourly limit should be skipped")
+
+	// The limit refills by the Rebalancer's clock.
+	clock.Advance(30 * time.Minute)
+	r.DoReweight()
+	r.DoReweight()
+	assert.Equal(t, 3.0, cc.CrushWeights()[1])
+}
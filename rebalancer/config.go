@@ -0,0 +1,725 @@
+//   Copyright 2020 DigitalOcean
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package rebalancer
+
+import (
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/gates"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Option provides a safe way to update private
+// variables of rebalancer before creating an
+// instance of it.
+type Option func(*Rebalancer)
+
+// WithCephClient holds the ceph client connected
+// to the ceph cluster we want to perform reweighting
+// on.
+func WithCephClient(val cephclient.Client) Option {
+	return func(r *Rebalancer) {
+		r.ceph = val
+	}
+}
+
+// WithMaxBackfillPGsAllowed allows changing the
+// number of backfilling PGs that are acceptable
+// to be ongoing while we issue another reweight
+// operation.
+func WithMaxBackfillPGsAllowed(val int) Option {
+	return func(r *Rebalancer) {
+		r.maxBackfillPGsAllowed = val
+	}
+}
+
+// WithMaxRecoveryPGsAllowed allows changing the
+// number of recovering PGs that are acceptable
+// to be ongoing while we issue another reweight
+// operation.
+func WithMaxRecoveryPGsAllowed(val int) Option {
+	return func(r *Rebalancer) {
+		r.maxRecoveryPGsAllowed = val
+	}
+}
+
+// WithPoolAwarePGCounts restricts the count of backfilling
+// and recovering PGs to the pools whose CRUSH rules place
+// data on the target OSDs, so recovery elsewhere in a large
+// multi-pool cluster does not stall the rebalance.
+func WithPoolAwarePGCounts(val bool) Option {
+	return func(r *Rebalancer) {
+		r.poolAwarePGCounts = val
+	}
+}
+
+// WithPauseOnPGAutoscaling indicates whether reweighting
+// should pause while any pool is changing its pg_num or
+// pgp_num, since concurrent splits/merges and reweights
+// cause large swings in misplaced data.
+//
+// By default, reweighting pauses during PG autoscaling.
+func WithPauseOnPGAutoscaling(val bool) Option {
+	return func(r *Rebalancer) {
+		r.pauseOnPGAutoscaling = val
+	}
+}
+
+// WithMaxInactivePGsAllowed allows changing the
+// number of inactive, peering or incomplete PGs that
+// are acceptable while we issue another reweight
+// operation. Defaults to 0.
+func WithMaxInactivePGsAllowed(val int) Option {
+	return func(r *Rebalancer) {
+		r.maxInactivePGsAllowed = val
+	}
+}
+
+// WithMaxScrubbingPGs allows changing the number of
+// scrubbing or deep-scrubbing PGs that are acceptable
+// while we issue another reweight operation. A negative
+// value disables the check, which is the default.
+func WithMaxScrubbingPGs(val int) Option {
+	return func(r *Rebalancer) {
+		r.maxScrubbingPGs = val
+	}
+}
+
+// WithMaxSnapTrimPGs allows changing the number of PGs
+// in snaptrim or snaptrim_wait state that are acceptable
+// while we issue another reweight operation. A negative
+// value disables the check, which is the default.
+func WithMaxSnapTrimPGs(val int) Option {
+	return func(r *Rebalancer) {
+		r.maxSnapTrimPGs = val
+	}
+}
+
+// WithMaxMisplacedRatio allows changing the ratio of
+// misplaced objects, between 0 and 1, that is acceptable
+// while we issue another reweight operation. A value of
+// 0 disables the check.
+func WithMaxMisplacedRatio(val float64) Option {
+	return func(r *Rebalancer) {
+		r.maxMisplacedRatio = val
+	}
+}
+
+// WithMaxDegradedObjects allows changing the number of
+// degraded objects that are acceptable while we issue
+// another reweight operation, so that upweights do not
+// compete with recovery of redundancy. A negative value
+// disables the check.
+func WithMaxDegradedObjects(val int) Option {
+	return func(r *Rebalancer) {
+		r.maxDegradedObjects = val
+	}
+}
+
+// WithMaxDegradedPGs allows changing the number of PGs in a
+// degraded state that are acceptable while we issue another
+// reweight operation. Unlike the number of degraded objects, it
+// tells how much of the data is at risk regardless of object sizes.
+// A negative value disables the check.
+func WithMaxDegradedPGs(val int) Option {
+	return func(r *Rebalancer) {
+		r.maxDegradedPGs = val
+	}
+}
+
+// WithMaxSlowOps allows changing the number of slow or
+// blocked requests that are acceptable while we issue
+// another reweight operation, protecting client I/O
+// latency. A negative value disables the check.
+func WithMaxSlowOps(val int) Option {
+	return func(r *Rebalancer) {
+		r.maxSlowOps = val
+	}
+}
+
+// WithMaxDownOSDs allows changing the number of OSDs that
+// may be down, while still in, as we issue another reweight
+// operation. Reweighting on top of degraded recovery should
+// be an explicit choice, so this defaults to 0. A negative
+// value disables the check.
+func WithMaxDownOSDs(val int) Option {
+	return func(r *Rebalancer) {
+		r.maxDownOSDs = val
+	}
+}
+
+// WithMaxHeartbeatLatency allows changing the highest
+// average heartbeat ping time between any two OSDs that
+// is acceptable while we issue another reweight operation.
+// Rising heartbeat latency is an early sign of a network
+// saturated by backfill. A value of 0 disables the check.
+func WithMaxHeartbeatLatency(val time.Duration) Option {
+	return func(r *Rebalancer) {
+		r.maxHeartbeatLatency = val
+	}
+}
+
+// WithMaxOSDLatency allows changing the client-visible
+// OSD commit/apply latency that is acceptable while we
+// issue another reweight operation, so that rebalancing
+// yields to production traffic. A value of 0 disables the
+// check.
+func WithMaxOSDLatency(val time.Duration) Option {
+	return func(r *Rebalancer) {
+		r.maxOSDLatency = val
+	}
+}
+
+// WithOSDLatencyPercentile sets the percentile, between 0
+// and 100, of OSD latencies compared against the value of
+// WithMaxOSDLatency. Defaults to 99.
+func WithOSDLatencyPercentile(val float64) Option {
+	return func(r *Rebalancer) {
+		r.osdLatencyPercentile = val
+	}
+}
+
+// WithRequireMonQuorum indicates whether every monitor in the
+// monmap has to be in quorum before we issue another reweight
+// operation.
+//
+// By default, a full mon quorum is required.
+func WithRequireMonQuorum(val bool) Option {
+	return func(r *Rebalancer) {
+		r.requireMonQuorum = val
+	}
+}
+
+// WithPauseOnHealthWarnChecks lists the health check codes,
+// e.g. `OSD_NEARFULL`, which pause reweighting while they are
+// raised with a HEALTH_WARN severity. The special code `*`
+// pauses on any health warning.
+func WithPauseOnHealthWarnChecks(val []string) Option {
+	return func(r *Rebalancer) {
+		r.pauseOnHealthWarnChecks = val
+	}
+}
+
+// WithAbortOnHealthErr indicates whether reweighting should be
+// aborted altogether once the cluster reports HEALTH_ERR.
+//
+// By default, the rebalancer aborts on HEALTH_ERR.
+func WithAbortOnHealthErr(val bool) Option {
+	return func(r *Rebalancer) {
+		r.abortOnHealthErr = val
+	}
+}
+
+// WithFullOSDScope sets which OSDs are checked for nearfull,
+// backfillfull or full state before every iteration: `any`
+// OSD in the cluster, only the `targets`, or every OSD in the
+// `subtree` of failure domains holding targets. `none` disables
+// the check. Defaults to `any`.
+func WithFullOSDScope(val string) Option {
+	return func(r *Rebalancer) {
+		r.fullScope = val
+	}
+}
+
+// WithFlagPolicy sets how the rebalancer reacts to OSD map
+// flags that conflict with reweighting, i.e. norebalance,
+// norecover and nobackfill: `pause` reweighting until
+// they are cleared, `abort` the run, or `ignore` them.
+// Defaults to `pause`.
+func WithFlagPolicy(val string) Option {
+	return func(r *Rebalancer) {
+		r.flagPolicy = val
+	}
+}
+
+// WithAllowedFlags lists conflicting OSD map flags that are
+// expected to be set and should not trigger the flag policy.
+func WithAllowedFlags(val []string) Option {
+	return func(r *Rebalancer) {
+		r.allowedFlags = val
+	}
+}
+
+// WithPromQLQueries adds Prometheus queries that are evaluated
+// before every iteration, letting reweights be gated on signals
+// from outside the Ceph cluster. An iteration is skipped as soon
+// as any query returns a sample above its threshold.
+func WithPromQLQueries(val ...gates.PromQLQuery) Option {
+	return func(r *Rebalancer) {
+		r.promQLQueries = append(r.promQLQueries, val...)
+	}
+}
+
+// WithAlertmanagerQueries adds Alertmanager alert selectors
+// that pause reweighting while any matching alert is firing,
+// unless it has been silenced or inhibited.
+func WithAlertmanagerQueries(val ...gates.AlertmanagerQuery) Option {
+	return func(r *Rebalancer) {
+		r.alertmanagerQueries = append(r.alertmanagerQueries, val...)
+	}
+}
+
+// WithGateHooks adds executables that are run before every
+// iteration. A non-zero exit status from any of them skips the
+// iteration, which allows encoding site-specific checks without
+// modifying the rebalancer.
+func WithGateHooks(val ...string) Option {
+	return func(r *Rebalancer) {
+		r.gateHooks = append(r.gateHooks, val...)
+	}
+}
+
+// WithGateHookTimeout updates the duration a gate hook may run
+// for before it is killed and the iteration is skipped.
+func WithGateHookTimeout(val time.Duration) Option {
+	return func(r *Rebalancer) {
+		r.gateHookTimeout = val
+	}
+}
+
+// WithActiveHours limits reweighting to the given time windows,
+// e.g. off-peak hours or weekends. Outside of them iterations are
+// skipped while metrics are still exported, and Run suspends until
+// the next window opens. Without any windows, reweighting may
+// happen at any time.
+func WithActiveHours(windows ...gates.TimeWindow) Option {
+	return func(r *Rebalancer) {
+		r.activeHours = append(r.activeHours, windows...)
+	}
+}
+
+// WithGateExpressions adds gates written as expressions over a
+// snapshot of cluster stats, e.g. `hour < 6 || backfill_pgs <= 10`.
+// They only add restrictions to the built-in gates. See expr.go for
+// the supported syntax and variables.
+func WithGateExpressions(exprs ...string) Option {
+	return func(r *Rebalancer) {
+		r.gateExpressions = append(r.gateExpressions, exprs...)
+	}
+}
+
+// WithGates adds custom gates that all have to be open before
+// an iteration reweights any OSDs. They are evaluated in order
+// after the built-in checks.
+func WithGates(val ...gates.Gate) Option {
+	return func(r *Rebalancer) {
+		r.gates = append(r.gates, val...)
+	}
+}
+
+// WithMaxBackfillBytes allows changing the amount of
+// data, in bytes, held by backfilling PGs that is
+// acceptable while we issue another reweight operation.
+// A value of 0 disables the check.
+func WithMaxBackfillBytes(val int64) Option {
+	return func(r *Rebalancer) {
+		r.maxBackfillBytes = val
+	}
+}
+
+// WithTargetCrushWeightMap passes the mapping of each
+// candidate OSD to its target CRUSH weight that it
+// hopes to reach.
+//
+// This is a required option since we cannot run the
+// reebalancer without any OSDs to reweight.
+func WithTargetCrushWeightMap(val map[int]float64) Option {
+	return func(r *Rebalancer) {
+		r.targetCrushWeightMap = val
+	}
+}
+
+// WithWeightIncrement updates the increment value by
+// which each OSD will be upweighted.
+func WithWeightIncrement(val float64) Option {
+	return func(r *Rebalancer) {
+		r.weightIncrement = val
+	}
+}
+
+// WithMaxMissingIterations updates the number of iterations in a
+// row a target OSD may be missing from the osd tree, or be rejected
+// as unknown by the mons, before it is given up on and skipped.
+// Newly deployed OSDs often flap during their first boot.
+func WithMaxMissingIterations(val int) Option {
+	return func(r *Rebalancer) {
+		r.maxMissingIterations = val
+	}
+}
+
+// WithVerifyReweights sets whether the weights of reweighted OSDs are
+// read back to confirm the reweights took effect, which is the
+// default. Reweights found not to have taken effect are not counted
+// as applied.
+func WithVerifyReweights(val bool) Option {
+	return func(r *Rebalancer) {
+		r.verifyReweights = val
+	}
+}
+
+// WithOSDMapWait updates how long to wait for the osdmap epoch to
+// change after reweighting before reading the weights back. No
+// waiting is done by default.
+func WithOSDMapWait(val time.Duration) Option {
+	return func(r *Rebalancer) {
+		r.osdmapWait = val
+	}
+}
+
+// WithWeightPrecision updates the number of decimal places crush
+// weights are rounded to, 4 by default and at most. Weights within
+// half a step of their target are considered to have reached it.
+func WithWeightPrecision(val int) Option {
+	return func(r *Rebalancer) {
+		r.weightPrecision = val
+	}
+}
+
+// WithSleepInterval updates the duration for which the
+// rebalancer will sleep for between each of its reweight
+// runs.
+func WithSleepInterval(val time.Duration) Option {
+	return func(r *Rebalancer) {
+		r.sleepInterval = val
+	}
+}
+
+// WithCanaryOSD designates one of the target OSDs as a canary,
+// which is fully ramped on its own before any other OSD starts.
+// Should slow ops, OSD latency, heartbeat latency or degraded
+// objects go beyond their thresholds meanwhile, or during the
+// soak window that follows, the run is aborted. A negative value,
+// the default, disables the canary.
+func WithCanaryOSD(val int) Option {
+	return func(r *Rebalancer) {
+		r.canaryOSD = val
+	}
+}
+
+// WithCanarySoak updates the duration for which the cluster is
+// observed once the canary OSD reached its target weight, before
+// the remaining OSDs start.
+func WithCanarySoak(val time.Duration) Option {
+	return func(r *Rebalancer) {
+		r.canarySoak = val
+	}
+}
+
+// WithMaxImpactScore slows reweighting down by halving the weight
+// increment whenever the impact score, the highest ratio of
+// backfilling PGs, misplaced objects or OSD latency to its threshold,
+// stays above the given value for WithImpactScoreSamples iterations
+// in a row. A value of 0 only reports the score.
+func WithMaxImpactScore(val float64) Option {
+	return func(r *Rebalancer) {
+		r.maxImpactScore = val
+	}
+}
+
+// WithImpactScoreSamples updates the number of consecutive samples
+// above WithMaxImpactScore that trigger a slow-down. Defaults to 3.
+func WithImpactScoreSamples(val int) Option {
+	return func(r *Rebalancer) {
+		r.impactScoreSamples = val
+	}
+}
+
+// WithMaxDuration bounds how long Run may go on for. Once it
+// passes, Run returns cleanly and leaves the remaining OSDs, see
+// RemainingTargets, untouched. A value of 0 disables the limit.
+func WithMaxDuration(val time.Duration) Option {
+	return func(r *Rebalancer) {
+		r.maxDuration = val
+	}
+}
+
+// WithMaxIterations bounds the number of iterations Run performs,
+// counting only those that reweighted at least one OSD, so that a
+// ramp-up can be done in exactly that many increments. A value of
+// 0 disables the limit.
+func WithMaxIterations(val int) Option {
+	return func(r *Rebalancer) {
+		r.maxIterations = val
+	}
+}
+
+// WithRunImmediately indicates whether Run performs its first
+// iteration right away instead of sleeping for a full interval
+// first.
+//
+// By default, the first iteration runs immediately.
+func WithRunImmediately(val bool) Option {
+	return func(r *Rebalancer) {
+		r.runImmediately = val
+	}
+}
+
+// WithMinSleepInterval sets the lower bound of an adaptive sleep
+// interval. Together with WithMaxSleepInterval, the interval starts
+// at the configured sleep interval, shortens while the cluster
+// absorbs backfill quickly and lengthens while PGs linger in
+// backfill_wait. A value of 0 keeps the interval fixed.
+func WithMinSleepInterval(val time.Duration) Option {
+	return func(r *Rebalancer) {
+		r.minSleepInterval = val
+	}
+}
+
+// WithMaxSleepInterval sets the upper bound of an adaptive sleep
+// interval, see WithMinSleepInterval. A value of 0 keeps the
+// interval fixed.
+func WithMaxSleepInterval(val time.Duration) Option {
+	return func(r *Rebalancer) {
+		r.maxSleepInterval = val
+	}
+}
+
+// WithEnableCephBalancer indicates whether Ceph's balancer should be enabled
+// after reweights successful complete.
+func WithEnableCephBalancer(val bool) Option {
+	return func(r *Rebalancer) {
+		r.enableCephBalancer = val
+	}
+}
+
+// WithBalancerPolicy sets how the rebalancer reacts to an
+// active Ceph balancer when it starts: `refuse` to run,
+// `disable` the balancer for the duration of the run and turn
+// it back on afterwards, or `ignore` it. Defaults to `refuse`.
+func WithBalancerPolicy(val string) Option {
+	return func(r *Rebalancer) {
+		r.balancerPolicy = val
+	}
+}
+
+// WithDryRun will change the mode of rebalancer. When
+// dry-run is disabled, the reweights will be actually
+// performed on the cluster.
+//
+// By default, dry-run is enabled to make sure no adverse
+// impact occurs on the cluster until explicitly requested
+// to.
+func WithDryRun(val bool) Option {
+	return func(r *Rebalancer) {
+		r.dryRun = val
+	}
+}
+
+// WithMaxReweightsPerHour caps the number of CRUSH reweight
+// commands issued to the cluster within any one hour window,
+// independent of the sleep interval. A value of 0 disables
+// the limit.
+func WithMaxReweightsPerHour(val int) Option {
+	return func(r *Rebalancer) {
+		r.maxReweightsPerHour = val
+	}
+}
+
+// WithMaxOSDsPerIteration limits the number of OSDs that are
+// reweighted in a single iteration. The OSDs are picked in a
+// round-robin fashion across iterations so that each of them
+// eventually reaches its target. A value of 0 disables the cap.
+func WithMaxOSDsPerIteration(val int) Option {
+	return func(r *Rebalancer) {
+		r.maxOSDsPerIteration = val
+	}
+}
+
+// WithFailureDomain sets the CRUSH bucket type, e.g. `host` or
+// `rack`, that is considered a failure domain when limiting the
+// number of OSDs ramped concurrently. Defaults to `host`.
+func WithFailureDomain(val string) Option {
+	return func(r *Rebalancer) {
+		r.failureDomain = val
+	}
+}
+
+// WithMaxOSDsPerFailureDomain limits how many OSDs within a
+// single failure domain are ramped up at the same time. New
+// OSDs in a domain only start once an in-progress one reaches
+// its target. A value of 0 disables the limit.
+func WithMaxOSDsPerFailureDomain(val int) Option {
+	return func(r *Rebalancer) {
+		r.maxOSDsPerFailureDomain = val
+	}
+}
+
+// WithMaxWeightDeltaPerHost caps the sum of weight changes applied
+// to OSDs under any single host bucket in one iteration. Increments
+// are trimmed to fit the remaining budget of the host. A value of 0
+// disables the cap.
+func WithMaxWeightDeltaPerHost(val float64) Option {
+	return func(r *Rebalancer) {
+		r.maxWeightDeltaPerHost = val
+	}
+}
+
+// WithAllowDownweight indicates whether target weights below the
+// current weight of an OSD are allowed. They are refused by default,
+// so that a mistyped target cannot silently evacuate data off an OSD.
+func WithAllowDownweight(val bool) Option {
+	return func(r *Rebalancer) {
+		r.allowDownweight = val
+	}
+}
+
+// WithOKToStopPolicy sets how the rebalancer reacts when `ceph osd
+// ok-to-stop` fails for the OSDs about to be downweighted: either
+// `refuse` to run, which is the default, only `warn`, or `ignore`
+// the check altogether.
+func WithOKToStopPolicy(val string) Option {
+	return func(r *Rebalancer) {
+		r.okToStopPolicy = val
+	}
+}
+
+// WithDownOSDPolicy sets how the rebalancer treats target OSDs which
+// are down or out while they are yet to be weighted up: `skip` them
+// until they are back, which is the default, `wait` for them before
+// reweighting anything, or `fail` the run.
+func WithDownOSDPolicy(val string) Option {
+	return func(r *Rebalancer) {
+		r.downOSDPolicy = val
+	}
+}
+
+// WithDrainCompletion sets the step taken once an OSD drained to
+// zero weight is safe to destroy: `none`, the default, leaves it be,
+// `out` marks it out, `remove` also removes it from the CRUSH map and
+// `purge` removes it from the cluster altogether, which requires the
+// OSD to be stopped.
+func WithDrainCompletion(val string) Option {
+	return func(r *Rebalancer) {
+		r.drainCompletion = val
+	}
+}
+
+// WithPrimaryAffinity indicates whether the primary affinity of
+// OSDs is adjusted along with their weight: OSDs to drain get it
+// lowered to 0 before they start draining, which spares clients from
+// reading off them, while OSDs finishing at a non-zero weight get it
+// raised back to 1.
+func WithPrimaryAffinity(val bool) Option {
+	return func(r *Rebalancer) {
+		r.primaryAffinity = val
+	}
+}
+
+// WithRollbackOnAbort indicates whether a Run aborted because the
+// cluster got into a state reweighting must not proceed in, e.g.
+// HEALTH_ERR, returns the OSDs it reweighted to the weights they had
+// before, gradually and regardless of the gates, instead of leaving
+// them halfway. The run still returns the error it was aborted with.
+func WithRollbackOnAbort(val bool) Option {
+	return func(r *Rebalancer) {
+		r.rollbackOnAbort = val
+	}
+}
+
+// WithState makes the Rebalancer carry on from a state taken from an
+// earlier one, e.g. by a process that crashed, replacing the target
+// weights given through WithTargetCrushWeightMap.
+func WithState(val State) Option {
+	return func(r *Rebalancer) {
+		r.resumed = &val
+	}
+}
+
+// WithStateHandler adds a handler called with the state of the run
+// after every iteration of Run, e.g. to persist it for WithState.
+// Like event handlers, it must not call back into the Rebalancer.
+func WithStateHandler(val func(State)) Option {
+	return func(r *Rebalancer) {
+		r.stateHandlers = append(r.stateHandlers, val)
+	}
+}
+
+// WithEventHandler adds a handler called with every event, e.g. to
+// drive notifications. Handlers are called in turn while reweighting
+// is in progress, so they should return quickly and must not call
+// back into the Rebalancer, with the exception of RunCompleted.
+func WithEventHandler(val func(Event)) Option {
+	return func(r *Rebalancer) {
+		r.eventHandlers = append(r.eventHandlers, val)
+	}
+}
+
+// WithLogger routes the logs of the Rebalancer to the given
+// logger instead of the standard logrus logger.
+func WithLogger(val log.FieldLogger) Option {
+	return func(r *Rebalancer) {
+		r.logger = val
+	}
+}
+
+// WithMetricsRegistry registers the metrics of the Rebalancer with
+// the given registry when it is created. Without one, the caller is
+// left to register the Rebalancer, which is a prometheus.Collector.
+func WithMetricsRegistry(val prometheus.Registerer) Option {
+	return func(r *Rebalancer) {
+		r.registry = val
+	}
+}
+
+// WithClock replaces the system clock used for sleeping between
+// iterations, the maximum run duration, active hours and the canary
+// soak, e.g. to drive a run from a fake clock in tests.
+func WithClock(val Clock) Option {
+	return func(r *Rebalancer) {
+		r.clock = val
+	}
+}
+
+// WithReweightWorkers updates the number of reweight commands that
+// may be in flight at once within an iteration, for the OSDs that
+// can't be reweighted along with their whole bucket. Values below 1
+// issue them one at a time.
+func WithReweightWorkers(val int) Option {
+	return func(r *Rebalancer) {
+		r.reweightWorkers = val
+	}
+}
+
+// WithOSDTreeCacheTTL updates the duration for which the OSD tree
+// is reused by the gates and reweighting before it is queried again.
+// The cache is dropped whenever an OSD gets reweighted or removed.
+// A value of 0 queries the OSD tree every time it is needed.
+func WithOSDTreeCacheTTL(val time.Duration) Option {
+	return func(r *Rebalancer) {
+		r.osdTreeCacheTTL = val
+	}
+}
+
+// WithOSDMaxBackfills raises `osd_max_backfills` for all OSDs to
+// the given value for the duration of the run. The original value
+// is restored once the run returns. A value of 0 leaves the option
+// untouched.
+func WithOSDMaxBackfills(val int) Option {
+	return func(r *Rebalancer) {
+		r.osdMaxBackfills = val
+	}
+}
+
+// WithOSDRecoveryMaxActive raises `osd_recovery_max_active` for all
+// OSDs to the given value for the duration of the run. The original
+// value is restored once the run returns. A value of 0 leaves the
+// option untouched.
+func WithOSDRecoveryMaxActive(val int) Option {
+	return func(r *Rebalancer) {
+		r.osdRecoveryMaxActive = val
+	}
+}
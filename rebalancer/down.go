@@ -0,0 +1,84 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/digitalocean/archimedes/cephclient"
+)
+
+// extractUnavailableOSDs maps the target OSDs which are down or
+// out to that state. Nodes without a status, as in hand-built trees,
+// are considered up and in.
+func (r *Rebalancer) extractUnavailableOSDs(out *cephclient.OSDTreeOut) map[int]string {
+	unavailable := make(map[int]string)
+	for _, node := range out.Nodes {
+		if node.Type != "osd" {
+			continue
+		}
+		if _, ok := r.targetCrushWeightMap[node.ID]; !ok {
+			continue
+		}
+
+		switch {
+		case node.Status == "down":
+			unavailable[node.ID] = "down"
+		case node.Status != "" && node.Reweight == 0:
+			unavailable[node.ID] = "out"
+		}
+	}
+
+	return unavailable
+}
+
+// raising reports whether the OSD is yet to be weighted up towards
+// its target. Down or out OSDs being drained are never held back,
+// draining dead OSDs is how they get decommissioned.
+func (r *Rebalancer) raising(osd int, cw float64) bool {
+	tw := r.targetCrushWeightMap[osd]
+	return tw > cw && !r.weightsEqual(cw, tw)
+}
+
+// checkDownOSDs applies `downOSDPolicy` to the target OSDs yet to be
+// raised which are down or out. Under `wait` the iteration is
+// skipped until they are back, under `fail` the run is aborted.
+// Under `skip` these OSDs are left out of the reweight loop instead.
+func (r *Rebalancer) checkDownOSDs(cws map[int]float64, unavailable map[int]string) bool {
+	if r.downOSDPolicy == DownOSDPolicySkip {
+		return true
+	}
+
+	var osds []int
+	for osd := range unavailable {
+		if cw, ok := cws[osd]; ok && r.raising(osd, cw) {
+			osds = append(osds, osd)
+		}
+	}
+	if len(osds) == 0 {
+		return true
+	}
+	sort.Ints(osds)
+
+	if r.downOSDPolicy == DownOSDPolicyFail {
+		r.abortErr = classify(ErrGateBlocked, fmt.Errorf("target osds %v are down or out", osds))
+		return false
+	}
+
+	r.log().WithField("osds", osds).Info("skipping reweighting, waiting for down or out target osds")
+	r.skipIteration(fmt.Sprintf("target osds down or out: %v", osds))
+	return false
+}
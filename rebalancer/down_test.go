@@ -0,0 +1,88 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownOSDPolicy(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		policy string
+
+		reweights map[int]float64
+		skipped   bool
+		aborted   bool
+	}{
+		{
+			name:      "Skip",
+			policy:    DownOSDPolicySkip,
+			reweights: map[int]float64{1: 1, 4: 0},
+		},
+		{
+			name:    "Wait",
+			policy:  DownOSDPolicyWait,
+			skipped: true,
+		},
+		{
+			name:    "Fail",
+			policy:  DownOSDPolicyFail,
+			aborted: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := cephtest.New(cephtest.State{
+				OSDTree: &cephclient.OSDTreeOut{
+					Nodes: []cephclient.OSDTreeNode{
+						{ID: 1, Type: "osd", Status: "up", Reweight: 1, CrushWeight: 0.5},
+						{ID: 2, Type: "osd", Status: "down", Reweight: 1, CrushWeight: 0.5},
+						{ID: 3, Type: "osd", Status: "up", Reweight: 0, CrushWeight: 0.5},
+						// Down OSDs are still drained.
+						{ID: 4, Type: "osd", Status: "down", Reweight: 0, CrushWeight: 0.5},
+					},
+				},
+			})
+			defer tc.Close()
+
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(map[int]float64{1: 1, 2: 1, 3: 1, 4: 0}),
+				WithWeightIncrement(0.5),
+				WithDownOSDPolicy(tt.policy),
+				WithDryRun(false),
+			)
+			assert.NoError(t, err)
+
+			res, err := r.RunOnce(context.Background())
+			assert.Equal(t, tt.aborted, errors.Is(err, ErrGateBlocked))
+			assert.Equal(t, tt.skipped, res.SkipReason != "")
+			if tt.reweights == nil {
+				assert.Empty(t, res.Reweights)
+			} else {
+				assert.Equal(t, tt.reweights, res.Reweights)
+			}
+			assert.Contains(t, r.RemainingTargets(), 2, "down osds should remain targets")
+			assert.Contains(t, r.RemainingTargets(), 3, "out osds should remain targets")
+		})
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	log "github.com/sirupsen/logrus"
+)
+
+// finishOSD removes an OSD that reached its target weight from the
+// target map. An OSD drained to zero still holds data until its PGs
+// have been moved off, so it is only finished once Ceph considers it
+// safe to destroy and is checked again on the next run otherwise.
+func (r *Rebalancer) finishOSD(ctx context.Context, osd int, ll log.FieldLogger) {
+	// OSDs rolled back to zero are returned to where they started
+	// rather than drained.
+	drained := r.targetCrushWeightMap[osd] == 0 && !r.rollingBack
+
+	// A simulated drain never moves any data off the OSD, so it is
+	// considered done without waiting for it to be safe to destroy.
+	if drained && r.dryRun {
+		ll.Info("drained osd will have to become safe to destroy in the actual run")
+	} else if drained {
+		out, err := r.ceph.SafeToDestroy(ctx, []int{osd})
+		if err != nil {
+			ll.WithError(err).Warn("failed checking whether drained osd is safe to destroy")
+			r.lastErr = err
+			return
+		}
+
+		safe := out.Safe(osd)
+		r.drainedOSDs[osd] = safe
+		if !safe {
+			ll.Info("waiting for drained osd to become safe to destroy")
+			return
+		}
+		ll = ll.WithField("safe.to.destroy", true)
+
+		if err := r.completeDrain(ctx, osd, ll); err != nil {
+			ll.WithError(err).Warn("failed completing drain, retrying on the next run")
+			r.lastErr = err
+			return
+		}
+	}
+
+	if r.primaryAffinity && r.targetCrushWeightMap[osd] > 0 {
+		if err := r.setPrimaryAffinity(ctx, osd, 1, ll); err != nil {
+			ll.WithError(err).Warn("failed restoring primary affinity, retrying on the next run")
+			r.lastErr = err
+			return
+		}
+	}
+
+	ll.Info("osd finished reweighting")
+	r.completeOSD(osd)
+}
+
+// setPrimaryAffinity sets the primary affinity of an OSD, unless it
+// is already at the given value.
+func (r *Rebalancer) setPrimaryAffinity(ctx context.Context, osd int, affinity float64, ll log.FieldLogger) error {
+	dump, err := r.ceph.OSDDump(ctx)
+	if err != nil {
+		return err
+	}
+	for _, info := range dump.OSDs {
+		if info.OSD == osd && info.PrimaryAffinity == affinity {
+			return nil
+		}
+	}
+
+	ll = ll.WithField("primary.affinity", affinity)
+	if r.dryRun {
+		ll.Info("primary affinity will be set in the actual run")
+		return nil
+	}
+
+	if err := r.ceph.SetPrimaryAffinity(ctx, osd, affinity); err != nil {
+		return err
+	}
+	ll.Info("set primary affinity of osd")
+	return nil
+}
+
+// completeDrain takes the step configured by `drainCompletion` to
+// decommission an OSD that is safe to destroy.
+func (r *Rebalancer) completeDrain(ctx context.Context, osd int, ll log.FieldLogger) error {
+	if r.drainCompletion == DrainCompletionNone {
+		return nil
+	}
+
+	ll = ll.WithField("drain.completion", r.drainCompletion)
+	if r.dryRun {
+		ll.Info("drained osd will be decommissioned in the actual run")
+		return nil
+	}
+
+	if err := r.ceph.MarkOut(ctx, []int{osd}); err != nil {
+		return fmt.Errorf("cannot mark osd out: %w", err)
+	}
+	ll.Info("marked drained osd out")
+	r.cachedTree = nil
+
+	switch r.drainCompletion {
+	case DrainCompletionRemove:
+		if err := r.ceph.CrushRemove(ctx, osd); err != nil {
+			return fmt.Errorf("cannot remove osd from crush map: %w", err)
+		}
+		ll.Info("removed drained osd from crush map")
+	case DrainCompletionPurge:
+		if err := r.ceph.PurgeOSD(ctx, osd); err != nil {
+			return fmt.Errorf("cannot purge osd: %w", err)
+		}
+		ll.Info("purged drained osd")
+	}
+
+	return nil
+}
+
+// DrainedOSDs returns the OSDs drained to zero weight so far, along
+// with whether each of them was found safe to destroy.
+func (r *Rebalancer) DrainedOSDs() map[int]bool {
+	v := r.currentView()
+
+	drained := make(map[int]bool, len(v.drainedOSDs))
+	for osd, safe := range v.drainedOSDs {
+		drained[osd] = safe
+	}
+	return drained
+}
+
+// downweightedOSDs returns the target OSDs of the tree with a target
+// below their current weight, by ascending ID.
+func (r *Rebalancer) downweightedOSDs(tree *cephclient.OSDTreeOut) []int {
+	var osds []int
+	for osd, cw := range r.extractCurrentWeights(tree) {
+		if tw, ok := r.targetCrushWeightMap[osd]; ok && tw < cw {
+			osds = append(osds, osd)
+		}
+	}
+	sort.Ints(osds)
+	return osds
+}
+
+// checkDownweights reports whether reweighting may start with regards
+// to targets below the current weight of their OSD, which are only
+// allowed through `allowDownweight`. Rolling back is always allowed
+// to downweight, as it returns OSDs to the weights they had before.
+func (r *Rebalancer) checkDownweights(ctx context.Context) bool {
+	if r.allowDownweight || r.rollingBack {
+		return true
+	}
+
+	tree, err := r.targetOSDTree(ctx)
+	if err != nil {
+		r.abortErr = fmt.Errorf("cannot check the osds to downweight: %w", err)
+		return false
+	}
+
+	if osds := r.downweightedOSDs(tree); len(osds) > 0 {
+		r.abortErr = fmt.Errorf("osds %v have targets below their current weight, which downweighting has to be allowed for", osds)
+		return false
+	}
+	return true
+}
+
+// checkOKToStop reports whether reweighting may start with regards
+// to the OSDs about to be downweighted. Losing all of them at once
+// must not make any PG unavailable, which matters most for EC pools
+// that cannot serve PGs below `min_size`. Depending on
+// `okToStopPolicy` a failed check either makes the run abort or is
+// only warned about.
+func (r *Rebalancer) checkOKToStop(ctx context.Context) bool {
+	if r.okToStopPolicy == OKToStopPolicyIgnore {
+		return true
+	}
+
+	tree, err := r.targetOSDTree(ctx)
+	if err != nil {
+		r.abortErr = fmt.Errorf("cannot check the osds to downweight: %w", err)
+		return false
+	}
+
+	osds := r.downweightedOSDs(tree)
+	if len(osds) == 0 {
+		return true
+	}
+
+	out, err := r.ceph.OKToStop(ctx, osds)
+	if err != nil {
+		r.abortErr = fmt.Errorf("cannot check whether osds to downweight are ok to stop: %w", err)
+		return false
+	}
+	if out.OKToStop {
+		return true
+	}
+
+	ll := r.log().WithField("osds", osds).WithField("inactive.pgs", out.BadBecomeInactive)
+	if r.okToStopPolicy == OKToStopPolicyWarn {
+		ll.Warn("osds to downweight are not ok to stop, some pgs would become inactive without them")
+		return true
+	}
+
+	r.abortErr = classify(ErrGateBlocked, fmt.Errorf("osds %v to downweight are not ok to stop, some pgs would become inactive without them", osds))
+	return false
+}
@@ -0,0 +1,435 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrain(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd", CrushWeight: 1.5},
+				{ID: 2, Type: "osd", CrushWeight: 2.0},
+			},
+		},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithWeightIncrement(1.0),
+		WithTargetCrushWeightMap(map[int]float64{
+			1: 0,
+			2: 1.5,
+		}),
+		WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	r.DoReweight()
+	assert.Equal(t, map[int]float64{1: 0.5, 2: 1.5}, tc.CrushWeights(), "osds should be downweighted")
+
+	r.DoReweight()
+	assert.Equal(t, map[int]float64{1: 0, 2: 1.5}, tc.CrushWeights(), "osd should be drained to zero")
+	assert.NotContains(t, r.RemainingTargets(), 2, "downweighted osd should be finished")
+
+	r.DoReweight()
+	assert.Contains(t, r.RemainingTargets(), 1, "drained osd should wait until safe to destroy")
+	assert.Equal(t, map[int]bool{1: false}, r.DrainedOSDs())
+
+	tc.Update(func(s *cephtest.State) { s.SafeToDestroy = []int{1} })
+	r.DoReweight()
+	assert.Empty(t, r.RemainingTargets(), "drained osd should be finished once safe to destroy")
+	assert.Equal(t, map[int]bool{1: true}, r.DrainedOSDs())
+	assert.Len(t, tc.CallsTo("CrushReweight"), 3, "finished osds should not be reweighted again")
+}
+
+func TestCheckOKToStop(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		policy      string
+		notOKToStop []int
+
+		ok      bool
+		aborted bool
+		calls   [][]int
+	}{
+		{
+			name:   "OK",
+			policy: OKToStopPolicyRefuse,
+			ok:     true,
+			calls:  [][]int{{1, 3}},
+		},
+		{
+			name:        "Refuse",
+			policy:      OKToStopPolicyRefuse,
+			notOKToStop: []int{3},
+			aborted:     true,
+			calls:       [][]int{{1, 3}},
+		},
+		{
+			name:        "Warn",
+			policy:      OKToStopPolicyWarn,
+			notOKToStop: []int{3},
+			ok:          true,
+			calls:       [][]int{{1, 3}},
+		},
+		{
+			name:        "Ignore",
+			policy:      OKToStopPolicyIgnore,
+			notOKToStop: []int{3},
+			ok:          true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := cephtest.New(cephtest.State{
+				OSDTree: &cephclient.OSDTreeOut{
+					Nodes: []cephclient.OSDTreeNode{
+						{ID: 1, Type: "osd", CrushWeight: 1.0},
+						{ID: 2, Type: "osd", CrushWeight: 1.0},
+						{ID: 3, Type: "osd", CrushWeight: 1.0},
+					},
+				},
+				NotOKToStop: tt.notOKToStop,
+			})
+			defer tc.Close()
+
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(map[int]float64{1: 0, 2: 2.0, 3: 0.5}),
+				WithOKToStopPolicy(tt.policy),
+			)
+			if err != nil {
+				t.Fatalf("failed initializing rebalancer")
+			}
+
+			assert.Equal(t, tt.ok, r.checkOKToStop(context.Background()), "ok-to-stop check result should match")
+			assert.Equal(t, tt.aborted, r.abortErr != nil, "abort state should match")
+			var calls [][]int
+			for _, call := range tc.CallsTo("OKToStop") {
+				calls = append(calls, call.Args[0].([]int))
+			}
+			assert.Equal(t, tt.calls, calls, "only osds to downweight should be checked")
+		})
+	}
+}
+
+func TestCheckDownweights(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		targets     map[int]float64
+		allow       bool
+		rollingBack bool
+
+		ok bool
+	}{
+		{
+			name:    "Upweights",
+			targets: map[int]float64{1: 2.0, 2: 1.0},
+			ok:      true,
+		},
+		{
+			name:    "Refused",
+			targets: map[int]float64{1: 2.0, 2: 0.5},
+		},
+		{
+			name:    "Allowed",
+			targets: map[int]float64{1: 2.0, 2: 0.5},
+			allow:   true,
+			ok:      true,
+		},
+		{
+			name:        "RollingBack",
+			targets:     map[int]float64{1: 2.0, 2: 0.5},
+			rollingBack: true,
+			ok:          true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := cephtest.New(cephtest.State{
+				OSDTree: &cephclient.OSDTreeOut{
+					Nodes: []cephclient.OSDTreeNode{
+						{ID: 1, Type: "osd", CrushWeight: 1.0},
+						{ID: 2, Type: "osd", CrushWeight: 1.0},
+					},
+				},
+			})
+			defer tc.Close()
+
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(tt.targets),
+				WithAllowDownweight(tt.allow),
+				WithDryRun(false),
+			)
+			if err != nil {
+				t.Fatalf("failed initializing rebalancer")
+			}
+			r.rollingBack = tt.rollingBack
+
+			assert.Equal(t, tt.ok, r.checkDownweights(context.Background()), "downweight check result should match")
+			assert.Equal(t, !tt.ok, r.abortErr != nil, "abort state should match")
+		})
+	}
+
+	t.Run("AddTargets", func(t *testing.T) {
+		tc := cephtest.New(cephtest.State{
+			OSDTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{ID: 1, Type: "osd", CrushWeight: 1.0},
+					{ID: 2, Type: "osd", CrushWeight: 1.0},
+				},
+			},
+		})
+		defer tc.Close()
+
+		r, err := New(
+			WithCephClient(tc),
+			WithTargetCrushWeightMap(map[int]float64{1: 2.0}),
+			WithDryRun(false),
+		)
+		if err != nil {
+			t.Fatalf("failed initializing rebalancer")
+		}
+
+		assert.Error(t, r.AddTargets(context.Background(), map[int]float64{2: 0.5}), "downweights should be refused")
+		assert.Equal(t, map[int]float64{1: 2.0}, r.RemainingTargets(), "refused targets should not be added")
+		assert.Nil(t, r.abortErr, "refused targets should not abort the run")
+	})
+
+	t.Run("Reconfigure", func(t *testing.T) {
+		tc := cephtest.New(cephtest.State{
+			OSDTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{ID: 1, Type: "osd", CrushWeight: 1.0},
+					{ID: 2, Type: "osd", CrushWeight: 1.0},
+				},
+			},
+			NotOKToStop: []int{2},
+		})
+		defer tc.Close()
+
+		r, err := New(
+			WithCephClient(tc),
+			WithTargetCrushWeightMap(map[int]float64{1: 2.0, 2: 2.0}),
+			WithDryRun(false),
+		)
+		if err != nil {
+			t.Fatalf("failed initializing rebalancer")
+		}
+		ctx := context.Background()
+
+		err = r.Reconfigure(ctx, WithTargetCrushWeightMap(map[int]float64{1: 0.5, 2: 2.0}), WithWeightIncrement(0.5))
+		if assert.Error(t, err, "reloaded downweights should be refused") {
+			assert.Contains(t, err.Error(), "below their current weight")
+		}
+		assert.Equal(t, map[int]float64{1: 2.0, 2: 2.0}, r.RemainingTargets(), "refused targets should not be applied")
+		assert.Equal(t, 0.02, r.weightIncrement, "no option should be applied on error")
+
+		// Allowed downweights still have to be ok to stop.
+		err = r.Reconfigure(ctx, WithTargetCrushWeightMap(map[int]float64{1: 2.0, 2: 0.5}), WithAllowDownweight(true))
+		if assert.Error(t, err, "reloaded downweights should be checked for ok-to-stop") {
+			assert.Contains(t, err.Error(), "not ok to stop")
+		}
+		assert.Equal(t, map[int]float64{1: 2.0, 2: 2.0}, r.RemainingTargets(), "refused targets should not be applied")
+		assert.Nil(t, r.abortErr, "refused targets should not abort the run")
+
+		err = r.Reconfigure(ctx, WithTargetCrushWeightMap(map[int]float64{1: 0.5, 2: 2.0}), WithAllowDownweight(true))
+		assert.NoError(t, err, "allowed downweights of osds ok to stop should be reloaded")
+		assert.Equal(t, map[int]float64{1: 0.5, 2: 2.0}, r.RemainingTargets())
+	})
+
+	t.Run("Run", func(t *testing.T) {
+		tc := cephtest.New(cephtest.State{
+			OSDTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{ID: 1, Type: "osd", CrushWeight: 1.0},
+				},
+			},
+		})
+		defer tc.Close()
+
+		r, err := New(
+			WithCephClient(tc),
+			WithTargetCrushWeightMap(map[int]float64{1: 0.5}),
+			WithSleepInterval(time.Millisecond),
+			WithDryRun(false),
+		)
+		if err != nil {
+			t.Fatalf("failed initializing rebalancer")
+		}
+
+		_, err = r.Run(context.Background())
+		assert.Error(t, err, "run should refuse to downweight")
+		assert.Empty(t, tc.CallsTo("CrushReweight"), "no osd should be reweighted")
+	})
+}
+
+func TestDrainCompletion(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		completion string
+		dryRun     bool
+		purgeErr   error
+
+		finished     bool
+		markedOut    []int
+		crushRemoved []int
+		purged       []int
+	}{
+		{
+			name:       "None",
+			completion: DrainCompletionNone,
+			finished:   true,
+		},
+		{
+			name:       "Out",
+			completion: DrainCompletionOut,
+			finished:   true,
+			markedOut:  []int{1},
+		},
+		{
+			name:         "Remove",
+			completion:   DrainCompletionRemove,
+			finished:     true,
+			markedOut:    []int{1},
+			crushRemoved: []int{1},
+		},
+		{
+			name:       "Purge",
+			completion: DrainCompletionPurge,
+			finished:   true,
+			markedOut:  []int{1},
+			purged:     []int{1},
+		},
+		{
+			name:       "PurgeFailed",
+			completion: DrainCompletionPurge,
+			purgeErr:   errors.New("osd.1 is not `down`"),
+			markedOut:  []int{1},
+			purged:     []int{1},
+		},
+		{
+			name:       "DryRun",
+			completion: DrainCompletionPurge,
+			dryRun:     true,
+			finished:   true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := cephtest.New(cephtest.State{
+				OSDTree: &cephclient.OSDTreeOut{
+					Nodes: []cephclient.OSDTreeNode{
+						{ID: 1, Type: "osd", Status: "down", CrushWeight: 0},
+					},
+				},
+				SafeToDestroy: []int{1},
+			})
+			defer tc.Close()
+			if tt.purgeErr != nil {
+				tc.InjectFault("PurgeOSD", cephtest.Fault{Err: tt.purgeErr})
+			}
+			osdsOf := func(method string) []int {
+				var osds []int
+				for _, call := range tc.CallsTo(method) {
+					switch arg := call.Args[0].(type) {
+					case int:
+						osds = append(osds, arg)
+					case []int:
+						osds = append(osds, arg...)
+					}
+				}
+				return osds
+			}
+
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(map[int]float64{1: 0}),
+				WithDrainCompletion(tt.completion),
+				WithDryRun(tt.dryRun),
+			)
+			if err != nil {
+				t.Fatalf("failed initializing rebalancer")
+			}
+
+			r.DoReweight()
+			assert.Equal(t, tt.finished, len(r.RemainingTargets()) == 0, "finished state should match")
+			assert.Equal(t, tt.markedOut, osdsOf("MarkOut"), "osds marked out should match")
+			assert.Equal(t, tt.crushRemoved, osdsOf("CrushRemove"), "osds removed from crush should match")
+			assert.Equal(t, tt.purged, osdsOf("PurgeOSD"), "osds to purge should match")
+		})
+	}
+}
+
+func TestPrimaryAffinity(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd", CrushWeight: 1.0},
+				{ID: 2, Type: "osd", CrushWeight: 0},
+				{ID: 3, Type: "osd", CrushWeight: 0},
+			},
+		},
+		OSDDump: &cephclient.OSDDumpOut{
+			OSDs: []cephclient.OSDInfo{
+				{OSD: 1, PrimaryAffinity: 1},
+				{OSD: 2, PrimaryAffinity: 0},
+				{OSD: 3, PrimaryAffinity: 1},
+			},
+		},
+		SafeToDestroy: []int{1},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithWeightIncrement(1.0),
+		WithTargetCrushWeightMap(map[int]float64{1: 0, 2: 1.0, 3: 1.0}),
+		WithPrimaryAffinity(true),
+		WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	affinities := func() map[int]float64 {
+		calls := map[int]float64{}
+		for _, call := range tc.CallsTo("SetPrimaryAffinity") {
+			calls[call.Args[0].(int)] = call.Args[1].(float64)
+		}
+		return calls
+	}
+
+	r.DoReweight()
+	assert.Equal(t, map[int]float64{1: 0}, affinities(), "osd to drain should lose primary affinity")
+
+	r.DoReweight()
+	assert.Empty(t, r.RemainingTargets(), "all osds should be finished")
+	assert.Equal(t, map[int]float64{1: 0, 2: 1}, affinities(), "ramped up osd should regain primary affinity")
+}
@@ -0,0 +1,53 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import "errors"
+
+var (
+	// ErrGateBlocked classifies errors a run is aborted with because
+	// the cluster is in a state reweighting must not proceed in, e.g.
+	// HEALTH_ERR, conflicting flags, OSDs that are not ok to stop or
+	// target OSDs that are down under the `fail` down OSD policy.
+	ErrGateBlocked = errors.New("blocked by a gate")
+
+	// ErrWeightConflict classifies errors a run is aborted with
+	// because something else would change the placements concurrently,
+	// e.g. the Ceph balancer.
+	ErrWeightConflict = errors.New("conflicting weight changes")
+)
+
+// classifiedError attaches one of the exported sentinels to an error
+// without changing its message.
+type classifiedError struct {
+	class error
+	err   error
+}
+
+func classify(class error, err error) error {
+	return &classifiedError{class: class, err: err}
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.class
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunErrorClassification(t *testing.T) {
+	tests := []struct {
+		name  string
+		state cephtest.State
+		opts  []Option
+		want  error
+	}{
+		{
+			name: "HEALTH_ERR",
+			state: cephtest.State{
+				Health: &cephclient.HealthOut{Status: "HEALTH_ERR"},
+			},
+			want: ErrGateBlocked,
+		},
+		{
+			name: "Active Balancer",
+			state: cephtest.State{
+				BalancerActive: true,
+			},
+			opts: []Option{WithBalancerPolicy(BalancerPolicyRefuse)},
+			want: ErrWeightConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.state.OSDTree = &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{ID: 1, Type: "osd", CrushWeight: 1},
+				},
+			}
+			tc := cephtest.New(tt.state)
+			defer tc.Close()
+
+			r, err := New(append([]Option{
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(map[int]float64{1: 2}),
+				WithSleepInterval(time.Millisecond),
+				WithMaxIterations(1),
+			}, tt.opts...)...)
+			assert.NoError(t, err)
+
+			_, err = r.Run(context.Background())
+			assert.True(t, errors.Is(err, tt.want), "unexpected error: %v", err)
+			assert.False(t, cephclient.IsTransient(err))
+		})
+	}
+}
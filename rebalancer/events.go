@@ -0,0 +1,68 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+// Event is one of ReweightApplied, TargetReached, WeightChanged,
+// IterationSkipped or RunCompleted, as handed to the handlers given
+// through WithEventHandler.
+type Event interface {
+	event()
+}
+
+// ReweightApplied is emitted whenever an OSD gets reweighted, or
+// would have been reweighted in a dry run.
+type ReweightApplied struct {
+	OSD    int
+	Weight float64
+	DryRun bool
+}
+
+// TargetReached is emitted whenever an OSD finishes reweighting.
+type TargetReached struct {
+	OSD int
+}
+
+// WeightChanged is emitted whenever an OSD gets paused because its
+// weight was found to differ from the weight last applied to it.
+type WeightChanged struct {
+	OSD      int
+	Applied  float64
+	Observed float64
+}
+
+// IterationSkipped is emitted whenever an iteration doesn't reweight
+// at all, e.g. because a gate is closed.
+type IterationSkipped struct {
+	Reason string
+}
+
+// RunCompleted is emitted once Run returns, with what it returns.
+type RunCompleted struct {
+	Summary Summary
+	Err     error
+}
+
+func (ReweightApplied) event()  {}
+func (TargetReached) event()    {}
+func (WeightChanged) event()    {}
+func (IterationSkipped) event() {}
+func (RunCompleted) event()     {}
+
+// emit hands the event to every event handler in turn.
+func (r *Rebalancer) emit(e Event) {
+	for _, h := range r.eventHandlers {
+		h(e)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvents(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd", CrushWeight: 0.5},
+			},
+		},
+		PGsByState: map[string]int{"active+remapped+backfilling": 20},
+	})
+	defer tc.Close()
+
+	var events []Event
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1}),
+		WithWeightIncrement(0.5),
+		WithMaxBackfillPGsAllowed(10),
+		WithSleepInterval(time.Millisecond),
+		WithEventHandler(func(e Event) { events = append(events, e) }),
+		WithDryRun(false),
+	)
+	assert.NoError(t, err)
+
+	_, err = r.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.IsType(t, IterationSkipped{}, events[0])
+
+	setPGs(tc, "active+remapped+backfilling", 0)
+	events = nil
+	summary, err := r.Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []Event{
+		ReweightApplied{OSD: 1, Weight: 1},
+		TargetReached{OSD: 1},
+		RunCompleted{Summary: summary},
+	}, events)
+}
@@ -0,0 +1,329 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strconv"
+	"time"
+)
+
+// Gate expressions are comparisons over the variables below, joined
+// with `&&` and `||`, negated with `!` and grouped with parentheses.
+// Operands are numbers, double-quoted strings, `true`, `false`, the
+// variables and their sums, differences, products and quotients. All
+// numbers are floating point. The syntax is that of Go expressions,
+// which they are parsed as, restricted to the above.
+//
+// An expression has to evaluate to a bool, true letting the
+// iteration proceed. Expressions are evaluated on top of the
+// built-in gates and can only add restrictions to them, e.g. with
+// `--max-backfill-pgs 50`, allowing no more than 10 backfilling PGs
+// during the day:
+//
+//   hour >= 22 || hour < 6 || backfill_pgs <= 10
+//
+// The variables are taken from a snapshot of the cluster made
+// right before the expression is evaluated:
+//
+//   backfill_pgs, recovery_pgs, inactive_pgs  PG counts
+//   misplaced_ratio                           misplaced objects ratio
+//   degraded_objects                          degraded object count
+//   health                                    e.g. "HEALTH_OK"
+//   hour, minute, weekday                     local time, Sunday is 0
+//
+// Expressions referring to any other variable fail to compile.
+
+// exprVariables are the variables gate expressions may refer to, as
+// taken by the snapshot of an expressionGate.
+var exprVariables = map[string]bool{
+	"backfill_pgs":     true,
+	"recovery_pgs":     true,
+	"inactive_pgs":     true,
+	"misplaced_ratio":  true,
+	"degraded_objects": true,
+	"health":           true,
+	"hour":             true,
+	"minute":           true,
+	"weekday":          true,
+}
+
+// expressionGate is a Gate evaluating a compiled expression over
+// a snapshot of cluster stats.
+type expressionGate struct {
+	r    *Rebalancer
+	src  string
+	expr exprNode
+	now  func() time.Time
+}
+
+func (r *Rebalancer) newExpressionGate(src string) (*expressionGate, error) {
+	expr, err := compileExpr(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gate expression %q: %s", src, err)
+	}
+
+	return &expressionGate{r: r, src: src, expr: expr, now: r.now}, nil
+}
+
+// Evaluate implements Gate.
+func (g *expressionGate) Evaluate(ctx context.Context) (bool, string, error) {
+	vars, err := g.snapshot(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed taking cluster snapshot: %s", err)
+	}
+
+	val, err := g.expr(vars)
+	if err != nil {
+		return false, "", fmt.Errorf("failed evaluating gate expression %q: %s", g.src, err)
+	}
+
+	ok, isBool := val.(bool)
+	if !isBool {
+		return false, "", fmt.Errorf("gate expression %q evaluated to %v, not a bool", g.src, val)
+	}
+	if !ok {
+		return false, fmt.Sprintf("gate expression %q is false", g.src), nil
+	}
+
+	return true, "", nil
+}
+
+func (g *expressionGate) snapshot(ctx context.Context) (map[string]interface{}, error) {
+	status, err := g.r.clusterStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := g.now()
+	return map[string]interface{}{
+		"backfill_pgs":     float64(status.PGsInState("backfilling", "backfill_wait")),
+		"recovery_pgs":     float64(status.PGsInState("recovering", "recovery_wait")),
+		"inactive_pgs":     float64(status.InactivePGs()),
+		"misplaced_ratio":  status.MisplacedRatio(),
+		"degraded_objects": status.PGMap.DegradedObjects,
+		"health":           status.Health.Status,
+		"hour":             float64(now.Hour()),
+		"minute":           float64(now.Minute()),
+		"weekday":          float64(now.Weekday()),
+	}, nil
+}
+
+// exprNode evaluates a compiled (sub)expression against the given
+// variables.
+type exprNode func(vars map[string]interface{}) (interface{}, error)
+
+// compileExpr parses the expression into an exprNode.
+func compileExpr(src string) (exprNode, error) {
+	e, err := parser.ParseExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	return compileNode(e)
+}
+
+func compileNode(e ast.Expr) (exprNode, error) {
+	switch e := e.(type) {
+	case *ast.ParenExpr:
+		return compileNode(e.X)
+
+	case *ast.BasicLit:
+		switch e.Kind {
+		case token.INT, token.FLOAT:
+			f, err := strconv.ParseFloat(e.Value, 64)
+			if err != nil {
+				return nil, err
+			}
+			return constant(f), nil
+		case token.STRING:
+			str, err := strconv.Unquote(e.Value)
+			if err != nil {
+				return nil, err
+			}
+			return constant(str), nil
+		}
+
+	case *ast.Ident:
+		switch name := e.Name; name {
+		case "true", "false":
+			return constant(name == "true"), nil
+		default:
+			// A typo would otherwise only show once evaluated, and
+			// keep the gate closed for the whole run.
+			if !exprVariables[name] {
+				return nil, fmt.Errorf("unknown variable %q", name)
+			}
+			return func(vars map[string]interface{}) (interface{}, error) {
+				v, ok := vars[name]
+				if !ok {
+					return nil, fmt.Errorf("unknown variable %q", name)
+				}
+				return v, nil
+			}, nil
+		}
+
+	case *ast.UnaryExpr:
+		x, err := compileNode(e.X)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case token.NOT:
+			return func(vars map[string]interface{}) (interface{}, error) {
+				b, err := evalBool(x, vars)
+				return !b, err
+			}, nil
+		case token.SUB:
+			return func(vars map[string]interface{}) (interface{}, error) {
+				f, err := evalNumber(x, vars)
+				return -f, err
+			}, nil
+		}
+
+	case *ast.BinaryExpr:
+		return compileBinary(e)
+	}
+
+	return nil, fmt.Errorf("unsupported expression %q", types.ExprString(e))
+}
+
+func compileBinary(e *ast.BinaryExpr) (exprNode, error) {
+	x, err := compileNode(e.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := compileNode(e.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op := e.Op; op {
+	case token.LAND, token.LOR:
+		// The right-hand side is only evaluated when needed.
+		return func(vars map[string]interface{}) (interface{}, error) {
+			b, err := evalBool(x, vars)
+			if err != nil || b == (op == token.LOR) {
+				return b, err
+			}
+			return evalBool(y, vars)
+		}, nil
+
+	case token.ADD, token.SUB, token.MUL, token.QUO:
+		return func(vars map[string]interface{}) (interface{}, error) {
+			a, err := evalNumber(x, vars)
+			if err != nil {
+				return nil, err
+			}
+			b, err := evalNumber(y, vars)
+			if err != nil {
+				return nil, err
+			}
+			switch op {
+			case token.ADD:
+				return a + b, nil
+			case token.SUB:
+				return a - b, nil
+			case token.MUL:
+				return a * b, nil
+			}
+			if b == 0 {
+				return nil, errors.New("division by zero")
+			}
+			return a / b, nil
+		}, nil
+
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		return func(vars map[string]interface{}) (interface{}, error) {
+			a, err := x(vars)
+			if err != nil {
+				return nil, err
+			}
+			b, err := y(vars)
+			if err != nil {
+				return nil, err
+			}
+			return compare(op, a, b)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported operator %s", e.Op)
+}
+
+// compare applies a comparison operator to two values of the same
+// type. Strings and bools can only be compared for equality.
+func compare(op token.Token, a, b interface{}) (bool, error) {
+	if fa, ok := a.(float64); ok {
+		fb, ok := b.(float64)
+		if !ok {
+			return false, fmt.Errorf("cannot compare %v to %v", a, b)
+		}
+		switch op {
+		case token.LSS:
+			return fa < fb, nil
+		case token.LEQ:
+			return fa <= fb, nil
+		case token.GTR:
+			return fa > fb, nil
+		case token.GEQ:
+			return fa >= fb, nil
+		}
+	} else if op != token.EQL && op != token.NEQ {
+		return false, fmt.Errorf("cannot order %v and %v", a, b)
+	}
+
+	switch a.(type) {
+	case float64, string, bool:
+	default:
+		return false, fmt.Errorf("cannot compare %v", a)
+	}
+	if fmt.Sprintf("%T", a) != fmt.Sprintf("%T", b) {
+		return false, fmt.Errorf("cannot compare %v to %v", a, b)
+	}
+	return (a == b) == (op == token.EQL), nil
+}
+
+func constant(v interface{}) exprNode {
+	return func(map[string]interface{}) (interface{}, error) { return v, nil }
+}
+
+func evalBool(n exprNode, vars map[string]interface{}) (bool, error) {
+	v, err := n(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected a bool, got %v", v)
+	}
+	return b, nil
+}
+
+func evalNumber(n exprNode, vars map[string]interface{}) (float64, error) {
+	v, err := n(vars)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("expected a number, got %v", v)
+	}
+	return f, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileExpr(t *testing.T) {
+	vars := map[string]interface{}{
+		"backfill_pgs": float64(20),
+		"hour":         float64(23),
+		"health":       "HEALTH_WARN",
+	}
+
+	for _, tt := range []struct {
+		expr string
+
+		val        interface{}
+		compileErr bool
+		evalErr    bool
+	}{
+		{expr: "1 + 2 * 3", val: float64(7)},
+		{expr: "(1 + 2) * 3", val: float64(9)},
+		{expr: "10 - 4 - 3", val: float64(3)},
+		{expr: "9 / 4", val: float64(2.25)},
+		{expr: "-2 * -1.5e1", val: float64(30)},
+		{expr: "hour >= 22 || hour < 6 || backfill_pgs <= 10", val: true},
+		{expr: "health == \"HEALTH_OK\" || health == \"HEALTH_WARN\" && backfill_pgs < 10", val: false},
+		{expr: "!(health != \"HEALTH_OK\")", val: false},
+		{expr: "backfill_pgs == 20 && true != false", val: true},
+		{expr: "false && health + 1 > 0", val: false},
+		{expr: "true || health + 1 > 0", val: true},
+		{expr: "undefined_var", compileErr: true},
+		{expr: "false && backfil_pgs < 10", compileErr: true},
+		{expr: "health + 1", evalErr: true},
+		{expr: "health < 1", evalErr: true},
+		{expr: "health == 1", evalErr: true},
+		{expr: "1 / 0", evalErr: true},
+		{expr: "1 && true", evalErr: true},
+		{expr: "(1 + 2", compileErr: true},
+		{expr: "1 2", compileErr: true},
+		{expr: "\"unterminated", compileErr: true},
+		{expr: "7 % 4", compileErr: true},
+		{expr: "max(1, 2)", compileErr: true},
+		{expr: "'HEALTH_OK'", compileErr: true},
+		{expr: "", compileErr: true},
+	} {
+		t.Run(tt.expr, func(t *testing.T) {
+			node, err := compileExpr(tt.expr)
+			if tt.compileErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			val, err := node(vars)
+			if tt.evalErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.val, val)
+		})
+	}
+}
+
+func TestExpressionGate(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		PGsByState: map[string]int{"active+remapped+backfilling": 20},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	_, err = r.newExpressionGate("backfill_pgs <")
+	assert.Error(t, err, "invalid expressions should be rejected")
+
+	_, err = New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+		WithGateExpressions("backfil_pgs <= 10"),
+	)
+	if assert.Error(t, err, "unknown variables should be rejected up front") {
+		assert.Contains(t, err.Error(), `unknown variable "backfil_pgs"`)
+	}
+
+	g, err := r.newExpressionGate("hour >= 22 || hour < 6 || backfill_pgs <= 10")
+	if err != nil {
+		t.Fatalf("failed compiling expression: %s", err)
+	}
+
+	vars, err := g.snapshot(context.Background())
+	assert.NoError(t, err)
+	for name := range vars {
+		assert.True(t, exprVariables[name], "variable %q should be known to the compiler", name)
+	}
+	assert.Len(t, vars, len(exprVariables), "every known variable should be in the snapshot")
+
+	g.now = func() time.Time { return time.Date(2021, 1, 1, 23, 0, 0, 0, time.Local) }
+	ok, _, err := g.Evaluate(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok, "20 backfilling pgs should be allowed at night")
+
+	g.now = func() time.Time { return time.Date(2021, 1, 1, 12, 0, 0, 0, time.Local) }
+	ok, reason, err := g.Evaluate(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, ok, "20 backfilling pgs should not be allowed during the day")
+	assert.NotEmpty(t, reason)
+
+	g, err = r.newExpressionGate("backfill_pgs")
+	if err != nil {
+		t.Fatalf("failed compiling expression: %s", err)
+	}
+	_, _, err = g.Evaluate(context.Background())
+	assert.Error(t, err, "non-bool results should fail evaluation")
+}
@@ -0,0 +1,443 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/digitalocean/archimedes/gates"
+)
+
+// namedGate is a gate along with the name it is reported under by
+// Preflight.
+type namedGate struct {
+	name string
+	gates.Gate
+}
+
+// builtinGates returns the gates backing the checks that are
+// configured through options, in the order they are evaluated.
+// Each of them lets the iteration through when disabled.
+func (r *Rebalancer) builtinGates() []gates.Gate {
+	return []gates.Gate{
+		namedGate{"active hours", gates.GateFunc(r.activeHoursGate)},
+		namedGate{"health", gates.GateFunc(r.healthGate)},
+		namedGate{"flags", gates.GateFunc(r.flagGate)},
+		namedGate{"pg states", gates.GateFunc(r.pgStateGate)},
+		namedGate{"inactive pgs", gates.GateFunc(r.inactivePGsGate)},
+		namedGate{"scrubbing pgs", gates.GateFunc(r.scrubbingPGsGate)},
+		namedGate{"snaptrim pgs", gates.GateFunc(r.snapTrimPGsGate)},
+		namedGate{"misplaced objects", gates.GateFunc(r.misplacedGate)},
+		namedGate{"degraded objects", gates.GateFunc(r.degradedGate)},
+		namedGate{"degraded pgs", gates.GateFunc(r.degradedPGsGate)},
+		namedGate{"slow ops", gates.GateFunc(r.slowOpsGate)},
+		namedGate{"mon quorum", gates.GateFunc(r.quorumGate)},
+		namedGate{"down osds", gates.GateFunc(r.downOSDsGate)},
+		namedGate{"heartbeat latency", gates.GateFunc(r.heartbeatLatencyGate)},
+		namedGate{"osd latency", gates.GateFunc(r.osdLatencyGate)},
+		namedGate{"pg autoscaling", gates.GateFunc(r.autoscalingGate)},
+		namedGate{"promql", gates.GateFunc(r.promQLGate)},
+		namedGate{"alertmanager", gates.GateFunc(r.alertmanagerGate)},
+		namedGate{"gate hooks", gates.GateFunc(r.hookGate)},
+		namedGate{"backfill bytes", gates.GateFunc(r.backfillBytesGate)},
+	}
+}
+
+// checkGates evaluates the gates in order and reports whether all
+// of them are open. Evaluation stops at the first closed gate.
+func (r *Rebalancer) checkGates(ctx context.Context) bool {
+	for _, g := range r.gates {
+		ok, reason, err := g.Evaluate(ctx)
+		if r.iteration != nil {
+			r.iteration.Gates = append(r.iteration.Gates, Check{Name: gateName(g), OK: ok, Reason: reason, Err: err})
+		}
+		if err != nil {
+			r.log().WithError(err).Error("failed evaluating gate")
+			r.lastErr = err
+			r.skipIteration(fmt.Sprintf("failed evaluating gate: %s", err))
+			return false
+		}
+		if !ok {
+			r.log().WithField("reason", reason).Warn("skipping reweighting, gate is closed")
+			r.skipIteration(reason)
+			return false
+		}
+	}
+
+	return true
+}
+
+// healthGate checks the overall cluster health. A HEALTH_ERR cluster
+// aborts the run altogether when `abortOnHealthErr` is set, while
+// HEALTH_WARN only closes the gate for the checks the operator
+// asked for.
+func (r *Rebalancer) healthGate(ctx context.Context) (bool, string, error) {
+	if !r.abortOnHealthErr && len(r.pauseOnHealthWarnChecks) == 0 {
+		return true, "", nil
+	}
+
+	status, err := r.clusterStatus(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for cluster health: %w", err)
+	}
+	health := status.Health
+
+	if health.Status == "HEALTH_ERR" && r.abortOnHealthErr {
+		r.abortErr = classify(ErrGateBlocked, fmt.Errorf("cluster health is %s", health.Status))
+		return false, "cluster is unhealthy", nil
+	}
+
+	for code, check := range health.Checks {
+		if check.Severity != "HEALTH_WARN" {
+			continue
+		}
+
+		for _, c := range r.pauseOnHealthWarnChecks {
+			if c == "*" || c == code {
+				return false, fmt.Sprintf("health warning %s found: %s", code, check.Summary.Message), nil
+			}
+		}
+	}
+
+	return true, "", nil
+}
+
+// flagGate checks the OSD map for flags that conflict with
+// reweighting. Conflicting flags that were not explicitly allowed
+// either close the gate or abort the run altogether, depending on
+// `flagPolicy`.
+func (r *Rebalancer) flagGate(ctx context.Context) (bool, string, error) {
+	if r.flagPolicy == FlagPolicyIgnore {
+		return true, "", nil
+	}
+
+	out, err := r.ceph.OSDDump(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for cluster flags: %w", err)
+	}
+
+	set := make(map[string]bool)
+	for _, flag := range strings.Split(out.Flags, ",") {
+		set[flag] = true
+	}
+	for _, flag := range r.allowedFlags {
+		delete(set, flag)
+	}
+
+	var found []string
+	for _, flag := range conflictingFlags {
+		if set[flag] {
+			found = append(found, flag)
+		}
+	}
+	if len(found) == 0 {
+		return true, "", nil
+	}
+
+	if r.flagPolicy == FlagPolicyAbort {
+		r.abortErr = classify(ErrGateBlocked, fmt.Errorf("conflicting cluster flags set: %s", strings.Join(found, ",")))
+	}
+
+	return false, fmt.Sprintf("conflicting cluster flags found: %s", strings.Join(found, ",")), nil
+}
+
+// pgStateGate checks the number of backfilling and recovering PGs,
+// either across the whole cluster or only across the pools of the
+// target OSDs when `poolAwarePGCounts` is set.
+func (r *Rebalancer) pgStateGate(ctx context.Context) (bool, string, error) {
+	var pools map[int]bool
+	if r.poolAwarePGCounts {
+		var err error
+		if pools, err = r.targetPools(ctx); err != nil {
+			return false, "", fmt.Errorf("failed finding pools of target osds: %w", err)
+		}
+	}
+
+	bpgs, err := r.backfillingPGs(ctx, pools)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for backfilling pgs: %w", err)
+	}
+	if bpgs > r.maxBackfillPGsAllowed {
+		return false, fmt.Sprintf("%d backfilling pgs found", bpgs), nil
+	}
+
+	rpgs, err := r.recoveringPGs(ctx, pools)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for recovering pgs: %w", err)
+	}
+	if rpgs > r.maxRecoveryPGsAllowed {
+		return false, fmt.Sprintf("%d recovering pgs found", rpgs), nil
+	}
+
+	return true, "", nil
+}
+
+func (r *Rebalancer) inactivePGsGate(ctx context.Context) (bool, string, error) {
+	status, err := r.clusterStatus(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for inactive pgs: %w", err)
+	}
+	ipgs := status.InactivePGs()
+	if ipgs > r.maxInactivePGsAllowed {
+		return false, fmt.Sprintf("%d inactive pgs found", ipgs), nil
+	}
+
+	return true, "", nil
+}
+
+func (r *Rebalancer) scrubbingPGsGate(ctx context.Context) (bool, string, error) {
+	if r.maxScrubbingPGs < 0 {
+		return true, "", nil
+	}
+
+	status, err := r.clusterStatus(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for scrubbing pgs: %w", err)
+	}
+	spgs := status.PGsInState("scrubbing")
+	if spgs > r.maxScrubbingPGs {
+		return false, fmt.Sprintf("%d scrubbing pgs found", spgs), nil
+	}
+
+	return true, "", nil
+}
+
+func (r *Rebalancer) snapTrimPGsGate(ctx context.Context) (bool, string, error) {
+	if r.maxSnapTrimPGs < 0 {
+		return true, "", nil
+	}
+
+	status, err := r.clusterStatus(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for snaptrimming pgs: %w", err)
+	}
+	// Matching on the prefix covers 'snaptrim_wait' as well.
+	stpgs := status.PGsInState("snaptrim")
+	if stpgs > r.maxSnapTrimPGs {
+		return false, fmt.Sprintf("snaptrim backlog of %d pgs found", stpgs), nil
+	}
+
+	return true, "", nil
+}
+
+func (r *Rebalancer) misplacedGate(ctx context.Context) (bool, string, error) {
+	if r.maxMisplacedRatio <= 0 {
+		return true, "", nil
+	}
+
+	status, err := r.clusterStatus(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for misplaced objects: %w", err)
+	}
+	ratio := status.MisplacedRatio()
+	if ratio > r.maxMisplacedRatio {
+		return false, fmt.Sprintf("too many misplaced objects, ratio is %g", ratio), nil
+	}
+
+	return true, "", nil
+}
+
+func (r *Rebalancer) degradedGate(ctx context.Context) (bool, string, error) {
+	if r.maxDegradedObjects < 0 {
+		return true, "", nil
+	}
+
+	status, err := r.clusterStatus(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for degraded objects: %w", err)
+	}
+	dobjs := int(status.PGMap.DegradedObjects)
+	if dobjs > r.maxDegradedObjects {
+		return false, fmt.Sprintf("%d degraded objects found", dobjs), nil
+	}
+
+	return true, "", nil
+}
+
+// degradedPGsGate checks the number of PGs whose state includes
+// `degraded`, across the pools of the target OSDs only when
+// `poolAwarePGCounts` is set, like the pg states gate.
+func (r *Rebalancer) degradedPGsGate(ctx context.Context) (bool, string, error) {
+	if r.maxDegradedPGs < 0 {
+		return true, "", nil
+	}
+
+	var pools map[int]bool
+	if r.poolAwarePGCounts {
+		var err error
+		if pools, err = r.targetPools(ctx); err != nil {
+			return false, "", fmt.Errorf("failed finding pools of target osds: %w", err)
+		}
+	}
+
+	dpgs, err := r.degradedPGs(ctx, pools)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for degraded pgs: %w", err)
+	}
+	if dpgs > r.maxDegradedPGs {
+		return false, fmt.Sprintf("%d degraded pgs found", dpgs), nil
+	}
+
+	return true, "", nil
+}
+
+func (r *Rebalancer) slowOpsGate(ctx context.Context) (bool, string, error) {
+	if r.maxSlowOps < 0 {
+		return true, "", nil
+	}
+
+	status, err := r.clusterStatus(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for slow ops: %w", err)
+	}
+	ops := status.SlowOps()
+	if ops > r.maxSlowOps {
+		return false, fmt.Sprintf("%d slow ops found", ops), nil
+	}
+
+	return true, "", nil
+}
+
+func (r *Rebalancer) quorumGate(ctx context.Context) (bool, string, error) {
+	if !r.requireMonQuorum {
+		return true, "", nil
+	}
+
+	qs, err := r.ceph.QuorumStatus(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for mon quorum: %w", err)
+	}
+	if len(qs.Quorum) < len(qs.MonMap.Mons) {
+		return false, fmt.Sprintf("not all mons are in quorum: %s", strings.Join(qs.QuorumNames, ",")), nil
+	}
+
+	return true, "", nil
+}
+
+func (r *Rebalancer) downOSDsGate(ctx context.Context) (bool, string, error) {
+	if r.maxDownOSDs < 0 {
+		return true, "", nil
+	}
+
+	down, err := r.downOSDs(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for down osds: %w", err)
+	}
+	if len(down) > r.maxDownOSDs {
+		return false, fmt.Sprintf("down osds found: %v", down), nil
+	}
+
+	return true, "", nil
+}
+
+func (r *Rebalancer) heartbeatLatencyGate(ctx context.Context) (bool, string, error) {
+	if r.maxHeartbeatLatency <= 0 {
+		return true, "", nil
+	}
+
+	latency, err := r.heartbeatLatency(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for osd heartbeat latency: %w", err)
+	}
+	if latency > r.maxHeartbeatLatency {
+		return false, fmt.Sprintf("high osd heartbeat latency of %s found", latency), nil
+	}
+
+	return true, "", nil
+}
+
+func (r *Rebalancer) osdLatencyGate(ctx context.Context) (bool, string, error) {
+	if r.maxOSDLatency <= 0 {
+		return true, "", nil
+	}
+
+	latency, err := r.osdLatency(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for osd latency: %w", err)
+	}
+	if latency > r.maxOSDLatency {
+		return false, fmt.Sprintf("high p%g osd latency of %s found", r.osdLatencyPercentile, latency), nil
+	}
+
+	return true, "", nil
+}
+
+func (r *Rebalancer) autoscalingGate(ctx context.Context) (bool, string, error) {
+	if !r.pauseOnPGAutoscaling {
+		return true, "", nil
+	}
+
+	resizing, err := r.resizingPools(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for pools being resized: %w", err)
+	}
+	if len(resizing) > 0 {
+		return false, fmt.Sprintf("pools are changing pg_num: %s", strings.Join(resizing, ",")), nil
+	}
+
+	return true, "", nil
+}
+
+func (r *Rebalancer) promQLGate(ctx context.Context) (bool, string, error) {
+	for _, q := range r.promQLQueries {
+		if ok, reason, err := q.Evaluate(ctx); err != nil || !ok {
+			return ok, reason, err
+		}
+	}
+
+	return true, "", nil
+}
+
+func (r *Rebalancer) alertmanagerGate(ctx context.Context) (bool, string, error) {
+	for _, q := range r.alertmanagerQueries {
+		if ok, reason, err := q.Evaluate(ctx); err != nil || !ok {
+			return ok, reason, err
+		}
+	}
+
+	return true, "", nil
+}
+
+func (r *Rebalancer) hookGate(ctx context.Context) (bool, string, error) {
+	for _, hook := range r.gateHooks {
+		ok, output, err := r.runGateHook(ctx, hook)
+		if err != nil {
+			return false, "", fmt.Errorf("failed running gate hook %s: %s: %s", hook, err, output)
+		}
+		if !ok {
+			return false, fmt.Sprintf("gate hook %s failed: %s", hook, output), nil
+		}
+	}
+
+	return true, "", nil
+}
+
+func (r *Rebalancer) backfillBytesGate(ctx context.Context) (bool, string, error) {
+	if r.maxBackfillBytes <= 0 {
+		return true, "", nil
+	}
+
+	bytes, err := r.backfillBytes(ctx)
+	if err != nil {
+		return false, "", fmt.Errorf("failed checking for bytes queued for backfill: %w", err)
+	}
+	if bytes > r.maxBackfillBytes {
+		return false, fmt.Sprintf("too much data queued for backfill, %d bytes", bytes), nil
+	}
+
+	return true, "", nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/digitalocean/archimedes/gates"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithGates(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		gates []gates.Gate
+
+		reweightCount int
+	}{
+		{
+			name: "Open",
+			gates: []gates.Gate{
+				gates.GateFunc(func(ctx context.Context) (bool, string, error) { return true, "", nil }),
+			},
+			reweightCount: 1,
+		},
+		{
+			name: "Closed",
+			gates: []gates.Gate{
+				gates.GateFunc(func(ctx context.Context) (bool, string, error) { return true, "", nil }),
+				gates.GateFunc(func(ctx context.Context) (bool, string, error) { return false, "closed", nil }),
+			},
+			reweightCount: 0,
+		},
+		{
+			name: "Error",
+			gates: []gates.Gate{
+				gates.GateFunc(func(ctx context.Context) (bool, string, error) { return false, "", errors.New("boom") }),
+			},
+			reweightCount: 0,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := cephtest.New(cephtest.State{
+				OSDTree: &cephclient.OSDTreeOut{
+					Nodes: []cephclient.OSDTreeNode{
+						{ID: 1, Type: "osd"},
+					},
+				},
+			})
+			defer tc.Close()
+
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+				WithDryRun(false),
+				WithGates(tt.gates...),
+			)
+			if err != nil {
+				t.Fatalf("failed initializing rebalancer")
+			}
+
+			r.DoReweight()
+			assert.Len(t, tc.CallsTo("CrushReweight"), tt.reweightCount, "reweight count should match")
+		})
+	}
+}
+
+func TestIterationGates(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd"},
+			},
+		},
+	})
+	defer tc.Close()
+
+	closed := gates.GateFunc(func(ctx context.Context) (bool, string, error) { return false, "closed", nil })
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+		WithGates(closed, closed),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	res, err := r.RunOnce(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, res.Gates, len(r.builtinGates())+1, "gates after the first closed one should not be recorded") {
+		assert.Equal(t, "health", res.Gates[1].Name)
+		assert.True(t, res.Gates[1].OK)
+		assert.Equal(t, Check{Name: "custom gate", Reason: "closed"}, res.Gates[len(res.Gates)-1])
+	}
+	assert.Equal(t, res.Gates, r.Status().LastIteration.Gates)
+}
+
+func TestDegradedPGsGate(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		maxDegradedPGs int
+		degradedPGs    int
+
+		ok     bool
+		reason string
+	}{
+		{
+			name:           "Disabled",
+			maxDegradedPGs: -1,
+			degradedPGs:    20,
+			ok:             true,
+		},
+		{
+			name:           "Below",
+			maxDegradedPGs: 5,
+			degradedPGs:    5,
+			ok:             true,
+		},
+		{
+			name:           "Above",
+			maxDegradedPGs: 5,
+			degradedPGs:    6,
+			reason:         "6 degraded pgs found",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := cephtest.New(cephtest.State{
+				PGsByState: map[string]int{"active+undersized+degraded": tt.degradedPGs},
+			})
+			defer tc.Close()
+
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+				WithMaxDegradedPGs(tt.maxDegradedPGs),
+			)
+			if err != nil {
+				t.Fatalf("failed initializing rebalancer")
+			}
+
+			ok, reason, err := r.degradedPGsGate(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.ok, ok, "gate result should match")
+			assert.Equal(t, tt.reason, reason)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultGateHookTimeout = time.Minute
+
+// runGateHook executes the hook and reports whether it allows the
+// iteration to proceed, along with whatever the hook printed. A hook
+// that fails to run or exceeds the timeout blocks the iteration.
+//
+// The hook is handed the pending target OSDs through the
+// ARCHIMEDES_TARGET_OSDS environment variable as a comma separated
+// list, and ARCHIMEDES_DRY_RUN is set to either true or false.
+func (r *Rebalancer) runGateHook(ctx context.Context, path string) (bool, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.gateHookTimeout)
+	defer cancel()
+
+	osds := make([]int, 0, len(r.targetCrushWeightMap))
+	for osd := range r.targetCrushWeightMap {
+		osds = append(osds, osd)
+	}
+	sort.Ints(osds)
+
+	ids := make([]string, 0, len(osds))
+	for _, osd := range osds {
+		ids = append(ids, strconv.Itoa(osd))
+	}
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = append(os.Environ(),
+		"ARCHIMEDES_TARGET_OSDS="+strings.Join(ids, ","),
+		"ARCHIMEDES_DRY_RUN="+strconv.FormatBool(r.dryRun),
+	)
+
+	out, err := cmd.CombinedOutput()
+	output := strings.TrimSpace(string(out))
+	if ctx.Err() == context.DeadlineExceeded {
+		return false, output, fmt.Errorf("hook timed out after %s", r.gateHookTimeout)
+	}
+	if _, ok := err.(*exec.ExitError); ok {
+		return false, output, nil
+	}
+	if err != nil {
+		return false, output, err
+	}
+
+	return true, output, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunGateHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archimedes-hooks")
+	if err != nil {
+		t.Fatalf("failed creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		name   string
+		script string
+
+		ok     bool
+		output string
+		err    bool
+	}{
+		{
+			name:   "Pass",
+			script: "#!/bin/sh\necho \"osds=$ARCHIMEDES_TARGET_OSDS dry=$ARCHIMEDES_DRY_RUN\"\n",
+			ok:     true,
+			output: "osds=1,2 dry=true",
+		},
+		{
+			name:   "Block",
+			script: "#!/bin/sh\necho maintenance in progress\nexit 3\n",
+			ok:     false,
+			output: "maintenance in progress",
+		},
+		{
+			name:   "Timeout",
+			script: "#!/bin/sh\nexec sleep 5\n",
+			ok:     false,
+			err:    true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := ioutil.WriteFile(path, []byte(tt.script), 0755); err != nil {
+				t.Fatalf("failed writing hook: %s", err)
+			}
+
+			r, err := New(
+				WithCephClient(cephtest.New(cephtest.State{})),
+				WithTargetCrushWeightMap(map[int]float64{2: 1.0, 1: 1.0}),
+				WithGateHookTimeout(100*time.Millisecond),
+			)
+			if err != nil {
+				t.Fatalf("failed initializing rebalancer")
+			}
+
+			ok, output, err := r.runGateHook(context.Background(), path)
+			assert.Equal(t, tt.err, err != nil, "error should match")
+			assert.Equal(t, tt.ok, ok, "hook result should match")
+			if !tt.err {
+				assert.Equal(t, tt.output, output, "hook output should match")
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"math"
+)
+
+const defaultImpactScoreSamples = 3
+
+// impactScore rates how disruptive the campaign currently is as the
+// highest ratio of backfilling PGs, misplaced objects and OSD latency
+// to their configured thresholds. A score of 1 means that one of them
+// is right at its threshold. Metrics without a threshold are left out.
+func (r *Rebalancer) impactScore(ctx context.Context) (float64, error) {
+	var score float64
+
+	if r.maxBackfillPGsAllowed > 0 {
+		bpgs, err := r.backfillingPGs(ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+		score = math.Max(score, float64(bpgs)/float64(r.maxBackfillPGsAllowed))
+	}
+
+	if r.maxMisplacedRatio > 0 {
+		status, err := r.clusterStatus(ctx)
+		if err != nil {
+			return 0, err
+		}
+		ratio := status.MisplacedRatio()
+		score = math.Max(score, ratio/r.maxMisplacedRatio)
+	}
+
+	if r.maxOSDLatency > 0 {
+		latency, err := r.osdLatency(ctx)
+		if err != nil {
+			return 0, err
+		}
+		score = math.Max(score, float64(latency)/float64(r.maxOSDLatency))
+	}
+
+	return score, nil
+}
+
+// scoreImpact samples the impact of the previous increments, and
+// halves the weight increment once the score stayed above
+// `maxImpactScore` for `impactScoreSamples` samples in a row.
+func (r *Rebalancer) scoreImpact(ctx context.Context) {
+	// Nothing to rate before the first increment.
+	if r.iterations == 0 {
+		return
+	}
+
+	score, err := r.impactScore(ctx)
+	if err != nil {
+		r.log().WithError(err).Warn("failed sampling impact score")
+		return
+	}
+	r.lastImpactScore = score
+
+	ll := r.log().WithField("impact.score", score)
+	ll.Info("sampled impact of previous increments")
+
+	if r.maxImpactScore <= 0 || score <= r.maxImpactScore {
+		r.highImpactSamples = 0
+		return
+	}
+
+	r.highImpactSamples++
+	if r.highImpactSamples < r.impactScoreSamples {
+		return
+	}
+
+	r.highImpactSamples = 0
+	if r.weightIncrement/2 < r.weightStep() {
+		ll.WithField("inc", r.weightIncrement).Warn("sustained high impact, but the weight increment cannot be lowered any further")
+		return
+	}
+	r.weightIncrement /= 2
+	ll.WithField("inc", r.weightIncrement).Warn("sustained high impact, slowing down by halving the weight increment")
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImpactScore(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		MisplacedRatio: 0.06,
+		OSDPerf: &cephclient.OSDPerfOut{
+			OSDPerfInfos: []cephclient.OSDPerfInfo{
+				newTestOSDPerfInfo(1, 10, 10),
+			},
+		},
+		PGsByState: map[string]int{"active+remapped+backfilling": 5},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+		WithMaxBackfillPGsAllowed(10),
+		WithMaxMisplacedRatio(0.05),
+		WithMaxOSDLatency(20*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	score, err := r.impactScore(context.Background())
+	assert.NoError(t, err)
+	assert.InDelta(t, 1.2, score, 1e-9, "misplaced ratio should dominate the score")
+
+	tc.Update(func(s *cephtest.State) {
+		s.OSDPerf.OSDPerfInfos[0] = newTestOSDPerfInfo(1, 50, 10)
+	})
+	score, err = r.impactScore(context.Background())
+	assert.NoError(t, err)
+	assert.InDelta(t, 2.5, score, 1e-9, "osd latency should dominate the score")
+}
+
+func TestImpactSlowdown(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		PGsByState: map[string]int{"active+remapped+backfilling": 20},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+		WithWeightIncrement(0.4),
+		WithMaxBackfillPGsAllowed(10),
+		WithMaxImpactScore(1.5),
+		WithImpactScoreSamples(2),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	r.scoreImpact(context.Background())
+	assert.Zero(t, r.lastImpactScore, "impact should not be sampled before the first increment")
+
+	r.iterations = 1
+	r.scoreImpact(context.Background())
+	assert.Equal(t, 2.0, r.lastImpactScore)
+	assert.Equal(t, 0.4, r.weightIncrement, "a single high sample should not slow down")
+
+	r.scoreImpact(context.Background())
+	assert.Equal(t, 0.2, r.weightIncrement, "sustained high impact should halve the increment")
+
+	setPGs(tc, "active+remapped+backfilling", 5)
+	r.scoreImpact(context.Background())
+	setPGs(tc, "active+remapped+backfilling", 20)
+	r.scoreImpact(context.Background())
+	assert.Equal(t, 0.2, r.weightIncrement, "samples should have to be consecutive")
+}
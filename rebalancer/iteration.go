@@ -0,0 +1,148 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import "context"
+
+// IterationResult describes what a single iteration did.
+type IterationResult struct {
+	// Reweights maps the OSDs reweighted to the weight applied, or
+	// the weight that would have been applied in a dry run.
+	Reweights map[int]float64
+
+	// Order lists the target OSDs in the order they were visited in.
+	Order []int
+
+	// Completed lists the OSDs that finished reweighting.
+	Completed []int
+
+	// Skipped maps the OSDs given up on without finishing to the
+	// reason why.
+	Skipped map[int]string
+
+	// SkipReason tells why the iteration didn't reweight at all,
+	// e.g. a closed gate. It is empty when the iteration went on.
+	SkipReason string
+
+	// Gates lists the outcome of the gates evaluated by the
+	// iteration, in order, up to the first closed one.
+	Gates []Check
+
+	// Done reports whether all target OSDs had been processed
+	// already, i.e. there is nothing left for further iterations.
+	Done bool
+}
+
+func newIterationResult() *IterationResult {
+	return &IterationResult{
+		Reweights: make(map[int]float64),
+		Skipped:   make(map[int]string),
+	}
+}
+
+// copy returns a deep copy of the result.
+func (res *IterationResult) copy() *IterationResult {
+	c := *res
+	c.Reweights = make(map[int]float64, len(res.Reweights))
+	for osd, w := range res.Reweights {
+		c.Reweights[osd] = w
+	}
+	c.Order = append([]int(nil), res.Order...)
+	c.Gates = append([]Check(nil), res.Gates...)
+	c.Completed = append([]int(nil), res.Completed...)
+	c.Skipped = make(map[int]string, len(res.Skipped))
+	for osd, reason := range res.Skipped {
+		c.Skipped[osd] = reason
+	}
+	return &c
+}
+
+// RunOnce performs a single gated iteration and reports what it did,
+// for callers that schedule iterations on their own rather than
+// through Run. Unlike Run, it neither checks nor restores the Ceph
+// balancer and recovery options around the iterations. The returned
+// error tells that reweighting was aborted and no further iteration
+// should be run.
+func (r *Rebalancer) RunOnce(ctx context.Context) (*IterationResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.publish()
+
+	res := r.runOnce(withSharedStatus(ctx))
+	if r.abortErr != nil {
+		return res, r.abort()
+	}
+	return res, nil
+}
+
+// runOnce performs a single iteration, unless all target OSDs have
+// been processed or reweighting is outside of active hours.
+func (r *Rebalancer) runOnce(ctx context.Context) *IterationResult {
+	r.iteration = newIterationResult()
+
+	if len(r.targetCrushWeightMap) <= 0 {
+		r.log().Info("all given osds completed reweighting")
+		if r.enableCephBalancer && !r.dryRun {
+			r.log().Info("enabling the Ceph balancer")
+			err := r.ceph.EnableCephBalancer(ctx)
+			if err != nil {
+				r.log().WithError(err).Warn("failed to enable the Ceph balancer after upweight completion")
+			}
+			r.disabledBalancer = false
+		}
+		r.iteration.Done = true
+		return r.iteration
+	}
+
+	if r.Paused() {
+		r.skipIteration("paused")
+		return r.iteration
+	}
+
+	// Stay idle outside of active hours rather than evaluating
+	// every gate only to have the iteration skipped.
+	if !r.rollingBack && !r.inActiveHours(r.now()) {
+		if !r.suspended {
+			r.log().Info("outside of active hours, suspending reweighting")
+			r.suspended = true
+		}
+		r.skipIteration("outside of active hours")
+		return r.iteration
+	}
+	if r.suspended {
+		r.log().Info("active hours started, resuming reweighting")
+		r.suspended = false
+	}
+
+	r.reweight(ctx)
+	return r.iteration
+}
+
+// recordReweight records a reweight of the current iteration.
+func (r *Rebalancer) recordReweight(osd int, weight float64) {
+	r.recordPreRunWeight(osd)
+	if r.iteration != nil {
+		r.iteration.Reweights[osd] = weight
+	}
+	r.emit(ReweightApplied{OSD: osd, Weight: weight, DryRun: r.dryRun})
+}
+
+// skipIteration records why the current iteration didn't reweight.
+func (r *Rebalancer) skipIteration(reason string) {
+	if r.iteration != nil {
+		r.iteration.SkipReason = reason
+	}
+	r.emit(IterationSkipped{Reason: reason})
+}
@@ -0,0 +1,136 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunOnce(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd", CrushWeight: 0.5},
+				{ID: 2, Type: "osd", CrushWeight: 1},
+			},
+		},
+		PGsByState: map[string]int{"active+remapped+backfilling": 20},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1, 2: 1, 3: 1}),
+		WithWeightIncrement(0.5),
+		WithMaxBackfillPGsAllowed(10),
+		WithMaxMissingIterations(1),
+		WithDryRun(false),
+	)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	res, err := r.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, res.SkipReason, "a closed gate should skip the iteration")
+	assert.Empty(t, res.Reweights)
+	assert.False(t, res.Done)
+
+	setPGs(tc, "active+remapped+backfilling", 0)
+	res, err = r.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, res.SkipReason)
+	assert.Equal(t, map[int]float64{1: 1}, res.Reweights)
+	assert.Equal(t, []int{2}, res.Completed)
+	assert.Equal(t, map[int]string{3: "not found in the osd tree"}, res.Skipped)
+	assert.False(t, res.Done)
+
+	res, err = r.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, res.Reweights)
+	assert.Equal(t, []int{1}, res.Completed)
+	assert.False(t, res.Done)
+
+	res, err = r.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.True(t, res.Done, "nothing should be left to reweight")
+}
+
+func TestRunOnceMissingOSD(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd", CrushWeight: 0.5},
+			},
+		},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1, 2: 1, 3: 1}),
+		WithWeightIncrement(0.5),
+		WithMaxMissingIterations(3),
+		WithDryRun(false),
+	)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		res, err := r.RunOnce(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, res.Skipped, "missing osds should be retried")
+		assert.False(t, res.Done)
+	}
+
+	// osd.2 comes back before running out of retries, osd.3 does not.
+	tc.Update(func(s *cephtest.State) {
+		s.OSDTree.Nodes = append(s.OSDTree.Nodes, cephclient.OSDTreeNode{ID: 2, Type: "osd", CrushWeight: 0.5})
+	})
+	res, err := r.RunOnce(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]float64{2: 1}, res.Reweights)
+	assert.Equal(t, map[int]string{3: "not found in the osd tree"}, res.Skipped)
+	assert.Empty(t, r.missingOSDs)
+}
+
+func TestRunOnceOrder(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 12, Type: "osd"},
+				{ID: 3, Type: "osd"},
+				{ID: 7, Type: "osd"},
+			},
+		},
+	})
+	defer tc.Close()
+
+	for i := 0; i < 5; i++ {
+		r, err := New(
+			WithCephClient(tc),
+			WithTargetCrushWeightMap(map[int]float64{12: 1, 3: 1, 7: 1}),
+		)
+		assert.NoError(t, err)
+
+		res, err := r.RunOnce(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []int{3, 7, 12}, res.Order, "osds should be visited by ascending id")
+	}
+}
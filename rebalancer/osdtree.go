@@ -0,0 +1,147 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"sort"
+
+	"github.com/digitalocean/archimedes/cephclient"
+)
+
+// targetOSDTree returns the OSD tree holding every bucket but only
+// the target OSDs, reusing the last one for up to `osdTreeCacheTTL`.
+// The returned tree is shared and must not be modified.
+func (r *Rebalancer) targetOSDTree(ctx context.Context) (*cephclient.OSDTreeOut, error) {
+	if r.cachedTree != nil && r.now().Sub(r.cachedTreeAt) < r.osdTreeCacheTTL {
+		return r.cachedTree, nil
+	}
+
+	tree, err := r.queryTargetOSDTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if r.osdTreeCacheTTL > 0 {
+		r.cachedTree, r.cachedTreeAt = tree, r.now()
+	}
+	return tree, nil
+}
+
+// queryTargetOSDTree queries the OSD tree holding the target OSDs.
+// Once the targets are known to share a bucket below the root, only
+// that bucket is queried, with the buckets above it taken from the
+// last whole tree. The whole tree is queried again whenever a target
+// can't be found underneath the bucket anymore.
+func (r *Rebalancer) queryTargetOSDTree(ctx context.Context) (*cephclient.OSDTreeOut, error) {
+	osds := make([]int, 0, len(r.targetCrushWeightMap))
+	for osd := range r.targetCrushWeightMap {
+		osds = append(osds, osd)
+	}
+	sort.Ints(osds)
+
+	if r.treeBucket != "" {
+		tree, err := r.ceph.OSDTreeFrom(ctx, r.treeBucket, osds)
+		if err == nil && treeHoldsOSDs(tree, osds) {
+			tree.Nodes = append(append([]cephclient.OSDTreeNode(nil), r.treeAncestors...), tree.Nodes...)
+			return tree, nil
+		}
+		if err != nil {
+			r.log().WithError(err).WithField("bucket", r.treeBucket).Warn("failed to get output of osd-tree-from, falling back to osd-tree")
+		}
+		r.treeBucket, r.treeAncestors = "", nil
+	}
+
+	tree, err := r.ceph.OSDTreeOf(ctx, osds)
+	if err != nil {
+		return nil, err
+	}
+	r.treeBucket, r.treeAncestors = r.commonBucket(tree, osds)
+	return tree, nil
+}
+
+// commonBucket returns the name of the lowest bucket holding all the
+// given OSDs along with the buckets above it, from the root down. The
+// bucket holds the whole failure domain of each OSD, so that the OSDs
+// sharing a failure domain with a target are found underneath it. No
+// bucket is returned when only the root holds all the OSDs.
+func (r *Rebalancer) commonBucket(tree *cephclient.OSDTreeOut, osds []int) (string, []cephclient.OSDTreeNode) {
+	if len(osds) == 0 {
+		return "", nil
+	}
+
+	nodes := make(map[int]cephclient.OSDTreeNode, len(tree.Nodes))
+	parents := make(map[int]int)
+	for _, node := range tree.Nodes {
+		nodes[node.ID] = node
+		for _, child := range node.Children {
+			parents[child] = node.ID
+		}
+	}
+
+	// ancestry lists the buckets above the OSD, from the bottom up,
+	// leaving out those below its failure domain.
+	ancestry := func(osd int) []int {
+		var path []int
+		for id, ok := parents[osd]; ok; id, ok = parents[id] {
+			if nodes[id].Type == r.failureDomain {
+				path = path[:0]
+			}
+			path = append(path, id)
+		}
+		return path
+	}
+
+	holding := make(map[int]int)
+	for _, osd := range osds {
+		for _, id := range ancestry(osd) {
+			holding[id]++
+		}
+	}
+
+	path := ancestry(osds[0])
+	for i, id := range path {
+		if holding[id] != len(osds) {
+			continue
+		}
+		if i == len(path)-1 {
+			break
+		}
+
+		ancestors := make([]cephclient.OSDTreeNode, 0, len(path)-i-1)
+		for j := len(path) - 1; j > i; j-- {
+			ancestors = append(ancestors, nodes[path[j]])
+		}
+		return nodes[id].Name, ancestors
+	}
+
+	return "", nil
+}
+
+// treeHoldsOSDs reports whether every given OSD is part of the tree.
+func treeHoldsOSDs(tree *cephclient.OSDTreeOut, osds []int) bool {
+	found := make(map[int]bool, len(osds))
+	for _, node := range tree.Nodes {
+		if node.Type == "osd" {
+			found[node.ID] = true
+		}
+	}
+
+	for _, osd := range osds {
+		if !found[osd] {
+			return false
+		}
+	}
+	return true
+}
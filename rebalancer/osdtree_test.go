@@ -0,0 +1,190 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTargetOSDTree(t *testing.T) {
+	tree := &cephclient.OSDTreeOut{
+		Nodes: []cephclient.OSDTreeNode{
+			{ID: -1, Name: "default", Type: "root", Children: []int{-2, -3}},
+			{ID: -2, Name: "rack1", Type: "rack", Children: []int{-4, -5}},
+			{ID: -3, Name: "rack2", Type: "rack", Children: []int{-6}},
+			{ID: -4, Name: "host-a", Type: "host", Children: []int{1, 2}},
+			{ID: -5, Name: "host-b", Type: "host", Children: []int{3}},
+			{ID: -6, Name: "host-c", Type: "host", Children: []int{4}},
+			{ID: 1, Name: "osd.1", Type: "osd", CrushWeight: 1},
+			{ID: 2, Name: "osd.2", Type: "osd", CrushWeight: 1},
+			{ID: 3, Name: "osd.3", Type: "osd", CrushWeight: 1},
+			{ID: 4, Name: "osd.4", Type: "osd", CrushWeight: 1},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		targets       []int
+		failureDomain string
+		wantBucket    string
+		wantAncestors []int
+	}{
+		{
+			name:          "single host",
+			targets:       []int{1, 2},
+			failureDomain: "host",
+			wantBucket:    "host-a",
+			wantAncestors: []int{-1, -2},
+		},
+		{
+			name:          "single rack",
+			targets:       []int{1, 3},
+			failureDomain: "host",
+			wantBucket:    "rack1",
+			wantAncestors: []int{-1},
+		},
+		{
+			name:          "failure domain above the common host",
+			targets:       []int{1, 2},
+			failureDomain: "rack",
+			wantBucket:    "rack1",
+			wantAncestors: []int{-1},
+		},
+		{
+			name:          "only the root is common",
+			targets:       []int{1, 4},
+			failureDomain: "host",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets := make(map[int]float64)
+			for _, osd := range tt.targets {
+				targets[osd] = 2
+			}
+			c := cephtest.New(cephtest.State{
+				OSDTree: tree,
+			})
+			r := &Rebalancer{ceph: c, targetCrushWeightMap: targets, failureDomain: tt.failureDomain}
+
+			for i := 0; i < 2; i++ {
+				got, err := r.targetOSDTree(context.Background())
+				assert.NoError(t, err)
+				assert.Equal(t, tt.targets, osdIDsIn(got), "the tree should hold the targets")
+				assert.Equal(t, r.extractAncestors(tree, tt.failureDomain), r.extractAncestors(got, tt.failureDomain))
+			}
+
+			var ancestors []int
+			for _, node := range r.treeAncestors {
+				ancestors = append(ancestors, node.ID)
+			}
+			assert.Equal(t, tt.wantBucket, r.treeBucket)
+			assert.Equal(t, tt.wantAncestors, ancestors)
+			if tt.wantBucket != "" {
+				assert.Equal(t, []string{tt.wantBucket}, treeFromBuckets(c), "the second tree should be scoped to the bucket")
+			} else {
+				assert.Empty(t, treeFromBuckets(c))
+			}
+		})
+	}
+
+	t.Run("target moved out of the bucket", func(t *testing.T) {
+		c := cephtest.New(cephtest.State{
+			OSDTree: tree,
+		})
+		r := &Rebalancer{
+			ceph:                 c,
+			targetCrushWeightMap: map[int]float64{1: 2, 4: 2},
+			failureDomain:        "host",
+			treeBucket:           "host-a",
+			treeAncestors:        tree.Nodes[:2],
+		}
+
+		got, err := r.targetOSDTree(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1, 4}, osdIDsIn(got))
+		assert.Equal(t, []string{"host-a"}, treeFromBuckets(c))
+		assert.Empty(t, r.treeBucket, "the whole tree should be queried again")
+	})
+}
+
+// treeFromBuckets returns the buckets c was asked to scope the osd tree to, in order.
+func treeFromBuckets(c *cephtest.Client) []string {
+	var buckets []string
+	for _, call := range c.CallsTo("OSDTreeFrom") {
+		buckets = append(buckets, call.Args[0].(string))
+	}
+	return buckets
+}
+
+// osdIDsIn returns the IDs of the OSDs in the tree, in order.
+func osdIDsIn(tree *cephclient.OSDTreeOut) []int {
+	var osds []int
+	for _, node := range tree.Nodes {
+		if node.Type == "osd" {
+			osds = append(osds, node.ID)
+		}
+	}
+	sort.Ints(osds)
+	return osds
+}
+
+func TestOSDTreeCache(t *testing.T) {
+	clock := newFakeClock(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+	c := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: -1, Name: "default", Type: "root", Children: []int{1}},
+				{ID: 1, Name: "osd.1", Type: "osd", CrushWeight: 1},
+			},
+		},
+	})
+	r := &Rebalancer{
+		ceph:                 c,
+		targetCrushWeightMap: map[int]float64{1: 2},
+		crushWeightMap:       make(map[int]float64),
+		osdTreeCacheTTL:      time.Minute,
+		clock:                clock,
+	}
+
+	first, err := r.targetOSDTree(context.Background())
+	assert.NoError(t, err)
+
+	clock.Advance(30 * time.Second)
+	got, err := r.targetOSDTree(context.Background())
+	assert.NoError(t, err)
+	assert.Same(t, first, got, "the tree should be reused within the ttl")
+
+	clock.Advance(time.Minute)
+	got, err = r.targetOSDTree(context.Background())
+	assert.NoError(t, err)
+	assert.NotSame(t, first, got, "the tree should be queried again after the ttl")
+
+	first = got
+	r.applyReweights(context.Background(), first, []plannedReweight{{osd: 1, weight: 1.5, ll: log.WithField("osd", 1)}})
+	got, err = r.targetOSDTree(context.Background())
+	assert.NoError(t, err)
+	assert.NotSame(t, first, got, "the tree should be queried again after a reweight")
+	assert.Equal(t, 1.5, got.Nodes[1].CrushWeight)
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// changedExternally reports whether the weight observed for an OSD
+// differs from the one last applied to it, meaning someone else,
+// e.g. another admin or the Ceph balancer, changed it since. Such
+// OSDs are paused rather than fighting over their weight, until
+// either the weight is changed back or ResumeOSD is called.
+func (r *Rebalancer) changedExternally(osd int, cw float64, ll log.FieldLogger) bool {
+	w, started := r.crushWeightMap[osd]
+	if !started || r.weightsEqual(w, cw) {
+		delete(r.pausedOSDs, osd)
+		return false
+	}
+
+	ll = ll.WithField("applied.weight", w).WithField("current.weight", cw)
+	if observed, paused := r.pausedOSDs[osd]; paused && r.weightsEqual(observed, cw) {
+		ll.Info("skipping reweight, osd is paused after its weight was changed externally")
+		return true
+	}
+
+	if r.pausedOSDs == nil {
+		r.pausedOSDs = make(map[int]float64)
+	}
+	r.pausedOSDs[osd] = cw
+	ll.Warn("weight of osd was changed externally, pausing it")
+	r.emit(WeightChanged{OSD: osd, Applied: w, Observed: cw})
+	return true
+}
+
+// PausedOSDs returns the OSDs paused because their weight was
+// changed externally, along with the weight observed for them.
+func (r *Rebalancer) PausedOSDs() map[int]float64 {
+	return copyWeights(r.currentView().pausedOSDs)
+}
+
+// ResumeOSD resumes reweighting an OSD paused because its weight was
+// changed externally, carrying on from the weight observed for it.
+// It reports whether the OSD was paused.
+func (r *Rebalancer) ResumeOSD(osd int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.publish()
+
+	observed, ok := r.pausedOSDs[osd]
+	if !ok {
+		return false
+	}
+	r.crushWeightMap[osd] = observed
+	delete(r.pausedOSDs, osd)
+
+	r.log().WithField("osd", osd).WithField("weight", observed).Info("resumed osd")
+	return true
+}
+
+// Pause stops reweighting until Resume is called, e.g. during an
+// incident. Iterations keep being scheduled but skip reweighting,
+// and the progress made so far is kept. Pause doesn't wait for an
+// iteration in progress, which still finishes its reweights.
+func (r *Rebalancer) Pause() {
+	r.viewMu.Lock()
+	defer r.viewMu.Unlock()
+
+	if !r.paused {
+		r.log().Info("reweighting paused")
+	}
+	r.paused = true
+}
+
+// Resume carries on with reweighting paused by Pause from the next
+// iteration on.
+func (r *Rebalancer) Resume() {
+	r.viewMu.Lock()
+	defer r.viewMu.Unlock()
+
+	if r.paused {
+		r.log().Info("reweighting resumed")
+	}
+	r.paused = false
+}
+
+// Paused reports whether reweighting is paused by Pause.
+func (r *Rebalancer) Paused() bool {
+	r.viewMu.Lock()
+	defer r.viewMu.Unlock()
+
+	return r.paused
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExternalWeightChange(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd", CrushWeight: 0},
+				{ID: 2, Type: "osd", CrushWeight: 0},
+			},
+		},
+	})
+	defer tc.Close()
+
+	var events []Event
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 2, 2: 2}),
+		WithWeightIncrement(0.5),
+		WithEventHandler(func(e Event) {
+			if _, ok := e.(WeightChanged); ok {
+				events = append(events, e)
+			}
+		}),
+		WithDryRun(false),
+	)
+	assert.NoError(t, err)
+
+	r.DoReweight()
+	assert.Equal(t, map[int]float64{1: 0.5, 2: 0.5}, tc.CrushWeights())
+
+	// Someone else changes both weights.
+	tc.Update(func(s *cephtest.State) {
+		s.OSDTree.Nodes[0].CrushWeight = 1.2
+		s.OSDTree.Nodes[1].CrushWeight = 0.8
+	})
+	r.DoReweight()
+	assert.Equal(t, map[int]float64{1: 1.2, 2: 0.8}, r.PausedOSDs())
+	assert.ElementsMatch(t, []Event{
+		WeightChanged{OSD: 1, Applied: 0.5, Observed: 1.2},
+		WeightChanged{OSD: 2, Applied: 0.5, Observed: 0.8},
+	}, events)
+
+	r.DoReweight()
+	assert.Len(t, events, 2, "paused osds should only be reported once")
+	assert.Len(t, tc.CallsTo("CrushReweight"), 2, "paused osds should not be reweighted")
+
+	// osd.1 is resumed from the observed weight, osd.2 is changed back.
+	assert.True(t, r.ResumeOSD(1))
+	assert.False(t, r.ResumeOSD(3))
+	tc.Update(func(s *cephtest.State) { s.OSDTree.Nodes[1].CrushWeight = 0.5 })
+	r.DoReweight()
+	assert.Empty(t, r.PausedOSDs())
+	assert.Equal(t, map[int]float64{1: 1.7, 2: 1}, tc.CrushWeights())
+}
+
+func TestPauseResume(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd", CrushWeight: 1},
+			},
+		},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 2}),
+		WithWeightIncrement(0.5),
+		WithDryRun(false),
+	)
+	assert.NoError(t, err)
+
+	r.Pause()
+	assert.True(t, r.Paused())
+	assert.True(t, r.Status().Paused)
+
+	res, err := r.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "paused", res.SkipReason)
+	assert.Len(t, tc.CallsTo("CrushReweight"), 0, "paused iterations should not reweight")
+
+	r.Resume()
+	assert.False(t, r.Paused())
+
+	res, err = r.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]float64{1: 1.5}, res.Reweights)
+	assert.Len(t, tc.CallsTo("CrushReweight"), 1)
+}
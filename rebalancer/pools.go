@@ -0,0 +1,134 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"strings"
+)
+
+// targetPools returns the IDs of the pools whose CRUSH rules take
+// from a bucket that holds at least one of the target OSDs.
+func (r *Rebalancer) targetPools(ctx context.Context) (map[int]bool, error) {
+	tree, err := r.targetOSDTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := r.ceph.CrushRuleDump(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	od, err := r.ceph.OSDDump(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parents := make(map[int]int)
+	names := make(map[int]string, len(tree.Nodes))
+	for _, node := range tree.Nodes {
+		names[node.ID] = node.Name
+		for _, child := range node.Children {
+			parents[child] = node.ID
+		}
+	}
+
+	// Every bucket above a target OSD, and the OSD itself, is a
+	// possible starting point of a rule placing data on it.
+	holders := make(map[string]bool)
+	for osd := range r.targetCrushWeightMap {
+		id, ok := osd, true
+		for ok {
+			holders[names[id]] = true
+			id, ok = parents[id]
+		}
+	}
+
+	rulesInUse := make(map[int]bool)
+	for _, rule := range rules.Rules {
+		for _, step := range rule.Steps {
+			if step.Op != "take" {
+				continue
+			}
+
+			// Device class rules take from shadow buckets such as
+			// `default~hdd` which aren't part of the regular tree.
+			name := strings.SplitN(step.ItemName, "~", 2)[0]
+			if holders[name] {
+				rulesInUse[rule.RuleID] = true
+			}
+		}
+	}
+
+	pools := make(map[int]bool)
+	for _, pool := range od.Pools {
+		if rulesInUse[pool.CrushRule] {
+			pools[pool.Pool] = true
+		}
+	}
+
+	return pools, nil
+}
+
+// countPGsInPools counts the PGs that belong to one of the given
+// pools and are in any of the given states.
+func (r *Rebalancer) countPGsInPools(ctx context.Context, pools map[int]bool, states ...string) (int, error) {
+	out, err := r.ceph.PGDump(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	for _, pg := range out.PGStats {
+		pool, err := pg.Pool()
+		if err != nil || !pools[pool] {
+			continue
+		}
+
+		for _, state := range states {
+			if strings.Contains(pg.State, state) {
+				count++
+				break
+			}
+		}
+	}
+
+	return count, nil
+}
+
+// resizingPools returns the names of the pools whose PG or PGP count
+// has not yet reached its target, e.g. while the PG autoscaler is
+// splitting or merging PGs.
+func (r *Rebalancer) resizingPools(ctx context.Context) ([]string, error) {
+	od, err := r.ceph.OSDDump(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var resizing []string
+	for _, pool := range od.Pools {
+		// Releases before Nautilus do not report targets at all.
+		if pool.PGNumTarget > 0 && pool.PGNum != pool.PGNumTarget {
+			resizing = append(resizing, pool.PoolName)
+			continue
+		}
+		if pool.PGPNumTarget > 0 && pool.PGPNum != pool.PGPNumTarget {
+			resizing = append(resizing, pool.PoolName)
+		}
+	}
+
+	return resizing, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolAwarePGCounts(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: -1, Name: "default", Type: "root", Children: []int{-3}},
+				{ID: -2, Name: "archive", Type: "root", Children: []int{-4}},
+				{ID: -3, Name: "host-a", Type: "host", Children: []int{1}},
+				{ID: -4, Name: "host-b", Type: "host", Children: []int{2}},
+				{ID: 1, Name: "osd.1", Type: "osd"},
+				{ID: 2, Name: "osd.2", Type: "osd"},
+			},
+		},
+		CrushRuleDump: &cephclient.CrushRuleDumpOut{
+			Rules: []cephclient.CrushRule{
+				{RuleID: 0, Steps: []cephclient.CrushRuleStep{{Op: "take", ItemName: "default~hdd"}}},
+				{RuleID: 1, Steps: []cephclient.CrushRuleStep{{Op: "take", ItemName: "archive"}}},
+			},
+		},
+		OSDDump: &cephclient.OSDDumpOut{
+			Pools: []cephclient.PoolInfo{
+				{Pool: 1, CrushRule: 0},
+				{Pool: 2, CrushRule: 1},
+			},
+		},
+		PGDump: &cephclient.PGDumpOut{
+			PGStats: []cephclient.PGStat{
+				{PGID: "1.0", State: "active+remapped+backfilling"},
+				{PGID: "1.1", State: "active+recovery_wait"},
+				{PGID: "2.0", State: "active+remapped+backfill_wait"},
+				{PGID: "2.1", State: "active+remapped+backfill_wait"},
+			},
+		},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+		WithPoolAwarePGCounts(true),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	pools, err := r.targetPools(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]bool{1: true}, pools, "only the pool mapped to osd.1 should be found")
+
+	bpgs, err := r.backfillingPGs(context.Background(), pools)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, bpgs, "backfilling pgs of unrelated pools should be ignored")
+
+	rpgs, err := r.recoveringPGs(context.Background(), pools)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, rpgs)
+}
+
+func TestResizingPools(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDDump: &cephclient.OSDDumpOut{
+			Pools: []cephclient.PoolInfo{
+				{PoolName: "settled", PGNum: 64, PGNumTarget: 64, PGPNum: 64, PGPNumTarget: 64},
+				{PoolName: "splitting", PGNum: 96, PGNumTarget: 128, PGPNum: 96, PGPNumTarget: 128},
+				{PoolName: "remapping", PGNum: 128, PGNumTarget: 128, PGPNum: 100, PGPNumTarget: 128},
+				{PoolName: "luminous", PGNum: 64},
+			},
+		},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	resizing, err := r.resizingPools(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"splitting", "remapping"}, resizing)
+}
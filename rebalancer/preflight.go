@@ -0,0 +1,115 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/digitalocean/archimedes/gates"
+)
+
+// Check is the outcome of a single check run by Preflight, or of a
+// gate evaluated by an iteration.
+type Check struct {
+	// Name tells what was checked, e.g. "health" or "mon quorum".
+	Name string
+
+	// OK is true if the check would let reweighting start.
+	OK bool
+
+	// Reason tells why the check did not pass or, for a check that
+	// passed, anything worth noting about it.
+	Reason string
+
+	// Err is set if the check could not be run at all.
+	Err error
+}
+
+// Preflight runs the connection check, the Ceph balancer check and
+// every configured gate once, in the order an iteration evaluates
+// them, and reports the outcome of each. Unlike an iteration, it
+// doesn't stop at the first closed gate, and it neither changes
+// anything on the cluster nor aborts the Rebalancer.
+func (r *Rebalancer) Preflight(ctx context.Context) []Check {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ctx = withSharedStatus(ctx)
+	if _, err := r.clusterStatus(ctx); err != nil {
+		return []Check{{Name: "connection", Err: fmt.Errorf("failed getting cluster status: %w", err)}}
+	}
+	checks := []Check{{Name: "connection", OK: true}}
+	checks = append(checks, r.preflightBalancer(ctx))
+
+	// Gates that would abort the run only set the abort error,
+	// which must not carry over into an actual run.
+	abortErr := r.abortErr
+	defer func() { r.abortErr = abortErr }()
+
+	for _, g := range r.gates {
+		r.abortErr = nil
+		c := Check{Name: gateName(g)}
+		c.OK, c.Reason, c.Err = g.Evaluate(ctx)
+		if c.OK && r.abortErr != nil {
+			c.OK = false
+		}
+		if r.abortErr != nil {
+			c.Reason = r.abortErr.Error()
+		}
+		checks = append(checks, c)
+	}
+
+	return checks
+}
+
+// preflightBalancer checks the Ceph balancer the way checkBalancer
+// does, without disabling it.
+func (r *Rebalancer) preflightBalancer(ctx context.Context) Check {
+	c := Check{Name: "balancer"}
+	if r.balancerPolicy == BalancerPolicyIgnore {
+		c.OK = true
+		return c
+	}
+
+	status, err := r.ceph.BalancerStatus(ctx)
+	if err != nil {
+		c.Err = fmt.Errorf("failed checking the Ceph balancer: %w", err)
+		return c
+	}
+
+	c.OK = true
+	if status.Conflicts() {
+		if r.balancerPolicy == BalancerPolicyRefuse {
+			c.OK = false
+			c.Reason = fmt.Sprintf("the Ceph balancer is active in %s mode", status.Mode)
+		} else {
+			c.Reason = "the Ceph balancer will be disabled for the run"
+		}
+	}
+	return c
+}
+
+// gateName returns the name a gate is reported under.
+func gateName(g gates.Gate) string {
+	switch g := g.(type) {
+	case namedGate:
+		return g.name
+	case *expressionGate:
+		return fmt.Sprintf("expression %q", g.src)
+	default:
+		return "custom gate"
+	}
+}
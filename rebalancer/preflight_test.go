@@ -0,0 +1,65 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/digitalocean/archimedes/gates"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreflight(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		BalancerActive: true,
+		OSDDump:        &cephclient.OSDDumpOut{Flags: "norebalance"},
+		PGsByState:     map[string]int{"active+remapped+backfilling": 5},
+	})
+	defer tc.Close()
+
+	custom := gates.GateFunc(func(ctx context.Context) (bool, string, error) {
+		return true, "", nil
+	})
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+		WithMaxBackfillPGsAllowed(1),
+		WithBalancerPolicy(BalancerPolicyRefuse),
+		WithFlagPolicy(FlagPolicyAbort),
+		WithGates(custom),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer: %s", err)
+	}
+
+	checks := make(map[string]Check)
+	for _, c := range r.Preflight(context.Background()) {
+		checks[c.Name] = c
+	}
+
+	assert.True(t, checks["connection"].OK)
+	assert.False(t, checks["balancer"].OK, "active balancer should be refused")
+	assert.False(t, checks["flags"].OK, "conflicting flag should fail the check")
+	assert.Contains(t, checks["flags"].Reason, "norebalance")
+	assert.False(t, checks["pg states"].OK, "all gates should be evaluated")
+	assert.Equal(t, "5 backfilling pgs found", checks["pg states"].Reason)
+	assert.True(t, checks["mon quorum"].OK)
+	assert.True(t, checks["custom gate"].OK)
+	assert.Nil(t, r.abortErr, "preflight should not abort the rebalancer")
+	assert.Len(t, tc.CallsTo("CrushReweight"), 0)
+}
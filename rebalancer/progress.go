@@ -0,0 +1,148 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"math"
+)
+
+// OSDProgress describes how far an OSD got towards its target weight.
+type OSDProgress struct {
+	// Start is the weight the OSD had when first seen by an
+	// iteration.
+	Start float64
+
+	// Current is the weight the OSD was last seen with, or last
+	// reweighted to.
+	Current float64
+
+	// Target is the weight the OSD is being reweighted to.
+	Target float64
+
+	// Completed reports whether the OSD finished reweighting.
+	Completed bool
+}
+
+// Progress describes how far reweighting got.
+type Progress struct {
+	// OSDs holds the progress of every target OSD seen by an
+	// iteration so far.
+	OSDs map[int]OSDProgress
+
+	// Percent is the share of the total weight change, across all
+	// OSDs seen so far, that has been applied, from 0 to 100.
+	Percent float64
+}
+
+// Status describes the state of the rebalancer.
+type Status struct {
+	// Remaining maps the OSDs left to be reweighted to their target
+	// weight.
+	Remaining map[int]float64
+
+	// Progress tells how far reweighting got.
+	Progress Progress
+
+	// LastIteration is the outcome of the last iteration, nil until
+	// the first one has run.
+	LastIteration *IterationResult
+
+	// Paused reports whether reweighting is paused by Pause.
+	Paused bool
+}
+
+// copy returns a deep copy of the progress.
+func (p Progress) copy() Progress {
+	c := Progress{OSDs: make(map[int]OSDProgress, len(p.OSDs)), Percent: p.Percent}
+	for osd, op := range p.OSDs {
+		c.OSDs[osd] = op
+	}
+	return c
+}
+
+// Progress returns how far reweighting got.
+func (r *Rebalancer) Progress() Progress {
+	return r.currentView().progress.copy()
+}
+
+// Status returns the state of the rebalancer, for callers that want
+// to show how a run is going.
+func (r *Rebalancer) Status() Status {
+	v := r.currentView()
+	s := Status{
+		Remaining: copyWeights(v.remaining),
+		Progress:  v.progress.copy(),
+		Paused:    r.Paused(),
+	}
+	if v.iteration != nil {
+		s.LastIteration = v.iteration.copy()
+	}
+	return s
+}
+
+func (r *Rebalancer) currentProgress() Progress {
+	p := Progress{OSDs: make(map[int]OSDProgress, len(r.osdProgress))}
+
+	var moved, total float64
+	for osd, op := range r.osdProgress {
+		p.OSDs[osd] = op
+		moved += math.Abs(op.Current - op.Start)
+		total += math.Abs(op.Target - op.Start)
+	}
+
+	switch {
+	case total > 0:
+		p.Percent = math.Min(100*moved/total, 100)
+	case len(r.targetCrushWeightMap) == 0:
+		p.Percent = 100
+	}
+	return p
+}
+
+// trackWeights records the current weights of the target OSDs, as
+// found by an iteration.
+func (r *Rebalancer) trackWeights(cws map[int]float64) {
+	if r.osdProgress == nil {
+		r.osdProgress = make(map[int]OSDProgress)
+	}
+
+	for osd, cw := range cws {
+		op, seen := r.osdProgress[osd]
+		if !seen {
+			op.Start = cw
+		}
+		op.Current = cw
+		op.Target = r.targetCrushWeightMap[osd]
+		r.osdProgress[osd] = op
+	}
+}
+
+// trackReweight records the weight an OSD was reweighted to.
+func (r *Rebalancer) trackReweight(osd int, weight float64) {
+	if op, ok := r.osdProgress[osd]; ok {
+		op.Current = weight
+		r.osdProgress[osd] = op
+	}
+	r.publish()
+}
+
+// trackCompletion records that an OSD finished reweighting.
+func (r *Rebalancer) trackCompletion(osd int) {
+	if op, ok := r.osdProgress[osd]; ok {
+		op.Completed = true
+		r.osdProgress[osd] = op
+	}
+	r.publish()
+}
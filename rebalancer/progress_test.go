@@ -0,0 +1,73 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd", CrushWeight: 0},
+				{ID: 2, Type: "osd", CrushWeight: 2},
+			},
+		},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 2, 2: 1}),
+		WithWeightIncrement(0.5),
+		WithOKToStopPolicy(OKToStopPolicyIgnore),
+		WithDryRun(false),
+	)
+	assert.NoError(t, err)
+
+	s := r.Status()
+	assert.Equal(t, map[int]float64{1: 2, 2: 1}, s.Remaining)
+	assert.Empty(t, s.Progress.OSDs)
+	assert.Zero(t, s.Progress.Percent)
+	assert.Nil(t, s.LastIteration)
+
+	_, err = r.RunOnce(context.Background())
+	assert.NoError(t, err)
+
+	s = r.Status()
+	assert.Equal(t, map[int]OSDProgress{
+		1: {Start: 0, Current: 0.5, Target: 2},
+		2: {Start: 2, Current: 1.5, Target: 1},
+	}, s.Progress.OSDs)
+	assert.InDelta(t, 100.0/3, s.Progress.Percent, 1e-9, "1 out of 3 should be moved")
+	assert.Equal(t, map[int]float64{1: 0.5, 2: 1.5}, s.LastIteration.Reweights)
+
+	for i := 0; i < 4; i++ {
+		_, err = r.RunOnce(context.Background())
+		assert.NoError(t, err)
+	}
+
+	p := r.Progress()
+	assert.Equal(t, OSDProgress{Start: 0, Current: 2, Target: 2, Completed: true}, p.OSDs[1])
+	assert.Equal(t, OSDProgress{Start: 2, Current: 1, Target: 1, Completed: true}, p.OSDs[2])
+	assert.Equal(t, 100.0, p.Percent)
+	assert.Empty(t, r.Status().Remaining)
+}
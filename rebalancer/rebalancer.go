@@ -0,0 +1,1475 @@
+// Copyright 2020 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rebalancer gradually reweights Ceph OSDs to their target
+// CRUSH weights, one increment per iteration, for as long as the
+// gates let it.
+package rebalancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/gates"
+	"github.com/digitalocean/archimedes/internal/ratelimit"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	serviceName = "archimedes"
+)
+
+// Policies applied when OSD map flags that conflict with
+// reweighting, e.g. `norebalance`, are found set.
+const (
+	FlagPolicyIgnore = "ignore"
+	FlagPolicyPause  = "pause"
+	FlagPolicyAbort  = "abort"
+)
+
+// Policies applied when the Ceph balancer is found active
+// before reweighting starts.
+const (
+	BalancerPolicyIgnore  = "ignore"
+	BalancerPolicyRefuse  = "refuse"
+	BalancerPolicyDisable = "disable"
+)
+
+// Policies applied when `ceph osd ok-to-stop` fails for the OSDs
+// about to be downweighted before reweighting starts.
+const (
+	OKToStopPolicyIgnore = "ignore"
+	OKToStopPolicyWarn   = "warn"
+	OKToStopPolicyRefuse = "refuse"
+)
+
+// Policies applied to target OSDs which are down or out while they
+// are yet to be weighted up, as newly deployed OSDs often flap during
+// their first boot.
+const (
+	// DownOSDPolicyWait skips iterations until they are up and in.
+	DownOSDPolicyWait = "wait"
+	// DownOSDPolicySkip leaves them out of iterations until they are
+	// up and in, reweighting the other OSDs meanwhile.
+	DownOSDPolicySkip = "skip"
+	// DownOSDPolicyFail aborts the run.
+	DownOSDPolicyFail = "fail"
+)
+
+// Steps taken to complete the decommission of OSDs once drained to
+// zero weight and found safe to destroy. All but `none` mark the OSD
+// out first.
+const (
+	DrainCompletionNone   = "none"
+	DrainCompletionOut    = "out"
+	DrainCompletionRemove = "remove"
+	DrainCompletionPurge  = "purge"
+)
+
+// conflictingFlags are the OSD map flags that keep reweights
+// from taking effect or hide their impact. `noout` is not one of
+// them: it is routinely set during maintenance and leaves backfill
+// be.
+var conflictingFlags = []string{"norebalance", "norecover", "nobackfill"}
+
+// Scopes of OSDs that are checked for nearfull, backfillfull
+// or full state before every iteration.
+const (
+	FullScopeNone    = "none"
+	FullScopeAny     = "any"
+	FullScopeTargets = "targets"
+	FullScopeSubtree = "subtree"
+)
+
+// Rebalancer is responsible for performing data rebalancing
+// by control weight changes to OSDs.
+type Rebalancer struct {
+	// mu serializes iterations and changes to the run, and is held
+	// across calls to the cluster. Readers which mustn't wait for
+	// those go through the view under viewMu instead.
+	mu sync.Mutex
+
+	// viewMu guards the view, and paused, which pauses reweighting
+	// altogether.
+	viewMu sync.Mutex
+	view   view
+	paused bool
+
+	ceph cephclient.Client
+
+	maxBackfillPGsAllowed int
+	maxRecoveryPGsAllowed int
+	maxInactivePGsAllowed int
+	maxScrubbingPGs       int
+	maxSnapTrimPGs        int
+	maxBackfillBytes      int64
+	poolAwarePGCounts     bool
+	promQLQueries         []gates.PromQLQuery
+	alertmanagerQueries   []gates.AlertmanagerQuery
+	gateHooks             []string
+	gateHookTimeout       time.Duration
+	gateExpressions       []string
+	pauseOnPGAutoscaling  bool
+	maxMisplacedRatio     float64
+	maxDegradedObjects    int
+	maxDegradedPGs        int
+	maxSlowOps            int
+	maxDownOSDs           int
+	maxHeartbeatLatency   time.Duration
+	maxOSDLatency         time.Duration
+	osdLatencyPercentile  float64
+	requireMonQuorum      bool
+
+	fullScope string
+
+	flagPolicy   string
+	allowedFlags []string
+
+	pauseOnHealthWarnChecks []string
+	abortOnHealthErr        bool
+	abortErr                error
+
+	targetCrushWeightMap map[int]float64
+	weightIncrement      float64
+	weightPrecision      int
+
+	sleepInterval      time.Duration
+	runImmediately     bool
+	enableCephBalancer bool
+	dryRun             bool
+
+	minSleepInterval time.Duration
+	maxSleepInterval time.Duration
+	interval         time.Duration
+	lastBackfillPGs  int
+
+	canaryOSD    int
+	canarySoak   time.Duration
+	canaryDoneAt time.Time
+	canaryPassed bool
+
+	maxImpactScore     float64
+	impactScoreSamples int
+	highImpactSamples  int
+	lastImpactScore    float64
+
+	maxDuration   time.Duration
+	maxIterations int
+	iterations    int
+
+	// elapsed is the run time of earlier processes, carried over
+	// through WithState, and runStartedAt when this one started.
+	elapsed       time.Duration
+	runStartedAt  time.Time
+	resumed       *State
+	stateHandlers []func(State)
+
+	balancerPolicy   string
+	disabledBalancer bool
+
+	allowDownweight bool
+	okToStopPolicy  string
+	downOSDPolicy   string
+	drainCompletion string
+	primaryAffinity bool
+
+	osdMaxBackfills      int
+	osdRecoveryMaxActive int
+	tunedOptions         []TunedOption
+
+	maxReweightsPerHour int
+	reweightLimiter     *ratelimit.TokenBucket
+
+	maxOSDsPerIteration int
+	lastReweightedOSD   int
+	reweightWorkers     int
+
+	gates       []gates.Gate
+	activeHours []gates.TimeWindow
+	suspended   bool
+	clock       Clock
+
+	failureDomain           string
+	maxOSDsPerFailureDomain int
+	maxWeightDeltaPerHost   float64
+
+	drainedOSDs map[int]bool
+
+	// missingOSDs counts the iterations in a row target OSDs could
+	// not be found in, up to `maxMissingIterations`.
+	missingOSDs          map[int]int
+	maxMissingIterations int
+
+	// pausedOSDs maps the target OSDs whose weight was changed
+	// externally to the weight observed for them.
+	pausedOSDs map[int]float64
+
+	// simulatedWeights maps the OSDs reweighted in a dry run to the
+	// weight they would have been given, standing in for the weights
+	// found in the osd tree in later dry run iterations.
+	simulatedWeights map[int]float64
+
+	verifyReweights bool
+	osdmapWait      time.Duration
+
+	// preRunWeights maps the OSDs reweighted so far to the weight
+	// they had before, for rolling back to when aborting.
+	preRunWeights   map[int]float64
+	rollbackOnAbort bool
+	rollingBack     bool
+	rollbackErr     error
+
+	completedOSDs []int
+	skippedOSDs   map[int]string
+	lastErr       error
+	iteration     *IterationResult
+	osdProgress   map[int]OSDProgress
+
+	eventHandlers []func(Event)
+	logger        log.FieldLogger
+
+	treeBucket    string
+	treeAncestors []cephclient.OSDTreeNode
+
+	osdTreeCacheTTL time.Duration
+	cachedTree      *cephclient.OSDTreeOut
+	cachedTreeAt    time.Time
+
+	crushWeightMap    map[int]float64
+	crushWeightDesc   *prometheus.Desc
+	targetOSDsDesc    *prometheus.Desc
+	impactDesc        *prometheus.Desc
+	safeToDestroyDesc *prometheus.Desc
+	registry          prometheus.Registerer
+}
+
+// New returns a new instance of Rebalancer. It is expected
+// that non-empty values for map of osd<->crush weights
+// is passed as an input.
+func New(opt ...Option) (*Rebalancer, error) {
+	r := &Rebalancer{
+		maxBackfillPGsAllowed: 10,
+		maxRecoveryPGsAllowed: 10,
+		maxScrubbingPGs:       -1,
+		maxSnapTrimPGs:        -1,
+		maxDegradedObjects:    -1,
+		maxDegradedPGs:        -1,
+		maxSlowOps:            -1,
+		requireMonQuorum:      true,
+		pauseOnPGAutoscaling:  true,
+		osdLatencyPercentile:  99,
+		gateHookTimeout:       defaultGateHookTimeout,
+		abortOnHealthErr:      true,
+		fullScope:             FullScopeAny,
+		flagPolicy:            FlagPolicyPause,
+		balancerPolicy:        BalancerPolicyRefuse,
+		okToStopPolicy:        OKToStopPolicyRefuse,
+		downOSDPolicy:         DownOSDPolicySkip,
+		drainCompletion:       DrainCompletionNone,
+		weightIncrement:       0.02,
+		weightPrecision:       defaultWeightPrecision,
+		maxMissingIterations:  3,
+		verifyReweights:       true,
+		sleepInterval:         30 * time.Second,
+		runImmediately:        true,
+		dryRun:                true,
+		lastReweightedOSD:     -1,
+		lastBackfillPGs:       -1,
+		canaryOSD:             -1,
+		impactScoreSamples:    defaultImpactScoreSamples,
+		failureDomain:         "host",
+		clock:                 realClock{},
+		logger:                log.StandardLogger(),
+
+		drainedOSDs:    map[int]bool{},
+		crushWeightMap: map[int]float64{},
+		crushWeightDesc: prometheus.NewDesc(
+			fmt.Sprintf("%s_crushweight", serviceName),
+			"Crush Weight set for a given OSD",
+			[]string{
+				"osd",
+			}, nil,
+		),
+		targetOSDsDesc: prometheus.NewDesc(
+			fmt.Sprintf("%s_target_osds_total", serviceName),
+			"Count of target OSDs still left to be upweighted",
+			nil, nil,
+		),
+		impactDesc: prometheus.NewDesc(
+			fmt.Sprintf("%s_impact_score", serviceName),
+			"Highest ratio of backfilling PGs, misplaced objects or OSD latency to its threshold",
+			nil, nil,
+		),
+		safeToDestroyDesc: prometheus.NewDesc(
+			fmt.Sprintf("%s_osd_safe_to_destroy", serviceName),
+			"Whether an OSD drained to zero weight is safe to destroy",
+			[]string{
+				"osd",
+			}, nil,
+		),
+	}
+
+	for _, fn := range opt {
+		fn(r)
+	}
+
+	if r.resumed != nil {
+		r.restoreState(r.resumed)
+	}
+
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := r.targetCrushWeightMap[r.canaryOSD]; r.canaryOSD >= 0 && !ok {
+		return nil, fmt.Errorf("canary osd %d is not a target osd", r.canaryOSD)
+	}
+	// Without any impact threshold the canary could never fail.
+	if r.canaryOSD >= 0 && r.maxSlowOps < 0 && r.maxOSDLatency <= 0 && r.maxHeartbeatLatency <= 0 && r.maxDegradedObjects < 0 {
+		return nil, errors.New("the canary osd needs at least one of the slow ops, osd latency, heartbeat latency or degraded objects thresholds")
+	}
+
+	r.reweightLimiter = ratelimit.New(r.maxReweightsPerHour, time.Hour, limiterClock{r.getClock()})
+	if r.resumed != nil && r.resumed.ReweightTokens != nil && r.reweightLimiter != nil {
+		r.reweightLimiter.SetTokens(*r.resumed.ReweightTokens, r.resumed.SavedAt)
+	}
+	r.interval = r.clampSleepInterval(r.sleepInterval)
+
+	// Custom gates run after the built-in ones, which are cheap
+	// and most likely to close first.
+	builtin := r.builtinGates()
+	for _, src := range r.gateExpressions {
+		g, err := r.newExpressionGate(src)
+		if err != nil {
+			return nil, err
+		}
+		builtin = append(builtin, g)
+	}
+	r.gates = append(builtin, r.gates...)
+	r.publish()
+
+	if r.registry != nil {
+		if err := r.registry.Register(r); err != nil {
+			return nil, fmt.Errorf("failed registering metrics: %w", err)
+		}
+	}
+
+	return r, nil
+}
+
+// log returns the logger given through WithLogger, or the standard
+// logrus logger when there is none.
+func (r *Rebalancer) log() log.FieldLogger {
+	if r.logger == nil {
+		return log.StandardLogger()
+	}
+	return r.logger
+}
+
+// validate checks the options applied to the rebalancer.
+func (r *Rebalancer) validate() error {
+	if len(r.targetCrushWeightMap) == 0 {
+		return errors.New("no weight map found")
+	}
+
+	// A ceph client with an existing connection to the cluster
+	// is expected as an input. It is also the caller's responsibility
+	// to Close() the connection that's established for the ceph client.
+	if r.ceph == nil {
+		return errors.New("no ceph client found")
+	}
+
+	switch r.fullScope {
+	case FullScopeNone, FullScopeAny, FullScopeTargets, FullScopeSubtree:
+	default:
+		return fmt.Errorf("unknown full osd scope %q", r.fullScope)
+	}
+
+	switch r.flagPolicy {
+	case FlagPolicyIgnore, FlagPolicyPause, FlagPolicyAbort:
+	default:
+		return fmt.Errorf("unknown cluster flag policy %q", r.flagPolicy)
+	}
+
+	switch r.balancerPolicy {
+	case BalancerPolicyIgnore, BalancerPolicyRefuse, BalancerPolicyDisable:
+	default:
+		return fmt.Errorf("unknown balancer policy %q", r.balancerPolicy)
+	}
+
+	switch r.okToStopPolicy {
+	case OKToStopPolicyIgnore, OKToStopPolicyWarn, OKToStopPolicyRefuse:
+	default:
+		return fmt.Errorf("unknown ok-to-stop policy %q", r.okToStopPolicy)
+	}
+	switch r.downOSDPolicy {
+	case DownOSDPolicyWait, DownOSDPolicySkip, DownOSDPolicyFail:
+	default:
+		return fmt.Errorf("unknown down osd policy %q", r.downOSDPolicy)
+	}
+
+	switch r.drainCompletion {
+	case DrainCompletionNone, DrainCompletionOut, DrainCompletionRemove, DrainCompletionPurge:
+	default:
+		return fmt.Errorf("unknown drain completion %q", r.drainCompletion)
+	}
+
+	if r.osdMaxBackfills < 0 || r.osdRecoveryMaxActive < 0 {
+		return errors.New("recovery options cannot be negative")
+	}
+
+	// Settings like these would otherwise make for runs that never
+	// reweight anything, or never stop doing so.
+	if r.weightIncrement <= 0 {
+		return fmt.Errorf("weight increment must be positive, got %g", r.weightIncrement)
+	}
+	if r.weightPrecision < 0 || r.weightPrecision > maxWeightPrecision {
+		return fmt.Errorf("weight precision must be between 0 and %d decimal places, got %d", maxWeightPrecision, r.weightPrecision)
+	}
+	if r.maxMissingIterations < 1 {
+		return fmt.Errorf("osds must be allowed to be missing for at least 1 iteration, got %d", r.maxMissingIterations)
+	}
+	if r.weightIncrement < r.weightStep() {
+		return fmt.Errorf("weight increment %g is finer than the weight precision of %d decimal places", r.weightIncrement, r.weightPrecision)
+	}
+	if r.sleepInterval <= 0 {
+		return fmt.Errorf("sleep interval must be positive, got %s", r.sleepInterval)
+	}
+	if r.minSleepInterval > 0 && r.maxSleepInterval > 0 && r.maxSleepInterval < r.minSleepInterval {
+		return fmt.Errorf("maximum sleep interval %s is below the minimum of %s", r.maxSleepInterval, r.minSleepInterval)
+	}
+	if r.maxBackfillPGsAllowed < 0 || r.maxRecoveryPGsAllowed < 0 || r.maxInactivePGsAllowed < 0 {
+		return errors.New("backfilling, recovering and inactive pg thresholds cannot be negative")
+	}
+	for name, val := range map[string]int{
+		"scrubbing pgs":    r.maxScrubbingPGs,
+		"snaptrimming pgs": r.maxSnapTrimPGs,
+		"degraded objects": r.maxDegradedObjects,
+		"degraded pgs":     r.maxDegradedPGs,
+		"slow ops":         r.maxSlowOps,
+		"down osds":        r.maxDownOSDs,
+	} {
+		if val < -1 {
+			return fmt.Errorf("threshold for %s must be -1 to disable it or above, got %d", name, val)
+		}
+	}
+	if r.osdLatencyPercentile < 0 || r.osdLatencyPercentile > 100 {
+		return fmt.Errorf("osd latency percentile must be within [0, 100], got %g", r.osdLatencyPercentile)
+	}
+
+	return nil
+}
+
+// Reconfigure applies the given options to the rebalancer, e.g. to
+// tune a long running campaign without losing its in-memory state.
+// Options take effect from the next iteration on, while gates are
+// only ever set up by New. If the resulting configuration is invalid
+// none of the options are applied. New target weights are merged
+// with the progress made so far, see mergeTargets, and go through
+// the same checks as targets added by AddTargets.
+func (r *Rebalancer) Reconfigure(ctx context.Context, opt ...Option) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.publish()
+
+	probe := &Rebalancer{
+		ceph:                 r.ceph,
+		targetCrushWeightMap: r.targetCrushWeightMap,
+		fullScope:            r.fullScope,
+		flagPolicy:           r.flagPolicy,
+		balancerPolicy:       r.balancerPolicy,
+		allowDownweight:      r.allowDownweight,
+		okToStopPolicy:       r.okToStopPolicy,
+		downOSDPolicy:        r.downOSDPolicy,
+		drainCompletion:      r.drainCompletion,
+
+		osdMaxBackfills:       r.osdMaxBackfills,
+		osdRecoveryMaxActive:  r.osdRecoveryMaxActive,
+		weightIncrement:       r.weightIncrement,
+		weightPrecision:       r.weightPrecision,
+		maxMissingIterations:  r.maxMissingIterations,
+		verifyReweights:       r.verifyReweights,
+		osdmapWait:            r.osdmapWait,
+		sleepInterval:         r.sleepInterval,
+		minSleepInterval:      r.minSleepInterval,
+		maxSleepInterval:      r.maxSleepInterval,
+		maxBackfillPGsAllowed: r.maxBackfillPGsAllowed,
+		maxRecoveryPGsAllowed: r.maxRecoveryPGsAllowed,
+		maxInactivePGsAllowed: r.maxInactivePGsAllowed,
+		maxScrubbingPGs:       r.maxScrubbingPGs,
+		maxSnapTrimPGs:        r.maxSnapTrimPGs,
+		maxDegradedObjects:    r.maxDegradedObjects,
+		maxDegradedPGs:        r.maxDegradedPGs,
+		maxSlowOps:            r.maxSlowOps,
+		maxDownOSDs:           r.maxDownOSDs,
+		osdLatencyPercentile:  r.osdLatencyPercentile,
+	}
+	for _, fn := range opt {
+		fn(probe)
+	}
+	if err := probe.validate(); err != nil {
+		return err
+	}
+
+	targets, removed, changed := r.mergeTargets(probe.targetCrushWeightMap)
+
+	if changed {
+		// The targets are checked as configured by the options.
+		allow, policy := r.allowDownweight, r.okToStopPolicy
+		r.allowDownweight, r.okToStopPolicy = probe.allowDownweight, probe.okToStopPolicy
+		ok := r.checkTargets(ctx, targets)
+		r.allowDownweight, r.okToStopPolicy = allow, policy
+		if !ok {
+			err := r.abortErr
+			r.abortErr = nil
+			return err
+		}
+	}
+
+	current := r.targetCrushWeightMap
+	maxReweightsPerHour := r.maxReweightsPerHour
+	for _, fn := range opt {
+		fn(r)
+	}
+	r.targetCrushWeightMap = current
+	if changed {
+		r.replaceTargets(targets, removed)
+	}
+
+	if r.maxReweightsPerHour != maxReweightsPerHour {
+		r.reweightLimiter = ratelimit.New(r.maxReweightsPerHour, time.Hour, limiterClock{r.getClock()})
+	}
+	r.interval = r.clampSleepInterval(r.sleepInterval)
+
+	return nil
+}
+
+// Run performs continues reweighting by pausing for
+// `sleepInterval` duration, or an adaptive one, between runs,
+// starting right away unless asked to wait for the first one. It returns
+// when either the caller context is cancelled or
+// when all entries from osd<->target-crush-weight
+// are processed, or once the maximum run duration
+// has passed or number of iterations has been run.
+// The returned error tells why the run ended before all
+// entries were processed, and is nil otherwise.
+func (r *Rebalancer) Run(ctx context.Context) (Summary, error) {
+	summary, err := r.run(ctx)
+	r.emit(RunCompleted{Summary: summary, Err: err})
+	return summary, err
+}
+
+func (r *Rebalancer) run(ctx context.Context) (Summary, error) {
+	if err := r.start(ctx); err != nil {
+		return r.Summary(), err
+	}
+	defer r.stop()
+
+	first := r.interval
+	if r.runImmediately {
+		first = 0
+	}
+	timer := r.getClock().NewTimer(first)
+	defer timer.Stop()
+
+	// A nil channel never fires, so without a maximum duration the
+	// run only ends once done or cancelled.
+	var deadline <-chan time.Time
+	if r.maxDuration > 0 {
+		dt := r.getClock().NewTimer(r.maxDuration - r.elapsed)
+		defer dt.Stop()
+		deadline = dt.C()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return r.Summary(), ctx.Err()
+		case <-deadline:
+			// Leaving a rollback halfway would leave the cluster
+			// with a mix of old and new weights.
+			if r.isRollingBack() {
+				r.log().WithField("max.duration", r.maxDuration).Warn("maximum run duration reached, finishing the rollback first")
+				deadline = nil
+				continue
+			}
+			r.log().WithField("max.duration", r.maxDuration).WithField("remaining.osds", len(r.RemainingTargets())).
+				Warn("maximum run duration reached, leaving remaining osds untouched")
+			return r.Summary(), ErrMaxDuration
+		case <-timer.C():
+			next, done, err := r.tick(ctx)
+			if done {
+				return r.Summary(), err
+			}
+			timer.Reset(next)
+		}
+	}
+}
+
+// start checks whether reweighting may start and prepares the
+// cluster for it.
+func (r *Rebalancer) start(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.runStartedAt = r.now()
+
+	// Refuse to even start when the cluster is flagged in a way
+	// that conflicts with reweighting and we were asked to abort.
+	if ok, _, _ := r.flagGate(ctx); !ok && r.abortErr != nil {
+		return r.abort()
+	}
+
+	// A target mistakenly given below the current weight of an OSD
+	// would evacuate data off it, so that has to be asked for.
+	if !r.checkDownweights(ctx) {
+		return r.abort()
+	}
+
+	// Draining OSDs that hold the last available copies of some PGs
+	// would eventually make those PGs unavailable.
+	if !r.checkOKToStop(ctx) {
+		return r.abort()
+	}
+
+	// The Ceph balancer would fight over the same placements, so
+	// either refuse to run alongside it or turn it off meanwhile.
+	if !r.checkBalancer(ctx) {
+		return r.abort()
+	}
+
+	r.tuneRecovery(ctx)
+	return nil
+}
+
+// stop restores the Ceph balancer and recovery options changed by
+// start, however the run ends. The caller context is likely done by
+// then, so a fresh one is used.
+func (r *Rebalancer) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.restoreRecovery(context.Background())
+	r.restoreBalancer(context.Background())
+}
+
+// abort ends reweighting because of `abortErr`, which it returns.
+func (r *Rebalancer) abort() error {
+	r.log().WithError(r.abortErr).Error("aborting reweighting")
+	r.lastErr = r.abortErr
+	return r.abortErr
+}
+
+// tick performs a single run, returning how long to sleep for until
+// the next one, whether Run is done and, if so, why it is done early.
+func (r *Rebalancer) tick(ctx context.Context) (time.Duration, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.publish()
+
+	ctx = withSharedStatus(ctx)
+
+	next := r.nextSleepInterval(ctx)
+
+	res := r.runOnce(ctx)
+	r.saveState()
+	if res.Done {
+		return next, true, r.rollbackErr
+	}
+	if r.abortErr != nil {
+		if r.startRollback() {
+			return next, false, nil
+		}
+		return next, true, r.abort()
+	}
+
+	if r.maxIterations > 0 && r.iterations >= r.maxIterations && !r.rollingBack {
+		r.log().WithField("max.iterations", r.maxIterations).WithField("remaining.osds", len(r.targetCrushWeightMap)).
+			Info("maximum number of iterations reached")
+		if len(r.targetCrushWeightMap) > 0 {
+			return next, true, ErrMaxIterations
+		}
+		return next, true, nil
+	}
+
+	return next, false, nil
+}
+
+// RemainingTargets returns a copy of the osd<->target-crush-weight
+// entries that are yet to be processed.
+func (r *Rebalancer) RemainingTargets() map[int]float64 {
+	return copyWeights(r.currentView().remaining)
+}
+
+// AddTargets adds OSDs to be reweighted to the given target weights
+// while running, updating the targets of OSDs already being
+// reweighted. Downweighted OSDs go through the same checks as when
+// the run started, and no target is added when one fails. Run
+// must not have returned already for the new targets to be picked up.
+func (r *Rebalancer) AddTargets(ctx context.Context, targets map[int]float64) error {
+	for osd, w := range targets {
+		if w < 0 {
+			return fmt.Errorf("negative target weight %g for osd.%d", w, osd)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.publish()
+
+	merged := make(map[int]float64, len(r.targetCrushWeightMap)+len(targets))
+	for osd, w := range r.targetCrushWeightMap {
+		merged[osd] = w
+	}
+	for osd, w := range targets {
+		merged[osd] = w
+	}
+	if !r.checkTargets(ctx, merged) {
+		err := r.abortErr
+		r.abortErr = nil
+		return err
+	}
+
+	for osd, w := range targets {
+		if _, ok := r.targetCrushWeightMap[osd]; !ok {
+			r.requeue(osd)
+		}
+		r.targetCrushWeightMap[osd] = w
+		delete(r.missingOSDs, osd)
+	}
+	r.cachedTree = nil
+
+	r.log().WithField("targets", targets).Info("added target osds")
+	return nil
+}
+
+// checkTargets reports whether reweighting may go on with the given
+// targets instead of the current ones, as far as downweights are
+// concerned. It sets `abortErr` when not.
+func (r *Rebalancer) checkTargets(ctx context.Context, targets map[int]float64) bool {
+	current := r.targetCrushWeightMap
+	r.targetCrushWeightMap = targets
+	defer func() {
+		r.targetCrushWeightMap = current
+		r.cachedTree = nil
+	}()
+
+	// The osd tree cached for the current targets may not cover the
+	// new ones.
+	r.cachedTree = nil
+	return r.checkDownweights(ctx) && r.checkOKToStop(ctx)
+}
+
+// mergeTargets merges a new set of targets, e.g. reloaded from a
+// config file, with the progress made so far: OSDs which already
+// completed or were skipped stay so unless their target changed,
+// while OSDs left out of the new set are removed from the targets.
+// It returns the resulting targets, the OSDs to remove and whether
+// anything changed.
+func (r *Rebalancer) mergeTargets(targets map[int]float64) (map[int]float64, []int, bool) {
+	merged := make(map[int]float64, len(targets))
+	changed := false
+	for osd, w := range targets {
+		cur, ok := r.targetCrushWeightMap[osd]
+		if !ok && r.doneWith(osd, w) {
+			continue
+		}
+		merged[osd] = w
+		changed = changed || !ok || cur != w
+	}
+
+	var removed []int
+	for osd := range r.targetCrushWeightMap {
+		if _, ok := targets[osd]; !ok {
+			removed = append(removed, osd)
+		}
+	}
+	sort.Ints(removed)
+
+	return merged, removed, changed || len(removed) > 0
+}
+
+// doneWith reports whether an OSD that is no longer a target already
+// completed, or was skipped, on its way to the given target.
+func (r *Rebalancer) doneWith(osd int, target float64) bool {
+	if op, ok := r.osdProgress[osd]; ok && op.Target != target {
+		return false
+	}
+	if reason, ok := r.skippedOSDs[osd]; ok {
+		return reason != reasonRemoved
+	}
+	for _, o := range r.completedOSDs {
+		if o == osd {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceTargets sets the targets as merged by mergeTargets, removing
+// the given OSDs from them. OSDs taken up again are no longer counted
+// as completed or skipped.
+// requeue forgets that osd was completed or skipped, so that a target
+// added back for it is reweighted again.
+func (r *Rebalancer) requeue(osd int) {
+	delete(r.skippedOSDs, osd)
+	if op, ok := r.osdProgress[osd]; ok {
+		op.Completed = false
+		r.osdProgress[osd] = op
+	}
+	for i, o := range r.completedOSDs {
+		if o == osd {
+			r.completedOSDs = append(r.completedOSDs[:i:i], r.completedOSDs[i+1:]...)
+			break
+		}
+	}
+}
+
+func (r *Rebalancer) replaceTargets(targets map[int]float64, removed []int) {
+	for osd := range targets {
+		if _, ok := r.targetCrushWeightMap[osd]; ok {
+			continue
+		}
+		r.requeue(osd)
+		delete(r.missingOSDs, osd)
+	}
+
+	for _, osd := range removed {
+		r.log().WithField("osd", osd).Info("removed target osd")
+		r.skipOSD(osd, reasonRemoved)
+	}
+	for osd, w := range targets {
+		r.targetCrushWeightMap[osd] = w
+	}
+	r.cachedTree = nil
+	r.log().WithField("targets", targets).Info("reloaded target osds")
+}
+
+// reasonRemoved is why OSDs removed from the targets while running
+// are skipped.
+const reasonRemoved = "removed from the targets"
+
+// RemoveTargets stops reweighting the given OSDs, leaving them at
+// their current weight.
+func (r *Rebalancer) RemoveTargets(osds ...int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.publish()
+
+	for _, osd := range osds {
+		if _, ok := r.targetCrushWeightMap[osd]; !ok {
+			continue
+		}
+		r.log().WithField("osd", osd).Info("removed target osd")
+		r.skipOSD(osd, reasonRemoved)
+	}
+}
+
+// DoReweight is the main function where the validation and
+// actual crush reweighting occurs.
+func (r *Rebalancer) DoReweight() {
+	r.DoReweightContext(context.Background())
+}
+
+// DoReweightContext is like DoReweight but hands the given context
+// to every gate evaluated before reweighting.
+func (r *Rebalancer) DoReweightContext(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.publish()
+
+	r.iteration = newIterationResult()
+	r.reweight(withSharedStatus(ctx))
+}
+
+func (r *Rebalancer) reweight(ctx context.Context) {
+	r.scoreImpact(ctx)
+
+	// Rolling back is what the gates call for, so it isn't held
+	// back by them.
+	if !r.rollingBack {
+		if !r.checkCanary(ctx) {
+			return
+		}
+		if !r.checkGates(ctx) {
+			return
+		}
+	}
+
+	out, err := r.targetOSDTree(ctx)
+	if err != nil {
+		r.log().WithError(err).Error("failed to get output of osd-tree")
+		r.lastErr = err
+		r.skipIteration(fmt.Sprintf("failed to get output of osd-tree: %s", err))
+		return
+	}
+
+	domains := r.extractAncestors(out, r.failureDomain)
+
+	full, err := r.fullOSDs(ctx, out, domains)
+	if err != nil {
+		r.log().WithError(err).Error("failed checking for full osds")
+		r.lastErr = err
+		r.skipIteration(fmt.Sprintf("failed checking for full osds: %s", err))
+		return
+	}
+	if len(full) > 0 {
+		r.log().WithField("full.osds", full).Warn("skipping reweighting, nearfull/backfillfull/full osds found")
+		r.skipIteration(fmt.Sprintf("full osds found: %v", full))
+		return
+	}
+
+	var reweighted int
+	var planned []plannedReweight
+
+	cws := r.extractCurrentWeights(out)
+	r.simulateWeights(cws)
+	r.trackWeights(cws)
+	unavailable := r.extractUnavailableOSDs(out)
+	if !r.checkDownOSDs(cws, unavailable) {
+		return
+	}
+
+	ramping := r.rampingPerFailureDomain(domains)
+	hosts := r.extractAncestors(out, "host")
+	hostDeltas := make(map[int]float64)
+	order := r.osdsInOrder()
+	if r.iteration != nil {
+		r.iteration.Order = order
+	}
+	r.log().WithField("order", order).Debug("visiting target osds")
+	for _, osd := range order {
+		if r.maxOSDsPerIteration > 0 && reweighted+len(planned) >= r.maxOSDsPerIteration {
+			r.log().WithField("max.osds", r.maxOSDsPerIteration).Info("per-iteration osd cap reached")
+			break
+		}
+
+		tw := r.targetCrushWeightMap[osd]
+		ll := r.log().WithField("osd", osd)
+
+		cw, ok := cws[osd]
+		if !ok {
+			r.missOSD(osd, "not found in the osd tree", ll)
+			continue
+		}
+		delete(r.missingOSDs, osd)
+
+		if r.changedExternally(osd, cw, ll) {
+			continue
+		}
+
+		ll = ll.WithField("target.weight", tw).WithField("current.weight", cw)
+		if r.weightsEqual(cw, tw) {
+			r.finishOSD(ctx, osd, ll.WithField("reason", "target weight achieved"))
+			continue
+		}
+
+		if state, ok := unavailable[osd]; ok && r.raising(osd, cw) {
+			ll.WithField("state", state).Info("skipping reweight, osd is down or out")
+			continue
+		}
+
+		// OSDs being drained are decremented towards their target the
+		// same way others are incremented.
+		weight := r.nextWeight(cw, tw)
+
+		ll = ll.WithField("weight", weight).WithField("inc", r.weightIncrement)
+		if weight < 0 {
+			ll.Error("negative weight found")
+
+			r.skipOSD(osd, "negative weight")
+			continue
+		}
+
+		// If the next reweight value is the same one we set previously, that
+		// means we have achieved optimal weight. Nothing more to do here.
+		w, started := r.crushWeightMap[osd]
+		if started && r.weightsEqual(w, weight) {
+			r.finishOSD(ctx, osd, ll.WithField("reason", "optimal weight achieved"))
+			continue
+		}
+
+		// OSDs which have already started ramping always continue, new ones
+		// only begin once their failure domain has room for them.
+		domain, ok := domains[osd]
+		if !started && ok && r.maxOSDsPerFailureDomain > 0 {
+			if ramping[domain] >= r.maxOSDsPerFailureDomain {
+				ll.WithField("failure.domain", domain).Info("skipping reweight, failure domain is busy")
+				continue
+			}
+			ramping[domain]++
+		}
+
+		// Bound the total weight added or removed under a single host in this
+		// iteration by trimming the increment to whatever budget the host has left.
+		if host, ok := hosts[osd]; ok && r.maxWeightDeltaPerHost > 0 {
+			remaining := r.maxWeightDeltaPerHost - hostDeltas[host]
+			if remaining <= 0 {
+				ll.WithField("host", host).Info("skipping reweight, host weight delta cap reached")
+				continue
+			}
+			if math.Abs(weight-cw) > remaining {
+				weight = r.roundWeight(cw + math.Copysign(remaining, weight-cw))
+				if r.weightsEqual(weight, cw) {
+					ll.WithField("host", host).Info("skipping reweight, host weight delta cap reached")
+					continue
+				}
+				ll = ll.WithField("weight", weight)
+			}
+			hostDeltas[host] += math.Abs(weight - cw)
+		}
+
+		// Reads are steered away from OSDs before they start draining.
+		if r.primaryAffinity && !started && tw < cw {
+			if err := r.setPrimaryAffinity(ctx, osd, 0, ll); err != nil {
+				ll.WithError(err).Warn("failed lowering primary affinity of osd to drain")
+			}
+		}
+
+		if r.dryRun {
+			ll.Info("weight will be applied in the actual run")
+
+			reweighted++
+			r.lastReweightedOSD = osd
+			r.simulateReweight(osd, weight)
+			r.recordReweight(osd, weight)
+			r.trackReweight(osd, weight)
+			continue
+		}
+
+		// The limiter is shared across iterations, so OSDs that miss
+		// out here are simply picked up again on a later run.
+		if !r.reweightLimiter.Allow() {
+			ll.Warn("skipping reweight, hourly reweight limit reached")
+			continue
+		}
+
+		planned = append(planned, plannedReweight{osd: osd, weight: weight, ll: ll})
+	}
+
+	if len(planned) > 0 {
+		reweighted += r.applyReweights(ctx, out, planned)
+	}
+
+	if reweighted > 0 {
+		r.iterations++
+	}
+}
+
+// osdsInOrder returns the OSDs left in the target map in the order
+// they should be visited, only the canary OSD while it is ramping.
+// OSDs are visited by ascending ID so that runs and dry runs behave
+// the same. When the number of OSDs per iteration is capped, they are
+// rotated to start right after the last OSD reweighted so that every
+// OSD gets its turn round-robin.
+func (r *Rebalancer) osdsInOrder() []int {
+	if r.inCanaryPhase() {
+		if _, ok := r.targetCrushWeightMap[r.canaryOSD]; ok {
+			return []int{r.canaryOSD}
+		}
+		return nil
+	}
+
+	return r.orderOSDs(r.targetCrushWeightMap, r.lastReweightedOSD)
+}
+
+// orderOSDs sorts the given target OSDs, rotated to start right
+// after the given one when the number of OSDs per iteration is capped.
+func (r *Rebalancer) orderOSDs(targets map[int]float64, last int) []int {
+	osds := make([]int, 0, len(targets))
+	for osd := range targets {
+		osds = append(osds, osd)
+	}
+	sort.Ints(osds)
+	if r.maxOSDsPerIteration <= 0 {
+		return osds
+	}
+
+	i := sort.SearchInts(osds, last+1)
+
+	ordered := make([]int, 0, len(osds))
+	ordered = append(ordered, osds[i:]...)
+	return append(ordered, osds[:i]...)
+}
+
+// backfillingPGs counts the backfilling PGs, either across the
+// whole cluster or only across the given pools when `pools` is
+// non-nil.
+func (r *Rebalancer) backfillingPGs(ctx context.Context, pools map[int]bool) (int, error) {
+	if pools == nil {
+		status, err := r.clusterStatus(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return status.PGsInState("backfilling", "backfill_wait"), nil
+	}
+	return r.countPGsInPools(ctx, pools, "backfilling", "backfill_wait")
+}
+
+// recoveringPGs counts the recovering PGs, either across the
+// whole cluster or only across the given pools when `pools` is
+// non-nil.
+func (r *Rebalancer) recoveringPGs(ctx context.Context, pools map[int]bool) (int, error) {
+	if pools == nil {
+		status, err := r.clusterStatus(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return status.PGsInState("recovering", "recovery_wait"), nil
+	}
+	return r.countPGsInPools(ctx, pools, "recovering", "recovery_wait")
+}
+
+// degradedPGs counts the PGs with fewer copies than they should
+// have, either across the whole cluster or only across the given
+// pools when `pools` is non-nil.
+func (r *Rebalancer) degradedPGs(ctx context.Context, pools map[int]bool) (int, error) {
+	if pools == nil {
+		status, err := r.clusterStatus(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return status.PGsInState("degraded"), nil
+	}
+	return r.countPGsInPools(ctx, pools, "degraded")
+}
+
+// downOSDs returns the OSDs which are down while still marked in,
+// meaning their data is currently served from fewer replicas.
+func (r *Rebalancer) downOSDs(ctx context.Context) ([]int, error) {
+	out, err := r.ceph.OSDDump(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var down []int
+	for _, o := range out.OSDs {
+		if o.Up == 0 && o.In == 1 {
+			down = append(down, o.OSD)
+		}
+	}
+
+	return down, nil
+}
+
+// heartbeatLatency returns the highest 1 minute average heartbeat
+// ping time between any two OSDs, ignoring stale entries.
+func (r *Rebalancer) heartbeatLatency(ctx context.Context) (time.Duration, error) {
+	out, err := r.ceph.OSDNetworkPings(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var highest float64
+	for _, ping := range out.Entries {
+		if ping.Stale {
+			continue
+		}
+		highest = math.Max(highest, ping.Average.OneMin)
+	}
+
+	// Ping times are reported in milliseconds.
+	return time.Duration(highest * float64(time.Millisecond)), nil
+}
+
+// osdLatency returns the `osdLatencyPercentile` percentile of the
+// worse of commit and apply latency across all OSDs.
+func (r *Rebalancer) osdLatency(ctx context.Context) (time.Duration, error) {
+	out, err := r.ceph.OSDPerf(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(out.OSDPerfInfos) == 0 {
+		return 0, nil
+	}
+
+	latencies := make([]time.Duration, 0, len(out.OSDPerfInfos))
+	for _, info := range out.OSDPerfInfos {
+		latencies = append(latencies, info.Latency())
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	// Nearest-rank percentile.
+	rank := int(math.Ceil(r.osdLatencyPercentile / 100 * float64(len(latencies))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(latencies) {
+		rank = len(latencies)
+	}
+
+	return latencies[rank-1], nil
+}
+
+// fullOSDs returns the OSDs within `fullScope` that are marked
+// nearfull, backfillfull or full in the OSD map. The subtree scope
+// covers every OSD that shares a failure domain with a target OSD.
+func (r *Rebalancer) fullOSDs(ctx context.Context, tree *cephclient.OSDTreeOut, domains map[int]int) ([]int, error) {
+	if r.fullScope == FullScopeNone {
+		return nil, nil
+	}
+
+	out, err := r.ceph.OSDDump(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inScope := func(osd int) bool { return true }
+	switch r.fullScope {
+	case FullScopeTargets:
+		inScope = func(osd int) bool {
+			_, ok := r.targetCrushWeightMap[osd]
+			return ok
+		}
+	case FullScopeSubtree:
+		nodes := make(map[int]cephclient.OSDTreeNode, len(tree.Nodes))
+		for _, node := range tree.Nodes {
+			nodes[node.ID] = node
+		}
+
+		subtree := make(map[int]bool)
+		for _, domain := range domains {
+			collectOSDs(nodes, domain, subtree)
+		}
+		for osd := range r.targetCrushWeightMap {
+			subtree[osd] = true
+		}
+		inScope = func(osd int) bool { return subtree[osd] }
+	}
+
+	var full []int
+	for _, o := range out.OSDs {
+		if !inScope(o.OSD) {
+			continue
+		}
+
+		for _, state := range o.State {
+			if state == "nearfull" || state == "backfillfull" || state == "full" {
+				full = append(full, o.OSD)
+				break
+			}
+		}
+	}
+
+	return full, nil
+}
+
+// collectOSDs adds every OSD found underneath the given bucket
+// to `osds`. OSDs are told apart from buckets by their non-negative
+// ID, so that OSDs left out of the tree are still collected.
+func collectOSDs(nodes map[int]cephclient.OSDTreeNode, bucket int, osds map[int]bool) {
+	stack := append([]int(nil), nodes[bucket].Children...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if id >= 0 {
+			osds[id] = true
+			continue
+		}
+		stack = append(stack, nodes[id].Children...)
+	}
+}
+
+// checkBalancer reports whether reweighting may start with regards
+// to the Ceph balancer. An active balancer either makes the run abort
+// or gets disabled until the run returns, depending on `balancerPolicy`.
+func (r *Rebalancer) checkBalancer(ctx context.Context) bool {
+	if r.balancerPolicy == BalancerPolicyIgnore {
+		return true
+	}
+
+	status, err := r.ceph.BalancerStatus(ctx)
+	if err != nil {
+		r.abortErr = fmt.Errorf("cannot check the Ceph balancer: %w", err)
+		return false
+	}
+	if !status.Conflicts() {
+		return true
+	}
+
+	ll := r.log().WithField("balancer.mode", status.Mode)
+	if r.balancerPolicy == BalancerPolicyRefuse {
+		r.abortErr = classify(ErrWeightConflict, errors.New("the Ceph balancer is active, disable it or allow archimedes to"))
+		return false
+	}
+
+	if r.dryRun {
+		ll.Info("the Ceph balancer will be disabled in the actual run")
+		return true
+	}
+
+	ll.Info("disabling the Ceph balancer for the duration of the run")
+	if err := r.ceph.DisableCephBalancer(ctx); err != nil {
+		r.abortErr = fmt.Errorf("cannot disable the Ceph balancer: %w", err)
+		return false
+	}
+	r.disabledBalancer = true
+
+	return true
+}
+
+// restoreBalancer turns the Ceph balancer back on if it was disabled
+// by checkBalancer and hasn't been enabled since.
+func (r *Rebalancer) restoreBalancer(ctx context.Context) {
+	if !r.disabledBalancer {
+		return
+	}
+
+	r.log().Info("re-enabling the Ceph balancer")
+	if err := r.ceph.EnableCephBalancer(ctx); err != nil {
+		r.log().WithError(err).Warn("failed to re-enable the Ceph balancer")
+		return
+	}
+	r.disabledBalancer = false
+}
+
+// backfillBytes estimates the amount of data still to be moved
+// by summing up the size of every PG waiting on or undergoing
+// backfill.
+func (r *Rebalancer) backfillBytes(ctx context.Context) (int64, error) {
+	out, err := r.ceph.PGDump(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var bytes int64
+	for _, pg := range out.PGStats {
+		if strings.Contains(pg.State, "backfilling") || strings.Contains(pg.State, "backfill_wait") {
+			bytes += pg.StatSum.NumBytes
+		}
+	}
+
+	return bytes, nil
+}
+
+func (r *Rebalancer) extractCurrentWeights(out *cephclient.OSDTreeOut) map[int]float64 {
+	osdsToReweight := make(map[int]float64)
+	for _, node := range out.Nodes {
+		if node.Type != "osd" {
+			continue
+		}
+
+		_, ok := r.targetCrushWeightMap[node.ID]
+		if ok {
+			osdsToReweight[node.ID] = float64(node.CrushWeight)
+		}
+	}
+
+	return osdsToReweight
+}
+
+// extractAncestors maps each target OSD to the ID of its closest
+// ancestor bucket of the given type. OSDs without such an ancestor
+// are left out of the mapping.
+func (r *Rebalancer) extractAncestors(out *cephclient.OSDTreeOut, bucketType string) map[int]int {
+	parents := make(map[int]int)
+	types := make(map[int]string, len(out.Nodes))
+	for _, node := range out.Nodes {
+		types[node.ID] = node.Type
+		for _, child := range node.Children {
+			parents[child] = node.ID
+		}
+	}
+
+	ancestors := make(map[int]int)
+	for osd := range r.targetCrushWeightMap {
+		id, ok := parents[osd]
+		for ok {
+			if types[id] == bucketType {
+				ancestors[osd] = id
+				break
+			}
+			id, ok = parents[id]
+		}
+	}
+
+	return ancestors
+}
+
+// rampingPerFailureDomain counts the target OSDs in each failure domain
+// that have already been reweighted at least once and are still ramping.
+func (r *Rebalancer) rampingPerFailureDomain(domains map[int]int) map[int]int {
+	ramping := make(map[int]int)
+	for osd := range r.targetCrushWeightMap {
+		domain, ok := domains[osd]
+		if !ok {
+			continue
+		}
+		if _, started := r.crushWeightMap[osd]; started {
+			ramping[domain]++
+		}
+	}
+
+	return ramping
+}
+
+// Verify that Rebalancer implements prometheus.Collector.
+var _ prometheus.Collector = &Rebalancer{}
+
+// Collect is responsible for collecting values for all declared
+// metrics.
+func (r *Rebalancer) Collect(ch chan<- prometheus.Metric) {
+	// Scrapes happen concurrently to reweighting, so they are
+	// served from the view.
+	v := r.currentView()
+
+	for osd, cw := range v.crushWeights {
+		ch <- prometheus.MustNewConstMetric(
+			r.crushWeightDesc,
+			prometheus.GaugeValue,
+			float64(cw),
+			strconv.Itoa(osd),
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		r.targetOSDsDesc,
+		prometheus.GaugeValue,
+		float64(len(v.remaining)),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		r.impactDesc,
+		prometheus.GaugeValue,
+		v.impactScore,
+	)
+	for osd, safe := range v.drainedOSDs {
+		var val float64
+		if safe {
+			val = 1
+		}
+		ch <- prometheus.MustNewConstMetric(
+			r.safeToDestroyDesc,
+			prometheus.GaugeValue,
+			val,
+			strconv.Itoa(osd),
+		)
+	}
+}
+
+// Describe returns the descriptions for registered metrics.
+func (r *Rebalancer) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.crushWeightDesc
+	ch <- r.targetOSDsDesc
+	ch <- r.impactDesc
+	ch <- r.safeToDestroyDesc
+}
@@ -0,0 +1,1609 @@
+//   Copyright 2020 DigitalOcean
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoReweight(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		weightIncrement float64
+		backfillingPGs  int
+		recoveringPGs   int
+		inactivePGs     int
+		osdTree         *cephclient.OSDTreeOut
+		dryRun          bool
+
+		maxOSDsPerIteration int
+		maxBackfillBytes    int64
+		pgDump              *cephclient.PGDumpOut
+		maxMisplacedRatio   float64
+		misplacedRatio      float64
+		degradedObjects     int
+		slowOps             int
+		osdDump             *cephclient.OSDDumpOut
+		quorumStatus        *cephclient.QuorumStatusOut
+
+		iterations      int
+		reweightCount   int
+		crushWeightMap  map[int]float64
+		targetWeightMap map[int]float64
+	}{
+		{
+			name: "High BackfillPGs",
+
+			backfillingPGs: 100,
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: nil,
+			},
+			reweightCount:  0,
+			crushWeightMap: nil,
+
+			weightIncrement: 1.0,
+			targetWeightMap: map[int]float64{
+				1: 7.4999,
+				2: 15.4999,
+			},
+		},
+		{
+			name: "High RecoveryPGs",
+
+			recoveringPGs: 100,
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: nil,
+			},
+			reweightCount:  0,
+			crushWeightMap: nil,
+
+			weightIncrement: 1.0,
+			targetWeightMap: map[int]float64{
+				1: 7.4999,
+				2: 15.4999,
+			},
+		},
+		{
+			name: "Inactive PGs",
+
+			inactivePGs: 1,
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount:  0,
+			crushWeightMap: nil,
+
+			weightIncrement: 1.0,
+			targetWeightMap: map[int]float64{
+				1: 7.4999,
+			},
+		},
+		{
+			name: "High BackfillBytes",
+
+			maxBackfillBytes: 1 << 30,
+			pgDump: &cephclient.PGDumpOut{
+				PGStats: []cephclient.PGStat{
+					newTestPGStat("active+remapped+backfill_wait", 1<<30),
+					newTestPGStat("active+remapped+backfilling", 1<<30),
+					newTestPGStat("active+clean", 1<<30),
+				},
+			},
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount:  0,
+			crushWeightMap: nil,
+
+			weightIncrement: 1.0,
+			targetWeightMap: map[int]float64{
+				1: 7.4999,
+			},
+		},
+		{
+			name: "High MisplacedRatio",
+
+			maxMisplacedRatio: 0.05,
+			misplacedRatio:    0.10,
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount:  0,
+			crushWeightMap: nil,
+
+			weightIncrement: 1.0,
+			targetWeightMap: map[int]float64{
+				1: 7.4999,
+			},
+		},
+		{
+			name: "Degraded Objects",
+
+			degradedObjects: 12,
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount:  0,
+			crushWeightMap: nil,
+
+			weightIncrement: 1.0,
+			targetWeightMap: map[int]float64{
+				1: 7.4999,
+			},
+		},
+		{
+			name: "Slow Ops",
+
+			slowOps: 3,
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount:  0,
+			crushWeightMap: nil,
+
+			weightIncrement: 1.0,
+			targetWeightMap: map[int]float64{
+				1: 7.4999,
+			},
+		},
+		{
+			name: "Mon Quorum Lost",
+
+			quorumStatus: newTestQuorumStatus(3, 0, 1),
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount:  0,
+			crushWeightMap: nil,
+
+			weightIncrement: 1.0,
+			targetWeightMap: map[int]float64{
+				1: 7.4999,
+			},
+		},
+		{
+			name: "Down OSDs",
+
+			osdDump: &cephclient.OSDDumpOut{
+				OSDs: []cephclient.OSDInfo{
+					{OSD: 1, Up: 1, In: 1},
+					{OSD: 2, Up: 0, In: 1},
+				},
+			},
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount:  0,
+			crushWeightMap: nil,
+
+			weightIncrement: 1.0,
+			targetWeightMap: map[int]float64{
+				1: 7.4999,
+			},
+		},
+		{
+			name: "Down Out OSDs Ignored",
+
+			osdDump: &cephclient.OSDDumpOut{
+				OSDs: []cephclient.OSDInfo{
+					{OSD: 1, Up: 1, In: 1},
+					{OSD: 2, Up: 0, In: 0},
+				},
+			},
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount: 1,
+			crushWeightMap: map[int]float64{
+				1: 1.0,
+			},
+
+			weightIncrement: 1.0,
+			targetWeightMap: map[int]float64{
+				1: 7.4999,
+			},
+		},
+		{
+			name: "DryRun Enabled",
+
+			dryRun: true,
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+					{
+						ID:          2,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount:  0,
+			crushWeightMap: nil,
+
+			weightIncrement: 4.0,
+			targetWeightMap: map[int]float64{
+				1: 7.4999,
+				2: 15.4999,
+			},
+		},
+		{
+			name: "Single Increment",
+
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+					{
+						ID:          2,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount: 1,
+			crushWeightMap: map[int]float64{
+				1: 4.0,
+				2: 4.0,
+			},
+
+			weightIncrement: 4.0,
+			iterations:      1,
+			targetWeightMap: map[int]float64{
+				1: 4.0,
+				2: 4.0,
+			},
+		},
+		{
+			name: "Distinct TargetWeights",
+
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+					{
+						ID:          2,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount: 1,
+			crushWeightMap: map[int]float64{
+				1: 4.0,
+				2: 4.0,
+			},
+
+			weightIncrement: 4.0,
+			iterations:      1,
+			targetWeightMap: map[int]float64{
+				1: 16.0,
+				2: 8.0,
+			},
+		},
+		{
+			name: "Same TargetWeight Reached",
+
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+					{
+						ID:          2,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount: 1,
+			crushWeightMap: map[int]float64{
+				1: 2.0,
+				2: 2.0,
+			},
+
+			weightIncrement: 4.0, // Increment is x2 times the target weight!
+			iterations:      10,
+			targetWeightMap: map[int]float64{
+				1: 2.0,
+				2: 2.0,
+			},
+		},
+		{
+			name: "Distinct TargetWeight Reached",
+
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+					{
+						ID:          2,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount: 1,
+			crushWeightMap: map[int]float64{
+				1: 2.0,
+				2: 4.0,
+			},
+
+			weightIncrement: 8.0, // Increment is x2 times the largest target weight!
+			iterations:      10,
+			targetWeightMap: map[int]float64{
+				1: 2.0,
+				2: 4.0,
+			},
+		},
+		{
+			name: "Granular TargetWeight Reached",
+
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+					{
+						ID:          2,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount: 125,
+			crushWeightMap: map[int]float64{
+				1: 2.4999,
+				2: 2.4999,
+			},
+
+			weightIncrement: 0.02, // tiny increment!
+			iterations:      1000,
+			targetWeightMap: map[int]float64{
+				1: 2.4999,
+				2: 2.4999,
+			},
+		},
+		{
+			name: "Non-Zero CrushWeight TargetWeight Reached",
+
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 1.0, // Non-zero Crush weight.
+					},
+					{
+						ID:          2,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount: 1,
+			crushWeightMap: map[int]float64{
+				1: 2.0,
+				2: 2.0,
+			},
+
+			weightIncrement: 4.0, // Increment is x2 times the target weight!
+			iterations:      10,
+			targetWeightMap: map[int]float64{
+				1: 2.0,
+				2: 2.0,
+			},
+		},
+		{
+			name: "Same TargetWeight Small Iterations",
+
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+					{
+						ID:          2,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount: 10,
+			crushWeightMap: map[int]float64{
+				1: 2.0,
+				2: 2.0,
+			},
+
+			weightIncrement: 0.2,
+			iterations:      10,
+			targetWeightMap: map[int]float64{
+				1: 2.0,
+				2: 2.0,
+			},
+		},
+		{
+			name: "Incomplete Iterations",
+
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+					{
+						ID:          2,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount: 5,
+			crushWeightMap: map[int]float64{
+				1: 1.0, // We can only partially fill these, sadly!
+				2: 1.0,
+			},
+
+			weightIncrement: 0.2,
+			iterations:      5, // No. of iterations is less than what is needed to fill up OSDs.
+			targetWeightMap: map[int]float64{
+				1: 2.0,
+				2: 2.0,
+			},
+		},
+		{
+			name: "Max OSDs Per Iteration Round Robin",
+
+			osdTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{
+						ID:          1,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+					{
+						ID:          2,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+					{
+						ID:          3,
+						Type:        "osd",
+						CrushWeight: 0,
+					},
+				},
+			},
+			reweightCount: 1,
+			crushWeightMap: map[int]float64{
+				1: 1.0, // Each OSD gets exactly one turn.
+				2: 1.0,
+				3: 1.0,
+			},
+
+			maxOSDsPerIteration: 1,
+			weightIncrement:     1.0,
+			iterations:          3,
+			targetWeightMap: map[int]float64{
+				1: 2.0,
+				2: 2.0,
+				3: 2.0,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := cephtest.New(cephtest.State{
+				OSDTree:         tt.osdTree,
+				PGDump:          tt.pgDump,
+				MisplacedRatio:  tt.misplacedRatio,
+				DegradedObjects: tt.degradedObjects,
+				SlowOps:         tt.slowOps,
+				OSDDump:         tt.osdDump,
+				QuorumStatus:    tt.quorumStatus,
+				PGsByState:      map[string]int{"active+remapped+backfilling": tt.backfillingPGs, "active+recovering": tt.recoveringPGs, "peering": tt.inactivePGs},
+			})
+			defer tc.Close()
+
+			r, err := New(
+				WithCephClient(tc),
+				WithWeightIncrement(tt.weightIncrement),
+				WithTargetCrushWeightMap(tt.targetWeightMap),
+				WithDryRun(tt.dryRun),
+				WithMaxOSDsPerIteration(tt.maxOSDsPerIteration),
+				WithMaxBackfillBytes(tt.maxBackfillBytes),
+				WithMaxMisplacedRatio(tt.maxMisplacedRatio),
+				WithMaxDegradedObjects(0),
+				WithMaxSlowOps(0),
+			)
+			if err != nil {
+				t.Fatalf("failed initializing rebalancer")
+			}
+
+			// Perform reweights.
+			if tt.iterations == 0 {
+				tt.iterations = 1
+			}
+			for i := tt.iterations; i > 0; i-- {
+				r.DoReweight()
+			}
+
+			assert.Len(t,
+				tc.CallsTo("CrushReweight"), tt.reweightCount*len(tt.osdTree.Nodes), "reweight counts should match")
+
+			assert.Equal(t,
+				tt.crushWeightMap, reweights(tc), "crush weight changes should match")
+		})
+	}
+}
+
+func TestFailureDomainLimit(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: -1, Type: "host", Children: []int{1, 2}},
+				{ID: -2, Type: "host", Children: []int{3}},
+				{ID: 1, Type: "osd"},
+				{ID: 2, Type: "osd"},
+				{ID: 3, Type: "osd"},
+			},
+		},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithWeightIncrement(1.0),
+		WithTargetCrushWeightMap(map[int]float64{
+			1: 1.0,
+			2: 1.0,
+			3: 1.0,
+		}),
+		WithDryRun(false),
+		WithMaxOSDsPerFailureDomain(1),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	r.DoReweight()
+	assert.Len(t, tc.CallsTo("CrushReweight"), 2, "only one osd per host should start ramping")
+	assert.Contains(t, tc.CrushWeights(), 3, "osd on the idle host should start ramping")
+
+	for i := 0; i < 3; i++ {
+		r.DoReweight()
+	}
+	assert.Equal(t, map[int]float64{1: 1.0, 2: 1.0, 3: 1.0}, tc.CrushWeights(),
+		"every osd should eventually be ramped")
+}
+
+func TestMaxWeightDeltaPerHost(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: -1, Type: "host", Children: []int{1, 2}},
+				{ID: 1, Type: "osd"},
+				{ID: 2, Type: "osd"},
+			},
+		},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithWeightIncrement(1.0),
+		WithTargetCrushWeightMap(map[int]float64{
+			1: 4.0,
+			2: 4.0,
+		}),
+		WithDryRun(false),
+		WithMaxWeightDeltaPerHost(1.5),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	r.DoReweight()
+
+	var total float64
+	for _, w := range tc.CrushWeights() {
+		total += w
+	}
+	assert.Len(t, tc.CallsTo("CrushReweight"), 2, "both osds should receive some weight")
+	assert.InDelta(t, 1.5, total, 1e-9, "host delta should be capped")
+}
+
+func TestMaxDuration(t *testing.T) {
+	tc := cephtest.New(cephtest.State{})
+	defer tc.Close()
+
+	targets := map[int]float64{1: 1.0, 2: 2.0}
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(targets),
+		WithSleepInterval(time.Hour),
+		WithRunImmediately(false),
+		WithMaxDuration(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	var summary Summary
+	done := make(chan error)
+	go func() {
+		var err error
+		summary, err = r.Run(context.Background())
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.Equal(t, ErrMaxDuration, err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("run did not stop after its maximum duration")
+	}
+	assert.Equal(t, targets, r.RemainingTargets(), "remaining osds should be left untouched")
+	assert.Equal(t, targets, summary.Remaining)
+	assert.False(t, summary.Finished())
+}
+
+func TestMaxIterations(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd"},
+			},
+		},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+		WithWeightIncrement(0.1),
+		WithSleepInterval(time.Millisecond),
+		WithMaxIterations(3),
+		WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	var summary Summary
+	done := make(chan error)
+	go func() {
+		var err error
+		summary, err = r.Run(context.Background())
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.Equal(t, ErrMaxIterations, err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("run did not stop after its maximum iterations")
+	}
+	assert.Len(t, tc.CallsTo("CrushReweight"), 3, "exactly 3 increments should be applied")
+	assert.Equal(t, 3, summary.Iterations)
+	assert.InDelta(t, 0.3, tc.CrushWeights()[1], 1e-9)
+}
+
+func TestRunImmediately(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		runImmediately bool
+		reweightCount  int
+	}{
+		{name: "Immediately", runImmediately: true, reweightCount: 1},
+		{name: "After Interval", runImmediately: false, reweightCount: 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := cephtest.New(cephtest.State{
+				OSDTree: &cephclient.OSDTreeOut{
+					Nodes: []cephclient.OSDTreeNode{
+						{ID: 1, Type: "osd"},
+					},
+				},
+			})
+			defer tc.Close()
+
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+				WithSleepInterval(time.Hour),
+				WithRunImmediately(tt.runImmediately),
+				WithMaxDuration(50*time.Millisecond),
+				WithDryRun(false),
+			)
+			if err != nil {
+				t.Fatalf("failed initializing rebalancer")
+			}
+
+			r.Run(context.Background())
+			assert.Len(t, tc.CallsTo("CrushReweight"), tt.reweightCount, "reweight count should match")
+		})
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd", CrushWeight: 0.5},
+			},
+		},
+	})
+	defer tc.Close()
+
+	logger, hook := logtest.NewNullLogger()
+	std := logtest.NewGlobal()
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1}),
+		WithWeightIncrement(0.5),
+		WithLogger(logger.WithField("component", "rebalancer")),
+	)
+	assert.NoError(t, err)
+
+	_, err = r.RunOnce(context.Background())
+	assert.NoError(t, err)
+	assert.NotEmpty(t, hook.AllEntries())
+	for _, e := range hook.AllEntries() {
+		assert.Equal(t, "rebalancer", e.Data["component"])
+	}
+	assert.Empty(t, std.AllEntries(), "nothing should be logged to the standard logger")
+	log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opt  Option
+	}{
+		{name: "Zero Increment", opt: WithWeightIncrement(0)},
+		{name: "Negative Increment", opt: WithWeightIncrement(-0.1)},
+		{name: "Zero Sleep Interval", opt: WithSleepInterval(0)},
+		{name: "Inverted Sleep Range", opt: func(r *Rebalancer) {
+			r.minSleepInterval = time.Hour
+			r.maxSleepInterval = time.Minute
+		}},
+		{name: "Negative Backfilling PGs", opt: WithMaxBackfillPGsAllowed(-1)},
+		{name: "Negative Recovering PGs", opt: WithMaxRecoveryPGsAllowed(-1)},
+		{name: "Negative Inactive PGs", opt: WithMaxInactivePGsAllowed(-1)},
+		{name: "Slow Ops Below Disabled", opt: WithMaxSlowOps(-2)},
+		{name: "Percentile Above 100", opt: WithOSDLatencyPercentile(101)},
+		{name: "No Missing Iterations", opt: WithMaxMissingIterations(0)},
+		{name: "Unknown Down OSD Policy", opt: WithDownOSDPolicy("ignore")},
+		{name: "Negative Precision", opt: WithWeightPrecision(-1)},
+		{name: "Precision Beyond Crush", opt: WithWeightPrecision(5)},
+		{name: "Increment Below Precision", opt: func(r *Rebalancer) {
+			r.weightIncrement = 0.001
+			r.weightPrecision = 2
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(
+				WithCephClient(cephtest.New(cephtest.State{})),
+				WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+				tt.opt,
+			)
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := New(
+		WithCephClient(cephtest.New(cephtest.State{})),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+		WithMaxSlowOps(-1),
+		WithMaxInactivePGsAllowed(0),
+	)
+	assert.NoError(t, err, "-1 should disable thresholds that allow it")
+}
+
+func TestReconfigure(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd"},
+				{ID: 2, Type: "osd"},
+			},
+		},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+		WithWeightIncrement(0.1),
+		WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	r.DoReweight()
+	assert.InDelta(t, 0.1, tc.CrushWeights()[1], 1e-9)
+
+	err = r.Reconfigure(context.Background(), WithFlagPolicy("bogus"), WithWeightIncrement(0.5))
+	assert.Error(t, err, "invalid options should be rejected")
+	assert.Equal(t, 0.1, r.weightIncrement, "no option should be applied on error")
+
+	err = r.Reconfigure(context.Background(), WithWeightIncrement(-0.5))
+	assert.Error(t, err, "nonsensical options should be rejected")
+	assert.Equal(t, 0.1, r.weightIncrement, "no option should be applied on error")
+
+	err = r.Reconfigure(context.Background(),
+		WithWeightIncrement(0.5),
+		WithSleepInterval(time.Hour),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0, 2: 1.0}),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, r.interval, "sleep interval should be updated")
+
+	r.DoReweight()
+	assert.InDelta(t, 0.6, tc.CrushWeights()[1], 1e-9, "new increment should be used")
+	assert.InDelta(t, 0.5, tc.CrushWeights()[2], 1e-9, "new target should be reweighted")
+}
+
+func TestReconfigureTargets(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd"},
+				{ID: 2, Type: "osd"},
+				{ID: 3, Type: "osd"},
+			},
+		},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 0.2, 2: 0.2}),
+		WithWeightIncrement(0.1),
+		WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	for i := 0; i < 3; i++ {
+		r.DoReweight()
+	}
+	assert.ElementsMatch(t, []int{1, 2}, r.Summary().Completed)
+
+	// Completed OSDs stay completed unless their target changed.
+	assert.NoError(t, r.Reconfigure(context.Background(), WithTargetCrushWeightMap(map[int]float64{1: 0.2, 2: 0.4, 3: 0.1})))
+	assert.Equal(t, map[int]float64{2: 0.4, 3: 0.1}, r.RemainingTargets())
+	assert.Equal(t, []int{1}, r.Summary().Completed)
+	assert.False(t, r.Progress().OSDs[2].Completed, "osd taken up again should no longer be completed")
+
+	// OSDs left out are removed, and can be taken up again.
+	assert.NoError(t, r.Reconfigure(context.Background(), WithTargetCrushWeightMap(map[int]float64{1: 0.2, 2: 0.4})))
+	assert.Equal(t, map[int]float64{2: 0.4}, r.RemainingTargets())
+	assert.Equal(t, map[int]string{3: "removed from the targets"}, r.Summary().Skipped)
+
+	assert.NoError(t, r.Reconfigure(context.Background(), WithTargetCrushWeightMap(map[int]float64{1: 0.2, 2: 0.4, 3: 0.1})))
+	assert.Equal(t, map[int]float64{2: 0.4, 3: 0.1}, r.RemainingTargets())
+	assert.Empty(t, r.Summary().Skipped)
+
+	for i := 0; i < 3; i++ {
+		r.DoReweight()
+	}
+	assert.InDelta(t, 0.2, tc.CrushWeights()[1], 1e-9, "completed osd should not be reweighted again")
+	assert.InDelta(t, 0.4, tc.CrushWeights()[2], 1e-9)
+	assert.InDelta(t, 0.1, tc.CrushWeights()[3], 1e-9)
+}
+
+func TestCheckHealth(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		health      *cephclient.HealthOut
+		pauseChecks []string
+
+		ok      bool
+		aborted bool
+	}{
+		{
+			name:   "Healthy",
+			health: &cephclient.HealthOut{Status: "HEALTH_OK"},
+			ok:     true,
+		},
+		{
+			name: "Unselected Warning",
+			health: &cephclient.HealthOut{
+				Status: "HEALTH_WARN",
+				Checks: map[string]cephclient.HealthCheck{
+					"OSDMAP_FLAGS": {Severity: "HEALTH_WARN"},
+				},
+			},
+			pauseChecks: []string{"OSD_NEARFULL"},
+			ok:          true,
+		},
+		{
+			name: "Selected Warning",
+			health: &cephclient.HealthOut{
+				Status: "HEALTH_WARN",
+				Checks: map[string]cephclient.HealthCheck{
+					"OSD_NEARFULL": {Severity: "HEALTH_WARN"},
+				},
+			},
+			pauseChecks: []string{"OSD_NEARFULL"},
+			ok:          false,
+		},
+		{
+			name: "Any Warning",
+			health: &cephclient.HealthOut{
+				Status: "HEALTH_WARN",
+				Checks: map[string]cephclient.HealthCheck{
+					"OSDMAP_FLAGS": {Severity: "HEALTH_WARN"},
+				},
+			},
+			pauseChecks: []string{"*"},
+			ok:          false,
+		},
+		{
+			name:    "Error",
+			health:  &cephclient.HealthOut{Status: "HEALTH_ERR"},
+			ok:      false,
+			aborted: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := cephtest.New(cephtest.State{
+				Health: tt.health,
+			})
+			defer tc.Close()
+
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+				WithPauseOnHealthWarnChecks(tt.pauseChecks),
+			)
+			if err != nil {
+				t.Fatalf("failed initializing rebalancer")
+			}
+
+			ok, _, err := r.healthGate(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.ok, ok, "health check result should match")
+			assert.Equal(t, tt.aborted, r.abortErr != nil, "abort state should match")
+		})
+	}
+}
+
+func TestCheckFlags(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		flags   string
+		policy  string
+		allowed []string
+
+		ok      bool
+		aborted bool
+	}{
+		{
+			name:   "No Conflicting Flags",
+			flags:  "sortbitwise,recovery_deletes,purged_snapdirs",
+			policy: FlagPolicyAbort,
+			ok:     true,
+		},
+		{
+			name:   "Pause",
+			flags:  "sortbitwise,norebalance",
+			policy: FlagPolicyPause,
+			ok:     false,
+		},
+		{
+			name:    "Abort",
+			flags:   "sortbitwise,nobackfill",
+			policy:  FlagPolicyAbort,
+			ok:      false,
+			aborted: true,
+		},
+		{
+			name:   "Ignore",
+			flags:  "norecover",
+			policy: FlagPolicyIgnore,
+			ok:     true,
+		},
+		{
+			name:    "Allowed",
+			flags:   "sortbitwise,nobackfill",
+			policy:  FlagPolicyAbort,
+			allowed: []string{"nobackfill"},
+			ok:      true,
+		},
+		{
+			name:   "Noout",
+			flags:  "sortbitwise,noout",
+			policy: FlagPolicyAbort,
+			ok:     true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := cephtest.New(cephtest.State{
+				OSDDump: &cephclient.OSDDumpOut{Flags: tt.flags},
+			})
+			defer tc.Close()
+
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+				WithFlagPolicy(tt.policy),
+				WithAllowedFlags(tt.allowed),
+			)
+			if err != nil {
+				t.Fatalf("failed initializing rebalancer")
+			}
+
+			ok, _, err := r.flagGate(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.ok, ok, "flag check result should match")
+			assert.Equal(t, tt.aborted, r.abortErr != nil, "abort state should match")
+		})
+	}
+}
+
+func TestCheckBalancer(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		active bool
+		policy string
+
+		ok       bool
+		aborted  bool
+		toggles  []bool
+		restored []bool
+	}{
+		{
+			name:   "Inactive",
+			policy: BalancerPolicyRefuse,
+			ok:     true,
+		},
+		{
+			name:    "Refuse",
+			active:  true,
+			policy:  BalancerPolicyRefuse,
+			aborted: true,
+		},
+		{
+			name:   "Ignore",
+			active: true,
+			policy: BalancerPolicyIgnore,
+			ok:     true,
+		},
+		{
+			name:     "Disable",
+			active:   true,
+			policy:   BalancerPolicyDisable,
+			ok:       true,
+			toggles:  []bool{false},
+			restored: []bool{false, true},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := cephtest.New(cephtest.State{
+				BalancerActive: tt.active,
+			})
+			defer tc.Close()
+
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+				WithBalancerPolicy(tt.policy),
+				WithDryRun(false),
+			)
+			if err != nil {
+				t.Fatalf("failed initializing rebalancer")
+			}
+
+			assert.Equal(t, tt.ok, r.checkBalancer(context.Background()), "balancer check result should match")
+			assert.Equal(t, tt.aborted, r.abortErr != nil, "abort state should match")
+			assert.Equal(t, tt.toggles, balancerToggles(tc), "balancer toggles should match")
+
+			r.restoreBalancer(context.Background())
+			if tt.restored == nil {
+				tt.restored = tt.toggles
+			}
+			assert.Equal(t, tt.restored, balancerToggles(tc), "balancer should be restored")
+		})
+	}
+}
+
+// balancerToggles returns the ceph balancer toggles made through cc, in order,
+// with true for enabling it.
+func balancerToggles(cc *cephtest.Client) []bool {
+	var toggles []bool
+	for _, call := range cc.Calls() {
+		switch call.Method {
+		case "EnableCephBalancer":
+			toggles = append(toggles, true)
+		case "DisableCephBalancer":
+			toggles = append(toggles, false)
+		}
+	}
+	return toggles
+}
+
+func TestRunRestoresBalancerOnCancel(t *testing.T) {
+	cc := cephtest.New(cephtest.State{
+		OSDTree:        cephtest.NewOSDTree(cephtest.OSD{ID: 1, Host: "a", CrushWeight: 1}),
+		BalancerActive: true,
+	})
+	r, err := New(
+		WithCephClient(cc),
+		WithTargetCrushWeightMap(map[int]float64{1: 2}),
+		WithBalancerPolicy(BalancerPolicyDisable),
+		WithSleepInterval(time.Hour),
+		WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := r.Run(ctx)
+		done <- err
+	}()
+
+	// Cancel the run while it waits for its first iteration, as
+	// SIGINT or SIGTERM would.
+	assert.Eventually(t, func() bool {
+		return len(cc.CallsTo("DisableCephBalancer")) == 1
+	}, 5*time.Second, 10*time.Millisecond, "balancer should be disabled")
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	assert.Len(t, cc.CallsTo("EnableCephBalancer"), 1, "balancer should be re-enabled")
+	status, err := cc.BalancerStatus(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, status.Active, "balancer should be active again")
+}
+
+func TestFullOSDs(t *testing.T) {
+	tree := &cephclient.OSDTreeOut{
+		Nodes: []cephclient.OSDTreeNode{
+			{ID: -1, Type: "host", Children: []int{1, 2}},
+			{ID: -2, Type: "host", Children: []int{3}},
+			{ID: 1, Type: "osd"},
+			{ID: 2, Type: "osd"},
+			{ID: 3, Type: "osd"},
+		},
+	}
+
+	for _, tt := range []struct {
+		name string
+
+		scope string
+		dump  *cephclient.OSDDumpOut
+		full  []int
+	}{
+		{
+			name:  "Disabled",
+			scope: FullScopeNone,
+			dump: &cephclient.OSDDumpOut{
+				OSDs: []cephclient.OSDInfo{{OSD: 3, State: []string{"exists", "up", "full"}}},
+			},
+			full: nil,
+		},
+		{
+			name:  "Any",
+			scope: FullScopeAny,
+			dump: &cephclient.OSDDumpOut{
+				OSDs: []cephclient.OSDInfo{{OSD: 3, State: []string{"exists", "up", "nearfull"}}},
+			},
+			full: []int{3},
+		},
+		{
+			name:  "Targets Ignores Others",
+			scope: FullScopeTargets,
+			dump: &cephclient.OSDDumpOut{
+				OSDs: []cephclient.OSDInfo{{OSD: 2, State: []string{"exists", "up", "backfillfull"}}},
+			},
+			full: nil,
+		},
+		{
+			name:  "Subtree Includes Neighbours",
+			scope: FullScopeSubtree,
+			dump: &cephclient.OSDDumpOut{
+				OSDs: []cephclient.OSDInfo{
+					{OSD: 2, State: []string{"exists", "up", "backfillfull"}},
+					{OSD: 3, State: []string{"exists", "up", "full"}},
+				},
+			},
+			full: []int{2},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := cephtest.New(cephtest.State{
+				OSDTree: tree,
+				OSDDump: tt.dump,
+			})
+			defer tc.Close()
+
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+				WithFullOSDScope(tt.scope),
+			)
+			if err != nil {
+				t.Fatalf("failed initializing rebalancer")
+			}
+
+			full, err := r.fullOSDs(context.Background(), tree, r.extractAncestors(tree, r.failureDomain))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.full, full, "full osds should match")
+		})
+	}
+}
+
+func TestHeartbeatLatency(t *testing.T) {
+	ping := func(avg float64, stale bool) cephclient.OSDPing {
+		p := cephclient.OSDPing{Stale: stale}
+		p.Average.OneMin = avg
+		return p
+	}
+
+	tc := cephtest.New(cephtest.State{
+		OSDNetworkPings: &cephclient.OSDNetworkOut{
+			Entries: []cephclient.OSDPing{
+				ping(1.5, false),
+				ping(12.25, false),
+				ping(900, true), // Stale entries are ignored.
+			},
+		},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	latency, err := r.heartbeatLatency(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 12250*time.Microsecond, latency, "highest non-stale latency should be reported")
+}
+
+func TestOSDLatency(t *testing.T) {
+	perf := &cephclient.OSDPerfOut{}
+	for i := 1; i <= 100; i++ {
+		info := cephclient.OSDPerfInfo{ID: i}
+		info.PerfStats.CommitLatencyMS = float64(i)
+		info.PerfStats.ApplyLatencyMS = float64(i) / 2
+		perf.OSDPerfInfos = append(perf.OSDPerfInfos, info)
+	}
+
+	for _, tt := range []struct {
+		percentile float64
+		latency    time.Duration
+	}{
+		{percentile: 50, latency: 50 * time.Millisecond},
+		{percentile: 99, latency: 99 * time.Millisecond},
+		{percentile: 100, latency: 100 * time.Millisecond},
+		{percentile: 0, latency: 1 * time.Millisecond},
+	} {
+		tc := cephtest.New(cephtest.State{
+			OSDPerf: perf,
+		})
+
+		r, err := New(
+			WithCephClient(tc),
+			WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+			WithOSDLatencyPercentile(tt.percentile),
+		)
+		if err != nil {
+			t.Fatalf("failed initializing rebalancer")
+		}
+
+		latency, err := r.osdLatency(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, tt.latency, latency, "p%v latency should match", tt.percentile)
+	}
+}
+
+func newTestQuorumStatus(mons int, quorum ...int) *cephclient.QuorumStatusOut {
+	qs := &cephclient.QuorumStatusOut{Quorum: quorum}
+	for i := 0; i < mons; i++ {
+		qs.MonMap.Mons = append(qs.MonMap.Mons, cephclient.MonInfo{Rank: i})
+	}
+	return qs
+}
+
+func newTestPGStat(state string, bytes int64) cephclient.PGStat {
+	pg := cephclient.PGStat{State: state}
+	pg.StatSum.NumBytes = bytes
+	return pg
+}
+
+func newTestOSDPerfInfo(id int, commitMS, applyMS float64) cephclient.OSDPerfInfo {
+	info := cephclient.OSDPerfInfo{ID: id}
+	info.PerfStats.CommitLatencyMS = commitMS
+	info.PerfStats.ApplyLatencyMS = applyMS
+	return info
+}
+
+// setPGs sets the number of PGs in the given state served by cc.
+func setPGs(cc *cephtest.Client, state string, n int) {
+	cc.Update(func(s *cephtest.State) {
+		if s.PGsByState == nil {
+			s.PGsByState = map[string]int{}
+		}
+		s.PGsByState[state] = n
+	})
+}
+
+// reweights returns the last crush weight each OSD was reweighted to through cc,
+// or nil if none was.
+func reweights(cc *cephtest.Client) map[int]float64 {
+	var weights map[int]float64
+	for _, call := range cc.CallsTo("CrushReweight") {
+		if weights == nil {
+			weights = map[int]float64{}
+		}
+		weights[call.Args[0].(int)] = call.Args[1].(float64)
+	}
+	return weights
+}
+
+func TestCollectDuringReweight(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd"},
+				{ID: 2, Type: "osd"},
+			},
+		},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1, 2: 1}),
+		WithWeightIncrement(0.01),
+		WithSleepInterval(time.Millisecond),
+		WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(context.Background())
+	}()
+
+	// Scrape until the run is done, which `go test -race` checks
+	// for unsynchronized access.
+	for {
+		ch := make(chan prometheus.Metric, 16)
+		go func() {
+			r.Collect(ch)
+			close(ch)
+		}()
+		for range ch {
+		}
+
+		select {
+		case <-done:
+			assert.Empty(t, r.RemainingTargets())
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestAddRemoveTargets(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd", CrushWeight: 1},
+				{ID: 2, Type: "osd", CrushWeight: 1},
+				{ID: 3, Type: "osd", CrushWeight: 1},
+			},
+		},
+		NotOKToStop: []int{3},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 2}),
+		WithAllowDownweight(true),
+		WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+	ctx := context.Background()
+
+	assert.NoError(t, r.AddTargets(ctx, map[int]float64{2: 0.5}))
+	assert.Equal(t, map[int]float64{1: 2, 2: 0.5}, r.RemainingTargets())
+
+	assert.Error(t, r.AddTargets(ctx, map[int]float64{2: 1.5, 3: 0}), "osds not ok to stop should be refused")
+	assert.Equal(t, map[int]float64{1: 2, 2: 0.5}, r.RemainingTargets(), "refused targets should not be added")
+	assert.NoError(t, r.abortErr, "a refused addition should not abort the run")
+
+	assert.Error(t, r.AddTargets(ctx, map[int]float64{2: -1}))
+
+	r.RemoveTargets(1, 4)
+	assert.Equal(t, map[int]float64{2: 0.5}, r.RemainingTargets())
+	assert.Equal(t, map[int]string{1: "removed from the targets"}, r.Summary().Skipped)
+}
+
+func TestAddTargetsRequeues(t *testing.T) {
+	cc := cephtest.New(cephtest.State{
+		OSDTree: cephtest.NewOSDTree(
+			cephtest.OSD{ID: 1, Host: "a"},
+			cephtest.OSD{ID: 2, Host: "a"},
+		),
+	})
+
+	r, err := New(
+		WithCephClient(cc),
+		WithTargetCrushWeightMap(map[int]float64{1: 0.2, 2: 0.2}),
+		WithWeightIncrement(0.1),
+		WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+	ctx := context.Background()
+
+	r.RemoveTargets(2)
+	for i := 0; i < 3; i++ {
+		r.DoReweight()
+	}
+	assert.Equal(t, []int{1}, r.Summary().Completed)
+	assert.Equal(t, map[int]string{2: "removed from the targets"}, r.Summary().Skipped)
+
+	assert.NoError(t, r.AddTargets(ctx, map[int]float64{1: 0.4, 2: 0.4}))
+	assert.Equal(t, map[int]float64{1: 0.4, 2: 0.4}, r.RemainingTargets())
+	assert.Empty(t, r.Summary().Completed, "osds added again should no longer be completed")
+	assert.Empty(t, r.Summary().Skipped, "osds added again should no longer be skipped")
+	assert.False(t, r.Progress().OSDs[1].Completed)
+
+	for i := 0; i < 5; i++ {
+		r.DoReweight()
+	}
+	assert.ElementsMatch(t, []int{1, 2}, r.Summary().Completed)
+	assert.InDelta(t, 0.4, cc.CrushWeights()[1], 1e-9)
+	assert.InDelta(t, 0.4, cc.CrushWeights()[2], 1e-9)
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd"},
+			},
+		},
+	})
+	defer tc.Close()
+
+	newRebalancer := func(reg prometheus.Registerer) (*Rebalancer, error) {
+		return New(
+			WithCephClient(tc),
+			WithTargetCrushWeightMap(map[int]float64{1: 1}),
+			WithMetricsRegistry(reg),
+		)
+	}
+
+	// Rebalancers registered with their own registries may coexist.
+	first, second := prometheus.NewRegistry(), prometheus.NewRegistry()
+	_, err := newRebalancer(first)
+	assert.NoError(t, err)
+	_, err = newRebalancer(second)
+	assert.NoError(t, err)
+
+	mfs, err := first.Gather()
+	assert.NoError(t, err)
+	var names []string
+	for _, mf := range mfs {
+		names = append(names, mf.GetName())
+	}
+	assert.Contains(t, names, fmt.Sprintf("%s_target_osds_total", serviceName))
+
+	_, err = newRebalancer(first)
+	assert.Error(t, err, "registering twice with the same registry should fail")
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import "errors"
+
+// recordPreRunWeight remembers the weight an OSD had before it was
+// first reweighted, as found by the iteration reweighting it.
+func (r *Rebalancer) recordPreRunWeight(osd int) {
+	if r.dryRun || r.rollingBack {
+		return
+	}
+	if _, ok := r.preRunWeights[osd]; ok {
+		return
+	}
+	op, ok := r.osdProgress[osd]
+	if !ok {
+		return
+	}
+	if r.preRunWeights == nil {
+		r.preRunWeights = make(map[int]float64)
+	}
+	r.preRunWeights[osd] = op.Start
+}
+
+// isRollingBack reports whether the run is rolling back.
+func (r *Rebalancer) isRollingBack() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rollingBack
+}
+
+// startRollback turns an abort because of a gate into a rollback of
+// the OSDs reweighted so far, if asked to, and reports whether it
+// did. OSDs paused because their weight was changed externally are
+// left to whoever changed them. The abort error is kept to be
+// returned once the rollback is done.
+func (r *Rebalancer) startRollback() bool {
+	if !r.rollbackOnAbort || r.rollingBack || !errors.Is(r.abortErr, ErrGateBlocked) {
+		return false
+	}
+
+	targets := make(map[int]float64, len(r.preRunWeights))
+	for osd, w := range r.preRunWeights {
+		if _, paused := r.pausedOSDs[osd]; !paused {
+			targets[osd] = w
+		}
+	}
+	if len(targets) == 0 {
+		return false
+	}
+
+	r.log().WithError(r.abortErr).WithField("osds", len(targets)).Error("aborting reweighting, rolling back reweighted osds")
+	r.lastErr = r.abortErr
+	r.rollbackErr = r.abortErr
+	r.abortErr = nil
+	r.rollingBack = true
+	r.targetCrushWeightMap = targets
+	r.completedOSDs = nil
+	r.skippedOSDs = nil
+	r.missingOSDs = nil
+	return true
+}
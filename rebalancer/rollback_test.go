@@ -0,0 +1,155 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollbackOnAbort(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		rollback bool
+
+		weights    map[int]float64
+		rolledBack bool
+	}{
+		{
+			name:    "Disabled",
+			weights: map[int]float64{1: 2, 2: 2},
+		},
+		{
+			name:       "Enabled",
+			rollback:   true,
+			weights:    map[int]float64{1: 1, 2: 1},
+			rolledBack: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := cephtest.New(cephtest.State{
+				OSDTree: &cephclient.OSDTreeOut{
+					Nodes: []cephclient.OSDTreeNode{
+						{ID: 1, Type: "osd", CrushWeight: 1},
+						{ID: 2, Type: "osd", CrushWeight: 1},
+					},
+				},
+				Health: &cephclient.HealthOut{Status: "HEALTH_OK"},
+			})
+			defer tc.Close()
+
+			// The cluster breaks once both OSDs got two increments.
+			var reweights int
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(map[int]float64{1: 3, 2: 3}),
+				WithWeightIncrement(0.5),
+				WithSleepInterval(time.Millisecond),
+				WithAbortOnHealthErr(true),
+				WithRollbackOnAbort(tt.rollback),
+				WithDryRun(false),
+				WithEventHandler(func(e Event) {
+					if _, ok := e.(ReweightApplied); ok {
+						if reweights++; reweights == 4 {
+							tc.Update(func(s *cephtest.State) { s.Health = &cephclient.HealthOut{Status: "HEALTH_ERR"} })
+						}
+					}
+				}),
+			)
+			assert.NoError(t, err)
+
+			summary, err := r.Run(context.Background())
+			assert.True(t, errors.Is(err, ErrGateBlocked), "run should end with the abort error")
+			assert.Equal(t, tt.weights, tc.CrushWeights())
+			assert.Equal(t, tt.rolledBack, summary.RolledBack)
+			if tt.rolledBack {
+				assert.True(t, summary.Finished(), "rollback should finish")
+				assert.ElementsMatch(t, []int{1, 2}, summary.Completed)
+			}
+		})
+	}
+}
+
+func TestRollbackIgnoresMaxDuration(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd", CrushWeight: 1},
+			},
+		},
+		Health: &cephclient.HealthOut{Status: "HEALTH_OK"},
+	})
+	defer tc.Close()
+
+	// The cluster breaks once the OSD got two increments, so that the
+	// third iteration starts rolling back.
+	var reweights int
+	clock := newFakeClock(time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC))
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 3}),
+		WithWeightIncrement(0.5),
+		WithSleepInterval(10*time.Minute),
+		WithMaxDuration(25*time.Minute),
+		WithAbortOnHealthErr(true),
+		WithRollbackOnAbort(true),
+		WithDryRun(false),
+		WithClock(clock),
+		WithEventHandler(func(e Event) {
+			if _, ok := e.(ReweightApplied); ok {
+				if reweights++; reweights == 2 {
+					tc.Update(func(s *cephtest.State) { s.Health = &cephclient.HealthOut{Status: "HEALTH_ERR"} })
+				}
+			}
+		}),
+	)
+	assert.NoError(t, err)
+
+	type result struct {
+		summary Summary
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		summary, err := r.Run(context.Background())
+		done <- result{summary, err}
+	}()
+
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(2)
+		clock.Advance(10 * time.Minute)
+	}
+
+	// The maximum duration is reached while rolling back, which
+	// carries on regardless.
+	clock.BlockUntil(2)
+	clock.Advance(5 * time.Minute)
+	for i := 0; i < 4; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(10 * time.Minute)
+	}
+
+	res := <-done
+	assert.True(t, errors.Is(res.err, ErrGateBlocked), "run should end with the abort error")
+	assert.True(t, res.summary.RolledBack)
+	assert.Equal(t, map[int]float64{1: 1}, tc.CrushWeights())
+}
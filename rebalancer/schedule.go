@@ -0,0 +1,45 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"time"
+)
+
+// inActiveHours reports whether t falls within any of the active
+// hours, which is always the case when none are configured.
+func (r *Rebalancer) inActiveHours(t time.Time) bool {
+	if len(r.activeHours) == 0 {
+		return true
+	}
+	for _, w := range r.activeHours {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// activeHoursGate keeps iterations from reweighting outside of
+// the configured active hours. Without any windows configured
+// reweighting may happen at any time.
+func (r *Rebalancer) activeHoursGate(ctx context.Context) (bool, string, error) {
+	if !r.inActiveHours(r.now()) {
+		return false, "outside of active hours", nil
+	}
+
+	return true, "", nil
+}
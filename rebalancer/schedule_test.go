@@ -0,0 +1,65 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/digitalocean/archimedes/gates"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActiveHoursGate(t *testing.T) {
+	w, err := gates.ParseTimeWindow("22:00-06:00 UTC")
+	if err != nil {
+		t.Fatalf("failed parsing time window: %s", err)
+	}
+
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd"},
+			},
+		},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+		WithDryRun(false),
+		WithActiveHours(w),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	clock := newFakeClock(time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC))
+	r.clock = clock
+	r.DoReweight()
+	assert.Len(t, tc.CallsTo("CrushReweight"), 0, "no reweights should happen outside active hours")
+
+	clock.Set(time.Date(2021, 1, 1, 23, 0, 0, 0, time.UTC))
+	r.DoReweight()
+	assert.Len(t, tc.CallsTo("CrushReweight"), 1, "reweights should happen within active hours")
+
+	ok, _, err := r.activeHoursGate(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
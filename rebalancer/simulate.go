@@ -0,0 +1,143 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Simulation is the outcome of a reweighting campaign played through
+// in memory by Simulate.
+type Simulation struct {
+	// Start maps the target OSDs found in the osd tree to the weight
+	// they start from.
+	Start map[int]float64
+
+	// Iterations lists the weights each iteration would apply, by
+	// OSD.
+	Iterations []map[int]float64
+
+	// Duration estimates how long the campaign would take, sleeping
+	// between iterations but never being held back by a gate.
+	Duration time.Duration
+}
+
+// simulateWeights replaces the weights found in the osd tree by those
+// applied in earlier dry run iterations, so that successive dry runs
+// carry on from one another. Once dry run is turned off, the
+// simulated weights are forgotten rather than mistaken for weights
+// changed externally.
+func (r *Rebalancer) simulateWeights(cws map[int]float64) {
+	if !r.dryRun {
+		for osd := range r.simulatedWeights {
+			delete(r.crushWeightMap, osd)
+		}
+		r.simulatedWeights = nil
+		return
+	}
+
+	for osd, w := range r.simulatedWeights {
+		if _, ok := cws[osd]; ok {
+			cws[osd] = w
+		}
+	}
+}
+
+// simulateReweight records the weight an OSD would have been given by
+// a dry run iteration.
+func (r *Rebalancer) simulateReweight(osd int, weight float64) {
+	if r.simulatedWeights == nil {
+		r.simulatedWeights = make(map[int]float64)
+	}
+	r.simulatedWeights[osd] = weight
+	r.crushWeightMap[osd] = weight
+}
+
+// Simulate plays the remaining reweighting through in memory, from
+// the weights currently found in the osd tree, without changing
+// anything on the cluster or in the Rebalancer. Only the increments
+// and the number of OSDs per iteration are modelled; gates, active
+// hours and the caps per failure domain and host may make the actual
+// run take longer.
+func (r *Rebalancer) Simulate(ctx context.Context) (*Simulation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out, err := r.targetOSDTree(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get output of osd-tree: %w", err)
+	}
+
+	cws := r.extractCurrentWeights(out)
+	if r.dryRun {
+		r.simulateWeights(cws)
+	}
+
+	targets := make(map[int]float64, len(r.targetCrushWeightMap))
+	for osd, tw := range r.targetCrushWeightMap {
+		if _, ok := cws[osd]; ok {
+			targets[osd] = tw
+		}
+	}
+	applied := make(map[int]float64, len(r.crushWeightMap))
+	for osd, w := range r.crushWeightMap {
+		applied[osd] = w
+	}
+
+	sim := &Simulation{Start: make(map[int]float64, len(targets))}
+	for osd := range targets {
+		sim.Start[osd] = cws[osd]
+	}
+	last := r.lastReweightedOSD
+	for len(targets) > 0 {
+		if r.maxIterations > 0 && r.iterations+len(sim.Iterations) >= r.maxIterations {
+			break
+		}
+
+		weights := make(map[int]float64)
+		for _, osd := range r.orderOSDs(targets, last) {
+			if r.maxOSDsPerIteration > 0 && len(weights) >= r.maxOSDsPerIteration {
+				break
+			}
+
+			cw, tw := cws[osd], targets[osd]
+			if r.weightsEqual(cw, tw) {
+				delete(targets, osd)
+				continue
+			}
+			weight := r.nextWeight(cw, tw)
+			if w, started := applied[osd]; started && r.weightsEqual(w, weight) {
+				delete(targets, osd)
+				continue
+			}
+
+			cws[osd], applied[osd], weights[osd] = weight, weight, weight
+			last = osd
+		}
+		if len(weights) == 0 {
+			break
+		}
+		sim.Iterations = append(sim.Iterations, weights)
+	}
+
+	interval := r.sleepInterval
+	if r.adaptiveSleep() {
+		interval = r.minSleepInterval
+	}
+	sim.Duration = time.Duration(len(sim.Iterations)) * interval
+	return sim, nil
+}
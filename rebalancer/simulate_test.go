@@ -0,0 +1,127 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulate(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		maxOSDs int
+
+		iterations []map[int]float64
+	}{
+		{
+			name: "Uncapped",
+			iterations: []map[int]float64{
+				{1: 0.25, 2: 0.25, 3: 0.75},
+				{1: 0.5, 2: 0.5, 3: 0.5},
+				{1: 0.75},
+				{1: 1},
+			},
+		},
+		{
+			name:    "Capped",
+			maxOSDs: 2,
+			iterations: []map[int]float64{
+				{1: 0.25, 2: 0.25},
+				{3: 0.75, 1: 0.5},
+				{2: 0.5, 3: 0.5},
+				{1: 0.75},
+				{1: 1},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := cephtest.New(cephtest.State{
+				OSDTree: &cephclient.OSDTreeOut{
+					Nodes: []cephclient.OSDTreeNode{
+						{ID: 1, Type: "osd", CrushWeight: 0},
+						{ID: 2, Type: "osd", CrushWeight: 0},
+						{ID: 3, Type: "osd", CrushWeight: 1},
+					},
+				},
+			})
+			defer tc.Close()
+
+			targets := map[int]float64{1: 1, 2: 0.5, 3: 0.5}
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(targets),
+				WithWeightIncrement(0.25),
+				WithMaxOSDsPerIteration(tt.maxOSDs),
+				WithSleepInterval(time.Minute),
+			)
+			assert.NoError(t, err)
+
+			sim, err := r.Simulate(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, map[int]float64{1: 0, 2: 0, 3: 1}, sim.Start)
+			assert.Equal(t, tt.iterations, sim.Iterations)
+			assert.Equal(t, time.Duration(len(tt.iterations))*time.Minute, sim.Duration)
+
+			assert.Equal(t, targets, r.RemainingTargets(), "simulating should leave the targets intact")
+			assert.Empty(t, tc.CallsTo("CrushReweight"))
+		})
+	}
+}
+
+func TestDryRunCampaign(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd", CrushWeight: 0},
+				{ID: 2, Type: "osd", CrushWeight: 1},
+			},
+		},
+	})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1, 2: 0}),
+		WithWeightIncrement(0.5),
+		WithDryRun(true),
+	)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	sim, err := r.Simulate(ctx)
+	assert.NoError(t, err)
+
+	var reweights []map[int]float64
+	for {
+		res, err := r.RunOnce(ctx)
+		assert.NoError(t, err)
+		if res.Done {
+			break
+		}
+		if len(res.Reweights) > 0 {
+			reweights = append(reweights, res.Reweights)
+		}
+	}
+	assert.Equal(t, sim.Iterations, reweights, "dry run should carry on from one iteration to the next")
+	assert.ElementsMatch(t, []int{1, 2}, r.Summary().Completed)
+	assert.Empty(t, tc.CallsTo("CrushReweight"), "dry run should not reweight")
+}
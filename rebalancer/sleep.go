@@ -0,0 +1,69 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"time"
+)
+
+// adaptiveSleep reports whether the sleep interval adapts to how
+// quickly the cluster drains backfill.
+func (r *Rebalancer) adaptiveSleep() bool {
+	return r.minSleepInterval > 0 && r.maxSleepInterval >= r.minSleepInterval
+}
+
+// nextSleepInterval returns the duration to sleep for until the
+// next run. With an adaptive sleep interval, the backfilling PGs are
+// sampled every run: the interval is halved once the cluster has
+// absorbed all backfill, and doubled while the number of PGs in
+// backfill or backfill_wait fails to go down since the last run.
+func (r *Rebalancer) nextSleepInterval(ctx context.Context) time.Duration {
+	if !r.adaptiveSleep() {
+		return r.sleepInterval
+	}
+
+	bpgs, err := r.backfillingPGs(ctx, nil)
+	if err != nil {
+		r.log().WithError(err).Warn("failed sampling backfilling pgs, keeping sleep interval")
+		return r.interval
+	}
+
+	switch {
+	case bpgs == 0:
+		r.interval = r.clampSleepInterval(r.interval / 2)
+	case r.lastBackfillPGs >= 0 && bpgs >= r.lastBackfillPGs:
+		r.interval = r.clampSleepInterval(r.interval * 2)
+	}
+	r.lastBackfillPGs = bpgs
+
+	r.log().WithField("backfill.pgs", bpgs).WithField("interval", r.interval).Debug("adapted sleep interval")
+	return r.interval
+}
+
+// clampSleepInterval bounds the interval to the adaptive range, if
+// there is one.
+func (r *Rebalancer) clampSleepInterval(d time.Duration) time.Duration {
+	if !r.adaptiveSleep() {
+		return d
+	}
+	if d < r.minSleepInterval {
+		return r.minSleepInterval
+	}
+	if d > r.maxSleepInterval {
+		return r.maxSleepInterval
+	}
+	return d
+}
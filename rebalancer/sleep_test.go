@@ -0,0 +1,68 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextSleepInterval(t *testing.T) {
+	tc := cephtest.New(cephtest.State{})
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+		WithSleepInterval(4*time.Minute),
+		WithMinSleepInterval(time.Minute),
+		WithMaxSleepInterval(10*time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	for _, step := range []struct {
+		backfillingPGs int
+		interval       time.Duration
+	}{
+		{backfillingPGs: 20, interval: 4 * time.Minute},
+		{backfillingPGs: 10, interval: 4 * time.Minute},
+		{backfillingPGs: 10, interval: 8 * time.Minute},
+		{backfillingPGs: 12, interval: 10 * time.Minute},
+		{backfillingPGs: 0, interval: 5 * time.Minute},
+		{backfillingPGs: 0, interval: 150 * time.Second},
+		{backfillingPGs: 0, interval: 75 * time.Second},
+		{backfillingPGs: 0, interval: time.Minute},
+	} {
+		setPGs(tc, "active+remapped+backfilling", step.backfillingPGs)
+		assert.Equal(t, step.interval, r.nextSleepInterval(context.Background()),
+			"interval after sampling %d backfilling pgs should match", step.backfillingPGs)
+	}
+
+	r, err = New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+		WithSleepInterval(4*time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+	assert.Equal(t, 4*time.Minute, r.nextSleepInterval(context.Background()), "interval should be fixed by default")
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"errors"
+	"time"
+)
+
+// State is what a run needs to carry on in another process from
+// where it was left, e.g. after a crash. It is handed to the handlers
+// given through WithStateHandler after every iteration, and taken
+// back through WithState.
+type State struct {
+	// SavedAt is when the state was taken.
+	SavedAt time.Time `json:"saved_at"`
+
+	// Remaining maps the OSDs left to be reweighted to their target
+	// weight.
+	Remaining map[int]float64 `json:"remaining"`
+
+	// Applied maps the OSDs reweighted so far to the weight last
+	// applied to them.
+	Applied map[int]float64 `json:"applied"`
+
+	// PreRunWeights maps the OSDs reweighted so far to the weight
+	// they had before, for rolling back to.
+	PreRunWeights map[int]float64 `json:"pre_run_weights,omitempty"`
+
+	// Paused maps the OSDs whose weight was changed externally to
+	// the weight observed for them.
+	Paused map[int]float64 `json:"paused,omitempty"`
+
+	Completed []int          `json:"completed,omitempty"`
+	Skipped   map[int]string `json:"skipped,omitempty"`
+
+	// Iterations and Elapsed count against the maximum number of
+	// iterations and run duration.
+	Iterations int           `json:"iterations"`
+	Elapsed    time.Duration `json:"elapsed"`
+
+	// ReweightTokens is what is left of the hourly reweight budget,
+	// nil if reweights are not limited.
+	ReweightTokens *float64 `json:"reweight_tokens,omitempty"`
+
+	LastReweightedOSD int  `json:"last_reweighted_osd"`
+	CanaryPassed      bool `json:"canary_passed"`
+
+	// RollbackReason is the error a run rolling back was aborted
+	// with, empty unless rolling back.
+	RollbackReason string `json:"rollback_reason,omitempty"`
+
+	// TunedOptions holds the original values of the recovery
+	// options raised for the run, and DisabledBalancer whether the
+	// run disabled the Ceph balancer, for the resumed run to put
+	// the cluster back once done rather than keep the raised values.
+	TunedOptions     []TunedOption `json:"tuned_options,omitempty"`
+	DisabledBalancer bool          `json:"disabled_balancer,omitempty"`
+}
+
+// State returns the state of the run, for callers of RunOnce that
+// persist it on their own.
+func (r *Rebalancer) State() State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.state()
+}
+
+func (r *Rebalancer) state() State {
+	s := State{
+		SavedAt:           r.now(),
+		Remaining:         copyWeights(r.targetCrushWeightMap),
+		Applied:           copyWeights(r.crushWeightMap),
+		PreRunWeights:     copyWeights(r.preRunWeights),
+		Paused:            copyWeights(r.pausedOSDs),
+		Completed:         append([]int(nil), r.completedOSDs...),
+		Iterations:        r.iterations,
+		Elapsed:           r.elapsed,
+		LastReweightedOSD: r.lastReweightedOSD,
+		CanaryPassed:      r.canaryPassed,
+		TunedOptions:      append([]TunedOption(nil), r.tunedOptions...),
+		DisabledBalancer:  r.disabledBalancer,
+	}
+	if !r.runStartedAt.IsZero() {
+		s.Elapsed += r.now().Sub(r.runStartedAt)
+	}
+	if len(r.skippedOSDs) > 0 {
+		s.Skipped = make(map[int]string, len(r.skippedOSDs))
+		for osd, reason := range r.skippedOSDs {
+			s.Skipped[osd] = reason
+		}
+	}
+	if r.reweightLimiter != nil {
+		tokens, _ := r.reweightLimiter.Tokens()
+		s.ReweightTokens = &tokens
+	}
+	if r.rollingBack {
+		s.RollbackReason = r.rollbackErr.Error()
+	}
+	return s
+}
+
+// restoreState carries on from the state given through WithState,
+// replacing the target weights given through options.
+func (r *Rebalancer) restoreState(s *State) {
+	r.targetCrushWeightMap = copyWeights(s.Remaining)
+	r.crushWeightMap = copyWeights(s.Applied)
+	r.preRunWeights = copyWeights(s.PreRunWeights)
+	r.pausedOSDs = copyWeights(s.Paused)
+	r.completedOSDs = append([]int(nil), s.Completed...)
+	r.skippedOSDs = make(map[int]string, len(s.Skipped))
+	for osd, reason := range s.Skipped {
+		r.skippedOSDs[osd] = reason
+	}
+	r.iterations = s.Iterations
+	r.elapsed = s.Elapsed
+	r.lastReweightedOSD = s.LastReweightedOSD
+	r.canaryPassed = s.CanaryPassed
+	r.tunedOptions = append([]TunedOption(nil), s.TunedOptions...)
+	r.disabledBalancer = s.DisabledBalancer
+	if s.RollbackReason != "" {
+		r.rollingBack = true
+		r.rollbackErr = classify(ErrGateBlocked, errors.New(s.RollbackReason))
+	}
+}
+
+// saveState hands the state to the state handlers, if any.
+func (r *Rebalancer) saveState() {
+	if len(r.stateHandlers) == 0 {
+		return
+	}
+	s := r.state()
+	for _, h := range r.stateHandlers {
+		h(s)
+	}
+}
+
+func copyWeights(weights map[int]float64) map[int]float64 {
+	c := make(map[int]float64, len(weights))
+	for osd, w := range weights {
+		c[osd] = w
+	}
+	return c
+}
@@ -0,0 +1,151 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResumeFromState(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd", CrushWeight: 1},
+				{ID: 2, Type: "osd", CrushWeight: 1},
+			},
+		},
+	})
+	defer tc.Close()
+
+	opts := []Option{
+		WithCephClient(tc),
+		WithWeightIncrement(0.5),
+		WithSleepInterval(time.Millisecond),
+		WithMaxReweightsPerHour(10),
+		WithDryRun(false),
+	}
+
+	var saved []State
+	r, err := New(append(opts,
+		WithTargetCrushWeightMap(map[int]float64{1: 2, 2: 1.5}),
+		WithMaxIterations(1),
+		WithStateHandler(func(s State) { saved = append(saved, s) }),
+	)...)
+	assert.NoError(t, err)
+
+	_, err = r.Run(context.Background())
+	assert.Equal(t, ErrMaxIterations, err)
+	if !assert.Len(t, saved, 1, "state should be saved after every iteration") {
+		return
+	}
+	s := saved[0]
+	assert.Equal(t, map[int]float64{1: 2, 2: 1.5}, s.Remaining)
+	assert.Equal(t, map[int]float64{1: 1.5, 2: 1.5}, s.Applied)
+	assert.Equal(t, map[int]float64{1: 1, 2: 1}, s.PreRunWeights)
+	assert.Equal(t, 1, s.Iterations)
+	if assert.NotNil(t, s.ReweightTokens) {
+		assert.InDelta(t, 8, *s.ReweightTokens, 0.01)
+	}
+
+	// The targets of the resumed run come from the state.
+	r, err = New(append(opts,
+		WithTargetCrushWeightMap(map[int]float64{3: 1}),
+		WithState(s),
+	)...)
+	assert.NoError(t, err)
+
+	summary, err := r.Run(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, summary.Finished())
+	assert.ElementsMatch(t, []int{1, 2}, summary.Completed)
+	assert.Equal(t, 2, summary.Iterations, "iterations should carry on from the state")
+	assert.Empty(t, r.PausedOSDs(), "weights applied before resuming should not look changed externally")
+	assert.Equal(t, map[int]float64{1: 2, 2: 1.5}, tc.CrushWeights())
+
+	tokens, _ := r.reweightLimiter.Tokens()
+	assert.InDelta(t, 7, tokens, 0.01, "reweight budget should carry on from the state")
+}
+
+func TestResumeRestoresCluster(t *testing.T) {
+	cc := cephtest.New(cephtest.State{
+		OSDTree:        cephtest.NewOSDTree(cephtest.OSD{ID: 1, Host: "a", CrushWeight: 1}),
+		BalancerActive: true,
+		Config: map[string]map[string]string{
+			"osd": {osdMaxBackfillsOption: "2"},
+		},
+	})
+	opts := []Option{
+		WithCephClient(cc),
+		WithWeightIncrement(0.5),
+		WithSleepInterval(time.Millisecond),
+		WithOSDMaxBackfills(4),
+		WithOSDRecoveryMaxActive(8),
+		WithBalancerPolicy(BalancerPolicyDisable),
+		WithDryRun(false),
+	}
+
+	var saved []State
+	r, err := New(append(opts,
+		WithTargetCrushWeightMap(map[int]float64{1: 2}),
+		WithMaxIterations(1),
+		WithStateHandler(func(s State) { saved = append(saved, s) }),
+	)...)
+	assert.NoError(t, err)
+
+	_, err = r.Run(context.Background())
+	assert.Equal(t, ErrMaxIterations, err)
+	if !assert.Len(t, saved, 1, "state should be saved after every iteration") {
+		return
+	}
+	s := saved[0]
+	assert.Equal(t, []TunedOption{
+		{Key: osdMaxBackfillsOption, Value: "2", Set: true},
+		{Key: osdRecoveryMaxActiveOption},
+	}, s.TunedOptions)
+	assert.True(t, s.DisabledBalancer)
+
+	// The process crashes rather than putting the cluster back.
+	cc.Update(func(st *cephtest.State) {
+		st.Config["osd"][osdMaxBackfillsOption] = "4"
+		st.Config["osd"][osdRecoveryMaxActiveOption] = "8"
+		st.BalancerActive = false
+	})
+	gets := len(cc.CallsTo("ConfigGet"))
+
+	r, err = New(append(opts, WithState(s))...)
+	assert.NoError(t, err)
+
+	summary, err := r.Run(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, summary.Finished())
+	assert.Len(t, cc.CallsTo("ConfigGet"), gets, "raised options should not be taken for the originals")
+
+	val, set, err := cc.ConfigGet(context.Background(), "osd", osdMaxBackfillsOption)
+	assert.NoError(t, err)
+	assert.True(t, set)
+	assert.Equal(t, "2", val, "the original value from before the crash should be restored")
+	_, set, err = cc.ConfigGet(context.Background(), "osd", osdRecoveryMaxActiveOption)
+	assert.NoError(t, err)
+	assert.False(t, set, "the option unset before the crash should be removed")
+	status, err := cc.BalancerStatus(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, status.Active, "the balancer disabled before the crash should be re-enabled")
+}
@@ -0,0 +1,56 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+
+	"github.com/digitalocean/archimedes/cephclient"
+)
+
+// sharedStatusKey is the context key of the cluster status shared
+// within an iteration.
+type sharedStatusKey struct{}
+
+type sharedStatus struct {
+	status *cephclient.ClusterStatusOut
+}
+
+// withSharedStatus returns a context within which the cluster status
+// is fetched at most once, so that the gates of an iteration all see
+// the same status without each of them issuing `ceph status`.
+func withSharedStatus(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sharedStatusKey{}, &sharedStatus{})
+}
+
+// clusterStatus returns the cluster status shared within the context,
+// fetching it first if needed. Outside of an iteration, the status is
+// fetched on every call.
+func (r *Rebalancer) clusterStatus(ctx context.Context) (*cephclient.ClusterStatusOut, error) {
+	shared, ok := ctx.Value(sharedStatusKey{}).(*sharedStatus)
+	if ok && shared.status != nil {
+		return shared.status, nil
+	}
+
+	status, err := r.ceph.ClusterStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		shared.status = status
+	}
+	return status, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedStatus(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: -1, Type: "host", Children: []int{1}},
+				{ID: 1, Type: "osd"},
+			},
+		},
+	})
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+		WithMaxScrubbingPGs(0),
+		WithMaxSnapTrimPGs(0),
+		WithMaxMisplacedRatio(0.1),
+		WithMaxDegradedObjects(0),
+		WithMaxSlowOps(0),
+		WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	r.DoReweight()
+	assert.Len(t, tc.CallsTo("CrushReweight"), 1)
+	assert.Len(t, tc.CallsTo("ClusterStatus"), 1, "every gate should share a single status")
+
+	r.DoReweight()
+	assert.Len(t, tc.CallsTo("ClusterStatus"), 2, "every iteration should fetch a fresh status")
+
+	_, err = r.clusterStatus(context.Background())
+	assert.NoError(t, err)
+	_, err = r.clusterStatus(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, tc.CallsTo("ClusterStatus"), 4, "the status should not be shared outside of iterations")
+}
+
+func TestSharedStatusTick(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: -1, Type: "host", Children: []int{1}},
+				{ID: 1, Type: "osd"},
+			},
+		},
+	})
+
+	// Adaptive sleep and the impact score sample the backfilling PGs
+	// and the misplaced ratio ahead of the gates checking them again.
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+		WithWeightIncrement(0.1),
+		WithMinSleepInterval(time.Second),
+		WithMaxSleepInterval(time.Minute),
+		WithMaxImpactScore(1),
+		WithMaxMisplacedRatio(0.1),
+		WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer")
+	}
+
+	for i := 1; i <= 2; i++ {
+		_, done, _ := r.tick(context.Background())
+		assert.False(t, done)
+		assert.Len(t, tc.CallsTo("CrushReweight"), i)
+		assert.Len(t, tc.CallsTo("ClusterStatus"), i, "sleep, impact and gates should share a single status")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrMaxDuration is returned by Run when the maximum run duration
+	// passed before all target OSDs were processed.
+	ErrMaxDuration = errors.New("maximum run duration reached")
+
+	// ErrMaxIterations is returned by Run when the maximum number of
+	// iterations was run before all target OSDs were processed.
+	ErrMaxIterations = errors.New("maximum number of iterations reached")
+)
+
+// Summary describes the outcome of a run.
+type Summary struct {
+	// Completed lists the OSDs that finished reweighting, in the
+	// order they did.
+	Completed []int
+
+	// Skipped maps the OSDs given up on without finishing to the
+	// reason why.
+	Skipped map[int]string
+
+	// Remaining maps the OSDs left to be reweighted to their target
+	// weight.
+	Remaining map[int]float64
+
+	// Iterations is the number of iterations that reweighted at
+	// least one OSD.
+	Iterations int
+
+	// LastError is the last error met while reweighting, which need
+	// not have ended the run.
+	LastError error
+
+	// RolledBack reports whether the run was aborted and rolled the
+	// OSDs it reweighted back, see WithRollbackOnAbort. Completed
+	// and Remaining then refer to the rollback.
+	RolledBack bool
+}
+
+// Finished reports whether every target OSD has been processed.
+func (s Summary) Finished() bool {
+	return len(s.Remaining) == 0
+}
+
+// Summary returns a summary of the reweighting done so far.
+func (r *Rebalancer) Summary() Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.summary()
+}
+
+func (r *Rebalancer) summary() Summary {
+	s := Summary{
+		Completed:  append([]int(nil), r.completedOSDs...),
+		Skipped:    make(map[int]string, len(r.skippedOSDs)),
+		Remaining:  make(map[int]float64, len(r.targetCrushWeightMap)),
+		Iterations: r.iterations,
+		LastError:  r.lastErr,
+		RolledBack: r.rollingBack,
+	}
+	for osd, reason := range r.skippedOSDs {
+		s.Skipped[osd] = reason
+	}
+	for osd, w := range r.targetCrushWeightMap {
+		s.Remaining[osd] = w
+	}
+	return s
+}
+
+// completeOSD removes an OSD that finished reweighting from the
+// target map.
+func (r *Rebalancer) completeOSD(osd int) {
+	r.completedOSDs = append(r.completedOSDs, osd)
+	r.trackCompletion(osd)
+	if r.iteration != nil {
+		r.iteration.Completed = append(r.iteration.Completed, osd)
+	}
+	delete(r.targetCrushWeightMap, osd)
+	delete(r.missingOSDs, osd)
+	delete(r.pausedOSDs, osd)
+	r.emit(TargetReached{OSD: osd})
+}
+
+// skipOSD removes an OSD that can't be reweighted from the target
+// map, remembering why.
+func (r *Rebalancer) skipOSD(osd int, reason string) {
+	if r.skippedOSDs == nil {
+		r.skippedOSDs = make(map[int]string)
+	}
+	r.skippedOSDs[osd] = reason
+	if r.iteration != nil {
+		r.iteration.Skipped[osd] = reason
+	}
+	delete(r.targetCrushWeightMap, osd)
+	delete(r.missingOSDs, osd)
+	delete(r.pausedOSDs, osd)
+}
+
+// missOSD records that an OSD could not be found in this iteration,
+// e.g. because it flaps during its first boot or the mons answered
+// with a partial tree. The OSD is kept as a target and only skipped
+// once it has been missing for `maxMissingIterations` in a row.
+func (r *Rebalancer) missOSD(osd int, reason string, ll log.FieldLogger) {
+	if r.missingOSDs == nil {
+		r.missingOSDs = make(map[int]int)
+	}
+	r.missingOSDs[osd]++
+
+	ll = ll.WithField("reason", reason).WithField("missing.iterations", r.missingOSDs[osd])
+	if r.missingOSDs[osd] < r.maxMissingIterations {
+		ll.Warn("cannot find osd, retrying on the next iteration")
+		return
+	}
+	ll.Error("cannot find osd, giving up on it")
+	r.skipOSD(osd, reason)
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunSummary(t *testing.T) {
+	t.Run("Finished", func(t *testing.T) {
+		tc := cephtest.New(cephtest.State{
+			OSDTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{ID: 1, Type: "osd", CrushWeight: 0.5},
+					{ID: 2, Type: "osd", CrushWeight: 1},
+				},
+			},
+		})
+		defer tc.Close()
+
+		r, err := New(
+			WithCephClient(tc),
+			WithTargetCrushWeightMap(map[int]float64{1: 1, 2: 1, 3: 1}),
+			WithWeightIncrement(0.5),
+			WithSleepInterval(time.Millisecond),
+			WithDryRun(false),
+		)
+		assert.NoError(t, err)
+
+		summary, err := r.Run(context.Background())
+		assert.NoError(t, err)
+		assert.True(t, summary.Finished())
+		assert.ElementsMatch(t, []int{1, 2}, summary.Completed)
+		assert.Equal(t, map[int]string{3: "not found in the osd tree"}, summary.Skipped)
+		assert.Equal(t, 1, summary.Iterations)
+		assert.NoError(t, summary.LastError)
+	})
+
+	t.Run("Aborted", func(t *testing.T) {
+		tc := cephtest.New(cephtest.State{
+			OSDTree: &cephclient.OSDTreeOut{
+				Nodes: []cephclient.OSDTreeNode{
+					{ID: 1, Type: "osd", CrushWeight: 1},
+				},
+			},
+			BalancerActive: true,
+		})
+		defer tc.Close()
+
+		r, err := New(
+			WithCephClient(tc),
+			WithTargetCrushWeightMap(map[int]float64{1: 2}),
+			WithBalancerPolicy(BalancerPolicyRefuse),
+		)
+		assert.NoError(t, err)
+
+		summary, err := r.Run(context.Background())
+		assert.Error(t, err)
+		assert.Equal(t, err, summary.LastError)
+		assert.Equal(t, map[int]float64{1: 2}, summary.Remaining)
+		assert.False(t, summary.Finished())
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		tc := cephtest.New(cephtest.State{})
+		defer tc.Close()
+
+		r, err := New(
+			WithCephClient(tc),
+			WithTargetCrushWeightMap(map[int]float64{1: 2}),
+			WithSleepInterval(time.Hour),
+		)
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err = r.Run(ctx)
+		assert.True(t, errors.Is(err, context.Canceled))
+	})
+}
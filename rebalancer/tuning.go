@@ -0,0 +1,117 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"strconv"
+)
+
+// Recovery options raised for all OSDs for the duration of a run.
+const (
+	osdMaxBackfillsOption      = "osd_max_backfills"
+	osdRecoveryMaxActiveOption = "osd_recovery_max_active"
+)
+
+// TunedOption is the value an option had in the config database
+// before it was raised, and whether it was stored there at all.
+type TunedOption struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	Set   bool   `json:"set"`
+}
+
+// tuneRecovery raises the recovery options of all OSDs for the
+// duration of the run, remembering their original values so that
+// restoreRecovery can put them back. Options left at 0 are not
+// touched. Failing to raise an option only slows the campaign down,
+// so it is logged rather than fatal.
+func (r *Rebalancer) tuneRecovery(ctx context.Context) {
+	tunables := []struct {
+		key string
+		val int
+	}{
+		{osdMaxBackfillsOption, r.osdMaxBackfills},
+		{osdRecoveryMaxActiveOption, r.osdRecoveryMaxActive},
+	}
+
+	for _, t := range tunables {
+		key, val := t.key, t.val
+		if val <= 0 {
+			continue
+		}
+
+		ll := r.log().WithField("option", key).WithField("value", val)
+		if r.tuned(key) {
+			// The run resumed from carries the original value,
+			// while the cluster still has the raised one.
+			ll.Info("the option was raised by the resumed run already")
+			continue
+		}
+		if r.dryRun {
+			ll.Info("the option will be raised for all osds in the actual run")
+			continue
+		}
+
+		orig, set, err := r.ceph.ConfigGet(ctx, "osd", key)
+		if err != nil {
+			// Without the original value it couldn't be restored.
+			ll.WithError(err).Warn("failed to get the original value, leaving the option untouched")
+			continue
+		}
+
+		ll.Info("raising the option for all osds for the duration of the run")
+		if err := r.ceph.ConfigSet(ctx, "osd", key, strconv.Itoa(val)); err != nil {
+			ll.WithError(err).Warn("failed to raise the option")
+			continue
+		}
+		r.tunedOptions = append(r.tunedOptions, TunedOption{Key: key, Value: orig, Set: set})
+	}
+}
+
+// tuned reports whether the option was raised for the run already.
+func (r *Rebalancer) tuned(key string) bool {
+	for _, opt := range r.tunedOptions {
+		if opt.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreRecovery puts the options raised by tuneRecovery back to
+// their original values, or removes them from the config database
+// if they weren't stored there before.
+func (r *Rebalancer) restoreRecovery(ctx context.Context) {
+	var failed []TunedOption
+	for _, opt := range r.tunedOptions {
+		ll := r.log().WithField("option", opt.Key)
+
+		var err error
+		if opt.Set {
+			ll = ll.WithField("value", opt.Value)
+			ll.Info("restoring the option for all osds")
+			err = r.ceph.ConfigSet(ctx, "osd", opt.Key, opt.Value)
+		} else {
+			ll.Info("removing the option for all osds")
+			err = r.ceph.ConfigRemove(ctx, "osd", opt.Key)
+		}
+		if err != nil {
+			ll.WithError(err).Error("failed to restore the option, restore it manually")
+			failed = append(failed, opt)
+		}
+	}
+	r.tunedOptions = failed
+}
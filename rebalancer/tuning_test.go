@@ -0,0 +1,155 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTuneRecovery(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		config       map[string]map[string]string
+		dryRun       bool
+		configSetErr error
+
+		tuned    map[string]map[string]string
+		restored map[string]map[string]string
+	}{
+		{
+			name:   "Unset",
+			config: map[string]map[string]string{},
+			tuned: map[string]map[string]string{
+				"osd": {"osd_max_backfills": "4", "osd_recovery_max_active": "8"},
+			},
+			restored: map[string]map[string]string{"osd": {}},
+		},
+		{
+			name: "Set",
+			config: map[string]map[string]string{
+				"osd": {"osd_max_backfills": "2"},
+			},
+			tuned: map[string]map[string]string{
+				"osd": {"osd_max_backfills": "4", "osd_recovery_max_active": "8"},
+			},
+			restored: map[string]map[string]string{
+				"osd": {"osd_max_backfills": "2"},
+			},
+		},
+		{
+			name: "DryRun",
+			config: map[string]map[string]string{
+				"osd": {"osd_max_backfills": "2"},
+			},
+			dryRun: true,
+			tuned: map[string]map[string]string{
+				"osd": {"osd_max_backfills": "2"},
+			},
+			restored: map[string]map[string]string{
+				"osd": {"osd_max_backfills": "2"},
+			},
+		},
+		{
+			name: "Failed",
+			config: map[string]map[string]string{
+				"osd": {"osd_max_backfills": "2"},
+			},
+			configSetErr: errors.New("permission denied"),
+			tuned: map[string]map[string]string{
+				"osd": {"osd_max_backfills": "2"},
+			},
+			restored: map[string]map[string]string{
+				"osd": {"osd_max_backfills": "2"},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := cephtest.New(cephtest.State{Config: tt.config})
+			defer tc.Close()
+			if tt.configSetErr != nil {
+				tc.InjectFault("ConfigSet", cephtest.Fault{Err: tt.configSetErr})
+			}
+
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(map[int]float64{1: 1.0}),
+				WithOSDMaxBackfills(4),
+				WithOSDRecoveryMaxActive(8),
+				WithDryRun(tt.dryRun),
+			)
+			if err != nil {
+				t.Fatalf("failed initializing rebalancer")
+			}
+
+			r.tuneRecovery(context.Background())
+			assert.Equal(t, tt.tuned, tc.Config(), "tuned options should match")
+
+			r.restoreRecovery(context.Background())
+			assert.Equal(t, tt.restored, tc.Config(), "restored options should match")
+			assert.Empty(t, r.tunedOptions, "no option should be left to restore")
+		})
+	}
+}
+
+func TestRunRestoresRecoveryOnCancel(t *testing.T) {
+	cc := cephtest.New(cephtest.State{
+		OSDTree: cephtest.NewOSDTree(cephtest.OSD{ID: 1, Host: "a", CrushWeight: 1}),
+		Config: map[string]map[string]string{
+			"osd": {osdMaxBackfillsOption: "2"},
+		},
+	})
+	r, err := New(
+		WithCephClient(cc),
+		WithTargetCrushWeightMap(map[int]float64{1: 2}),
+		WithOSDMaxBackfills(4),
+		WithOSDRecoveryMaxActive(8),
+		WithSleepInterval(time.Hour),
+		WithDryRun(false),
+	)
+	if err != nil {
+		t.Fatalf("failed initializing rebalancer: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := r.Run(ctx)
+		done <- err
+	}()
+
+	// Cancel the run while it waits for its first iteration, as
+	// SIGINT or SIGTERM would.
+	assert.Eventually(t, func() bool {
+		return len(cc.CallsTo("ConfigSet")) == 2
+	}, 5*time.Second, 10*time.Millisecond, "recovery options should be raised")
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	assert.Equal(t, []cephtest.Call{
+		{Method: "ConfigSet", Args: []interface{}{"osd", osdMaxBackfillsOption, "4"}},
+		{Method: "ConfigSet", Args: []interface{}{"osd", osdRecoveryMaxActiveOption, "8"}},
+		{Method: "ConfigSet", Args: []interface{}{"osd", osdMaxBackfillsOption, "2"}},
+	}, cc.CallsTo("ConfigSet"), "the original value should be restored")
+	assert.Equal(t, []cephtest.Call{
+		{Method: "ConfigRemove", Args: []interface{}{"osd", osdRecoveryMaxActiveOption}},
+	}, cc.CallsTo("ConfigRemove"), "the option unset before should be removed")
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+)
+
+// epochPollInterval is how often the osdmap epoch is polled while
+// waiting for reweights to be published.
+const epochPollInterval = time.Second
+
+// osdmapEpoch returns the current osdmap epoch, or 0 when reweights
+// are not to wait for a new one or it cannot be read.
+func (r *Rebalancer) osdmapEpoch(ctx context.Context) int {
+	if !r.verifyReweights || r.osdmapWait <= 0 {
+		return 0
+	}
+
+	dump, err := r.ceph.OSDDump(ctx)
+	if err != nil {
+		r.log().WithError(err).Warn("cannot read osdmap epoch, not waiting for reweights to be published")
+		return 0
+	}
+	return dump.Epoch
+}
+
+// waitForEpoch waits up to `osdmapWait` for the osdmap to move past
+// the given epoch, so that the weights read back reflect reweights
+// applied since.
+func (r *Rebalancer) waitForEpoch(ctx context.Context, epoch int) {
+	timeout := r.getClock().NewTimer(r.osdmapWait)
+	defer timeout.Stop()
+
+	for {
+		dump, err := r.ceph.OSDDump(ctx)
+		if err == nil && dump.Epoch > epoch {
+			return
+		}
+
+		poll := r.getClock().NewTimer(epochPollInterval)
+		select {
+		case <-ctx.Done():
+			poll.Stop()
+			return
+		case <-timeout.C():
+			poll.Stop()
+			r.log().WithField("epoch", epoch).Warn("osdmap epoch did not change in time, verifying reweights anyway")
+			return
+		case <-poll.C():
+		}
+	}
+}
+
+// observeWeights reads back the weights of the given OSDs after they
+// were reweighted, waiting for the osdmap to move past the given
+// epoch first unless it is 0. It returns nil when verification is
+// disabled or the weights cannot be read, in which case reweights are
+// trusted to have taken effect.
+func (r *Rebalancer) observeWeights(ctx context.Context, osds []int, epoch int) map[int]float64 {
+	if !r.verifyReweights || len(osds) == 0 {
+		return nil
+	}
+	if epoch > 0 {
+		r.waitForEpoch(ctx, epoch)
+	}
+
+	// Like the targets, the weights are read back from the bucket the
+	// targets share rather than from the whole tree when it is known.
+	var tree *cephclient.OSDTreeOut
+	var err error
+	if r.treeBucket != "" {
+		tree, err = r.ceph.OSDTreeFrom(ctx, r.treeBucket, osds)
+		if err != nil || !treeHoldsOSDs(tree, osds) {
+			tree = nil
+		}
+	}
+	if tree == nil {
+		tree, err = r.ceph.OSDTreeOf(ctx, osds)
+	}
+	if err != nil {
+		r.log().WithError(err).Warn("cannot read back weights, reweights are not verified")
+		return nil
+	}
+
+	observed := make(map[int]float64, len(osds))
+	for _, node := range tree.Nodes {
+		if node.Type == "osd" {
+			observed[node.ID] = node.CrushWeight
+		}
+	}
+	return observed
+}
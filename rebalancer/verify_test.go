@@ -0,0 +1,212 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// clampClient silently clamps crush weights like a mon refusing
+// weights above a maximum would, while still reporting success.
+type clampClient struct {
+	*cephtest.Client
+	max float64
+}
+
+func (c *clampClient) CrushReweight(ctx context.Context, osdID int, crushWeight float64) error {
+	return c.Client.CrushReweight(ctx, osdID, math.Min(crushWeight, c.max))
+}
+
+func TestVerifyReweights(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		verify bool
+
+		paused  map[int]float64
+		applied int
+	}{
+		{
+			name:    "Verified",
+			verify:  true,
+			paused:  map[int]float64{},
+			applied: 1,
+		},
+		{
+			name:    "Unverified",
+			paused:  map[int]float64{1: 0.8},
+			applied: 2,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &clampClient{cephtest.New(cephtest.State{
+				OSDTree: &cephclient.OSDTreeOut{
+					Nodes: []cephclient.OSDTreeNode{
+						{ID: 1, Type: "osd", CrushWeight: 0},
+					},
+				},
+			}), 0.8}
+			defer tc.Close()
+
+			var applied int
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(map[int]float64{1: 2}),
+				WithWeightIncrement(0.5),
+				WithVerifyReweights(tt.verify),
+				WithEventHandler(func(e Event) {
+					if _, ok := e.(ReweightApplied); ok {
+						applied++
+					}
+				}),
+				WithDryRun(false),
+			)
+			assert.NoError(t, err)
+
+			for i := 0; i < 3; i++ {
+				r.DoReweight()
+			}
+			assert.Equal(t, tt.applied, applied)
+			assert.Equal(t, tt.paused, r.PausedOSDs(), "clamped reweights should only be mistaken for external changes when not verified")
+			if tt.verify {
+				assert.EqualError(t, r.Summary().LastError, "reweight of osd 1 to 1.3 did not take effect")
+			}
+		})
+	}
+}
+
+func TestVerifyReweightsScoped(t *testing.T) {
+	cc := cephtest.New(cephtest.State{
+		OSDTree: cephtest.NewOSDTree(
+			cephtest.OSD{ID: 1, Host: "a"},
+			cephtest.OSD{ID: 2, Host: "a"},
+			cephtest.OSD{ID: 3, Host: "b"},
+		),
+	})
+
+	r, err := New(
+		WithCephClient(cc),
+		WithTargetCrushWeightMap(map[int]float64{1: 2, 2: 2}),
+		WithWeightIncrement(0.5),
+		WithVerifyReweights(true),
+		WithDryRun(false),
+	)
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		r.DoReweight()
+	}
+	assert.NoError(t, r.Summary().LastError)
+	assert.Equal(t, map[int]float64{1: 1, 2: 1, 3: 0}, cc.CrushWeights())
+	assert.Len(t, cc.CallsTo("OSDTreeOf"), 1, "only the first tree should be queried whole")
+	for _, call := range cc.CallsTo("OSDTreeFrom") {
+		assert.Equal(t, "a", call.Args[0], "weights should be read back from the bucket of the targets")
+	}
+	assert.Len(t, cc.CallsTo("OSDTreeFrom"), 3)
+}
+
+// epochClient publishes a new osdmap epoch for reweights only once
+// it has been polled for a given number of times since.
+type epochClient struct {
+	*cephtest.Client
+
+	mu         sync.Mutex
+	reweighted bool
+	polls      int
+	publishAt  int
+}
+
+func (c *epochClient) CrushReweight(ctx context.Context, osdID int, crushWeight float64) error {
+	c.mu.Lock()
+	c.reweighted = true
+	c.mu.Unlock()
+	return c.Client.CrushReweight(ctx, osdID, crushWeight)
+}
+
+func (c *epochClient) OSDDump(ctx context.Context) (*cephclient.OSDDumpOut, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.reweighted {
+		return &cephclient.OSDDumpOut{Epoch: 5}, nil
+	}
+	c.polls++
+	if c.polls >= c.publishAt {
+		return &cephclient.OSDDumpOut{Epoch: 6}, nil
+	}
+	return &cephclient.OSDDumpOut{Epoch: 5}, nil
+}
+
+func TestOSDMapWait(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+
+		wait  time.Duration
+		polls int
+	}{
+		{name: "Published", wait: time.Minute, polls: 2},
+		// Only timing out, before the next poll is due.
+		{name: "Timeout", wait: epochPollInterval / 2, polls: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &epochClient{Client: cephtest.New(cephtest.State{
+				OSDTree: &cephclient.OSDTreeOut{
+					Nodes: []cephclient.OSDTreeNode{
+						{ID: 1, Type: "osd", CrushWeight: 0},
+					},
+				},
+			}), publishAt: 2}
+			defer tc.Close()
+
+			clk := newFakeClock(time.Now())
+			r, err := New(
+				WithCephClient(tc),
+				WithTargetCrushWeightMap(map[int]float64{1: 2}),
+				WithWeightIncrement(0.5),
+				WithOSDMapWait(tt.wait),
+				WithClock(clk),
+				WithDryRun(false),
+			)
+			assert.NoError(t, err)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				r.DoReweight()
+			}()
+
+			// Waiting on both the timeout and the next poll.
+			clk.BlockUntil(2)
+			if tt.wait < epochPollInterval {
+				clk.Advance(tt.wait)
+			} else {
+				clk.Advance(epochPollInterval)
+			}
+			<-done
+
+			assert.Equal(t, tt.polls, tc.polls)
+			assert.Equal(t, map[int]float64{1: 0.5}, tc.CrushWeights())
+			assert.Equal(t, 0.5, r.crushWeightMap[1], "reweight should be verified")
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+// view is a copy of the state read by Status, Collect and the like,
+// published under its own lock so that readers don't wait for an
+// iteration, which holds mu across calls to the cluster.
+type view struct {
+	remaining    map[int]float64
+	progress     Progress
+	iteration    *IterationResult
+	crushWeights map[int]float64
+	pausedOSDs   map[int]float64
+	drainedOSDs  map[int]bool
+	impactScore  float64
+}
+
+// publish refreshes the view from the current state. mu must be held.
+func (r *Rebalancer) publish() {
+	v := view{
+		remaining:    copyWeights(r.targetCrushWeightMap),
+		progress:     r.currentProgress(),
+		crushWeights: copyWeights(r.crushWeightMap),
+		pausedOSDs:   copyWeights(r.pausedOSDs),
+		drainedOSDs:  make(map[int]bool, len(r.drainedOSDs)),
+		impactScore:  r.lastImpactScore,
+	}
+	if r.iteration != nil {
+		v.iteration = r.iteration.copy()
+	}
+	for osd, safe := range r.drainedOSDs {
+		v.drainedOSDs[osd] = safe
+	}
+
+	r.viewMu.Lock()
+	defer r.viewMu.Unlock()
+	r.view = v
+}
+
+// currentView returns the view last published.
+func (r *Rebalancer) currentView() view {
+	r.viewMu.Lock()
+	defer r.viewMu.Unlock()
+	return r.view
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/digitalocean/archimedes/gates"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadersDontWaitForIteration(t *testing.T) {
+	tc := cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd", CrushWeight: 0},
+			},
+		},
+	})
+	defer tc.Close()
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 2}),
+		WithWeightIncrement(0.5),
+		WithGates(gates.GateFunc(func(ctx context.Context) (bool, string, error) {
+			close(entered)
+			<-release
+			return true, "", nil
+		})),
+		WithDryRun(false),
+	)
+	assert.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.DoReweight()
+	}()
+	<-entered
+
+	// The iteration holds on in the gate, as it would on a slow
+	// cluster, meanwhile reads and pausing go through.
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		assert.Equal(t, map[int]float64{1: 2}, r.Status().Remaining)
+		assert.Equal(t, float64(0), r.Progress().Percent)
+		r.Pause()
+		assert.True(t, r.Paused())
+
+		ch := make(chan prometheus.Metric, 10)
+		r.Collect(ch)
+		assert.NotEmpty(t, ch)
+	}()
+	select {
+	case <-read:
+	case <-time.After(5 * time.Second):
+		t.Fatal("readers blocked by the iteration")
+	}
+
+	close(release)
+	<-done
+	assert.Equal(t, map[int]float64{1: 0.5}, tc.CrushWeights())
+	assert.Equal(t, 0.5, r.Status().Progress.OSDs[1].Current, "the view should reflect the iteration once done")
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import "math"
+
+const (
+	// defaultWeightPrecision is the number of decimal places crush
+	// weights are rounded to by default.
+	defaultWeightPrecision = 4
+
+	// maxWeightPrecision is the finest precision crush weights can be
+	// rounded to. Ceph stores them as 16.16 fixed point numbers, which
+	// cannot tell apart weights less than 1/65536 apart.
+	maxWeightPrecision = 4
+)
+
+// weightStep returns the smallest difference between two crush
+// weights at the configured precision.
+func (r *Rebalancer) weightStep() float64 {
+	return math.Pow10(-r.weightPrecision)
+}
+
+// roundWeight rounds a crush weight to the configured precision.
+func (r *Rebalancer) roundWeight(w float64) float64 {
+	p := math.Pow10(r.weightPrecision)
+	return math.Round(w*p) / p
+}
+
+// weightsEqual reports whether two crush weights are the same at the
+// configured precision. Weights read back from Ceph are off by up to
+// 1/65536 from the ones set, so they are never compared exactly.
+func (r *Rebalancer) weightsEqual(a, b float64) bool {
+	return math.Abs(a-b) < r.weightStep()/2
+}
+
+// nextWeight returns the weight an OSD at `cw` is reweighted to on its
+// way to `tw`: an increment closer, rounded to the configured precision,
+// and never beyond the target, which is set as is once within reach.
+// The increment is at least one step, so every reweight makes progress.
+func (r *Rebalancer) nextWeight(cw, tw float64) float64 {
+	inc := math.Max(r.weightIncrement, r.weightStep())
+	if tw < cw {
+		return math.Max(r.roundWeight(cw-inc), tw)
+	}
+	return math.Min(r.roundWeight(cw+inc), tw)
+}
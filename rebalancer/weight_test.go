@@ -0,0 +1,96 @@
+// Copyright 2021 DigitalOcean
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rebalancer
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/archimedes/cephclient"
+	"github.com/digitalocean/archimedes/cephtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextWeight(t *testing.T) {
+	r := &Rebalancer{weightIncrement: 0.1, weightPrecision: 4}
+
+	for _, tt := range []struct {
+		name   string
+		cw, tw float64
+		want   float64
+	}{
+		{name: "Increment", cw: 0.5, tw: 1, want: 0.6},
+		{name: "Decrement", cw: 0.5, tw: 0, want: 0.4},
+		{name: "Capped At Target", cw: 0.95, tw: 1, want: 1},
+		{name: "Floored At Target", cw: 0.05, tw: 0, want: 0},
+		{name: "Rounded", cw: 0.123456, tw: 1, want: 0.2235},
+		{name: "Unrounded Target", cw: 0.95, tw: 1.000001, want: 1.000001},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, r.nextWeight(tt.cw, tt.tw))
+		})
+	}
+
+	// The increment never drops below a single step.
+	r.weightIncrement = 0.00001
+	assert.Equal(t, 0.5001, r.nextWeight(0.5, 1))
+
+	assert.True(t, r.weightsEqual(1, 1+1.0/65536), "weights within the crush resolution should be equal")
+	assert.False(t, r.weightsEqual(1, 1.0001))
+}
+
+// crushClient stores crush weights as Ceph does, in 16.16 fixed
+// point, so that they read back slightly off the weights set.
+type crushClient struct {
+	*cephtest.Client
+}
+
+func (c *crushClient) CrushReweight(ctx context.Context, osdID int, crushWeight float64) error {
+	return c.Client.CrushReweight(ctx, osdID, math.Round(crushWeight*65536)/65536)
+}
+
+func TestWeightPrecision(t *testing.T) {
+	tc := &crushClient{cephtest.New(cephtest.State{
+		OSDTree: &cephclient.OSDTreeOut{
+			Nodes: []cephclient.OSDTreeNode{
+				{ID: 1, Type: "osd", CrushWeight: 0.1},
+				{ID: 2, Type: "osd", CrushWeight: 1},
+			},
+		},
+	})}
+	defer tc.Close()
+
+	r, err := New(
+		WithCephClient(tc),
+		WithTargetCrushWeightMap(map[int]float64{1: 0.3, 2: 0.7}),
+		WithAllowDownweight(true),
+		WithWeightIncrement(0.1),
+		WithSleepInterval(time.Millisecond),
+		WithMaxIterations(10),
+		WithDryRun(false),
+	)
+	assert.NoError(t, err)
+
+	// 0.1 steps do not add up exactly in floating point, nor do the
+	// weights read back from the fixed point crush map.
+	summary, err := r.Run(context.Background())
+	assert.NoError(t, err, "the run should terminate once targets are reached")
+	assert.ElementsMatch(t, []int{1, 2}, summary.Completed)
+	assert.Len(t, tc.CallsTo("CrushReweight"), 5, "no increments beyond the targets should be made")
+	assert.InDelta(t, 0.3, tc.CrushWeights()[1], 1.0/65536)
+	assert.InDelta(t, 0.7, tc.CrushWeights()[2], 1.0/65536)
+}